@@ -0,0 +1,37 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package allmechs registers every mechanism implemented by this module.
+//
+// Neither the core sasl package nor the mechanism packages register any
+// mechanisms by themselves, so that programs only link the mechanisms (and
+// their dependencies) that they use.  Import this package for its side effect
+// to register all of them in the default registry:
+//
+//	import _ "github.com/golang-auth/go-sasl/allmechs"
+//
+// Each mechanism can be left out of this package with a build tag, which also
+// drops its dependencies from the binary:
+//
+//	sasl_nogssapi	GSSAPI, and go-gssapi with its Kerberos stack
+//	sasl_nooauth	OAUTHBEARER and XOAUTH2
+//
+// eg. go build -tags sasl_nogssapi
+//
+// Programs that want only some of the mechanisms enable them explicitly
+// instead, for all clients or for one:
+//
+//	sasl.Enable(gssapi.Mechanism(), oauth.BearerMechanism())
+//	sasl.NewSaslClient("imap", sasl.WithMechanisms(gssapi.Mechanism()))
+package allmechs
+
+import "github.com/golang-auth/go-sasl/registry"
+
+// mustEnable adds mechs to the default registry, from the init function of
+// the file that imports their package
+func mustEnable(mechs ...registry.Mechanism) {
+	if err := registry.Enable(mechs...); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build !sasl_nogssapi && !sasl_nooauth
+// +build !sasl_nogssapi,!sasl_nooauth
+
+package allmechs
+
+import (
+	"testing"
+
+	"github.com/golang-auth/go-sasl/gssapi"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistered(t *testing.T) {
+	assert.Equal(t, []string{"GSSAPI", "OAUTHBEARER", "XOAUTH2"}, registry.Mechs())
+	assert.Equal(t, gssapi.Mechanism().Props, registry.Properties("GSSAPI"))
+}
@@ -0,0 +1,14 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build !sasl_nogssapi
+// +build !sasl_nogssapi
+
+package allmechs
+
+import "github.com/golang-auth/go-sasl/gssapi"
+
+func init() {
+	mustEnable(gssapi.Mechanism())
+}
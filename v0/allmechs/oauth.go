@@ -0,0 +1,14 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build !sasl_nooauth
+// +build !sasl_nooauth
+
+package allmechs
+
+import "github.com/golang-auth/go-sasl/oauth"
+
+func init() {
+	mustEnable(oauth.BearerMechanism(), oauth.XOAuth2Mechanism())
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// Clone returns a client with the same configuration that hasn't been started,
+// which can be used concurrently with c.  The clone has its own copy of the
+// password and doesn't record to c's transcript;  other configuration such as
+// the registry, prompter, token source, audit sinks and metrics is shared and so
+// must itself be safe for concurrent use.
+func (c SaslClient) Clone() SaslClient {
+	clone := c
+
+	clone.mech = nil
+	clone.serverMechs = nil
+	clone.startTime = time.Time{}
+	clone.steps = 0
+	clone.failed = false
+	clone.busy = new(int32)
+	clone.transcript = nil
+
+	if c.password != nil {
+		clone.promptHandlers = make(common.PromptHandlers, len(c.promptHandlers))
+		for t, f := range c.promptHandlers {
+			clone.promptHandlers[t] = f
+		}
+		password := c.password.Bytes()
+		clone.setPassword(common.NewSecret(password))
+		common.Zero(password)
+	}
+
+	return clone
+}
+
+// acquire marks the client busy, returning false if it already is
+func (c *SaslClient) acquire() bool {
+	if c.busy == nil {
+		c.busy = new(int32)
+	}
+
+	return atomic.CompareAndSwapInt32(c.busy, 0, 1)
+}
+
+func (c *SaslClient) release() {
+	atomic.StoreInt32(c.busy, 0)
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// gateMech signals entered, if it can, when a step begins and then waits for release
+type gateMech struct {
+	scriptedMech
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (m *gateMech) Step(inToken []byte) (outToken []byte, status common.StepStatus, err error) {
+	select {
+	case m.entered <- struct{}{}:
+	default:
+	}
+	<-m.release
+	return m.scriptedMech.Step(inToken)
+}
+
+func TestClone(t *testing.T) {
+	r := registry.New()
+	props := common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous}
+	r.MustRegister("CLONE-A", func(common.MechConfig) common.Mech {
+		return &scriptedMech{name: "CLONE-A", steps: 2}
+	}, props)
+	r.MustRegister("CLONE-B", func(common.MechConfig) common.Mech {
+		return &scriptedMech{name: "CLONE-B", steps: 2}
+	}, props)
+
+	tr := &Transcript{}
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"CLONE-A", "CLONE-B"}), WithServerFQDN("imap.example.com"), WithTranscript(tr))
+	assert.NoError(t, err)
+	_, _, err = cli.ChooseMech([]string{"CLONE-B"})
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+
+	// the clone has the configuration but not the handshake or the server's mechs
+	clone := cli.Clone()
+	assert.Equal(t, configHash(t, cli), configHash(t, clone))
+	state, steps := clone.State()
+	assert.Equal(t, StateNotStarted, state)
+	assert.Equal(t, 0, steps)
+	mech, _, err := clone.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "CLONE-A", mech)
+
+	// nor the transcript
+	assert.Equal(t, "CLONE-B", tr.Mech)
+
+	_, _, err = cli.Step(nil)
+	assert.NoError(t, err)
+	assert.True(t, cli.IsEstablished())
+	assert.False(t, clone.IsEstablished())
+}
+
+func TestConcurrentUse(t *testing.T) {
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	r := registry.New()
+	r.MustRegister("GATE", func(common.MechConfig) common.Mech {
+		return &gateMech{scriptedMech{name: "GATE", steps: 2}, entered, release}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := NewSaslClient("imap", WithRegistry(r))
+	assert.NoError(t, err)
+	clone := cli.Clone()
+
+	started := make(chan error)
+	go func() {
+		_, _, err := cli.Start()
+		started <- err
+	}()
+	<-entered
+
+	_, _, err = cli.Step(nil)
+	assert.ErrorIs(t, err, common.ErrConcurrentUse)
+	_, _, err = cli.ChooseMech([]string{"GATE"})
+	assert.ErrorIs(t, err, common.ErrConcurrentUse)
+
+	close(release)
+	assert.NoError(t, <-started)
+
+	// a clone has its own handshake
+	_, _, err = clone.Start()
+	assert.NoError(t, err)
+	_, _, err = cli.Step(nil)
+	assert.NoError(t, err)
+	assert.True(t, cli.IsEstablished())
+	assert.False(t, clone.IsEstablished())
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/integrations/imap"
+)
+
+// imapProto reads the capabilities and runs STARTTLS;  AUTHENTICATE is left
+// to the imap package
+type imapProto struct {
+	conn net.Conn
+	r    *bufio.Reader
+	caps []string
+}
+
+func newIMAP(conn net.Conn, startTLS bool, config *tls.Config) (*imapProto, error) {
+	p := &imapProto{conn: conn, r: bufio.NewReader(conn)}
+	if _, err := readLine(p.r); err != nil {
+		return nil, err
+	}
+
+	if startTLS {
+		if err := p.cmd("s0 STARTTLS"); err != nil {
+			return nil, err
+		}
+		tc := tls.Client(conn, config)
+		if err := tc.Handshake(); err != nil {
+			return nil, err
+		}
+		p.conn, p.r = tc, bufio.NewReader(tc)
+	}
+
+	// ask, as the greeting may not list the capabilities and they change
+	// after STARTTLS
+	return p, p.cmd("c0 CAPABILITY")
+}
+
+// cmd sends a command and reads the responses up to the tagged one, keeping
+// any capabilities
+func (p *imapProto) cmd(line string) error {
+	tag := strings.Fields(line)[0]
+	if _, err := fmt.Fprintf(p.conn, "%s\r\n", line); err != nil {
+		return err
+	}
+
+	for {
+		resp, err := readLine(p.r)
+		if err != nil {
+			return err
+		}
+
+		if fields := strings.Fields(resp); len(fields) > 2 && fields[0] == "*" && strings.EqualFold(fields[1], "CAPABILITY") {
+			p.caps = fields[2:]
+		}
+		if !strings.HasPrefix(resp, tag+" ") {
+			continue
+		}
+
+		if status := strings.Fields(resp[len(tag)+1:]); len(status) == 0 || !strings.EqualFold(status[0], "OK") {
+			return fmt.Errorf("imap: %s: %w", resp, common.ErrProtocol)
+		}
+		return nil
+	}
+}
+
+func (p *imapProto) mechs() ([]string, error) {
+	return imap.Mechs(p.caps), nil
+}
+
+func (p *imapProto) authenticate(client *sasl.SaslClient, mechs []string) error {
+	_, err := imap.Authenticate(p.conn, client, "a0", p.caps)
+	return err
+}
+
+func (p *imapProto) tlsState() (tls.ConnectionState, bool) {
+	return connState(p.conn)
+}
@@ -0,0 +1,34 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package main
+
+import (
+	"crypto/tls"
+	"net"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/integrations/ldap"
+)
+
+// ldapProto reads the mechanisms from the root DSE and binds
+type ldapProto struct {
+	conn net.Conn
+}
+
+func newLDAP(conn net.Conn) *ldapProto {
+	return &ldapProto{conn: conn}
+}
+
+func (p *ldapProto) mechs() ([]string, error) {
+	return ldap.SupportedMechs(p.conn, 1)
+}
+
+func (p *ldapProto) authenticate(client *sasl.SaslClient, mechs []string) error {
+	_, err := ldap.Bind(p.conn, client, mechs, 2)
+	return err
+}
+
+func (p *ldapProto) tlsState() (tls.ConnectionState, bool) {
+	return connState(p.conn)
+}
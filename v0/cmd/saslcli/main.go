@@ -0,0 +1,285 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Command saslcli connects to an SMTP, IMAP, LDAP or ManageSieve server, lists
+// the SASL mechanisms it advertises and authenticates, printing the negotiated
+// security layer, the identities and a transcript of the exchange.
+//
+//	saslcli -proto imap -starttls mail.example.com:143
+//
+// Tokens in the transcript are shown by length and hash unless -unsafe is
+// given.  The SASL_* environment variables read by sasl.NewSaslClient apply,
+// and a password can be supplied in SASLCLI_PASSWORD.
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	_ "github.com/golang-auth/go-sasl/allmechs"
+	"github.com/golang-auth/go-sasl/common"
+)
+
+type options struct {
+	proto    string
+	addr     string
+	service  string
+	host     string
+	mechs    string
+	minSSF   uint
+	maxSSF   uint
+	implicit bool
+	startTLS bool
+	insecure bool
+	list     bool
+	debug    bool
+	unsafe   bool
+	ccache   string
+}
+
+// protocol is the application protocol spoken to the server
+type protocol interface {
+	// mechs returns the mechanisms advertised by the server, after STARTTLS
+	// if requested
+	mechs() ([]string, error)
+
+	// authenticate chooses one of mechs and runs the exchange
+	authenticate(client *sasl.SaslClient, mechs []string) error
+
+	// tlsState returns the state of the TLS connection, if there is one
+	tlsState() (tls.ConnectionState, bool)
+}
+
+var defaultPorts = map[string]struct {
+	plain, implicit string
+}{
+	"smtp":  {"587", "465"},
+	"imap":  {"143", "993"},
+	"ldap":  {"389", "636"},
+	"sieve": {"4190", ""},
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.proto, "proto", "imap", "protocol: smtp, imap, ldap or sieve")
+	flag.StringVar(&o.service, "service", "", "service name, defaults to the protocol's")
+	flag.StringVar(&o.host, "host", "", "server host name for the service principal, defaults to the one in the address")
+	flag.StringVar(&o.mechs, "mechs", "", "mechanisms to use, separated by commas")
+	flag.UintVar(&o.minSSF, "min-ssf", 0, "minimum security strength factor")
+	flag.UintVar(&o.maxSSF, "max-ssf", 256, "maximum security strength factor")
+	flag.BoolVar(&o.implicit, "tls", false, "connect with TLS")
+	flag.BoolVar(&o.startTLS, "starttls", false, "use STARTTLS before authenticating (not LDAP)")
+	flag.BoolVar(&o.insecure, "insecure", false, "don't verify the server's certificate")
+	flag.BoolVar(&o.list, "list", false, "only list the server's mechanisms")
+	flag.BoolVar(&o.debug, "debug", false, "log debug messages")
+	flag.BoolVar(&o.unsafe, "unsafe", false, "show tokens in full in the transcript and logs;  they may contain credentials")
+	flag.StringVar(&o.ccache, "ccache", "", "Kerberos credential cache, instead of $KRB5CCNAME")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] host[:port]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	o.addr = flag.Arg(0)
+
+	// the built-in Kerberos provider can't be given a cache, but it's safe to
+	// change the environment before anything else runs
+	if o.ccache != "" {
+		os.Setenv("KRB5CCNAME", o.ccache)
+	}
+
+	if err := run(o); err != nil {
+		log.Fatalf("saslcli: %s", err)
+	}
+}
+
+func run(o options) error {
+	ports, ok := defaultPorts[o.proto]
+	if !ok {
+		return fmt.Errorf("unknown protocol %q", o.proto)
+	}
+
+	host, port, err := net.SplitHostPort(o.addr)
+	if err != nil {
+		host, port = o.addr, ports.plain
+		if o.implicit {
+			port = ports.implicit
+		}
+	}
+	if o.host == "" {
+		o.host = host
+	}
+	if o.service == "" {
+		o.service = o.proto
+	}
+
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: o.insecure}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if o.implicit {
+		tc := tls.Client(conn, tlsConfig)
+		if err := tc.Handshake(); err != nil {
+			return err
+		}
+		conn = tc
+	}
+
+	var p protocol
+	switch o.proto {
+	case "smtp":
+		p, err = newSMTP(conn, o.startTLS, tlsConfig)
+	case "imap":
+		p, err = newIMAP(conn, o.startTLS, tlsConfig)
+	case "ldap":
+		if o.startTLS {
+			return fmt.Errorf("STARTTLS is not supported for LDAP, use -tls")
+		}
+		p = newLDAP(conn)
+	case "sieve":
+		p, err = newSieve(conn, o.startTLS, tlsConfig)
+	}
+	if err != nil {
+		return err
+	}
+
+	mechs, err := p.mechs()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("server mechanisms: %s\n", strings.Join(mechs, " "))
+	if o.list {
+		return nil
+	}
+
+	transcript := &sasl.Transcript{RecordBodies: o.unsafe}
+	opts := []sasl.SaslClientOption{
+		sasl.WithServerFQDN(o.host),
+		sasl.WithIPLiteralServer(),
+		sasl.WithMinSSF(o.minSSF),
+		sasl.WithMaxSSF(o.maxSSF),
+		sasl.WithTranscript(transcript),
+	}
+	if o.mechs != "" {
+		opts = append(opts, sasl.WithMechList(strings.Split(strings.ToUpper(o.mechs), ",")))
+	}
+	if o.proto == "smtp" {
+		// net/smtp can't install a security layer
+		opts = append(opts, sasl.WithMaxSSF(0))
+	}
+	if o.debug {
+		l := log.New(os.Stderr, "debug: ", log.Lmicroseconds)
+		opts = append(opts, sasl.WithDebugLogger(l), sasl.WithInfoLogger(l))
+	}
+	if o.unsafe {
+		opts = append(opts, sasl.WithUnsafeTokenLogging())
+	}
+	if password, ok := os.LookupEnv("SASLCLI_PASSWORD"); ok {
+		opts = append(opts, sasl.WithPassword([]byte(password)))
+	}
+	if cs, ok := p.tlsState(); ok {
+		opts = append(opts, sasl.WithExternalSSF(uint(tlsSSF(cs))))
+		if cb, err := common.ChannelBindingFromTLS(cs, common.CBTLSServerEndPoint); err == nil {
+			opts = append(opts, sasl.WithChannelBindings(cb))
+		}
+	}
+
+	client, err := sasl.NewSaslClient(o.service, opts...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	authErr := p.authenticate(&client, mechs)
+	printTranscript(transcript)
+	if authErr != nil {
+		return authErr
+	}
+
+	params, err := client.ContextParams()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("mechanism: %s\n", params.Mech)
+	fmt.Printf("ssf: %d\n", params.SSF)
+	if params.QOP != 0 {
+		fmt.Printf("qop: %s\n", params.QOP)
+	}
+	fmt.Printf("authentication id: %s\n", params.AuthCID)
+	fmt.Printf("authorization id: %s\n", params.AuthzID)
+	fmt.Printf("mutual authentication: %t\n", params.MutualAuth)
+	if !params.Expiry.IsZero() {
+		fmt.Printf("expires: %s\n", params.Expiry)
+	}
+
+	return nil
+}
+
+// tlsSSF is the external SSF provided by a TLS connection, after the key size
+// of its cipher
+func tlsSSF(cs tls.ConnectionState) int {
+	if aes128Suites[cs.CipherSuite] {
+		return 128
+	}
+	return 256
+}
+
+// aes128Suites are the cipher suites with 128 bit keys (tls.CipherSuiteName
+// needs Go 1.14)
+var aes128Suites = map[uint16]bool{
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA:            true,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA256:         true,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256:         true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA:    true,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA:      true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256: true,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256:   true,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256:   true,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256: true,
+	tls.TLS_AES_128_GCM_SHA256:                  true,
+}
+
+func printTranscript(t *sasl.Transcript) {
+	b, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return
+	}
+	fmt.Printf("transcript: %s\n", b)
+}
+
+func connState(conn net.Conn) (tls.ConnectionState, bool) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		return tc.ConnectionState(), true
+	}
+	return tls.ConnectionState{}, false
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// splitLines splits a multi-line reply returned by textproto
+func splitLines(msg string) []string {
+	return strings.Split(msg, "\n")
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/integrations/sieve"
+)
+
+// sieveProto reads the capabilities and runs STARTTLS;  AUTHENTICATE is left
+// to the sieve package
+type sieveProto struct {
+	conn net.Conn
+	caps []string
+}
+
+func newSieve(conn net.Conn, startTLS bool, config *tls.Config) (*sieveProto, error) {
+	p := &sieveProto{conn: conn}
+	r := bufio.NewReader(conn)
+	if err := p.readCaps(r); err != nil {
+		return nil, err
+	}
+
+	if startTLS {
+		if _, err := io.WriteString(conn, "STARTTLS\r\n"); err != nil {
+			return nil, err
+		}
+		if line, err := readLine(r); err != nil {
+			return nil, err
+		} else if !strings.HasPrefix(strings.ToUpper(line), "OK") {
+			return nil, fmt.Errorf("sieve: STARTTLS: %s: %w", line, common.ErrProtocol)
+		}
+
+		tc := tls.Client(conn, config)
+		if err := tc.Handshake(); err != nil {
+			return nil, err
+		}
+		p.conn = tc
+
+		// the server sends its capabilities again after STARTTLS (RFC 5804
+		// § 2.2)
+		if err := p.readCaps(bufio.NewReader(tc)); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// readCaps reads capability lines up to the OK response
+func (p *sieveProto) readCaps(r *bufio.Reader) error {
+	p.caps = nil
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+
+		switch upper := strings.ToUpper(line); {
+		case strings.HasPrefix(line, `"`):
+			p.caps = append(p.caps, line)
+		case strings.HasPrefix(upper, "OK"):
+			return nil
+		default:
+			return fmt.Errorf("sieve: %s: %w", line, common.ErrProtocol)
+		}
+	}
+}
+
+func (p *sieveProto) mechs() ([]string, error) {
+	return sieve.Mechs(p.caps), nil
+}
+
+func (p *sieveProto) authenticate(client *sasl.SaslClient, mechs []string) error {
+	_, err := sieve.Authenticate(p.conn, client, p.caps)
+	return err
+}
+
+func (p *sieveProto) tlsState() (tls.ConnectionState, bool) {
+	return connState(p.conn)
+}
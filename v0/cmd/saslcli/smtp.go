@@ -0,0 +1,134 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// smtpProto speaks just enough SMTP for EHLO, STARTTLS and AUTH (RFC 4954)
+type smtpProto struct {
+	conn net.Conn
+	text *textproto.Conn
+	ehlo []string
+}
+
+func newSMTP(conn net.Conn, startTLS bool, config *tls.Config) (*smtpProto, error) {
+	p := &smtpProto{conn: conn, text: textproto.NewConn(conn)}
+	if _, _, err := p.text.ReadResponse(220); err != nil {
+		return nil, err
+	}
+	if err := p.hello(); err != nil {
+		return nil, err
+	}
+
+	if startTLS {
+		if _, err := p.cmd(220, "STARTTLS"); err != nil {
+			return nil, err
+		}
+		tc := tls.Client(conn, config)
+		if err := tc.Handshake(); err != nil {
+			return nil, err
+		}
+		p.conn, p.text = tc, textproto.NewConn(tc)
+
+		// the extensions may change after STARTTLS (RFC 3207 § 4.2)
+		if err := p.hello(); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (p *smtpProto) hello() error {
+	msg, err := p.cmd(250, "EHLO localhost")
+	if err != nil {
+		return err
+	}
+	p.ehlo = splitLines(msg)
+	return nil
+}
+
+func (p *smtpProto) cmd(code int, format string, args ...interface{}) (string, error) {
+	id, err := p.text.Cmd(format, args...)
+	if err != nil {
+		return "", err
+	}
+	p.text.StartResponse(id)
+	defer p.text.EndResponse(id)
+
+	_, msg, err := p.text.ReadResponse(code)
+	return msg, err
+}
+
+func (p *smtpProto) mechs() ([]string, error) {
+	return wire.SMTPMechs(p.ehlo), nil
+}
+
+func (p *smtpProto) authenticate(client *sasl.SaslClient, mechs []string) error {
+	if _, _, err := client.ChooseMech(mechs); err != nil {
+		return err
+	}
+	return saslconn.Handshake(client, p)
+}
+
+func (p *smtpProto) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	cmd := "AUTH " + mech
+	if initialResponse != nil {
+		// a zero-length initial response is sent as "=" (RFC 4954 § 4)
+		ir := wire.EncodeBase64(initialResponse)
+		if ir == "" {
+			ir = "="
+		}
+		cmd += " " + ir
+	}
+	return p.exchange(cmd)
+}
+
+func (p *smtpProto) Next(response []byte) ([]byte, bool, error) {
+	return p.exchange(wire.EncodeBase64(response))
+}
+
+func (p *smtpProto) Cancel() error {
+	_, _, err := p.exchange(wire.Cancel)
+	if _, ok := err.(*textproto.Error); ok {
+		return nil
+	}
+	return err
+}
+
+// exchange sends line and returns the challenge in a 334 reply, or done once
+// the server replies 235
+func (p *smtpProto) exchange(line string) ([]byte, bool, error) {
+	if err := p.text.PrintfLine("%s", line); err != nil {
+		return nil, false, err
+	}
+
+	code, msg, err := p.text.ReadResponse(0)
+	switch {
+	case err != nil:
+		return nil, false, err
+	case code == 334:
+		challenge, err := wire.DecodeBase64(msg)
+		return challenge, false, err
+	case code == 235:
+		return nil, true, nil
+	case code == 535:
+		return nil, false, fmt.Errorf("smtp: %d %s: %w", code, msg, common.ErrAuthFailed)
+	}
+	return nil, false, &textproto.Error{Code: code, Msg: msg}
+}
+
+func (p *smtpProto) tlsState() (tls.ConnectionState, bool) {
+	return connState(p.conn)
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import "time"
+
+type AuditOutcome int
+
+const (
+	AuditSuccess AuditOutcome = iota // context established
+	AuditFailure                     // authentication failed
+)
+
+func (o AuditOutcome) String() string {
+	switch o {
+	case AuditSuccess:
+		return "success"
+	case AuditFailure:
+		return "failure"
+	}
+
+	return "unknown"
+}
+
+// AuditEvent describes the outcome of one authentication exchange
+type AuditEvent struct {
+	Time       time.Time     // when the exchange finished
+	Service    string        // service name, eg. imap
+	ServerFQDN string        // server host name, if configured
+	Mech       string        // chosen mechanism, empty if none was suitable
+	AuthCID    string        // authentication identity, when known
+	AuthZID    string        // authorization identity, when known
+	SSF        uint          // negotiated security strength factor
+	Outcome    AuditOutcome  // success or failure
+	Err        error         // the reason for a failure
+	Duration   time.Duration // time since the exchange was started
+	ConfigHash string        // hash of the canonical configuration, if it serializes
+}
+
+// AuditSink receives an event at the end of every authentication exchange.
+// Audit is called synchronously from the handshake so implementations that
+// ship events elsewhere should not block.
+type AuditSink interface {
+	Audit(event AuditEvent)
+}
+
+// AuditFunc allows an ordinary function to be used as an AuditSink
+type AuditFunc func(event AuditEvent)
+
+func (f AuditFunc) Audit(event AuditEvent) {
+	f(event)
+}
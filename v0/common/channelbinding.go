@@ -1,7 +1,71 @@
 package common
 
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// ChannelBinding binds authentication to an outer channel, usually TLS
 type ChannelBinding struct {
-	Name     string
+	Name     string // binding type, eg. tls-unique or tls-exporter
 	Critical bool
 	Data     []byte
+
+	// TLS version of the channel, eg. tls.VersionTLS13;  zero if unknown or
+	// the channel isn't TLS
+	TLSVersion uint16
+}
+
+// CBFlag is the GS2 channel binding flag sent by SCRAM and other GS2 style
+// mechanisms (RFC 5801 § 4)
+type CBFlag byte
+
+const (
+	CBFlagNone       CBFlag = 'n' // client has no channel bindings
+	CBFlagNotOffered CBFlag = 'y' // client has bindings but the server didn't offer the -PLUS mech
+	CBFlagUsed       CBFlag = 'p' // client is using a -PLUS mech
+)
+
+// Validate returns an error if the binding type is missing or can't be used
+// with the channel's TLS version
+func (cb ChannelBinding) Validate() error {
+	switch {
+	case cb.Name == "":
+		return fmt.Errorf("binding type not set: %w", ErrNoChannelBinding)
+	case cb.Name == CBTLSUnique && cb.TLSVersion >= tls.VersionTLS13:
+		// RFC 9266 § 3
+		return fmt.Errorf("%s is not defined for TLS 1.3: %w", cb.Name, ErrNoChannelBinding)
+	}
+
+	return nil
+}
+
+// ApplicationData returns the data prefixed with the binding type, the form
+// used by GSS-API mechanisms (RFC 5056 § 2.1)
+func (cb ChannelBinding) ApplicationData() []byte {
+	data := make([]byte, 0, len(cb.Name)+1+len(cb.Data))
+	data = append(data, cb.Name...)
+	data = append(data, ':')
+	return append(data, cb.Data...)
+}
+
+// SupportsChannelBinding reports whether a mech with these properties can use
+// binding type typ
+func (p MechProps) SupportsChannelBinding(typ string) bool {
+	if p.Fearures&FeatChannelBindings == 0 {
+		return false
+	}
+
+	// any type will do
+	if len(p.ChannelBindingTypes) == 0 {
+		return true
+	}
+
+	for _, t := range p.ChannelBindingTypes {
+		if t == typ {
+			return true
+		}
+	}
+
+	return false
 }
@@ -8,13 +8,90 @@ import (
 	"fmt"
 )
 
+// Error classes.  Errors from mechanisms match at most one of these with
+// errors.Is, which tells the application whether authenticating again can help:
+// not after ErrProtocol or ErrWeakSecurity, probably not with the same
+// credentials after ErrAuthFailed, and only once credentials are supplied
+// after ErrNoCredentials.
+var (
+	ErrAuthFailed    = errors.New("authentication failed")
+	ErrProtocol      = errors.New("protocol error")
+	ErrNoCredentials = errors.New("credentials not available")
+	ErrWeakSecurity  = errors.New("security requirements not met")
+	ErrBadConfig     = errors.New("bad configuration")
+)
+
 var (
 	ErrNoMech             = errors.New("no worthy mechs found")
 	ErrNotStarted         = errors.New("must use Start() before Step()")
 	ErrAlreadyEstablished = errors.New("context is already established")
 	ErrNotEstablished     = errors.New("context is not established")
+	ErrBadToken           = classified(ErrProtocol, "malformed token from peer")
+	ErrNoSecurityLayer    = classified(ErrWeakSecurity, "no suitable security layer available")
+	ErrNoLayer            = errors.New("no security layer negotiated")
+	ErrMessageTooLarge    = errors.New("message is larger than the peer accepts")
+	ErrReplayedToken      = classified(ErrProtocol, "replayed security layer token")
+	ErrOutOfSequence      = classified(ErrProtocol, "security layer token out of sequence")
+	ErrNotExportable      = errors.New("context can't be exported")
+	ErrConcurrentUse      = errors.New("client is in use by another goroutine")
+	ErrClosed             = errors.New("mech has been closed")
+	ErrNoChannelBinding   = errors.New("channel binding not available")
+	ErrNoMutualAuth       = classified(ErrWeakSecurity, "server was not authenticated")
+	ErrInvalidString      = classified(ErrBadConfig, "string not allowed by profile")
 )
 
+// classifiedError is a sentinel that also matches its class
+type classifiedError struct {
+	class error
+	msg   string
+}
+
+func classified(class error, msg string) error {
+	return &classifiedError{class, msg}
+}
+
+func (e *classifiedError) Error() string {
+	return e.msg
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
+
+// Error is returned by mechanisms.  It matches its Class and wraps Err, so
+// both the class and any more specific error can be tested with errors.Is.
+type Error struct {
+	Mech   string // mechanism name, used as a prefix to the message
+	Class  error  // ErrAuthFailed, ErrProtocol, etc;  nil if the mech can't tell
+	Detail string
+	Err    error // underlying error, if any
+}
+
+// NewError returns an *Error for mech with the given class, detail and cause
+func NewError(mech string, class error, detail string, err error) *Error {
+	return &Error{Mech: mech, Class: class, Detail: detail, Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := e.Mech + ": " + e.Detail
+	switch {
+	case e.Err == nil:
+		return msg
+	case e.Detail == "":
+		return e.Mech + ": " + e.Err.Error()
+	}
+
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return e.Class != nil && target == e.Class
+}
+
 type ErrTooWeak struct {
 	MechSSF     uint
 	ExtSSF      uint
@@ -28,3 +105,22 @@ func (e ErrTooWeak) Error() string {
 		return fmt.Sprintf("negotiated SSF (%d) is less than required SSF (%d)", e.MechSSF, e.RequiredSSF)
 	}
 }
+
+func (e ErrTooWeak) Is(target error) bool {
+	return target == ErrWeakSecurity
+}
+
+// ErrTokenTooLarge is returned when the peer sends a security layer token that
+// is larger than the maximum buffer size we advertised.  It matches ErrBadToken.
+type ErrTokenTooLarge struct {
+	Size uint
+	Max  uint
+}
+
+func (e ErrTokenTooLarge) Error() string {
+	return fmt.Sprintf("%d byte token is larger than the maximum of %d", e.Size, e.Max)
+}
+
+func (e ErrTokenTooLarge) Is(target error) bool {
+	return target == ErrBadToken || target == ErrProtocol
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClasses(t *testing.T) {
+	var tests = []struct {
+		err   error
+		class error
+	}{
+		{ErrBadToken, ErrProtocol},
+		{ErrNoSecurityLayer, ErrWeakSecurity},
+		{ErrNoMutualAuth, ErrWeakSecurity},
+		{ErrTooWeak{MechSSF: 1, RequiredSSF: 56}, ErrWeakSecurity},
+		{ErrTokenTooLarge{Size: 65537, Max: 65536}, ErrProtocol},
+		{fmt.Errorf("%s: %w", PromptPassword, ErrNoPromptHandler), ErrNoCredentials},
+		{NewError("test", ErrAuthFailed, "bad password", nil), ErrAuthFailed},
+	}
+
+	classes := []error{ErrAuthFailed, ErrProtocol, ErrNoCredentials, ErrWeakSecurity, ErrBadConfig}
+	for _, tt := range tests {
+		for _, class := range classes {
+			assert.Equal(t, class == tt.class, errors.Is(tt.err, class), "%s is %s", tt.err, class)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	cause := errors.New("ticket expired")
+
+	err := NewError("test", ErrNoCredentials, "can't initiate context", cause)
+	assert.EqualError(t, err, "test: can't initiate context: ticket expired")
+	assert.ErrorIs(t, err, cause)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+
+	var e *Error
+	assert.True(t, errors.As(fmt.Errorf("wrapped: %w", err), &e))
+	assert.Equal(t, "test", e.Mech)
+
+	assert.EqualError(t, NewError("test", nil, "", cause), "test: ticket expired")
+	assert.EqualError(t, NewError("test", nil, "bad state", nil), "test: bad state")
+
+	// the class of the underlying error still matches
+	err = NewError("test", nil, "bad SSF token", ErrBadToken)
+	assert.ErrorIs(t, err, ErrProtocol)
+}
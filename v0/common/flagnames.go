@@ -0,0 +1,114 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type flagName struct {
+	bit  uint32
+	name string
+}
+
+var secFlagNames = []flagName{
+	{uint32(SecNoPlainText), "SecNoPlainText"},
+	{uint32(SecNoActive), "SecNoActive"},
+	{uint32(SecNoDictionary), "SecNoDictionary"},
+	{uint32(SecForwardSecrecy), "SecForwardSecrecy"},
+	{uint32(SecNoAnonymous), "SecNoAnonymous"},
+	{uint32(SecPassCredentials), "SecPassCredentials"},
+	{uint32(SecMutualAuth), "SecMutualAuth"},
+}
+
+var featureNames = []flagName{
+	{uint32(FeatNeedServerFQDN), "FeatNeedServerFQDN"},
+	{uint32(FeatWantClientFirst), "FeatWantClientFirst"},
+	{uint32(FeatServerFirst), "FeatServerFirst"},
+	{uint32(FeatDontUseUserPassword), "FeatDontUseUserPassword"},
+	{uint32(FeatGSSFraming), "FeatGSSFraming"},
+	{uint32(FeatSupportsHTTP), "FeatSupportsHTTP"},
+	{uint32(FeatChannelBindings), "FeatChannelBindings"},
+}
+
+// String returns the names of the flags in f separated by commas, eg.
+// "SecNoPlainText,SecMutualAuth", or "0" if there are none
+func (f SecurityFlag) String() string {
+	return formatFlags(uint32(f), secFlagNames)
+}
+
+// String returns the names of the features in f separated by commas, eg.
+// "FeatGSSFraming,FeatChannelBindings", or "0" if there are none
+func (f Feature) String() string {
+	return formatFlags(uint32(f), featureNames)
+}
+
+// ParseSecurityFlag parses a list of flag names separated by commas, '|' or
+// spaces, as returned by SecurityFlag.String.  Names are not case sensitive
+// and the "Sec" prefix is optional, so "noplaintext|mutualauth" is accepted.
+func ParseSecurityFlag(s string) (SecurityFlag, error) {
+	f, err := parseFlags(s, secFlagNames, "Sec")
+	return SecurityFlag(f), err
+}
+
+// ParseFeature parses a list of feature names in the same way as
+// ParseSecurityFlag;  the "Feat" prefix is optional
+func ParseFeature(s string) (Feature, error) {
+	f, err := parseFlags(s, featureNames, "Feat")
+	return Feature(f), err
+}
+
+// formatFlags names the bits of v;  bits without a name are shown in hex
+func formatFlags(v uint32, names []flagName) string {
+	if v == 0 {
+		return "0"
+	}
+
+	var parts []string
+	for _, n := range names {
+		if v&n.bit != 0 {
+			parts = append(parts, n.name)
+			v &^= n.bit
+		}
+	}
+	if v != 0 {
+		parts = append(parts, fmt.Sprintf("%#x", v))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func parseFlags(s string, names []flagName, prefix string) (uint32, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '|' || r == ' ' || r == '\t'
+	})
+
+	var v uint32
+	for _, field := range fields {
+		bit, err := parseFlag(field, names, prefix)
+		if err != nil {
+			return 0, err
+		}
+		v |= bit
+	}
+
+	return v, nil
+}
+
+func parseFlag(field string, names []flagName, prefix string) (uint32, error) {
+	for _, n := range names {
+		if strings.EqualFold(field, n.name) || strings.EqualFold(field, n.name[len(prefix):]) {
+			return n.bit, nil
+		}
+	}
+
+	// numeric values, eg. the unnamed bits in String's output
+	if bits, err := strconv.ParseUint(field, 0, 32); err == nil {
+		return uint32(bits), nil
+	}
+
+	return 0, fmt.Errorf("unknown flag %q: %w", field, ErrBadConfig)
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityFlagString(t *testing.T) {
+	assert.Equal(t, "0", SecurityFlag(0).String())
+	assert.Equal(t, "SecNoPlainText", SecNoPlainText.String())
+	assert.Equal(t, "SecNoPlainText,SecNoAnonymous,SecMutualAuth", (SecNoPlainText | SecMutualAuth | SecNoAnonymous).String())
+	assert.Equal(t, "SecNoActive,0x100", (SecNoActive | 0x100).String())
+	assert.Equal(t, "FeatServerFirst,FeatChannelBindings", fmt.Sprint(FeatServerFirst|FeatChannelBindings))
+}
+
+func TestParseSecurityFlag(t *testing.T) {
+	var tests = []struct {
+		in   string
+		want SecurityFlag
+	}{
+		{"", 0},
+		{"0", 0},
+		{"SecNoPlainText|SecMutualAuth", SecNoPlainText | SecMutualAuth},
+		{"noplaintext, mutualauth", SecNoPlainText | SecMutualAuth},
+		{"SecNoActive,0x100", SecNoActive | 0x100},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSecurityFlag(tt.in)
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	_, err := ParseSecurityFlag("SecNoPlainText|Bogus")
+	assert.ErrorIs(t, err, ErrBadConfig)
+	_, err = ParseSecurityFlag("FeatServerFirst")
+	assert.ErrorIs(t, err, ErrBadConfig)
+
+	// String and Parse round trip
+	for f := SecurityFlag(0); f < 0x200; f++ {
+		got, err := ParseSecurityFlag(f.String())
+		assert.NoError(t, err)
+		assert.Equal(t, f, got)
+	}
+}
+
+func TestParseFeature(t *testing.T) {
+	f, err := ParseFeature("FeatGSSFraming | needserverfqdn")
+	assert.NoError(t, err)
+	assert.Equal(t, FeatGSSFraming|FeatNeedServerFQDN, f)
+
+	for f := Feature(0); f < 0x100; f++ {
+		got, err := ParseFeature(f.String())
+		assert.NoError(t, err)
+		assert.Equal(t, f, got)
+	}
+}
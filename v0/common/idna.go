@@ -0,0 +1,251 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// acePrefix marks an A-label, the ASCII form of an internationalized label
+const acePrefix = "xn--"
+
+// HostnameToASCII converts an internationalized host name to the A-label form
+// used in DNS and in service principal names (RFC 5890), eg. "bücher.example"
+// becomes "xn--bcher-kva.example".  U-labels are mapped to lower case and
+// normalized with NormalizeNFC if it is set;  existing A-labels are checked
+// by decoding them.  Errors match ErrBadConfig.
+func HostnameToASCII(host string) (string, error) {
+	if !utf8.ValidString(host) {
+		return "", fmt.Errorf("host name %q: invalid UTF-8: %w", host, ErrBadConfig)
+	}
+
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		a, err := labelToASCII(label)
+		if err != nil {
+			return "", fmt.Errorf("host name %q: %s: %w", host, err, ErrBadConfig)
+		}
+		labels[i] = a
+	}
+
+	out := strings.Join(labels, ".")
+	if len(out) > 253 {
+		return "", fmt.Errorf("host name %q: too long: %w", host, ErrBadConfig)
+	}
+
+	return out, nil
+}
+
+func labelToASCII(label string) (string, error) {
+	ascii := true
+	for i := 0; i < len(label); i++ {
+		if label[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+
+	if ascii {
+		if len(label) > 63 {
+			return "", errors.New("label too long")
+		}
+
+		if strings.HasPrefix(strings.ToLower(label), acePrefix) {
+			u, err := punyDecode(label[len(acePrefix):])
+			if err != nil {
+				return "", fmt.Errorf("invalid A-label %q: %s", label, err)
+			}
+			if a, err := labelToASCII(u); err != nil || !strings.EqualFold(a, label) {
+				return "", fmt.Errorf("invalid A-label %q", label)
+			}
+		}
+		return label, nil
+	}
+
+	u := nfc(strings.ToLower(label))
+	if strings.HasPrefix(u, "-") || strings.HasSuffix(u, "-") || (len(u) >= 4 && u[2:4] == "--") {
+		return "", fmt.Errorf("invalid hyphens in %q", label)
+	}
+	for _, r := range u {
+		if r != '-' && !unicode.In(r, unicode.Ll, unicode.Lo, unicode.Lm, unicode.Nd, unicode.Mn, unicode.Mc) {
+			return "", fmt.Errorf("disallowed character U+%04X in %q", r, label)
+		}
+	}
+
+	a := acePrefix + punyEncode(u)
+	if len(a) > 63 {
+		return "", errors.New("label too long")
+	}
+
+	return a, nil
+}
+
+// Punycode parameters (RFC 3492 § 5)
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyThreshold(k, bias int) int {
+	switch {
+	case k <= bias:
+		return punyTMin
+	case k >= bias+punyTMax:
+		return punyTMax
+	}
+	return k - bias
+}
+
+// punyEncode encodes s with Punycode (RFC 3492 § 6.3)
+func punyEncode(s string) string {
+	runes := []rune(s)
+
+	var out []byte
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	b := len(out)
+	h := b
+	if b > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for h < len(runes) {
+		m := int(unicode.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+
+		delta += (m - n) * (h + 1)
+		n = m
+
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := punyThreshold(k, bias)
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+
+			bias = punyAdapt(delta, h+1, h == b)
+			delta = 0
+			h++
+		}
+
+		delta++
+		n++
+	}
+
+	return string(out)
+}
+
+// punyDecode decodes a Punycode string (RFC 3492 § 6.2)
+func punyDecode(s string) (string, error) {
+	var out []rune
+	pos := 0
+	if i := strings.LastIndexByte(s, '-'); i >= 0 {
+		for _, c := range s[:i] {
+			if c >= 0x80 {
+				return "", errors.New("non-ASCII basic code point")
+			}
+			out = append(out, c)
+		}
+		pos = i + 1
+	}
+
+	n, i, bias := punyInitialN, 0, punyInitialBias
+	for pos < len(s) {
+		oldi, w := i, 1
+		for k := punyBase; ; k += punyBase {
+			if pos >= len(s) {
+				return "", errors.New("truncated input")
+			}
+
+			var digit int
+			switch c := s[pos]; {
+			case c >= 'a' && c <= 'z':
+				digit = int(c - 'a')
+			case c >= 'A' && c <= 'Z':
+				digit = int(c - 'A')
+			case c >= '0' && c <= '9':
+				digit = int(c-'0') + 26
+			default:
+				return "", fmt.Errorf("invalid character %q", c)
+			}
+			pos++
+
+			if digit > (int(unicode.MaxRune)-i)/w {
+				return "", errors.New("overflow")
+			}
+			i += digit * w
+
+			t := punyThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			w *= punyBase - t
+		}
+
+		bias = punyAdapt(i-oldi, len(out)+1, oldi == 0)
+		n += i / (len(out) + 1)
+		i %= len(out) + 1
+		if n > unicode.MaxRune || (n >= 0xD800 && n <= 0xDFFF) {
+			return "", errors.New("invalid code point")
+		}
+
+		out = append(out[:i], append([]rune{rune(n)}, out[i:]...)...)
+		i++
+	}
+
+	return string(out), nil
+}
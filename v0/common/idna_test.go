@@ -0,0 +1,64 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPunycode(t *testing.T) {
+	var tests = []struct {
+		u, a string
+	}{
+		{"bücher", "bcher-kva"},
+		{"münchen", "mnchen-3ya"},
+		{"日本語", "wgv71a119e"},
+		{"3年b組金八先生", "3b-ww4c5e180e575a65lsy2b"},        // RFC 3492 § 7.1 (L), lower case
+		{"ليهمابتكلموشعربي؟", "egbpdaj6bu4bxfgehfvwxn"}, // (A)
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.a, punyEncode(tt.u), tt.u)
+		u, err := punyDecode(tt.a)
+		assert.NoError(t, err, tt.a)
+		assert.Equal(t, tt.u, u, tt.a)
+	}
+
+	for _, bad := range []string{"bcher-kv!", "bcher-k9999999999", "ü-kva"} {
+		_, err := punyDecode(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestHostnameToASCII(t *testing.T) {
+	var tests = []struct {
+		in, out string
+	}{
+		{"imap.example.com", "imap.example.com"},
+		{"IMAP.Example.COM", "IMAP.Example.COM"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"Bücher.example", "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"mail.日本語.jp", "mail.xn--wgv71a119e.jp"},
+
+		{"xn--bcher-kv!.example", ""},
+		{"xn--bcher.example", ""},
+		{"-bücher.example", ""},
+		{"bü cher.example", ""},
+		{"bü\u0000.example", ""},
+		{"\xff.example", ""},
+	}
+
+	for _, tt := range tests {
+		out, err := HostnameToASCII(tt.in)
+		if tt.out == "" {
+			assert.ErrorIs(t, err, ErrBadConfig, tt.in)
+		} else {
+			assert.NoError(t, err, tt.in)
+			assert.Equal(t, tt.out, out, tt.in)
+		}
+	}
+}
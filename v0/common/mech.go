@@ -4,32 +4,234 @@
 package common
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/golang-auth/go-sasl/pkg/loggable"
 )
 
 type MechProps struct {
-	MaxSSF             uint
-	SecurityProperties SecurityFlag
-	Fearures           Feature
+	MaxSSF             uint         `json:"max_ssf"`
+	SecurityProperties SecurityFlag `json:"security_properties"`
+	Fearures           Feature      `json:"features"` // misspelt;  renamed Features in v1, see docs/v1.md
+
+	// channel binding types the mech accepts;  empty means any
+	ChannelBindingTypes []string `json:"channel_binding_types,omitempty"`
+}
+
+// CanonicalJSON returns a JSON encoding of the properties with a stable field
+// order, so that identical properties always serialize identically
+func (p MechProps) CanonicalJSON() []byte {
+	// can't fail: the struct only contains integers and strings
+	b, _ := json.Marshal(p)
+	return b
+}
+
+// Hash returns a digest of the canonical form of the properties
+func (p MechProps) Hash() string {
+	return CanonicalHash(p.CanonicalJSON())
+}
+
+// CanonicalHash returns the hex encoded SHA-256 digest of a canonical
+// serialization.  Comparing hashes between instances is a cheap way to
+// detect configuration drift.
+func CanonicalHash(canonical []byte) string {
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// QOP is a set of SASL security layers, using the bit values of RFC 4752 § 3.3
+type QOP uint8
+
+const (
+	QOPNone QOP = 1 << iota
+	QOPIntegrity
+	QOPConfidentiality
+)
+
+func (q QOP) String() string {
+	var names []string
+	if q&QOPNone > 0 {
+		names = append(names, "none")
+	}
+	if q&QOPIntegrity > 0 {
+		names = append(names, "integrity")
+	}
+	if q&QOPConfidentiality > 0 {
+		names = append(names, "confidentiality")
+	}
+
+	return strings.Join(names, ", ")
 }
 
+// ContextParams describes an established context.  Fields that a mechanism
+// doesn't know about are left empty.
 type ContextParams struct {
+	Mech               string // set by the SASL client
 	SSF                uint
+	QOP                QOP // the negotiated security layer;  zero if the mech has none
 	MaxPeerMessageSize uint32
+	AuthzID            string    // identity to act as, if one was requested
+	AuthCID            string    // identity whose credentials were used
+	Expiry             time.Time // when to re-authenticate by, or zero if not known
+	MutualAuth         bool      // the server was authenticated too
 }
 
 type MechConfig struct {
+	// Context bounds the handshake;  mechs should pass it on to anything that
+	// talks to the network.  It is never nil when set by the SASL client.
+	Context        context.Context
 	Logger         loggable.Loggable
 	Service        string
 	ServerFQDN     string
+	ServerIsIP     bool // ServerFQDN is an IP address, see sasl.WithIPLiteralServer
+	Realm          string
 	MinSSF         uint
 	MaxSSF         uint
 	MaxBufSize     uint
+	ReplayDetect   bool // the security layer must detect replayed and reordered tokens
 	ExternalSSF    uint
+	ExternalAuthID string // identity established by the external layer
 	SecProps       SecurityFlag
 	HTTPMode       bool
 	ExtraProps     map[string]string
+	Options        MechOptions // the options for this mech, or nil
 	ChannelBinding *ChannelBinding
+	CBFlag         CBFlag // GS2 channel binding flag for mechs that send one
+	Prompter       SaslPrompt
+	TokenSource    TokenSource
+	ReauthCache    ReauthCache // nil if re-authentication state isn't kept
+	Preparation    Preparation // profiles for usernames and passwords
+}
+
+// ReauthKey returns the key that mech should use for its re-authentication
+// state
+func (c MechConfig) ReauthKey(mech string) string {
+	return mech + ":" + c.Service + "@" + c.ServerFQDN
+}
+
+// Prompt asks the application for information using the configured prompter
+func (c MechConfig) Prompt(p Prompt) ([]byte, error) {
+	if c.Prompter == nil {
+		return nil, fmt.Errorf("%s: %w", p.Type, ErrNoPromptHandler)
+	}
+
+	return c.Prompter.Prompt(p)
+}
+
+// ChooseRealm returns the realm that mech should authenticate in, given the
+// realms offered by the server (if any).  The configured realm is used if the
+// server offered it or didn't offer any;  otherwise the application is prompted to
+// choose.  If there is no realm prompt handler and the server offered exactly one
+// realm, that realm is used.
+func (c MechConfig) ChooseRealm(mech string, offered []string) (string, error) {
+	if c.Realm != "" {
+		if len(offered) == 0 {
+			return c.Realm, nil
+		}
+		for _, r := range offered {
+			if r == c.Realm {
+				return r, nil
+			}
+		}
+	}
+
+	answer, err := c.Prompt(Prompt{
+		Type:    PromptRealm,
+		Mech:    mech,
+		Message: "Realm: ",
+		Default: c.Realm,
+		Choices: offered,
+		Echo:    true,
+	})
+
+	switch {
+	case err == nil:
+		return string(answer), nil
+	case errors.Is(err, ErrNoPromptHandler) && len(offered) == 1 && c.Realm == "":
+		return offered[0], nil
+	case errors.Is(err, ErrNoPromptHandler) && len(offered) == 0:
+		return "", nil
+	}
+
+	return "", err
+}
+
+// Password prompts for the password to use with mech.  The caller should
+// Zero the result once it has been used.
+func (c MechConfig) Password(mech string) ([]byte, error) {
+	return c.Prompt(Prompt{
+		Type:    PromptPassword,
+		Mech:    mech,
+		Message: "Password: ",
+	})
+}
+
+// MechOptions is implemented by the typed options of a mechanism, eg.
+// gssapi.Options.  Validate is called when the client starts so that bad
+// values are reported before any exchange with the server.
+type MechOptions interface {
+	Mech() string
+	Validate() error
+}
+
+// LayerNegotiator is implemented by mechs that negotiate a security layer in
+// separate steps after authentication, such as GSSAPI
+type LayerNegotiator interface {
+	NegotiatingLayer() bool
+}
+
+// AppendCoder is implemented by mechs whose security layer can append its output
+// to a caller's buffer instead of allocating a new one for each message
+type AppendCoder interface {
+	EncodeTo(dst, input []byte) ([]byte, error)
+	DecodeTo(dst, inputToken []byte) ([]byte, error)
+}
+
+// ContextExporter is implemented by mechs whose security layer state can be
+// serialized, so that an established context can be moved to another process.
+// ExportContext may invalidate the mech.  ImportContext is called on a new mech
+// created from the same factory and leaves it established.
+type ContextExporter interface {
+	ExportContext() ([]byte, error)
+	ImportContext(state []byte) error
+}
+
+// StepStatus tells the caller what to do after a successful step
+type StepStatus int
+
+const (
+	// StepContinue: send outToken (if not nil) and pass the server's next
+	// message to Step.  When the server reports success, any additional data
+	// that came with it (or nil) is the next message.
+	StepContinue StepStatus = iota
+
+	// StepDone: the exchange is complete on the client side and there is
+	// nothing to send
+	StepDone
+
+	// StepDoneWithFinalToken: send outToken, which completes the exchange on
+	// the client side.  The server's response is the outcome.
+	StepDoneWithFinalToken
+)
+
+func (s StepStatus) String() string {
+	switch s {
+	case StepContinue:
+		return "continue"
+	case StepDone:
+		return "done"
+	case StepDoneWithFinalToken:
+		return "done with final token"
+	}
+
+	return "unknown"
 }
 
 type Mech interface {
@@ -37,7 +239,11 @@ type Mech interface {
 	MechProperties() MechProps
 	IsEstablished() bool
 	ContextParams() ContextParams
-	Step(inToken []byte) (outToken []byte, err error)
+	Step(inToken []byte) (outToken []byte, status StepStatus, err error)
 	Encode(input []byte) (outToken []byte, err error)
 	Decode(inputToken []byte) (output []byte, err error)
+
+	// Close releases the resources held by the mech and zeroes any keys or
+	// credentials that it can.  The mech can't be used afterwards.
+	Close() error
 }
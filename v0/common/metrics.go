@@ -0,0 +1,36 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import "time"
+
+// Metrics receives instrumentation from clients, eg. to export as expvar or
+// Prometheus counters.  Methods are called synchronously, possibly from several
+// clients at once, so implementations must be fast and safe for concurrent use.
+type Metrics interface {
+	// HandshakeStarted is called when an instance of mech is created
+	HandshakeStarted(mech string)
+
+	// HandshakeFinished is called when an exchange using mech succeeds, or
+	// fails with err, d after the exchange was started
+	HandshakeFinished(mech string, err error, d time.Duration)
+
+	// MechRejected is called for each mech that is passed over when a
+	// handshake starts
+	MechRejected(mech string, reason error)
+
+	// BytesWrapped and BytesUnwrapped count the application data passed
+	// through the security layer
+	BytesWrapped(mech string, n int)
+	BytesUnwrapped(mech string, n int)
+}
+
+// NopMetrics discards everything;  embed it to implement part of Metrics
+type NopMetrics struct{}
+
+func (NopMetrics) HandshakeStarted(string)                        {}
+func (NopMetrics) HandshakeFinished(string, error, time.Duration) {}
+func (NopMetrics) MechRejected(string, error)                     {}
+func (NopMetrics) BytesWrapped(string, int)                       {}
+func (NopMetrics) BytesUnwrapped(string, int)                     {}
@@ -0,0 +1,156 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NormalizeNFC applies Unicode normalization form C for the PRECIS profiles.
+// Like Normalize it is nil unless set by the application, usually to
+// norm.NFC.String, in which case strings are assumed to be normalized already.
+var NormalizeNFC func(string) string
+
+// StringProfile prepares a username or password before a mechanism uses it
+type StringProfile func(s string) (string, error)
+
+// Preparation chooses the profiles a mechanism applies to usernames and
+// passwords.  A nil profile means SASLprep, which the older SASL mechanisms
+// require;  servers that have moved to PRECIS (RFC 8265) expect
+// UsernameCaseMapped or UsernameCasePreserved and OpaqueString instead.
+type Preparation struct {
+	Username StringProfile
+	Password StringProfile
+}
+
+// PrecisPreparation uses the PRECIS profiles, mapping usernames to lower case
+var PrecisPreparation = Preparation{Username: UsernameCaseMapped, Password: OpaqueString}
+
+func (p Preparation) PrepareUsername(s string) (string, error) {
+	if p.Username == nil {
+		return SASLprep(s)
+	}
+	return p.Username(s)
+}
+
+func (p Preparation) PreparePassword(s string) (string, error) {
+	if p.Password == nil {
+		return SASLprep(s)
+	}
+	return p.Password(s)
+}
+
+// UsernameCaseMapped enforces the PRECIS profile of the same name (RFC 8265 §
+// 3.3): fullwidth characters are mapped to their ASCII equivalents and the
+// username is mapped to lower case.  Errors match ErrInvalidString.
+func UsernameCaseMapped(s string) (string, error) {
+	return username(s, true)
+}
+
+// UsernameCasePreserved enforces the PRECIS profile of the same name (RFC 8265
+// § 3.4), which is UsernameCaseMapped without the case mapping
+func UsernameCasePreserved(s string) (string, error) {
+	return username(s, false)
+}
+
+// OpaqueString enforces the PRECIS profile for passwords (RFC 8265 § 4.2):
+// non-ASCII spaces are mapped to SPACE and control characters are rejected.
+func OpaqueString(s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", fmt.Errorf("OpaqueString: invalid UTF-8: %w", ErrInvalidString)
+	}
+
+	out := strings.Map(func(r rune) rune {
+		if r != ' ' && unicode.Is(unicode.Zs, r) {
+			return ' '
+		}
+		return r
+	}, s)
+	out = nfc(out)
+
+	if out == "" {
+		return "", fmt.Errorf("OpaqueString: empty string: %w", ErrInvalidString)
+	}
+
+	for _, r := range out {
+		if !freeform(r) {
+			return "", fmt.Errorf("OpaqueString: disallowed character U+%04X: %w", r, ErrInvalidString)
+		}
+	}
+
+	return out, nil
+}
+
+func username(s string, caseMap bool) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", fmt.Errorf("username: invalid UTF-8: %w", ErrInvalidString)
+	}
+
+	// width mapping:  only the fullwidth forms of ASCII are known without the
+	// Unicode decomposition tables
+	out := strings.Map(func(r rune) rune {
+		if r >= 0xFF01 && r <= 0xFF5E {
+			r -= 0xFF01 - 0x21
+		}
+		if caseMap {
+			r = unicode.ToLower(r)
+		}
+		return r
+	}, s)
+	out = nfc(out)
+
+	if out == "" {
+		return "", fmt.Errorf("username: empty string: %w", ErrInvalidString)
+	}
+
+	var randAL, lCat bool
+	for _, r := range out {
+		if !identifier(r) {
+			return "", fmt.Errorf("username: disallowed character U+%04X: %w", r, ErrInvalidString)
+		}
+		randAL = randAL || tableRandAL.contains(r)
+		lCat = lCat || isLCat(r)
+	}
+
+	// the Bidi Rule (RFC 5893) is approximated with the stringprep tables
+	if randAL {
+		first, _ := utf8.DecodeRuneInString(out)
+		last, _ := utf8.DecodeLastRuneInString(out)
+		if lCat || !tableRandAL.contains(first) || !tableRandAL.contains(last) {
+			return "", fmt.Errorf("username: mixed or misplaced right-to-left characters: %w", ErrInvalidString)
+		}
+	}
+
+	return out, nil
+}
+
+func nfc(s string) string {
+	if NormalizeNFC != nil {
+		return NormalizeNFC(s)
+	}
+	return s
+}
+
+// identifier reports whether r is valid in the IdentifierClass (RFC 8264 §
+// 4.2): printable ASCII, or a letter, digit or combining mark
+func identifier(r rune) bool {
+	if r >= 0x21 && r <= 0x7E {
+		return true
+	}
+
+	return unicode.In(r, unicode.Ll, unicode.Lu, unicode.Lo, unicode.Lm, unicode.Nd, unicode.Mn, unicode.Mc)
+}
+
+// freeform reports whether r is valid in the FreeformClass (RFC 8264 § 4.3),
+// which adds spaces, symbols, punctuation and other letters and digits
+func freeform(r rune) bool {
+	if r >= 0x20 && r <= 0x7E {
+		return true
+	}
+
+	return unicode.In(r, unicode.L, unicode.M, unicode.N, unicode.P, unicode.S, unicode.Zs)
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrecisProfiles(t *testing.T) {
+	var tests = []struct {
+		profile StringProfile
+		in, out string
+	}{
+		// RFC 8265 § 3.5
+		{UsernameCaseMapped, "juliet@example.com", "juliet@example.com"},
+		{UsernameCaseMapped, "fussball", "fussball"},
+		{UsernameCaseMapped, "fußball", "fußball"},
+		{UsernameCaseMapped, "π", "π"},
+		{UsernameCaseMapped, "Σ", "σ"},
+		{UsernameCaseMapped, "σ", "σ"},
+		{UsernameCaseMapped, "ς", "ς"},
+		{UsernameCaseMapped, "foo bar", ""},
+		{UsernameCaseMapped, "", ""},
+		{UsernameCaseMapped, "henryⅣ", ""},
+		{UsernameCaseMapped, "♚", ""},
+
+		{UsernameCaseMapped, "Juliet", "juliet"},
+		{UsernameCaseMapped, "ＪＵＬＩＥＴ", "juliet"},
+		{UsernameCasePreserved, "Juliet", "Juliet"},
+		{UsernameCasePreserved, "ＪＵＬＩＥＴ", "JULIET"},
+		{UsernameCasePreserved, "ابج", "ابج"},
+		{UsernameCasePreserved, "aب", ""},
+
+		// RFC 8265 § 4.4
+		{OpaqueString, "correct horse battery staple", "correct horse battery staple"},
+		{OpaqueString, "Correct Horse Battery Staple", "Correct Horse Battery Staple"},
+		{OpaqueString, "πßå", "πßå"},
+		{OpaqueString, "Jack of ♦s", "Jack of ♦s"},
+		{OpaqueString, "foo\u1680bar", "foo bar"},
+		{OpaqueString, "", ""},
+		{OpaqueString, "my cat is a \u0009by", ""},
+	}
+
+	for _, tt := range tests {
+		out, err := tt.profile(tt.in)
+		if tt.out == "" {
+			assert.ErrorIs(t, err, ErrInvalidString, "%q", tt.in)
+		} else {
+			assert.NoError(t, err, "%q", tt.in)
+			assert.Equal(t, tt.out, out, "%q", tt.in)
+		}
+	}
+}
+
+func TestPreparation(t *testing.T) {
+	// SASLprep by default
+	out, err := Preparation{}.PrepareUsername("I­X")
+	assert.NoError(t, err)
+	assert.Equal(t, "IX", out)
+	out, err = Preparation{}.PreparePassword("pass word")
+	assert.NoError(t, err)
+	assert.Equal(t, "pass word", out)
+
+	out, err = PrecisPreparation.PrepareUsername("Juliet")
+	assert.NoError(t, err)
+	assert.Equal(t, "juliet", out)
+	_, err = PrecisPreparation.PreparePassword("\u0007")
+	assert.ErrorIs(t, err, ErrInvalidString)
+}
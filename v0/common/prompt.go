@@ -0,0 +1,75 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import "fmt"
+
+var ErrNoPromptHandler = classified(ErrNoCredentials, "no handler for prompt")
+
+type PromptType int
+
+const (
+	PromptUsername PromptType = iota + 1 // authentication identity
+	PromptAuthzID                        // authorization identity
+	PromptPassword                       // password or other secret
+	PromptRealm                          // authentication realm
+	PromptOTP                            // one-time password or passcode
+	PromptConfirm                        // yes/no confirmation
+)
+
+func (t PromptType) String() string {
+	switch t {
+	case PromptUsername:
+		return "username"
+	case PromptAuthzID:
+		return "authzid"
+	case PromptPassword:
+		return "password"
+	case PromptRealm:
+		return "realm"
+	case PromptOTP:
+		return "otp"
+	case PromptConfirm:
+		return "confirm"
+	}
+
+	return "unknown"
+}
+
+// Prompt describes a piece of information that a mechanism needs
+type Prompt struct {
+	Type      PromptType
+	Mech      string   // the mechanism asking
+	Message   string   // human-readable prompt, eg. "Password: "
+	Challenge string   // mechanism supplied context, eg. an OTP challenge
+	Default   string   // suggested answer, if any
+	Choices   []string // acceptable answers, eg. the realms offered by the server
+	Echo      bool     // whether the answer may be displayed as it is entered
+}
+
+// SaslPrompt is implemented by applications to supply information that
+// mechanisms ask for.  Answers are returned as byte slices so that secrets can
+// be zeroed once they have been used.  A PromptConfirm prompt is confirmed
+// by a non-empty answer.
+type SaslPrompt interface {
+	Prompt(p Prompt) (answer []byte, err error)
+}
+
+// PromptFunc allows an ordinary function to answer prompts
+type PromptFunc func(p Prompt) (answer []byte, err error)
+
+func (f PromptFunc) Prompt(p Prompt) ([]byte, error) {
+	return f(p)
+}
+
+// PromptHandlers answers each type of prompt with a separate function
+type PromptHandlers map[PromptType]PromptFunc
+
+func (h PromptHandlers) Prompt(p Prompt) ([]byte, error) {
+	if f, ok := h[p.Type]; ok && f != nil {
+		return f(p)
+	}
+
+	return nil, fmt.Errorf("%s: %w", p.Type, ErrNoPromptHandler)
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// ReauthCache holds state from successful exchanges that lets a mechanism
+// authenticate to the same server again in fewer round trips or without
+// fetching new credentials.  The state may be as sensitive as a password.
+//
+// Entries are keyed by mechanism and server (see MechConfig.ReauthKey) but not
+// by user, so a cache must not be shared by clients acting for different users.
+type ReauthCache interface {
+	// Get returns a copy of the state stored under key, if it hasn't expired
+	Get(key string) (state []byte, ok bool)
+
+	// Put stores a copy of state;  a zero expiry means it doesn't expire
+	Put(key string, state []byte, expiry time.Time)
+
+	Delete(key string)
+}
+
+type reauthEntry struct {
+	state  *Secret
+	expiry time.Time
+}
+
+// MemoryReauthCache is a ReauthCache that is safe for concurrent use by many
+// clients in the same process
+type MemoryReauthCache struct {
+	mu      sync.Mutex
+	entries map[string]reauthEntry
+	now     func() time.Time
+}
+
+func NewMemoryReauthCache() *MemoryReauthCache {
+	return &MemoryReauthCache{
+		entries: make(map[string]reauthEntry),
+		now:     time.Now,
+	}
+}
+
+func (c *MemoryReauthCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if !e.expiry.IsZero() && !c.now().Before(e.expiry) {
+		e.state.Zero()
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	return e.state.Bytes(), true
+}
+
+func (c *MemoryReauthCache) Put(key string, state []byte, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.entries[key]; ok {
+		old.state.Zero()
+	}
+
+	c.entries[key] = reauthEntry{state: NewSecret(state), expiry: expiry}
+}
+
+func (c *MemoryReauthCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.state.Zero()
+		delete(c.entries, key)
+	}
+}
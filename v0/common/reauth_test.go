@@ -0,0 +1,37 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryReauthCache(t *testing.T) {
+	cache := NewMemoryReauthCache()
+	now := time.Unix(1000, 0)
+	cache.now = func() time.Time { return now }
+
+	state := []byte("state")
+	cache.Put("k", state, now.Add(time.Minute))
+	cache.Put("forever", state, time.Time{})
+
+	// the cache keeps its own copy
+	Zero(state)
+	got, ok := cache.Get("k")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("state"), got)
+
+	now = now.Add(time.Minute)
+	_, ok = cache.Get("k")
+	assert.False(t, ok)
+	_, ok = cache.Get("forever")
+	assert.True(t, ok)
+
+	cache.Delete("forever")
+	_, ok = cache.Get("forever")
+	assert.False(t, ok)
+}
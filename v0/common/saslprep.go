@@ -0,0 +1,157 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Normalize applies Unicode normalization form KC for SASLprep.  The standard
+// library has no normalization, so it is nil unless set by the application,
+// usually to norm.NFKC.String from golang.org/x/text/unicode/norm.  When nil,
+// strings are assumed to be normalized already, which holds for ASCII and for
+// most text entered on modern systems.
+var Normalize func(string) string
+
+// runeRange is an inclusive range of code points from RFC 3454
+type runeRange struct {
+	lo, hi rune
+}
+
+type runeTable []runeRange
+
+func (t runeTable) contains(r rune) bool {
+	for _, rr := range t {
+		if r < rr.lo {
+			return false
+		}
+		if r <= rr.hi {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// B.1: commonly mapped to nothing
+	tableB1 = runeTable{{0x00AD, 0x00AD}, {0x034F, 0x034F}, {0x1806, 0x1806}, {0x180B, 0x180D},
+		{0x200B, 0x200D}, {0x2060, 0x2060}, {0xFE00, 0xFE0F}, {0xFEFF, 0xFEFF}}
+
+	// C.1.2: non-ASCII space characters
+	tableC12 = runeTable{{0x00A0, 0x00A0}, {0x1680, 0x1680}, {0x2000, 0x200B}, {0x202F, 0x202F},
+		{0x205F, 0x205F}, {0x3000, 0x3000}}
+
+	// C.2.1 to C.9, less C.1.2 which is mapped to SPACE before checking.
+	// Surrogates (C.5) can't appear in valid UTF-8.
+	tableProhibited = runeTable{
+		{0x0000, 0x001F}, {0x007F, 0x009F}, {0x0340, 0x0341}, {0x06DD, 0x06DD}, {0x070F, 0x070F},
+		{0x180E, 0x180E}, {0x200C, 0x200F}, {0x2028, 0x202E}, {0x2060, 0x2063}, {0x206A, 0x206F},
+		{0x2FF0, 0x2FFB}, {0xE000, 0xF8FF}, {0xFDD0, 0xFDEF}, {0xFEFF, 0xFEFF}, {0xFFF9, 0xFFFF},
+		{0x1D173, 0x1D17A}, {0xE0001, 0xE0001}, {0xE0020, 0xE007F}, {0xF0000, 0x10FFFF},
+	}
+
+	// D.1: characters with bidirectional property R or AL
+	tableRandAL = runeTable{
+		{0x05BE, 0x05BE}, {0x05C0, 0x05C0}, {0x05C3, 0x05C3}, {0x05D0, 0x05EA}, {0x05F0, 0x05F4},
+		{0x061B, 0x061B}, {0x061F, 0x061F}, {0x0621, 0x063A}, {0x0640, 0x064A}, {0x066D, 0x066F},
+		{0x0671, 0x06D5}, {0x06DD, 0x06DD}, {0x06E5, 0x06E6}, {0x06FA, 0x06FE}, {0x0700, 0x070D},
+		{0x0710, 0x0710}, {0x0712, 0x072C}, {0x0780, 0x07A5}, {0x07B1, 0x07B1}, {0x200F, 0x200F},
+		{0xFB1D, 0xFB1D}, {0xFB1F, 0xFB28}, {0xFB2A, 0xFB36}, {0xFB38, 0xFB3C}, {0xFB3E, 0xFB3E},
+		{0xFB40, 0xFB41}, {0xFB43, 0xFB44}, {0xFB46, 0xFBB1}, {0xFBD3, 0xFD3D}, {0xFD50, 0xFD8F},
+		{0xFD92, 0xFDC7}, {0xFDF0, 0xFDFC}, {0xFE70, 0xFE74}, {0xFE76, 0xFEFC},
+	}
+)
+
+// noncharacters in planes 1 to 14 (C.4);  planes 15 and 16 are private use
+func isNonCharacter(r rune) bool {
+	return r&0xFFFE == 0xFFFE
+}
+
+// isLCat approximates table D.2, characters with bidirectional property L,
+// from the general categories known to the unicode package
+func isLCat(r rune) bool {
+	return unicode.In(r, unicode.L, unicode.Mc) && !tableRandAL.contains(r)
+}
+
+// isAssigned approximates the complement of table A.1 with the Unicode version
+// of the unicode package, so code points assigned after Unicode 3.2 are allowed
+func isAssigned(r rune) bool {
+	return unicode.In(r, unicode.L, unicode.M, unicode.N, unicode.P, unicode.S, unicode.Z, unicode.Cc, unicode.Cf)
+}
+
+// SASLprep prepares a username or password with the SASLprep profile of
+// stringprep (RFC 4013) for a stored string, such as a credential sent by a
+// client: unassigned code points are rejected.  Errors match ErrInvalidString.
+func SASLprep(s string) (string, error) {
+	return saslprep(s, false)
+}
+
+// SASLprepQuery is like SASLprep but allows unassigned code points, for
+// strings that are only compared with stored strings
+func SASLprepQuery(s string) (string, error) {
+	return saslprep(s, true)
+}
+
+func saslprep(s string, allowUnassigned bool) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", fmt.Errorf("SASLprep: invalid UTF-8: %w", ErrInvalidString)
+	}
+
+	// fast path: printable ASCII is unchanged
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7E {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s, nil
+	}
+
+	// mapping (RFC 4013 § 2.1)
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case tableC12.contains(r):
+			b.WriteRune(' ')
+		case tableB1.contains(r):
+		default:
+			b.WriteRune(r)
+		}
+	}
+	out := b.String()
+
+	// normalization (§ 2.2)
+	if Normalize != nil {
+		out = Normalize(out)
+	}
+
+	// prohibited output and unassigned code points (§ 2.3, 2.5)
+	var randAL, lCat bool
+	for _, r := range out {
+		if tableProhibited.contains(r) || isNonCharacter(r) {
+			return "", fmt.Errorf("SASLprep: prohibited character U+%04X: %w", r, ErrInvalidString)
+		}
+		if !allowUnassigned && !isAssigned(r) {
+			return "", fmt.Errorf("SASLprep: unassigned code point U+%04X: %w", r, ErrInvalidString)
+		}
+		randAL = randAL || tableRandAL.contains(r)
+		lCat = lCat || isLCat(r)
+	}
+
+	// bidirectional characters (§ 2.4, RFC 3454 § 6)
+	if randAL {
+		first, _ := utf8.DecodeRuneInString(out)
+		last, _ := utf8.DecodeLastRuneInString(out)
+		if lCat || !tableRandAL.contains(first) || !tableRandAL.contains(last) {
+			return "", fmt.Errorf("SASLprep: mixed or misplaced right-to-left characters: %w", ErrInvalidString)
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSASLprep(t *testing.T) {
+	// RFC 4013 § 3, the NFKC examples need a normalizer
+	defer func() { Normalize = nil }()
+	Normalize = strings.NewReplacer("ª", "a", "Ⅸ", "IX").Replace
+
+	var tests = []struct {
+		in, out string
+	}{
+		{"I­X", "IX"},
+		{"user", "user"},
+		{"USER", "USER"},
+		{"ª", "a"},
+		{"Ⅸ", "IX"},
+		{"\u0007", ""},
+		{"ا1", ""},
+
+		{"pass word", "pass word"},
+		{"pa​ss", "pa ss"},
+		{"café", "café"},
+		{"ا1ب", "ا1ب"},
+		{"aا", ""},
+		{"‮pass", ""},
+		{"", ""},
+		{"\U000E0041", ""},
+		{"￾", ""},
+		{"\xff", ""},
+	}
+
+	for _, tt := range tests {
+		out, err := SASLprep(tt.in)
+		if tt.out == "" {
+			assert.ErrorIs(t, err, ErrInvalidString, "%q", tt.in)
+			assert.ErrorIs(t, err, ErrBadConfig, "%q", tt.in)
+		} else {
+			assert.NoError(t, err, "%q", tt.in)
+			assert.Equal(t, tt.out, out, "%q", tt.in)
+		}
+	}
+
+	// unassigned code points are only allowed in queries
+	_, err := SASLprep("a͸")
+	assert.ErrorIs(t, err, ErrInvalidString)
+	out, err := SASLprepQuery("a͸")
+	assert.NoError(t, err)
+	assert.Equal(t, "a͸", out)
+
+	out, err = SASLprep("")
+	assert.NoError(t, err)
+	assert.Equal(t, "", out)
+}
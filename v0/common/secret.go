@@ -0,0 +1,58 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+// Secret holds a password or key that should be wiped from memory once it is
+// no longer needed.  The secret is never handed out directly: Bytes returns a
+// copy that the caller is responsible for zeroing.
+type Secret struct {
+	data []byte
+}
+
+// NewSecret returns a Secret holding a copy of data
+func NewSecret(data []byte) *Secret {
+	s := &Secret{data: make([]byte, len(data))}
+	copy(s.data, data)
+
+	return s
+}
+
+// Bytes returns a copy of the secret, or nil if it has been zeroed
+func (s *Secret) Bytes() []byte {
+	if s == nil || s.data == nil {
+		return nil
+	}
+
+	b := make([]byte, len(s.data))
+	copy(b, s.data)
+
+	return b
+}
+
+// String stops the secret being revealed by fmt or a logger
+func (s Secret) String() string {
+	return "[REDACTED]"
+}
+
+// GoString is String for the %#v verb
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// Zero overwrites the secret and releases it
+func (s *Secret) Zero() {
+	if s == nil {
+		return
+	}
+
+	Zero(s.data)
+	s.data = nil
+}
+
+// Zero overwrites b with zeros
+func Zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretRedacted(t *testing.T) {
+	s := NewSecret([]byte("hunter2"))
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s"} {
+		assert.Equal(t, "[REDACTED]", fmt.Sprintf(verb, s), verb)
+		assert.Equal(t, "[REDACTED]", fmt.Sprintf(verb, *s), verb)
+	}
+	assert.Equal(t, []byte("hunter2"), s.Bytes())
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	// hashes used for tls-server-end-point
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// TLS channel binding types
+const (
+	CBTLSUnique         = "tls-unique"           // RFC 5929 § 3
+	CBTLSServerEndPoint = "tls-server-end-point" // RFC 5929 § 4
+	CBTLSExporter       = "tls-exporter"         // RFC 9266
+)
+
+// ChannelBindingFromTLS returns the channel binding of type typ for a TLS
+// connection whose handshake is complete.  tls-unique is not defined for TLS
+// 1.3, nor tls-exporter for TLS 1.2 without the extended master secret.
+func ChannelBindingFromTLS(cs tls.ConnectionState, typ string) (ChannelBinding, error) {
+	if !cs.HandshakeComplete {
+		return ChannelBinding{}, fmt.Errorf("%s: TLS handshake not complete: %w", typ, ErrNoChannelBinding)
+	}
+
+	var data []byte
+	switch typ {
+	case CBTLSUnique:
+		if cs.Version >= tls.VersionTLS13 || len(cs.TLSUnique) == 0 {
+			return ChannelBinding{}, fmt.Errorf("%s: not defined for this connection: %w", typ, ErrNoChannelBinding)
+		}
+		data = append([]byte(nil), cs.TLSUnique...)
+
+	case CBTLSServerEndPoint:
+		if len(cs.PeerCertificates) == 0 {
+			return ChannelBinding{}, fmt.Errorf("%s: no server certificate: %w", typ, ErrNoChannelBinding)
+		}
+
+		cert := cs.PeerCertificates[0]
+		hash, err := endPointHash(cert.SignatureAlgorithm)
+		if err != nil {
+			return ChannelBinding{}, fmt.Errorf("%s: %s: %w", typ, err, ErrNoChannelBinding)
+		}
+
+		h := hash.New()
+		h.Write(cert.Raw)
+		data = h.Sum(nil)
+
+	case CBTLSExporter:
+		var err error
+		if data, err = cs.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32); err != nil {
+			return ChannelBinding{}, fmt.Errorf("%s: %s: %w", typ, err, ErrNoChannelBinding)
+		}
+
+	default:
+		return ChannelBinding{}, fmt.Errorf("unknown channel binding type %q: %w", typ, ErrNoChannelBinding)
+	}
+
+	return ChannelBinding{Name: typ, Data: data, TLSVersion: cs.Version}, nil
+}
+
+// endPointHash returns the hash for a tls-server-end-point binding: that of
+// the certificate's signature algorithm, except that MD5 and SHA-1 are
+// replaced by SHA-256 (RFC 5929 § 4.1)
+func endPointHash(alg x509.SignatureAlgorithm) (crypto.Hash, error) {
+	switch alg {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1,
+		x509.SHA256WithRSA, x509.SHA256WithRSAPSS, x509.DSAWithSHA256, x509.ECDSAWithSHA256:
+		return crypto.SHA256, nil
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		return crypto.SHA384, nil
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		return crypto.SHA512, nil
+	}
+
+	// eg. Ed25519, which has no separate hash
+	return 0, fmt.Errorf("no hash defined for signature algorithm %s", alg)
+}
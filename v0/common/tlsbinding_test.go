@@ -0,0 +1,132 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// tlsPair performs a handshake over a pipe and returns the client and server
+// connection states
+func tlsPair(t *testing.T, cert tls.Certificate, version uint16) (client, server tls.ConnectionState) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	cli := tls.Client(c, &tls.Config{InsecureSkipVerify: true, MinVersion: version, MaxVersion: version})
+	srv := tls.Server(s, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: version, MaxVersion: version})
+
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Handshake() }()
+	assert.NoError(t, cli.Handshake())
+	assert.NoError(t, <-errs)
+
+	return cli.ConnectionState(), srv.ConnectionState()
+}
+
+func TestChannelBindingFromTLS(t *testing.T) {
+	cert := testCert(t)
+
+	cs12, ss12 := tlsPair(t, cert, tls.VersionTLS12)
+	cs13, ss13 := tlsPair(t, cert, tls.VersionTLS13)
+
+	// both ends agree
+	cb, err := ChannelBindingFromTLS(cs12, CBTLSUnique)
+	assert.NoError(t, err)
+	assert.Equal(t, CBTLSUnique, cb.Name)
+	assert.Equal(t, ss12.TLSUnique, cb.Data)
+
+	for _, pair := range [][2]tls.ConnectionState{{cs12, ss12}, {cs13, ss13}} {
+		cb, err = ChannelBindingFromTLS(pair[0], CBTLSExporter)
+		assert.NoError(t, err)
+		assert.Len(t, cb.Data, 32)
+		srv, err := ChannelBindingFromTLS(pair[1], CBTLSExporter)
+		assert.NoError(t, err)
+		assert.Equal(t, srv.Data, cb.Data)
+	}
+
+	// ECDSA with SHA-256
+	sum := sha256.Sum256(cert.Certificate[0])
+	cb, err = ChannelBindingFromTLS(cs13, CBTLSServerEndPoint)
+	assert.NoError(t, err)
+	assert.Equal(t, CBTLSServerEndPoint, cb.Name)
+	assert.Equal(t, sum[:], cb.Data)
+
+	_, err = ChannelBindingFromTLS(cs13, CBTLSUnique)
+	assert.ErrorIs(t, err, ErrNoChannelBinding)
+	_, err = ChannelBindingFromTLS(cs13, "tls-bogus")
+	assert.ErrorIs(t, err, ErrNoChannelBinding)
+	_, err = ChannelBindingFromTLS(tls.ConnectionState{}, CBTLSExporter)
+	assert.ErrorIs(t, err, ErrNoChannelBinding)
+
+	// the server has no peer certificate
+	_, err = ChannelBindingFromTLS(ss13, CBTLSServerEndPoint)
+	assert.ErrorIs(t, err, ErrNoChannelBinding)
+}
+
+func TestEndPointHash(t *testing.T) {
+	for _, alg := range []x509.SignatureAlgorithm{x509.MD5WithRSA, x509.SHA1WithRSA, x509.ECDSAWithSHA256} {
+		h, err := endPointHash(alg)
+		assert.NoError(t, err)
+		assert.Equal(t, crypto.SHA256, h)
+	}
+
+	h, err := endPointHash(x509.SHA384WithRSAPSS)
+	assert.NoError(t, err)
+	assert.Equal(t, crypto.SHA384, h)
+
+	_, err = endPointHash(x509.PureEd25519)
+	assert.Error(t, err)
+}
+
+func TestChannelBindingValidate(t *testing.T) {
+	cs13, _ := tlsPair(t, testCert(t), tls.VersionTLS13)
+	cb, err := ChannelBindingFromTLS(cs13, CBTLSExporter)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), cb.TLSVersion)
+	assert.NoError(t, cb.Validate())
+
+	cb.Name = CBTLSUnique
+	assert.ErrorIs(t, cb.Validate(), ErrNoChannelBinding)
+
+	// the version may not be known
+	assert.NoError(t, ChannelBinding{Name: CBTLSUnique}.Validate())
+	assert.ErrorIs(t, ChannelBinding{Data: []byte{1}}.Validate(), ErrNoChannelBinding)
+}
+
+func TestSupportsChannelBinding(t *testing.T) {
+	assert.False(t, MechProps{}.SupportsChannelBinding(CBTLSUnique))
+	assert.True(t, MechProps{Fearures: FeatChannelBindings}.SupportsChannelBinding(CBTLSUnique))
+
+	props := MechProps{Fearures: FeatChannelBindings, ChannelBindingTypes: []string{CBTLSExporter}}
+	assert.True(t, props.SupportsChannelBinding(CBTLSExporter))
+	assert.False(t, props.SupportsChannelBinding(CBTLSUnique))
+}
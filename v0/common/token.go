@@ -0,0 +1,56 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import "time"
+
+// Token is an OAuth 2.0 access token.  The fields match those of
+// golang.org/x/oauth2.Token so converting between the two is trivial.
+type Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+	Expiry       time.Time // zero if the token doesn't expire
+}
+
+// Valid reports whether the token is set and won't expire in the next ten
+// seconds
+func (t *Token) Valid() bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+
+	return t.Expiry.IsZero() || time.Now().Add(10*time.Second).Before(t.Expiry)
+}
+
+// TokenSource supplies access tokens to mechanisms such as OAUTHBEARER.  It
+// has the same shape as golang.org/x/oauth2.TokenSource;  an oauth2 token
+// source can be adapted with a TokenSourceFunc:
+//
+//	sasl.WithTokenSource(common.TokenSourceFunc(func() (*common.Token, error) {
+//		t, err := ts.Token()
+//		if err != nil {
+//			return nil, err
+//		}
+//		return &common.Token{AccessToken: t.AccessToken, TokenType: t.TokenType, Expiry: t.Expiry}, nil
+//	}))
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+// TokenSourceFunc allows an ordinary function to be used as a TokenSource
+type TokenSourceFunc func() (*Token, error)
+
+func (f TokenSourceFunc) Token() (*Token, error) {
+	return f()
+}
+
+// TokenInvalidator is implemented by token sources that cache tokens.  Mechs
+// call Invalidate when the server rejects a token so that the next call to
+// Token fetches a new one.  Invalidate returns true if authenticating again is
+// worthwhile, ie. if the rejected token was not itself the result of a forced
+// refresh.
+type TokenInvalidator interface {
+	Invalidate() (retry bool)
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package common
+
+import "context"
+
+// Tracer creates spans around client operations.  It has the same shape as an
+// OpenTelemetry trace.Tracer so an adapter only needs to convert attributes,
+// without this module depending on OpenTelemetry.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is an operation started by a Tracer
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Attribute is a key/value pair attached to a span.  Values are strings, ints
+// or bools.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// span attribute keys
+const (
+	AttrService = "sasl.service"
+	AttrMech    = "sasl.mech"
+	AttrStep    = "sasl.step"
+	AttrOutcome = "sasl.outcome" // continue, success or failure
+	AttrSSF     = "sasl.ssf"
+	AttrBytes   = "sasl.bytes"
+)
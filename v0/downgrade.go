@@ -0,0 +1,70 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrDowngrade matches any *DowngradeError
+var ErrDowngrade = errors.New("possible mechanism downgrade")
+
+// DowngradeError reports mechanisms that a server stopped advertising in a way
+// that suggests an attacker is stripping the stronger ones
+type DowngradeError struct {
+	Removed []string // suspicious removals, in the order they were advertised
+}
+
+func (e *DowngradeError) Error() string {
+	return ErrDowngrade.Error() + ": server no longer offers " + strings.Join(e.Removed, ", ")
+}
+
+func (e *DowngradeError) Is(target error) bool {
+	return target == ErrDowngrade
+}
+
+// CheckDowngrade compares the mechanisms a server advertised in a more trusted
+// setting (eg. after STARTTLS, or on a previous connection) with those it
+// advertises now, and returns a *DowngradeError if a channel binding (-PLUS)
+// variant was removed, or if a mechanism that the client supports was removed
+// and is stronger than every mechanism that remains.  Mechs that are added, or
+// removed while something at least as strong remains, are not reported.
+func (c SaslClient) CheckDowngrade(trusted, current []string) error {
+	now := make(map[string]bool, len(current))
+	for _, name := range current {
+		now[strings.ToUpper(strings.TrimSpace(name))] = true
+	}
+
+	// the strongest mech still on offer, by the client's own measure
+	best := -1
+	for name := range now {
+		if c.registry.IsRegistered(name) {
+			if score := StrongestPolicy(name, c.registry.Properties(name)); score > best {
+				best = score
+			}
+		}
+	}
+
+	var removed []string
+	for _, name := range trusted {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if now[name] {
+			continue
+		}
+
+		switch {
+		case strings.HasSuffix(name, "-PLUS"):
+			removed = append(removed, name)
+		case c.registry.IsRegistered(name) && StrongestPolicy(name, c.registry.Properties(name)) > best:
+			removed = append(removed, name)
+		}
+	}
+
+	if len(removed) == 0 {
+		return nil
+	}
+
+	return &DowngradeError{Removed: removed}
+}
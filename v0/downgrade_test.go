@@ -0,0 +1,31 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDowngrade(t *testing.T) {
+	cli, err := NewSaslClient("imap", WithRegistry(selectionRegistry()))
+	assert.NoError(t, err)
+
+	// new mechs after STARTTLS are fine, as is losing a weaker one
+	assert.NoError(t, cli.CheckDowngrade([]string{"SEL-DES"}, []string{"SEL-DES", "SEL-AES", "PLAIN"}))
+	assert.NoError(t, cli.CheckDowngrade([]string{"SEL-AES", "SEL-DES"}, []string{"sel-aes"}))
+
+	// unknown mechs don't count, unless they are channel binding variants
+	assert.NoError(t, cli.CheckDowngrade([]string{"SEL-DES", "X-UNKNOWN"}, []string{"SEL-DES"}))
+	err = cli.CheckDowngrade([]string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256", "SEL-DES"}, []string{"SCRAM-SHA-256", "SEL-DES"})
+	assert.ErrorIs(t, err, ErrDowngrade)
+	var dgErr *DowngradeError
+	assert.ErrorAs(t, err, &dgErr)
+	assert.Equal(t, []string{"SCRAM-SHA-256-PLUS"}, dgErr.Removed)
+
+	// the strongest mechs were stripped
+	err = cli.CheckDowngrade([]string{"SEL-AES2", "SEL-AES", "SEL-DES", "SEL-NONE"}, []string{"SEL-DES", "SEL-NONE"})
+	assert.EqualError(t, err, "possible mechanism downgrade: server no longer offers SEL-AES2, SEL-AES")
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// Environment variables read by NewSaslClient, so operators can adjust the
+// behaviour of compiled tools.  The security settings can only be tightened:
+// the mech list is limited to the mechs the application allows, the minimum
+// SSF can only be raised and the maximum SSF only lowered.
+const (
+	EnvMechs      = "SASL_MECHS"       // mech list, separated by commas or spaces
+	EnvMinSSF     = "SASL_MIN_SSF"     // minimum security strength factor, if higher
+	EnvMaxSSF     = "SASL_MAX_SSF"     // maximum security strength factor, if lower
+	EnvServerFQDN = "SASL_SERVER_FQDN" // server host name override
+	EnvDebug      = "SASL_DEBUG"       // log to stderr: debug, info, warn or error and above
+)
+
+// WithoutEnvironment stops NewSaslClient reading the SASL_* environment
+// variables, for applications that must not be reconfigured that way
+func WithoutEnvironment() SaslClientOption {
+	return func(c *SaslClient) error {
+		c.ignoreEnv = true
+		return nil
+	}
+}
+
+// applyEnv applies the settings from the environment
+func (c *SaslClient) applyEnv() error {
+	if c.ignoreEnv {
+		return nil
+	}
+
+	if v, ok := os.LookupEnv(EnvMechs); ok {
+		if err := c.applyEnvMechs(v); err != nil {
+			return err
+		}
+	}
+
+	if n, ok, err := envUint(EnvMinSSF); err != nil {
+		return err
+	} else if ok && uint(n) > c.minSSF {
+		c.minSSF = uint(n)
+	}
+	if n, ok, err := envUint(EnvMaxSSF); err != nil {
+		return err
+	} else if ok && uint(n) < c.maxSSF {
+		c.maxSSF = uint(n)
+	}
+	if c.minSSF > c.maxSSF {
+		return fmt.Errorf("minimum SSF %d is more than the maximum %d: %w", c.minSSF, c.maxSSF, common.ErrBadConfig)
+	}
+
+	if v, ok := os.LookupEnv(EnvServerFQDN); ok {
+		if err := WithServerFQDN(v)(c); err != nil {
+			return fmt.Errorf("%s: %s: %w", EnvServerFQDN, err, common.ErrBadConfig)
+		}
+	}
+
+	if v, ok := os.LookupEnv(EnvDebug); ok {
+		return c.applyEnvDebug(v)
+	}
+
+	return nil
+}
+
+// applyEnvMechs limits the mech list to the mechs named in v, in that order.  If
+// the application chose mechs itself, the others are dropped.
+func (c *SaslClient) applyEnvMechs(v string) error {
+	allowed := make(map[string]bool, len(c.mechList))
+	for _, name := range c.mechList {
+		allowed[strings.ToUpper(name)] = true
+	}
+
+	var mechs []string
+	for _, name := range strings.FieldsFunc(strings.ToUpper(v), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		if len(allowed) == 0 || allowed[name] {
+			mechs = append(mechs, name)
+		}
+	}
+
+	if len(mechs) == 0 {
+		return fmt.Errorf("%s: none of %q are allowed: %w", EnvMechs, v, common.ErrNoMech)
+	}
+	c.mechList = mechs
+	return nil
+}
+
+// envUint returns the value of the named variable, if it is set
+func envUint(name string) (uint64, bool, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return 0, false, nil
+	}
+
+	n, err := strconv.ParseUint(v, 10, 0)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s: %s: %w", name, err, common.ErrBadConfig)
+	}
+	return n, true, nil
+}
+
+// applyEnvDebug logs messages at level and above to stderr
+func (c *SaslClient) applyEnvDebug(level string) error {
+	opts := []func(*log.Logger) SaslClientOption{WithDebugLogger, WithInfoLogger, WithWarnLogger, WithErrorLogger}
+	levels := []string{"debug", "info", "warn", "error"}
+
+	for i, l := range levels {
+		if !strings.EqualFold(level, l) {
+			continue
+		}
+
+		logger := log.New(os.Stderr, "sasl: ", log.LstdFlags)
+		for _, opt := range opts[i:] {
+			if err := opt(logger)(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%s: unknown level %q: %w", EnvDebug, level, common.ErrBadConfig)
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"os"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/golang-auth/go-sasl/saslmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// setenv sets vars and returns a function that unsets them again
+func setenv(vars map[string]string) func() {
+	for k, v := range vars {
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k := range vars {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	r := registry.New()
+	for _, name := range []string{"ENV-A", "ENV-B", "ENV-C"} {
+		assert.NoError(t, saslmock.New(name, saslmock.WithProps(common.MechProps{})).Register(r))
+	}
+
+	defer setenv(map[string]string{
+		EnvMechs:      "env-c, ENV-A",
+		EnvMinSSF:     "56",
+		EnvMaxSSF:     "256",
+		EnvServerFQDN: "imap.example.com",
+	})()
+
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMinSSF(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ENV-C", "ENV-A"}, cli.mechList)
+	assert.Equal(t, uint(56), cli.minSSF)
+	assert.Equal(t, uint(256), cli.maxSSF)
+	assert.Equal(t, "imap.example.com", cli.serverFQDN)
+
+	// the environment can't loosen the application's settings
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"ENV-A", "ENV-B"}), WithMinSSF(128), WithMaxSSF(200))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ENV-A"}, cli.mechList)
+	assert.Equal(t, uint(128), cli.minSSF)
+	assert.Equal(t, uint(200), cli.maxSSF)
+
+	defer setenv(map[string]string{EnvMinSSF: "0", EnvMaxSSF: "56"})()
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMinSSF(56))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(56), cli.minSSF)
+	assert.Equal(t, uint(56), cli.maxSSF)
+
+	// nor leave nothing to choose from
+	_, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"ENV-B"}))
+	assert.ErrorIs(t, err, common.ErrNoMech)
+
+	// the application can opt out
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"ENV-B"}), WithoutEnvironment())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ENV-B"}, cli.mechList)
+	assert.Equal(t, uint(0), cli.minSSF)
+	assert.Equal(t, "", cli.serverFQDN)
+}
+
+func TestEnvironmentErrors(t *testing.T) {
+	r := registry.New()
+	assert.NoError(t, saslmock.New("ENV-A", saslmock.WithProps(common.MechProps{})).Register(r))
+
+	for _, vars := range []map[string]string{
+		{EnvMinSSF: "high"},
+		{EnvMaxSSF: "-1"},
+		{EnvMinSSF: "256", EnvMaxSSF: "56"},
+		{EnvServerFQDN: "invalid-.hostname"},
+		{EnvDebug: "verbose"},
+	} {
+		unset := setenv(vars)
+		_, err := NewSaslClient("imap", WithRegistry(r))
+		assert.ErrorIs(t, err, common.ErrBadConfig, vars)
+		unset()
+	}
+
+	// levels are case insensitive
+	defer setenv(map[string]string{EnvDebug: "WARN"})()
+	_, err := NewSaslClient("imap", WithRegistry(r))
+	assert.NoError(t, err)
+}
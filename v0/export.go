@@ -0,0 +1,159 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+const exportVersion = 1
+
+type exportedContext struct {
+	Version    int                  `json:"version"`
+	Service    string               `json:"service"`
+	ServerFQDN string               `json:"server_fqdn"`
+	Mech       string               `json:"mech"`
+	Params     common.ContextParams `json:"params"`
+	MechState  []byte               `json:"mech_state,omitempty"`
+}
+
+// ExportContext serializes an established context so that it can be passed to
+// another process and restored with ImportContext, eg. along with the
+// connection's file descriptor.  Contexts without a security layer can always
+// be exported;  otherwise the mechanism must implement common.ContextExporter.
+//
+// The output of a context with a security layer contains key material and must
+// be protected accordingly.  The client can't be used once its security layer
+// has been exported.
+func (c *SaslClient) ExportContext() ([]byte, error) {
+	if c.mech == nil {
+		return nil, common.ErrNotStarted
+	}
+
+	if !c.IsEstablished() {
+		return nil, common.ErrNotEstablished
+	}
+
+	params, err := c.ContextParams()
+	if err != nil {
+		return nil, err
+	}
+
+	exp := exportedContext{
+		Version:    exportVersion,
+		Service:    c.service,
+		ServerFQDN: c.serverFQDN,
+		Mech:       params.Mech,
+		Params:     params,
+	}
+
+	if params.SSF > 0 {
+		exporter, ok := c.mech.(common.ContextExporter)
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", params.Mech, common.ErrNotExportable)
+		}
+
+		if exp.MechState, err = exporter.ExportContext(); err != nil {
+			return nil, err
+		}
+
+		// the layer now belongs to whoever imports it
+		c.mech = nil
+	}
+
+	return json.Marshal(exp)
+}
+
+// ImportContext returns an established client from the output of ExportContext.
+// The options configure the new client;  the registry must contain the mech if
+// the context has a security layer.
+func ImportContext(data []byte, opts ...SaslClientOption) (client SaslClient, err error) {
+	var exp exportedContext
+	if err = json.Unmarshal(data, &exp); err != nil {
+		return client, fmt.Errorf("bad exported context: %w", err)
+	}
+
+	if exp.Version != exportVersion {
+		return client, fmt.Errorf("unsupported exported context version %d", exp.Version)
+	}
+
+	opts = append(opts, WithServerFQDN(exp.ServerFQDN))
+	client, err = NewSaslClient(exp.Service, opts...)
+
+	// a context without a layer doesn't need the mech to be available
+	if err != nil && !(errors.Is(err, common.ErrNoMech) && exp.Params.SSF == 0) {
+		return client, err
+	}
+
+	if exp.Params.SSF == 0 {
+		client.mech = &importedMech{params: exp.Params}
+		return client, nil
+	}
+
+	if !client.registry.IsRegistered(exp.Mech) {
+		return client, fmt.Errorf("%s: %w", exp.Mech, common.ErrNoMech)
+	}
+
+	mech := client.registry.NewMech(exp.Mech, common.MechConfig{
+		Logger:       client.Loggable,
+		Service:      client.service,
+		ServerFQDN:   client.serverFQDN,
+		MaxBufSize:   client.maxBufSize,
+		ReplayDetect: client.replayDetect,
+		ExtraProps:   client.extraProps,
+	})
+
+	importer, ok := mech.(common.ContextExporter)
+	if !ok {
+		return client, fmt.Errorf("%s: %w", exp.Mech, common.ErrNotExportable)
+	}
+
+	if err = importer.ImportContext(exp.MechState); err != nil {
+		return client, err
+	}
+
+	client.mech = mech
+	return client, nil
+}
+
+// importedMech is an established context without a security layer
+type importedMech struct {
+	params common.ContextParams
+}
+
+func (m *importedMech) Name() string {
+	return m.params.Mech
+}
+
+func (m *importedMech) MechProperties() common.MechProps {
+	return common.MechProps{}
+}
+
+func (m *importedMech) IsEstablished() bool {
+	return true
+}
+
+func (m *importedMech) ContextParams() common.ContextParams {
+	return m.params
+}
+
+func (m *importedMech) Step(inToken []byte) ([]byte, common.StepStatus, error) {
+	return nil, common.StepDone, common.ErrAlreadyEstablished
+}
+
+func (m *importedMech) Encode(input []byte) ([]byte, error) {
+	return nil, fmt.Errorf("can't encode data: %w", common.ErrNoLayer)
+}
+
+func (m *importedMech) Decode(input []byte) ([]byte, error) {
+	return nil, fmt.Errorf("can't decode data: %w", common.ErrNoLayer)
+}
+
+func (m *importedMech) Close() error {
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// exportableMech has a fake security layer whose key can be exported
+type exportableMech struct {
+	scriptedMech
+	key string
+}
+
+func (m *exportableMech) ExportContext() ([]byte, error) {
+	return []byte(m.key), nil
+}
+
+func (m *exportableMech) ImportContext(state []byte) error {
+	m.key = string(state)
+	m.steps = 0
+	return nil
+}
+
+func (m *exportableMech) Encode(input []byte) ([]byte, error) {
+	return append([]byte(m.key), input...), nil
+}
+
+func exportRegistry() *registry.Registry {
+	r := registry.New()
+	props := common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous}
+	r.MustRegister("EXP-NOLAYER", func(common.MechConfig) common.Mech {
+		return &scriptedMech{name: "EXP-NOLAYER", steps: 1}
+	}, props)
+	r.MustRegister("EXP-LAYER", func(common.MechConfig) common.Mech {
+		return &scriptedMech{name: "EXP-LAYER", steps: 1, ssf: 56}
+	}, props)
+	r.MustRegister("EXP-KEY", func(common.MechConfig) common.Mech {
+		return &exportableMech{scriptedMech{name: "EXP-KEY", steps: 1, ssf: 56}, "key:"}
+	}, props)
+
+	return r
+}
+
+func established(t *testing.T, r *registry.Registry, mech string) *SaslClient {
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechList([]string{mech}), WithServerFQDN("imap.example.com"))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.True(t, cli.IsEstablished())
+
+	return &cli
+}
+
+func TestExportContext(t *testing.T) {
+	r := exportRegistry()
+
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"EXP-NOLAYER"}))
+	assert.NoError(t, err)
+	_, err = cli.ExportContext()
+	assert.ErrorIs(t, err, common.ErrNotStarted)
+
+	// without a layer the mech doesn't even need to be registered in the importer
+	data, err := established(t, r, "EXP-NOLAYER").ExportContext()
+	assert.NoError(t, err)
+	imported, err := ImportContext(data, WithRegistry(registry.New()))
+	assert.NoError(t, err)
+	assert.True(t, imported.IsEstablished())
+	assert.Equal(t, "imap.example.com", imported.serverFQDN)
+	params, err := imported.ContextParams()
+	assert.NoError(t, err)
+	assert.Equal(t, "EXP-NOLAYER", params.Mech)
+	out, err := imported.Encode([]byte("data"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), out)
+	_, _, err = imported.Step(nil)
+	assert.ErrorIs(t, err, common.ErrAlreadyEstablished)
+
+	// a layer needs mech support
+	_, err = established(t, r, "EXP-LAYER").ExportContext()
+	assert.ErrorIs(t, err, common.ErrNotExportable)
+
+	exporter := established(t, r, "EXP-KEY")
+	data, err = exporter.ExportContext()
+	assert.NoError(t, err)
+	assert.False(t, exporter.IsEstablished())
+
+	imported, err = ImportContext(data, WithRegistry(r))
+	assert.NoError(t, err)
+	out, err = imported.Encode([]byte("data"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("key:data"), out)
+
+	_, err = ImportContext(data, WithRegistry(registry.New()))
+	assert.ErrorIs(t, err, common.ErrNoMech)
+	_, err = ImportContext([]byte(`{"version": 99}`))
+	assert.Error(t, err)
+	_, err = ImportContext([]byte(`garbage`))
+	assert.Error(t, err)
+}
@@ -4,6 +4,6 @@ go 1.15
 
 require (
 	github.com/golang-auth/go-channelbinding v1.0.1 // indirect
-	github.com/golang-auth/go-gssapi/v2 v2.2.2-alpha.0.20210509232238-f8428098c5c3 // indirect
+	github.com/golang-auth/go-gssapi/v2 v2.2.2-alpha.0.20210509232238-f8428098c5c3
 	github.com/stretchr/testify v1.7.0
 )
@@ -0,0 +1,45 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package gssapi
+
+import (
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// FuzzSSFToken feeds the server's security layer offer (RFC 4752 § 3.1) to a
+// mech whose GSS-API context is established
+func FuzzSSFToken(f *testing.F) {
+	allLayers := byte(layerNone | layerIntegrity | layerConfidentiality)
+	f.Add([]byte{allLayers, 1, 0, 0}, false, uint8(0))
+	f.Add([]byte{byte(layerNone), 0, 0, 0}, true, uint8(0))
+	f.Add([]byte{byte(layerIntegrity), 0, 0, 0}, false, uint8(1))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff}, true, uint8(56))
+	f.Add([]byte{}, false, uint8(0))
+
+	f.Fuzz(func(t *testing.T, token []byte, strict bool, minSSF uint8) {
+		cfg := common.MechConfig{MinSSF: uint(minSSF), Options: Options{Strict: strict}}
+		m := newSSFCapMech(cfg, &fakeGSS{ssf: 56})
+
+		out, status, err := m.Step(token)
+		if err != nil {
+			if out != nil || m.IsEstablished() {
+				t.Fatalf("failed step left output %x or an established context", out)
+			}
+			return
+		}
+
+		if status != common.StepDoneWithFinalToken || len(out) != 4 || !m.IsEstablished() {
+			t.Fatalf("step returned %s with %x", status, out)
+		}
+		if params := m.ContextParams(); params.SSF > 56 || params.SSF < uint(minSSF) {
+			t.Fatalf("negotiated SSF %d", params.SSF)
+		}
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/golang-auth/go-sasl/common"
 	"github.com/golang-auth/go-sasl/pkg/loggable"
@@ -14,19 +15,73 @@ import (
 
 	"github.com/golang-auth/go-gssapi/v2"
 	gsscommon "github.com/golang-auth/go-gssapi/v2/common"
-	_ "github.com/golang-auth/go-gssapi/v2/krb5"
 )
 
 const mechName = "GSSAPI"
 
-func init() {
-	// see: https://www.iana.org/assignments/sasl-mechanisms/sasl-mechanisms.xhtml
+// see: https://www.iana.org/assignments/sasl-mechanisms/sasl-mechanisms.xhtml
+var mechProps = common.MechProps{
+	MaxSSF:             256,
+	SecurityProperties: common.SecNoPlainText | common.SecNoActive | common.SecNoAnonymous | common.SecMutualAuth | common.SecPassCredentials,
+	Fearures:           common.FeatNeedServerFQDN | common.FeatWantClientFirst | common.FeatChannelBindings | common.FeatSupportsHTTP,
+}
+
+// Mechanism returns the GSSAPI mechanism for sasl.Enable or sasl.WithMechanisms.
+// Importing this package doesn't register it;  see the allmechs package.
+func Mechanism() registry.Mechanism {
+	return registry.Mechanism{Name: mechName, Factory: NewMech, Props: mechProps}
+}
 
-	registry.Register(mechName, NewMech, common.MechProps{
-		MaxSSF:             256,
-		SecurityProperties: common.SecNoPlainText | common.SecNoActive | common.SecNoAnonymous | common.SecMutualAuth | common.SecPassCredentials,
-		Fearures:           common.FeatNeedServerFQDN | common.FeatWantClientFirst | common.FeatChannelBindings,
-	})
+// Options are the typed options of the GSSAPI mechanism, set with
+// sasl.WithMechOptions
+type Options struct {
+	// ADCompat requests integrity along with confidentiality, which Active
+	// Directory requires.  Equivalent to the "ad_compat" extra property.
+	ADCompat bool
+
+	// Delegate forwards the client's credentials to the server
+	Delegate bool
+
+	// Anonymous requests an anonymous context (RFC 6112).  The provider must
+	// implement AnonymousRequester, and authentication fails if it doesn't
+	// grant one.  Clients set SecNoAnonymous by default, so it must be cleared
+	// with sasl.WithSecurityProps as well.
+	Anonymous bool
+
+	// Strict enforces the RFC 4752 rules for the security layer negotiation
+	// instead of accepting what common servers send.  It can't be combined with
+	// ADCompat.
+	Strict bool
+
+	// Provider creates the GSS-API mechanism for each context, eg. one from
+	// the C bindings for MIT specific features.  The default is the pure-Go
+	// Kerberos provider, or SSPI on Windows.
+	Provider func() gssapi.Mech `json:"-"`
+}
+
+func (o Options) Mech() string {
+	return mechName
+}
+
+func (o Options) Validate() error {
+	if o.Strict && o.ADCompat {
+		return fmt.Errorf("gssapi: ADCompat selects two security layers, which Strict forbids: %w", common.ErrBadConfig)
+	}
+	return nil
+}
+
+// options returns the configured options, or the defaults
+func (m *GSSAPIMech) options() Options {
+	switch o := m.config.Options.(type) {
+	case Options:
+		return o
+	case *Options:
+		if o != nil {
+			return *o
+		}
+	}
+
+	return Options{}
 }
 
 type qop uint8
@@ -58,6 +113,7 @@ const (
 	stateAuthenticating state = iota
 	stateSSFCap
 	stateAuthenticated
+	stateClosed
 )
 
 type GSSAPIMech struct {
@@ -65,19 +121,28 @@ type GSSAPIMech struct {
 	config            common.MechConfig
 	client            gssapi.Mech
 	qop               qop
+	qopChoice         qop
 	ssf               uint
 	state             state
 	maxOutputBufferSz uint32
+	expiry            time.Time
 }
 
 func NewMech(cfg common.MechConfig) common.Mech {
 	cfg.Logger.Debugf("new GSSAPIMech")
-	return &GSSAPIMech{
+	m := &GSSAPIMech{
 		Loggable: cfg.Logger,
 		config:   cfg,
-		client:   gssapi.NewMech("kerberos_v5"),
 		state:    stateAuthenticating,
 	}
+
+	if provider := m.options().Provider; provider != nil {
+		m.client = provider()
+	} else {
+		m.client = defaultProvider()
+	}
+
+	return m
 }
 
 func (m GSSAPIMech) Name() string {
@@ -85,20 +150,33 @@ func (m GSSAPIMech) Name() string {
 }
 
 func (m GSSAPIMech) MechProperties() common.MechProps {
-	return registry.Properties(mechName)
+	return mechProps
 }
 
-func (m *GSSAPIMech) Step(inToken []byte) (outToken []byte, err error) {
+func (m *GSSAPIMech) Step(inToken []byte) (outToken []byte, status common.StepStatus, err error) {
 	switch m.state {
 	case stateAuthenticating:
-		return m.stepAuthenticating(inToken)
+		outToken, err = m.stepAuthenticating(inToken)
 	case stateSSFCap:
-		return m.stepSSFCap(inToken)
+		outToken, err = m.stepSSFCap(inToken)
 	case stateAuthenticated:
-		return nil, common.ErrAlreadyEstablished
+		return nil, common.StepDone, common.ErrAlreadyEstablished
+	case stateClosed:
+		return nil, common.StepContinue, common.ErrClosed
+	default:
+		return nil, common.StepContinue, gssError(nil, fmt.Sprintf("step - bad state (%d)", m.state), nil)
+	}
+
+	switch {
+	case err != nil || !m.IsEstablished():
+		status = common.StepContinue
+	case len(outToken) > 0:
+		status = common.StepDoneWithFinalToken
+	default:
+		status = common.StepDone
 	}
 
-	return nil, fmt.Errorf("gssapi: step - bad state (%d)", m.state)
+	return outToken, status, err
 }
 
 func (m *GSSAPIMech) stepAuthenticating(inToken []byte) (outToken []byte, err error) {
@@ -107,11 +185,33 @@ func (m *GSSAPIMech) stepAuthenticating(inToken []byte) (outToken []byte, err er
 	// only the first time..
 	if inToken == nil {
 		if len(m.config.ServerFQDN) == 0 {
-			return nil, errors.New("server FQDN not provided")
+			return nil, gssError(common.ErrBadConfig, "server FQDN not provided", nil)
+		}
+		if m.client == nil {
+			return nil, gssError(common.ErrBadConfig, "GSS-API provider returned no mechanism", nil)
 		}
 		princName := m.config.Service + "/" + m.config.ServerFQDN
+		if m.config.ServerIsIP {
+			m.Debugf("gssapi: server is an IP address, the KDC must have a principal for %s", princName)
+		}
 
 		var flags gssapi.ContextFlag = gssapi.ContextFlagMutual | gssapi.ContextFlagSequence
+		if m.options().Delegate {
+			flags |= gssapi.ContextFlagDeleg
+		}
+		if m.options().Anonymous {
+			if m.config.SecProps&common.SecNoAnonymous != 0 {
+				return nil, gssError(common.ErrBadConfig, "anonymous context requested but SecNoAnonymous is set", nil)
+			}
+			ar, ok := m.client.(AnonymousRequester)
+			if !ok {
+				return nil, gssError(common.ErrBadConfig, "provider can't request an anonymous context", nil)
+			}
+			ar.RequestAnonymous()
+		}
+		if m.config.ReplayDetect {
+			flags |= gssapi.ContextFlagReplay
+		}
 		if m.config.MaxSSF > m.config.ExternalSSF {
 			flags |= gssapi.ContextFlagInteg
 
@@ -122,16 +222,17 @@ func (m *GSSAPIMech) stepAuthenticating(inToken []byte) (outToken []byte, err er
 
 		m.Debugf("gssapi: requesting flags [%s]", flags.String())
 
-		// convery SASL channel binding data to GSSAPI channel binding data
+		// convert SASL channel binding data to GSSAPI channel binding data,
+		// prefixed with the binding type
 		var gsscb *gsscommon.ChannelBinding = nil
 		if m.config.ChannelBinding != nil {
 			gsscb = &gsscommon.ChannelBinding{
-				Data: m.config.ChannelBinding.Data,
+				Data: m.config.ChannelBinding.ApplicationData(),
 			}
 		}
 
 		if err = m.client.Initiate(princName, flags, gsscb); err != nil {
-			return
+			return nil, gssError(common.ErrNoCredentials, "can't initiate context", err)
 		}
 
 		switch {
@@ -148,8 +249,27 @@ func (m *GSSAPIMech) stepAuthenticating(inToken []byte) (outToken []byte, err er
 	}
 
 	outToken, err = m.client.Continue(inToken)
+	if err != nil {
+		return outToken, gssError(common.ErrAuthFailed, "", err)
+	}
 
 	if m.client.IsEstablished() {
+		if ar, ok := m.client.(AnonymousRequester); ok && m.options().Anonymous && !ar.Anonymous() {
+			return nil, gssError(common.ErrWeakSecurity, "anonymous context not granted", nil)
+		}
+
+		// the flag is always requested, but only checked if mutual
+		// authentication is required
+		if m.config.SecProps&common.SecMutualAuth != 0 && m.client.ContextFlags()&gssapi.ContextFlagMutual == 0 {
+			return nil, gssError(common.ErrWeakSecurity, "", common.ErrNoMutualAuth)
+		}
+
+		if lt, ok := m.client.(ContextLifetime); ok {
+			if d := lt.Lifetime(); d >= 0 {
+				m.expiry = time.Now().Add(d)
+			}
+		}
+
 		if m.config.HTTPMode {
 			m.Debugf("gssapi: step, GSSAPI context established (HTTP mode)")
 			m.state = stateAuthenticated
@@ -165,23 +285,72 @@ func (m *GSSAPIMech) stepAuthenticating(inToken []byte) (outToken []byte, err er
 	return outToken, err
 }
 
+// ContextLifetime is implemented by GSSAPI providers that know how long an
+// established context remains valid, usually until the service ticket expires.
+// Lifetime returns a negative duration if the context doesn't expire.
+type ContextLifetime interface {
+	Lifetime() time.Duration
+}
+
+// AnonymousRequester is implemented by GSSAPI providers that can request an
+// anonymous context (RFC 6112), which go-gssapi has no context flag for.
+// Anonymous reports whether the established context is anonymous.
+type AnonymousRequester interface {
+	RequestAnonymous()
+	Anonymous() bool
+}
+
+// InitiatorNamer is implemented by GSSAPI providers that report the name the
+// client authenticated as, once the context is established
+type InitiatorNamer interface {
+	InitiatorName() string
+}
+
+// SupplementaryStatus is implemented by the errors of GSSAPI providers that report
+// the supplementary status of a per-message token (RFC 2743 § 1.2.1.1)
+type SupplementaryStatus interface {
+	Duplicate() bool   // the token was already received
+	Unsequenced() bool // the token is old, early or out of order
+}
+
+// ContextDeleter is implemented by GSSAPI providers that can release a
+// security context before it is garbage collected
+type ContextDeleter interface {
+	Delete() error
+}
+
 func (m *GSSAPIMech) stepSSFCap(inToken []byte) (outToken []byte, err error) {
 	// inToken should be a wrapped token sent to us by the SASL server following the
 	// establishment of the GSSAPI context
 	m.Debugf("gssapi: step (negotiating SSF)")
 
+	// an empty token here means the server skipped the SSF negotiation
+	if len(inToken) == 0 {
+		return nil, gssError(common.ErrProtocol, "missing SSF negotiate token", common.ErrBadToken)
+	}
+
 	// read the server's quality-of-protection offer
-	data, _, err := m.client.Unwrap(inToken)
+	data, sealed, err := m.client.Unwrap(inToken)
 	if err != nil {
-		return nil, err
+		return nil, gssError(common.ErrProtocol, "can't unwrap SSF negotiate token", err)
 	}
 
 	if len(data) != 4 {
-		return nil, fmt.Errorf("gssapi: bad SSF negotiate token (%d bytes, wanted 4)", len(data))
+		return nil, gssError(common.ErrProtocol, fmt.Sprintf("bad SSF negotiate token (%d bytes, wanted 4)", len(data)), common.ErrBadToken)
 	}
 	var serverQOPOffer qop = qop(data[0])
 	m.Debugf("server QOP offer: %s,   our QOP: %s", serverQOPOffer, m.qop)
 
+	// max message size the server will accept
+	maxOutputBufferSz := uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+	m.Debugf("server max input buffer size: %d", maxOutputBufferSz)
+
+	if m.options().Strict {
+		if err = checkOffer(serverQOPOffer, maxOutputBufferSz, sealed); err != nil {
+			return nil, err
+		}
+	}
+
 	channelSSF := m.client.SSF()
 	m.Debugf("GSSAPI SSF: %d", channelSSF)
 	if m.config.MinSSF > (channelSSF + m.config.ExternalSSF) {
@@ -199,37 +368,46 @@ func (m *GSSAPIMech) stepSSFCap(inToken []byte) (outToken []byte, err error) {
 		m.Debugf("required SSF remaining: %d", needSSF)
 	}
 
+	// nothing is stored on the mech until negotiation succeeds, so that a failure
+	// can't leave a partially negotiated layer behind
 	var qopChoice qop
+	var ssf uint
 	switch {
 	case m.qop&layerConfidentiality > 0 && serverQOPOffer&layerConfidentiality > 0 && allowedSSF >= channelSSF && needSSF <= channelSSF:
 		qopChoice = layerConfidentiality
-		m.ssf = channelSSF
+		ssf = channelSSF
 
 		// AD explicitly requires integrity when requesting confidentiality
-		if val, ok := m.config.ExtraProps["ad_compat"]; ok && isTrue(val) {
+		if val, ok := m.config.ExtraProps["ad_compat"]; (ok && isTrue(val)) || m.options().ADCompat {
 			qopChoice = layerConfidentiality | layerIntegrity
 		}
 
-	case m.qop&layerIntegrity > 0 && serverQOPOffer&layerIntegrity > 0 && allowedSSF >= 1 && needSSF <= 2:
+	case m.qop&layerIntegrity > 0 && serverQOPOffer&layerIntegrity > 0 && allowedSSF >= 1 && needSSF <= 1:
 		qopChoice = layerIntegrity
-		m.ssf = 1
+		ssf = 1
 	case m.qop&layerNone > 0 && serverQOPOffer&layerNone > 0 && needSSF <= 0:
 		qopChoice = layerNone
-		m.ssf = 0
+		ssf = 0
 	default:
-		return nil, errors.New("no suitable security layer available")
+		return nil, gssError(common.ErrWeakSecurity, fmt.Sprintf("server QOP offer [%s]", serverQOPOffer), common.ErrNoSecurityLayer)
 	}
 
-	m.Debugf("selected QOP: %s, ssf: %d", qopChoice, m.ssf)
+	m.Debugf("selected QOP: %s, ssf: %d", qopChoice, ssf)
 
-	// max message size the server will accept
-	m.maxOutputBufferSz = uint32(data[1])<<16 | uint32(data[2])<<8 + uint32(data[3])
-	m.Debugf("server max input buffer size: %d", m.maxOutputBufferSz)
+	const replayFlags = gssapi.ContextFlagReplay | gssapi.ContextFlagSequence
+	if ssf > 0 && m.config.ReplayDetect && m.client.ContextFlags()&replayFlags != replayFlags {
+		return nil, gssError(common.ErrWeakSecurity, "context doesn't provide replay and sequence detection", nil)
+	}
+
+	if ssf > 0 {
+		// we could never send anything to the server
+		if maxOutputBufferSz == 0 {
+			return nil, gssError(common.ErrProtocol, "security layer selected but server max buffer size is zero", common.ErrBadToken)
+		}
 
-	if m.ssf > 0 {
 		// max size of an pre-wrapped message we can send to the server
-		m.maxOutputBufferSz = m.client.WrapSizeLimit(m.maxOutputBufferSz, (m.ssf > 1))
-		m.Debugf("our max unwrapped output buffer size: %d", m.maxOutputBufferSz)
+		maxOutputBufferSz = m.client.WrapSizeLimit(maxOutputBufferSz, (ssf > 1))
+		m.Debugf("our max unwrapped output buffer size: %d", maxOutputBufferSz)
 	}
 
 	dataOut := make([]byte, 4)
@@ -245,27 +423,63 @@ func (m *GSSAPIMech) stepSSFCap(inToken []byte) (outToken []byte, err error) {
 	// Create the wrapped token to send to the server
 	outToken, err = m.client.Wrap(dataOut, false)
 	if err != nil {
-		return nil, err
+		return nil, gssError(nil, "can't wrap SSF negotiate response", err)
 	}
 
+	m.ssf = ssf
+	m.qopChoice = qopChoice
+	m.maxOutputBufferSz = maxOutputBufferSz
 	m.state = stateAuthenticated
 	return outToken, err
 }
 
+// checkOffer enforces RFC 4752 § 3.1 on the server's security layer offer
+func checkOffer(offer qop, maxBufSize uint32, sealed bool) error {
+	switch {
+	case sealed:
+		return gssError(common.ErrProtocol, "SSF negotiate token is encrypted", common.ErrBadToken)
+	case offer == 0 || offer&^(layerNone|layerIntegrity|layerConfidentiality) != 0:
+		return gssError(common.ErrProtocol, fmt.Sprintf("bad server QOP offer (%#02x)", uint8(offer)), common.ErrBadToken)
+	case offer == layerNone && maxBufSize != 0:
+		return gssError(common.ErrProtocol, "server offers no security layer but a max buffer size", common.ErrBadToken)
+	}
+
+	return nil
+}
+
 func (m GSSAPIMech) IsEstablished() bool {
 	return (m.state == stateAuthenticated)
 }
 
+// NegotiatingLayer implements common.LayerNegotiator
+func (m GSSAPIMech) NegotiatingLayer() bool {
+	return m.state == stateSSFCap
+}
+
+// ContextParams describes the context.  AuthCID is only set if the provider
+// implements InitiatorNamer:  the configured client principal isn't necessarily
+// the identity that was authenticated, and is empty with default credentials.
 func (m GSSAPIMech) ContextParams() common.ContextParams {
-	return common.ContextParams{
+	params := common.ContextParams{
 		SSF:                m.ssf,
+		QOP:                common.QOP(m.qopChoice),
 		MaxPeerMessageSize: m.maxOutputBufferSz,
+		Expiry:             m.expiry,
+	}
+
+	if m.state == stateAuthenticated {
+		params.MutualAuth = m.client.ContextFlags()&gssapi.ContextFlagMutual != 0
+		if n, ok := m.client.(InitiatorNamer); ok {
+			params.AuthCID = n.InitiatorName()
+		}
 	}
+
+	return params
 }
 
 func (m *GSSAPIMech) Encode(input []byte) (outToken []byte, err error) {
 	if m.ssf == 0 {
-		return nil, errors.New("can't encode data: no security layer negotiated")
+		return nil, fmt.Errorf("can't encode data: %w", common.ErrNoLayer)
 	}
 
 	return m.client.Wrap(input, (m.ssf > 1))
@@ -273,11 +487,45 @@ func (m *GSSAPIMech) Encode(input []byte) (outToken []byte, err error) {
 
 func (m *GSSAPIMech) Decode(inputToken []byte) (output []byte, err error) {
 	if m.ssf == 0 {
-		return nil, errors.New("can't decode data: no security layer negotiated")
+		return nil, fmt.Errorf("can't decode data: %w", common.ErrNoLayer)
 	}
 
 	output, _, err = m.client.Unwrap(inputToken)
-	return
+	if err != nil {
+		var status SupplementaryStatus
+		switch {
+		case !errors.As(err, &status):
+		case status.Duplicate():
+			return nil, gssError(nil, err.Error(), common.ErrReplayedToken)
+		case status.Unsequenced():
+			return nil, gssError(nil, err.Error(), common.ErrOutOfSequence)
+		}
+	}
+
+	return output, err
+}
+
+// Close deletes the GSSAPI security context if the provider supports it, and
+// otherwise drops the mech's reference to it
+func (m *GSSAPIMech) Close() (err error) {
+	if m.state == stateClosed {
+		return nil
+	}
+
+	if d, ok := m.client.(ContextDeleter); ok {
+		err = d.Delete()
+	}
+
+	m.client = nil
+	m.ssf = 0
+	m.expiry = time.Time{}
+	m.qopChoice = 0
+	m.state = stateClosed
+	return err
+}
+
+func gssError(class error, detail string, err error) error {
+	return common.NewError("gssapi", class, detail, err)
 }
 
 func isTrue(val string) bool {
@@ -1,9 +1,15 @@
 package gssapi
 
 import (
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/golang-auth/go-sasl/common"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/golang-auth/go-gssapi/v2"
+	gsscommon "github.com/golang-auth/go-gssapi/v2/common"
 )
 
 func TestMsgSize(t *testing.T) {
@@ -27,3 +33,481 @@ func TestMsgSize(t *testing.T) {
 		assert.Equal(t, tt.size, sz)
 	}
 }
+
+// fakeGSS stands in for an established go-gssapi initiator context.  Wrap and
+// Unwrap are the identity so that tests can hand-craft the server's tokens.
+// Methods not overridden here panic via the nil embedded interface.
+type fakeGSS struct {
+	gssapi.Mech
+	ssf       uint
+	flags     gssapi.ContextFlag // in addition to mutual auth, integrity and confidentiality
+	unwrapErr error
+	sealed    bool
+}
+
+func (f *fakeGSS) IsEstablished() bool {
+	return true
+}
+func (f *fakeGSS) ContextFlags() gssapi.ContextFlag {
+	return gssapi.ContextFlagMutual | gssapi.ContextFlagInteg | gssapi.ContextFlagConf | f.flags
+}
+func (f *fakeGSS) SSF() uint {
+	return f.ssf
+}
+func (f *fakeGSS) Wrap(tokenIn []byte, confidentiality bool) ([]byte, error) {
+	return tokenIn, nil
+}
+func (f *fakeGSS) Unwrap(tokenIn []byte) ([]byte, bool, error) {
+	return tokenIn, f.sealed, f.unwrapErr
+}
+func (f *fakeGSS) WrapSizeLimit(requestedOutputSize uint32, conf bool) uint32 {
+	if requestedOutputSize < 64 {
+		return 0
+	}
+	return requestedOutputSize - 64
+}
+
+// newSSFCapMech returns a mech that has established the GSSAPI context and
+// is waiting for the server's SSF negotiation token
+func newSSFCapMech(cfg common.MechConfig, gss *fakeGSS) *GSSAPIMech {
+	if cfg.MaxSSF == 0 {
+		cfg.MaxSSF = ^uint(0)
+	}
+	if cfg.MaxBufSize == 0 {
+		cfg.MaxBufSize = 65536
+	}
+
+	return &GSSAPIMech{
+		config: cfg,
+		client: gss,
+		qop:    layerNone | layerIntegrity | layerConfidentiality,
+		state:  stateSSFCap,
+	}
+}
+
+func TestMisbehavingServer(t *testing.T) {
+	allLayers := byte(layerNone | layerIntegrity | layerConfidentiality)
+	strict := common.MechConfig{Options: Options{Strict: true}}
+
+	var tests = []struct {
+		name    string
+		cfg     common.MechConfig
+		gss     fakeGSS
+		token   []byte
+		wantErr error
+	}{
+		{"premature success", common.MechConfig{}, fakeGSS{ssf: 256}, nil, common.ErrBadToken},
+		{"empty token", common.MechConfig{}, fakeGSS{ssf: 256}, []byte{}, common.ErrBadToken},
+		{"truncated token", common.MechConfig{}, fakeGSS{ssf: 256}, []byte{allLayers, 0, 0}, common.ErrBadToken},
+		{"long token", common.MechConfig{}, fakeGSS{ssf: 256}, []byte{allLayers, 0, 0, 0, 0}, common.ErrBadToken},
+		{"unwrap failure", common.MechConfig{}, fakeGSS{ssf: 256, unwrapErr: errors.New("bad MIC")}, []byte{allLayers, 1, 0, 0}, nil},
+		{"unknown QOP bits", common.MechConfig{}, fakeGSS{ssf: 256}, []byte{0xf8, 1, 0, 0}, common.ErrNoSecurityLayer},
+		{"no QOP bits", common.MechConfig{}, fakeGSS{ssf: 256}, []byte{0, 1, 0, 0}, common.ErrNoSecurityLayer},
+		{"no layer offered, layer required", common.MechConfig{MinSSF: 1}, fakeGSS{ssf: 256}, []byte{byte(layerNone), 0, 0, 0}, common.ErrNoSecurityLayer},
+		{"integrity offered, more required", common.MechConfig{MinSSF: 2}, fakeGSS{ssf: 256}, []byte{byte(layerNone | layerIntegrity), 1, 0, 0}, common.ErrNoSecurityLayer},
+		{"zero maxbuf with integrity", common.MechConfig{MinSSF: 1}, fakeGSS{ssf: 256}, []byte{byte(layerIntegrity), 0, 0, 0}, common.ErrBadToken},
+		{"zero maxbuf with confidentiality", common.MechConfig{}, fakeGSS{ssf: 256}, []byte{allLayers, 0, 0, 0}, common.ErrBadToken},
+		{"strict: unknown QOP bits", strict, fakeGSS{ssf: 256}, []byte{0xff, 1, 0, 0}, common.ErrBadToken},
+		{"strict: sealed token", strict, fakeGSS{ssf: 256, sealed: true}, []byte{allLayers, 1, 0, 0}, common.ErrBadToken},
+		{"strict: maxbuf without a layer", strict, fakeGSS{ssf: 256}, []byte{byte(layerNone), 1, 0, 0}, common.ErrBadToken},
+	}
+
+	for _, tt := range tests {
+		gss := tt.gss
+		m := newSSFCapMech(tt.cfg, &gss)
+
+		out, _, err := m.Step(tt.token)
+		assert.Error(t, err, tt.name)
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name)
+		}
+		assert.Nil(t, out, tt.name)
+		assert.False(t, m.IsEstablished(), tt.name)
+		assert.Equal(t, common.ContextParams{}, m.ContextParams(), tt.name)
+
+		_, err = m.Encode([]byte("data"))
+		assert.Error(t, err, tt.name)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	// tolerated unless strict
+	for _, tt := range []struct {
+		gss   fakeGSS
+		token []byte
+	}{
+		{fakeGSS{ssf: 256}, []byte{0xff, 1, 0, 0}},
+		{fakeGSS{ssf: 256, sealed: true}, []byte{byte(layerConfidentiality), 1, 0, 0}},
+		{fakeGSS{ssf: 256}, []byte{byte(layerNone), 1, 0, 0}},
+	} {
+		gss := tt.gss
+		_, _, err := newSSFCapMech(common.MechConfig{}, &gss).Step(tt.token)
+		assert.NoError(t, err)
+	}
+
+	m := newSSFCapMech(common.MechConfig{Options: Options{Strict: true}}, &fakeGSS{ssf: 256})
+	out, _, err := m.Step([]byte{byte(layerNone | layerConfidentiality), 0x01, 0x02, 0x03})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(layerConfidentiality), out[0])
+	assert.Equal(t, uint32(0x010203-64), m.ContextParams().MaxPeerMessageSize)
+
+	assert.ErrorIs(t, Options{Strict: true, ADCompat: true}.Validate(), common.ErrBadConfig)
+}
+
+func TestServerChannelTooWeak(t *testing.T) {
+	m := newSSFCapMech(common.MechConfig{MinSSF: 56, ExternalSSF: 1}, &fakeGSS{ssf: 1})
+
+	_, _, err := m.Step([]byte{byte(layerNone | layerIntegrity | layerConfidentiality), 1, 0, 0})
+	assert.ErrorIs(t, err, common.ErrWeakSecurity)
+	var tooWeak common.ErrTooWeak
+	assert.ErrorAs(t, err, &tooWeak)
+	assert.Equal(t, common.ErrTooWeak{MechSSF: 1, ExtSSF: 1, RequiredSSF: 56}, tooWeak)
+	assert.False(t, m.IsEstablished())
+}
+
+func TestServerOversizedMaxBuf(t *testing.T) {
+	m := newSSFCapMech(common.MechConfig{MaxBufSize: 1 << 30}, &fakeGSS{ssf: 256})
+
+	out, _, err := m.Step([]byte{byte(layerConfidentiality), 0xff, 0xff, 0xff})
+	assert.NoError(t, err)
+	assert.True(t, m.IsEstablished())
+
+	// we can only send what fits in the server's buffer once wrapped
+	params := m.ContextParams()
+	assert.Equal(t, uint(256), params.SSF)
+	assert.Equal(t, uint32(0xffffff-64), params.MaxPeerMessageSize)
+
+	// and we never advertise more than the protocol can express
+	assert.Equal(t, []byte{byte(layerConfidentiality), 0xff, 0xff, 0xff}, out)
+}
+
+func TestServerResendsChallenge(t *testing.T) {
+	m := newSSFCapMech(common.MechConfig{}, &fakeGSS{ssf: 256})
+	token := []byte{byte(layerNone | layerIntegrity | layerConfidentiality), 1, 0, 0}
+
+	_, _, err := m.Step(token)
+	assert.NoError(t, err)
+	assert.True(t, m.IsEstablished())
+
+	_, _, err = m.Step(token)
+	assert.ErrorIs(t, err, common.ErrAlreadyEstablished)
+	assert.Equal(t, uint(256), m.ContextParams().SSF)
+}
+
+func TestServerDowngradesLayer(t *testing.T) {
+	// server only offers integrity: we must not end up with confidentiality
+	// semantics, nor accept "none" when a layer is required
+	m := newSSFCapMech(common.MechConfig{MinSSF: 1}, &fakeGSS{ssf: 256})
+
+	out, status, err := m.Step([]byte{byte(layerNone | layerIntegrity), 0, 4, 0})
+	assert.NoError(t, err)
+	assert.Equal(t, common.StepDoneWithFinalToken, status)
+	assert.Equal(t, byte(layerIntegrity), out[0])
+	assert.Equal(t, uint(1), m.ContextParams().SSF)
+	assert.Equal(t, common.QOPIntegrity, m.ContextParams().QOP)
+	assert.True(t, m.ContextParams().MutualAuth)
+}
+
+// initiatorGSS records how the context was initiated
+type initiatorGSS struct {
+	gssapi.Mech
+	princName string
+	flags     gssapi.ContextFlag
+	cb        *gsscommon.ChannelBinding
+}
+
+func (f *initiatorGSS) Initiate(serviceName string, requestFlags gssapi.ContextFlag, cb *gsscommon.ChannelBinding) error {
+	f.princName = serviceName
+	f.flags = requestFlags
+	f.cb = cb
+	return nil
+}
+func (f *initiatorGSS) ContextFlags() gssapi.ContextFlag {
+	return gssapi.ContextFlagMutual | gssapi.ContextFlagInteg | gssapi.ContextFlagConf
+}
+func (f *initiatorGSS) Continue(tokenIn []byte) ([]byte, error) {
+	return []byte("AP-REQ"), nil
+}
+func (f *initiatorGSS) IsEstablished() bool {
+	return false
+}
+
+func TestInitiate(t *testing.T) {
+	gss := &initiatorGSS{}
+	m := &GSSAPIMech{config: common.MechConfig{Service: "imap", ServerFQDN: "imap.example.com"}, client: gss, state: stateAuthenticating}
+	out, _, err := m.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("AP-REQ"), out)
+	assert.Equal(t, "imap/imap.example.com", gss.princName)
+}
+
+func TestOptions(t *testing.T) {
+	// delegation is only requested when asked for
+	gss := &initiatorGSS{}
+	m := &GSSAPIMech{config: common.MechConfig{ServerFQDN: "imap.example.com"}, client: gss, state: stateAuthenticating}
+	_, _, err := m.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, gssapi.ContextFlag(0), gss.flags&gssapi.ContextFlagDeleg)
+
+	gss = &initiatorGSS{}
+	m = &GSSAPIMech{config: common.MechConfig{ServerFQDN: "imap.example.com", Options: &Options{Delegate: true}}, client: gss, state: stateAuthenticating}
+	_, _, err = m.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, gssapi.ContextFlagDeleg, gss.flags&gssapi.ContextFlagDeleg)
+
+	// AD compatibility adds integrity to confidentiality
+	m = newSSFCapMech(common.MechConfig{Options: Options{ADCompat: true}}, &fakeGSS{ssf: 256})
+	out, _, err := m.Step([]byte{byte(layerConfidentiality), 1, 0, 0})
+	assert.NoError(t, err)
+	assert.Equal(t, byte(layerConfidentiality|layerIntegrity), out[0])
+	assert.Equal(t, "GSSAPI", Options{}.Mech())
+}
+
+// establishedGSS establishes the context in one step
+type establishedGSS struct {
+	initiatorGSS
+}
+
+func (f *establishedGSS) IsEstablished() bool {
+	return true
+}
+
+// anonGSS can request an anonymous context
+type anonGSS struct {
+	establishedGSS
+	requested bool
+	granted   bool
+}
+
+func (f *anonGSS) RequestAnonymous() {
+	f.requested = true
+}
+func (f *anonGSS) Anonymous() bool {
+	return f.requested && f.granted
+}
+
+func TestAnonymous(t *testing.T) {
+	newMech := func(opts Options, gss gssapi.Mech) *GSSAPIMech {
+		return &GSSAPIMech{config: common.MechConfig{ServerFQDN: "imap.example.com", Options: opts}, client: gss, state: stateAuthenticating}
+	}
+
+	gss := &anonGSS{granted: true}
+	_, _, err := newMech(Options{Anonymous: true}, gss).Step(nil)
+	assert.NoError(t, err)
+	assert.True(t, gss.requested)
+
+	// the provider authenticated us as ourselves
+	gss = &anonGSS{}
+	_, _, err = newMech(Options{Anonymous: true}, gss).Step(nil)
+	assert.ErrorIs(t, err, common.ErrWeakSecurity)
+
+	gss = &anonGSS{granted: true}
+	_, _, err = newMech(Options{}, gss).Step(nil)
+	assert.NoError(t, err)
+	assert.False(t, gss.requested)
+
+	// the provider can't ask for one
+	_, _, err = newMech(Options{Anonymous: true}, &establishedGSS{}).Step(nil)
+	assert.ErrorIs(t, err, common.ErrBadConfig)
+	// the security properties forbid it
+	gss = &anonGSS{granted: true}
+	m := newMech(Options{Anonymous: true}, gss)
+	m.config.SecProps = common.SecNoAnonymous
+	_, _, err = m.Step(nil)
+	assert.ErrorIs(t, err, common.ErrBadConfig)
+	assert.False(t, gss.requested)
+}
+
+// expiringGSS has a context that expires
+type expiringGSS struct {
+	establishedGSS
+	lifetime time.Duration
+}
+
+func (f *expiringGSS) Lifetime() time.Duration {
+	return f.lifetime
+}
+
+func TestExpiry(t *testing.T) {
+	newMech := func(gss gssapi.Mech) *GSSAPIMech {
+		return &GSSAPIMech{config: common.MechConfig{ServerFQDN: "imap.example.com"}, client: gss, state: stateAuthenticating}
+	}
+
+	m := newMech(&expiringGSS{lifetime: time.Hour})
+	_, _, err := m.Step(nil)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), m.ContextParams().Expiry, time.Minute)
+
+	assert.NoError(t, m.Close())
+	assert.True(t, m.ContextParams().Expiry.IsZero())
+
+	// indefinite, or not known
+	m = newMech(&expiringGSS{lifetime: -1})
+	_, _, err = m.Step(nil)
+	assert.NoError(t, err)
+	assert.True(t, m.ContextParams().Expiry.IsZero())
+
+	m = newMech(&establishedGSS{})
+	_, _, err = m.Step(nil)
+	assert.NoError(t, err)
+	assert.True(t, m.ContextParams().Expiry.IsZero())
+}
+
+// oneWayGSS doesn't authenticate the server
+type oneWayGSS struct {
+	establishedGSS
+}
+
+func (f *oneWayGSS) ContextFlags() gssapi.ContextFlag {
+	return f.establishedGSS.ContextFlags() &^ gssapi.ContextFlagMutual
+}
+
+// namedGSS reports the initiator's name
+type namedGSS struct {
+	establishedGSS
+}
+
+func (f *namedGSS) InitiatorName() string {
+	return "user@EXAMPLE.COM"
+}
+
+func TestAuthCID(t *testing.T) {
+	newMech := func(gss gssapi.Mech) *GSSAPIMech {
+		cfg := common.MechConfig{ServerFQDN: "imap.example.com", HTTPMode: true}
+		return &GSSAPIMech{config: cfg, client: gss, state: stateAuthenticating}
+	}
+
+	// the provider doesn't say who the default credentials belong to
+	m := newMech(&establishedGSS{})
+	_, _, err := m.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", m.ContextParams().AuthCID)
+
+	m = newMech(&namedGSS{})
+	assert.Equal(t, "", m.ContextParams().AuthCID)
+	_, _, err = m.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "user@EXAMPLE.COM", m.ContextParams().AuthCID)
+}
+
+func TestMutualAuth(t *testing.T) {
+	newMech := func(secProps common.SecurityFlag, gss gssapi.Mech) *GSSAPIMech {
+		return &GSSAPIMech{config: common.MechConfig{ServerFQDN: "imap.example.com", SecProps: secProps}, client: gss, state: stateAuthenticating}
+	}
+
+	gss := &oneWayGSS{}
+	_, _, err := newMech(common.SecMutualAuth, gss).Step(nil)
+	assert.ErrorIs(t, err, common.ErrNoMutualAuth)
+	assert.ErrorIs(t, err, common.ErrWeakSecurity)
+	assert.Equal(t, gssapi.ContextFlagMutual, gss.flags&gssapi.ContextFlagMutual)
+
+	_, _, err = newMech(common.SecMutualAuth, &establishedGSS{}).Step(nil)
+	assert.NoError(t, err)
+
+	// not required
+	_, _, err = newMech(0, &oneWayGSS{}).Step(nil)
+	assert.NoError(t, err)
+}
+
+func TestProvider(t *testing.T) {
+	gss := &initiatorGSS{}
+	cfg := common.MechConfig{
+		ServerFQDN: "imap.example.com",
+		Options:    Options{Provider: func() gssapi.Mech { return gss }},
+	}
+	out, _, err := NewMech(cfg).Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("AP-REQ"), out)
+	assert.Equal(t, "/imap.example.com", gss.princName)
+
+	cfg.Options = &Options{Provider: func() gssapi.Mech { return nil }}
+	_, _, err = NewMech(cfg).Step(nil)
+	assert.ErrorIs(t, err, common.ErrBadConfig)
+}
+
+func TestChannelBinding(t *testing.T) {
+	gss := &initiatorGSS{}
+	m := &GSSAPIMech{config: common.MechConfig{ServerFQDN: "imap.example.com"}, client: gss, state: stateAuthenticating}
+	_, _, err := m.Step(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, gss.cb)
+
+	// the data is prefixed with the binding type
+	cb := &common.ChannelBinding{Name: common.CBTLSExporter, Data: []byte{1, 2}}
+	gss = &initiatorGSS{}
+	m = &GSSAPIMech{config: common.MechConfig{ServerFQDN: "imap.example.com", ChannelBinding: cb}, client: gss, state: stateAuthenticating}
+	_, _, err = m.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("tls-exporter:\x01\x02"), gss.cb.Data)
+}
+
+// deletingGSS records whether the context was deleted
+type deletingGSS struct {
+	fakeGSS
+	deleted bool
+}
+
+func (f *deletingGSS) Delete() error {
+	f.deleted = true
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	gss := &deletingGSS{fakeGSS: fakeGSS{ssf: 256}}
+	m := newSSFCapMech(common.MechConfig{}, &gss.fakeGSS)
+	m.client = gss
+	_, _, err := m.Step([]byte{byte(layerConfidentiality), 1, 0, 0})
+	assert.NoError(t, err)
+	assert.True(t, m.IsEstablished())
+
+	assert.NoError(t, m.Close())
+	assert.True(t, gss.deleted)
+	assert.False(t, m.IsEstablished())
+	assert.Equal(t, uint(0), m.ContextParams().SSF)
+	_, err = m.Encode([]byte("data"))
+	assert.Error(t, err)
+	_, _, err = m.Step(nil)
+	assert.ErrorIs(t, err, common.ErrClosed)
+
+	// closing twice is harmless
+	assert.NoError(t, m.Close())
+}
+
+// statusError carries GSSAPI supplementary status
+type statusError struct {
+	duplicate, unsequenced bool
+}
+
+func (e statusError) Error() string     { return "bad token" }
+func (e statusError) Duplicate() bool   { return e.duplicate }
+func (e statusError) Unsequenced() bool { return e.unsequenced }
+
+func TestReplayDetection(t *testing.T) {
+	offer := []byte{byte(layerNone | layerIntegrity | layerConfidentiality), 1, 0, 0}
+
+	m := newSSFCapMech(common.MechConfig{ReplayDetect: true}, &fakeGSS{ssf: 256})
+	_, _, err := m.Step(offer)
+	assert.ErrorIs(t, err, common.ErrWeakSecurity)
+
+	// not needed without a layer
+	m = newSSFCapMech(common.MechConfig{ReplayDetect: true}, &fakeGSS{ssf: 256})
+	m.config.MaxSSF = 0
+	_, _, err = m.Step(offer)
+	assert.NoError(t, err)
+
+	gss := &fakeGSS{ssf: 256, flags: gssapi.ContextFlagReplay | gssapi.ContextFlagSequence}
+	m = newSSFCapMech(common.MechConfig{ReplayDetect: true}, gss)
+	_, _, err = m.Step(offer)
+	assert.NoError(t, err)
+
+	gss.unwrapErr = statusError{duplicate: true}
+	_, err = m.Decode([]byte("data"))
+	assert.ErrorIs(t, err, common.ErrReplayedToken)
+	assert.ErrorIs(t, err, common.ErrProtocol)
+	gss.unwrapErr = statusError{unsequenced: true}
+	_, err = m.Decode([]byte("data"))
+	assert.ErrorIs(t, err, common.ErrOutOfSequence)
+	gss.unwrapErr = errors.New("bad MIC")
+	_, err = m.Decode([]byte("data"))
+	assert.EqualError(t, err, "bad MIC")
+}
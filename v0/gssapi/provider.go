@@ -0,0 +1,19 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package gssapi
+
+import (
+	"github.com/golang-auth/go-gssapi/v2"
+	_ "github.com/golang-auth/go-gssapi/v2/krb5"
+)
+
+// defaultProvider returns the pure-Go Kerberos provider, which uses the
+// credential cache named by KRB5CCNAME
+func defaultProvider() gssapi.Mech {
+	return gssapi.NewMech("kerberos_v5")
+}
@@ -0,0 +1,15 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+package gssapi
+
+import (
+	"github.com/golang-auth/go-gssapi/v2"
+)
+
+// defaultProvider returns an SSPI provider that uses the logged-in user's
+// credentials
+func defaultProvider() gssapi.Mech {
+	return NewSSPIMech(SSPIKerberos)
+}
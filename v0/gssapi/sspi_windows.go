@@ -0,0 +1,478 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+package gssapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/golang-auth/go-gssapi/v2"
+	gsscommon "github.com/golang-auth/go-gssapi/v2/common"
+)
+
+// SSPI security packages.  SASL GSSAPI needs raw Kerberos tokens;  Negotiate
+// produces SPNEGO tokens, as used by HTTP.
+const (
+	SSPIKerberos  = "Kerberos"
+	SSPINegotiate = "Negotiate"
+)
+
+var (
+	secur32 = syscall.NewLazyDLL("secur32.dll")
+
+	procAcquireCredentialsHandleW  = secur32.NewProc("AcquireCredentialsHandleW")
+	procInitializeSecurityContextW = secur32.NewProc("InitializeSecurityContextW")
+	procCompleteAuthToken          = secur32.NewProc("CompleteAuthToken")
+	procQueryContextAttributesW    = secur32.NewProc("QueryContextAttributesW")
+	procEncryptMessage             = secur32.NewProc("EncryptMessage")
+	procDecryptMessage             = secur32.NewProc("DecryptMessage")
+	procMakeSignature              = secur32.NewProc("MakeSignature")
+	procVerifySignature            = secur32.NewProc("VerifySignature")
+	procFreeContextBuffer          = secur32.NewProc("FreeContextBuffer")
+	procDeleteSecurityContext      = secur32.NewProc("DeleteSecurityContext")
+	procFreeCredentialsHandle      = secur32.NewProc("FreeCredentialsHandle")
+)
+
+const (
+	secEOK                  = 0
+	secIContinueNeeded      = 0x00090312
+	secICompleteNeeded      = 0x00090313
+	secICompleteAndContinue = 0x00090314
+
+	secpkgCredOutbound = 2
+	securityNativeDrep = 0x10
+
+	secbufferVersion         = 0
+	secbufferData            = 1
+	secbufferToken           = 2
+	secbufferPadding         = 9
+	secbufferStream          = 10
+	secbufferChannelBindings = 14
+
+	// the ISC_RET_ flags have the same values
+	iscReqDelegate        = 0x1
+	iscReqMutualAuth      = 0x2
+	iscReqReplayDetect    = 0x4
+	iscReqSequenceDetect  = 0x8
+	iscReqConfidentiality = 0x10
+	iscReqAllocateMemory  = 0x100
+	iscReqIntegrity       = 0x10000
+
+	secpkgAttrSizes   = 0
+	secpkgAttrKeyInfo = 5
+
+	secqopWrapNoEncrypt = 0x80000001
+)
+
+type secHandle struct {
+	lower, upper uintptr
+}
+
+type secBuffer struct {
+	size uint32
+	typ  uint32
+	buf  *byte
+}
+
+type secBufferDesc struct {
+	version uint32
+	count   uint32
+	buffers *secBuffer
+}
+
+type secPkgContextSizes struct {
+	maxToken        uint32
+	maxSignature    uint32
+	blockSize       uint32
+	securityTrailer uint32
+}
+
+type secPkgContextKeyInfo struct {
+	sigAlgName *uint16
+	encAlgName *uint16
+	keySize    uint32
+	sigAlg     uint32
+	encAlg     uint32
+}
+
+var flagMap = []struct {
+	gss gssapi.ContextFlag
+	isc uint32
+}{
+	{gssapi.ContextFlagDeleg, iscReqDelegate},
+	{gssapi.ContextFlagMutual, iscReqMutualAuth},
+	{gssapi.ContextFlagReplay, iscReqReplayDetect},
+	{gssapi.ContextFlagSequence, iscReqSequenceDetect},
+	{gssapi.ContextFlagConf, iscReqConfidentiality},
+	{gssapi.ContextFlagInteg, iscReqIntegrity},
+}
+
+// SSPIMech is a GSS-API provider backed by the Windows SSPI, which uses the
+// logged-in user's credentials without krb5.conf or keytabs.  It can only
+// initiate contexts.
+type SSPIMech struct {
+	pkg         string
+	target      string
+	cred        secHandle
+	ctx         secHandle
+	hasCred     bool
+	hasCtx      bool
+	reqFlags    uint32
+	attrs       uint32
+	cb          []byte // SEC_CHANNEL_BINDINGS followed by the application data
+	established bool
+	sizes       secPkgContextSizes
+	ssf         uint
+	expiry      time.Time
+}
+
+var _ gssapi.Mech = (*SSPIMech)(nil)
+
+// NewSSPIMech returns a provider for SSPI package pkg, eg. SSPIKerberos.  Use
+// it with Options.Provider to select a different package from the default.
+func NewSSPIMech(pkg string) *SSPIMech {
+	return &SSPIMech{pkg: pkg}
+}
+
+func sspiError(fn string, status uintptr) error {
+	return fmt.Errorf("sspi: %s failed: 0x%08x", fn, uint32(status))
+}
+
+func bufPtr(b []byte) *byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return &b[0]
+}
+
+// cBytes copies a buffer allocated by SSPI
+func cBytes(p *byte, n uint32) []byte {
+	if p == nil || n == 0 {
+		return nil
+	}
+	return append([]byte(nil), (*[1 << 30]byte)(unsafe.Pointer(p))[:n:n]...)
+}
+
+func freeContextBuffer(p unsafe.Pointer) {
+	if p != nil {
+		procFreeContextBuffer.Call(uintptr(p))
+	}
+}
+
+func (m *SSPIMech) Initiate(serviceName string, requestFlags gssapi.ContextFlag, cb *gsscommon.ChannelBinding) error {
+	pkg, err := syscall.UTF16PtrFromString(m.pkg)
+	if err != nil {
+		return err
+	}
+
+	var expiry int64
+	status, _, _ := procAcquireCredentialsHandleW.Call(
+		0, uintptr(unsafe.Pointer(pkg)), secpkgCredOutbound, 0, 0, 0, 0,
+		uintptr(unsafe.Pointer(&m.cred)), uintptr(unsafe.Pointer(&expiry)))
+	if status != secEOK {
+		return sspiError("AcquireCredentialsHandle", status)
+	}
+	m.hasCred = true
+	m.target = serviceName
+
+	// the Kerberos GSS-API mech always provides integrity and confidentiality;
+	// SSPI only does if asked
+	m.reqFlags = iscReqAllocateMemory | iscReqConfidentiality | iscReqIntegrity
+	for _, f := range flagMap {
+		if requestFlags&f.gss != 0 {
+			m.reqFlags |= f.isc
+		}
+	}
+
+	if cb != nil && len(cb.Data) > 0 {
+		// only the application data is used;  its offset follows the header
+		m.cb = make([]byte, 32, 32+len(cb.Data))
+		binary.LittleEndian.PutUint32(m.cb[24:], uint32(len(cb.Data)))
+		binary.LittleEndian.PutUint32(m.cb[28:], 32)
+		m.cb = append(m.cb, cb.Data...)
+	}
+
+	return nil
+}
+
+func (m *SSPIMech) Accept(serviceName string) error {
+	return errors.New("sspi: accepting contexts is not supported")
+}
+
+func (m *SSPIMech) Continue(tokenIn []byte) (tokenOut []byte, err error) {
+	if !m.hasCred {
+		return nil, errors.New("sspi: context not initiated")
+	}
+
+	target, err := syscall.UTF16PtrFromString(m.target)
+	if err != nil {
+		return nil, err
+	}
+
+	var in []secBuffer
+	if len(tokenIn) > 0 {
+		in = append(in, secBuffer{uint32(len(tokenIn)), secbufferToken, &tokenIn[0]})
+	}
+	if len(m.cb) > 0 {
+		in = append(in, secBuffer{uint32(len(m.cb)), secbufferChannelBindings, &m.cb[0]})
+	}
+	var inDesc *secBufferDesc
+	if len(in) > 0 {
+		inDesc = &secBufferDesc{secbufferVersion, uint32(len(in)), &in[0]}
+	}
+
+	out := secBuffer{typ: secbufferToken}
+	outDesc := secBufferDesc{secbufferVersion, 1, &out}
+
+	var ctx *secHandle
+	if m.hasCtx {
+		ctx = &m.ctx
+	}
+
+	var expiry int64
+	status, _, _ := procInitializeSecurityContextW.Call(
+		uintptr(unsafe.Pointer(&m.cred)), uintptr(unsafe.Pointer(ctx)), uintptr(unsafe.Pointer(target)),
+		uintptr(m.reqFlags), 0, securityNativeDrep, uintptr(unsafe.Pointer(inDesc)), 0,
+		uintptr(unsafe.Pointer(&m.ctx)), uintptr(unsafe.Pointer(&outDesc)),
+		uintptr(unsafe.Pointer(&m.attrs)), uintptr(unsafe.Pointer(&expiry)))
+	runtime.KeepAlive(in)
+	runtime.KeepAlive(tokenIn)
+	defer freeContextBuffer(unsafe.Pointer(out.buf))
+
+	switch status {
+	case secEOK, secIContinueNeeded, secICompleteNeeded, secICompleteAndContinue:
+		m.hasCtx = true
+	default:
+		return nil, sspiError("InitializeSecurityContext", status)
+	}
+
+	if status == secICompleteNeeded || status == secICompleteAndContinue {
+		if s, _, _ := procCompleteAuthToken.Call(uintptr(unsafe.Pointer(&m.ctx)), uintptr(unsafe.Pointer(&outDesc))); s != secEOK {
+			return nil, sspiError("CompleteAuthToken", s)
+		}
+	}
+
+	if status == secEOK || status == secICompleteNeeded {
+		if err = m.complete(); err != nil {
+			return nil, err
+		}
+		m.expiry = localTime(expiry)
+	}
+
+	return cBytes(out.buf, out.size), nil
+}
+
+// complete records the sizes and key strength of the completed context
+func (m *SSPIMech) complete() error {
+	status, _, _ := procQueryContextAttributesW.Call(uintptr(unsafe.Pointer(&m.ctx)), secpkgAttrSizes, uintptr(unsafe.Pointer(&m.sizes)))
+	if status != secEOK {
+		return sspiError("QueryContextAttributes", status)
+	}
+
+	var keyInfo secPkgContextKeyInfo
+	status, _, _ = procQueryContextAttributesW.Call(uintptr(unsafe.Pointer(&m.ctx)), secpkgAttrKeyInfo, uintptr(unsafe.Pointer(&keyInfo)))
+	if status != secEOK {
+		return sspiError("QueryContextAttributes", status)
+	}
+	freeContextBuffer(unsafe.Pointer(keyInfo.sigAlgName))
+	freeContextBuffer(unsafe.Pointer(keyInfo.encAlgName))
+
+	m.ssf = uint(keyInfo.keySize)
+	m.established = true
+	return nil
+}
+
+// localTime converts an SSPI timestamp, which is in local time, or zero if it
+// is the maximum value that means the context doesn't expire
+func localTime(ts int64) time.Time {
+	if ts <= 0 || ts == 1<<63-1 {
+		return time.Time{}
+	}
+
+	ft := syscall.Filetime{LowDateTime: uint32(ts), HighDateTime: uint32(ts >> 32)}
+	u := time.Unix(0, ft.Nanoseconds()).UTC()
+	return time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), u.Nanosecond(), time.Local)
+}
+
+// Lifetime returns how long the context remains valid
+func (m *SSPIMech) Lifetime() time.Duration {
+	if m.expiry.IsZero() {
+		return -1
+	}
+	if d := time.Until(m.expiry); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (m *SSPIMech) IsEstablished() bool {
+	return m.established
+}
+
+func (m *SSPIMech) ContextFlags() (flags gssapi.ContextFlag) {
+	for _, f := range flagMap {
+		if m.attrs&f.isc != 0 {
+			flags |= f.gss
+		}
+	}
+	return flags
+}
+
+// PeerName returns the target name;  SSPI doesn't report the name the server
+// authenticated as to the client
+func (m *SSPIMech) PeerName() string {
+	return m.target
+}
+
+func (m *SSPIMech) SSF() uint {
+	return m.ssf
+}
+
+func (m *SSPIMech) WrapSizeLimit(requestedOutputSize uint32, conf bool) uint32 {
+	overhead := m.sizes.securityTrailer + m.sizes.blockSize
+	if requestedOutputSize <= overhead {
+		return 0
+	}
+	return requestedOutputSize - overhead
+}
+
+// Wrap produces a GSS-API wrap token:  the security trailer, the data and any
+// padding, as written by EncryptMessage
+func (m *SSPIMech) Wrap(tokenIn []byte, confidentiality bool) ([]byte, error) {
+	if !m.established {
+		return nil, errors.New("sspi: context not established")
+	}
+
+	trailer := make([]byte, m.sizes.securityTrailer)
+	data := append([]byte(nil), tokenIn...)
+	padding := make([]byte, m.sizes.blockSize)
+
+	bufs := [3]secBuffer{
+		{uint32(len(trailer)), secbufferToken, bufPtr(trailer)},
+		{uint32(len(data)), secbufferData, bufPtr(data)},
+		{uint32(len(padding)), secbufferPadding, bufPtr(padding)},
+	}
+	desc := secBufferDesc{secbufferVersion, uint32(len(bufs)), &bufs[0]}
+
+	var qop uintptr
+	if !confidentiality {
+		qop = secqopWrapNoEncrypt
+	}
+
+	status, _, _ := procEncryptMessage.Call(uintptr(unsafe.Pointer(&m.ctx)), qop, uintptr(unsafe.Pointer(&desc)), 0)
+	runtime.KeepAlive(bufs)
+	if status != secEOK {
+		return nil, sspiError("EncryptMessage", status)
+	}
+
+	// the buffer sizes are updated to what was used
+	out := make([]byte, 0, bufs[0].size+bufs[1].size+bufs[2].size)
+	out = append(out, trailer[:bufs[0].size]...)
+	out = append(out, data[:bufs[1].size]...)
+	return append(out, padding[:bufs[2].size]...), nil
+}
+
+func (m *SSPIMech) Unwrap(tokenIn []byte) (tokenOut []byte, isSealed bool, err error) {
+	if !m.established {
+		return nil, false, errors.New("sspi: context not established")
+	}
+	if len(tokenIn) == 0 {
+		return nil, false, errors.New("sspi: empty token")
+	}
+
+	stream := append([]byte(nil), tokenIn...)
+	bufs := [2]secBuffer{
+		{uint32(len(stream)), secbufferStream, &stream[0]},
+		{0, secbufferData, nil},
+	}
+	desc := secBufferDesc{secbufferVersion, uint32(len(bufs)), &bufs[0]}
+
+	var qop uint32
+	status, _, _ := procDecryptMessage.Call(uintptr(unsafe.Pointer(&m.ctx)), uintptr(unsafe.Pointer(&desc)), 0, uintptr(unsafe.Pointer(&qop)))
+	runtime.KeepAlive(bufs)
+	if status != secEOK {
+		return nil, false, sspiError("DecryptMessage", status)
+	}
+
+	// the data is decrypted in place
+	if bufs[1].buf == nil {
+		return []byte{}, qop != secqopWrapNoEncrypt, nil
+	}
+	offset := uintptr(unsafe.Pointer(bufs[1].buf)) - uintptr(unsafe.Pointer(&stream[0]))
+	return stream[offset : offset+uintptr(bufs[1].size)], qop != secqopWrapNoEncrypt, nil
+}
+
+// MakeSignature produces a GSS-API MIC token for payload
+func (m *SSPIMech) MakeSignature(payload []byte) ([]byte, error) {
+	if !m.established {
+		return nil, errors.New("sspi: context not established")
+	}
+
+	sig := make([]byte, m.sizes.maxSignature)
+	bufs := [2]secBuffer{
+		{uint32(len(payload)), secbufferData, bufPtr(payload)},
+		{uint32(len(sig)), secbufferToken, bufPtr(sig)},
+	}
+	desc := secBufferDesc{secbufferVersion, uint32(len(bufs)), &bufs[0]}
+
+	status, _, _ := procMakeSignature.Call(uintptr(unsafe.Pointer(&m.ctx)), 0, uintptr(unsafe.Pointer(&desc)), 0)
+	runtime.KeepAlive(bufs)
+	runtime.KeepAlive(payload)
+	if status != secEOK {
+		return nil, sspiError("MakeSignature", status)
+	}
+
+	return sig[:bufs[1].size], nil
+}
+
+// VerifySignature checks a MIC token received from the server against payload
+func (m *SSPIMech) VerifySignature(payload []byte, tokenIn []byte) error {
+	if !m.established {
+		return errors.New("sspi: context not established")
+	}
+	if len(tokenIn) == 0 {
+		return errors.New("sspi: empty token")
+	}
+
+	data := append([]byte(nil), payload...)
+	sig := append([]byte(nil), tokenIn...)
+	bufs := [2]secBuffer{
+		{uint32(len(data)), secbufferData, bufPtr(data)},
+		{uint32(len(sig)), secbufferToken, &sig[0]},
+	}
+	desc := secBufferDesc{secbufferVersion, uint32(len(bufs)), &bufs[0]}
+
+	var qop uint32
+	status, _, _ := procVerifySignature.Call(uintptr(unsafe.Pointer(&m.ctx)), uintptr(unsafe.Pointer(&desc)), 0, uintptr(unsafe.Pointer(&qop)))
+	runtime.KeepAlive(bufs)
+	if status != secEOK {
+		return sspiError("VerifySignature", status)
+	}
+
+	return nil
+}
+
+// Delete releases the security context and credentials
+func (m *SSPIMech) Delete() error {
+	var err error
+	if m.hasCtx {
+		if status, _, _ := procDeleteSecurityContext.Call(uintptr(unsafe.Pointer(&m.ctx))); status != secEOK {
+			err = sspiError("DeleteSecurityContext", status)
+		}
+		m.hasCtx = false
+	}
+	if m.hasCred {
+		procFreeCredentialsHandle.Call(uintptr(unsafe.Pointer(&m.cred)))
+		m.hasCred = false
+	}
+
+	m.established = false
+	return err
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// InitialResponseMode says how the initial response returned by Start should be
+// sent to the server
+type InitialResponseMode int
+
+const (
+	// IRNone: the mechanism is server-first.  Send only the mech name and pass
+	// the server's first challenge to Step.
+	IRNone InitialResponseMode = iota
+
+	// IRWithCommand: send the initial response along with the mech name, eg.
+	// "AUTHENTICATE PLAIN dXNlcg==" (RFC 4959) or "AUTH PLAIN dXNlcg==" (RFC 4954)
+	IRWithCommand
+
+	// IRAfterChallenge: the protocol or server doesn't support initial
+	// responses.  Send only the mech name, then send the initial response in
+	// reply to the server's empty challenge.  That challenge must not be passed
+	// to Step.
+	IRAfterChallenge
+)
+
+func (m InitialResponseMode) String() string {
+	switch m {
+	case IRNone:
+		return "none"
+	case IRWithCommand:
+		return "with command"
+	case IRAfterChallenge:
+		return "after challenge"
+	}
+
+	return "unknown"
+}
+
+// ClientFirst reports whether the started mechanism sends an initial response
+func (c SaslClient) ClientFirst() (bool, error) {
+	if c.mech == nil {
+		return false, common.ErrNotStarted
+	}
+
+	return c.registry.Properties(c.mech.Name()).Fearures&common.FeatServerFirst == 0, nil
+}
+
+// InitialResponseMode returns how the initial response should be sent, given
+// whether the server supports initial responses (eg. it advertised the SASL-IR
+// IMAP capability;  SMTP always does)
+func (c SaslClient) InitialResponseMode(serverSupportsIR bool) (InitialResponseMode, error) {
+	clientFirst, err := c.ClientFirst()
+	switch {
+	case err != nil:
+		return IRNone, err
+	case !clientFirst:
+		return IRNone, nil
+	case serverSupportsIR:
+		return IRWithCommand, nil
+	}
+
+	return IRAfterChallenge, nil
+}
+
+// EncodeInitialResponse returns the base64 form of an initial response used by
+// text protocols such as IMAP and SMTP.  An empty initial response is sent as
+// "=" to distinguish it from no response at all;  ok is false when ir is nil,
+// ie. there is nothing to send.
+func EncodeInitialResponse(ir []byte) (encoded string, ok bool) {
+	switch {
+	case ir == nil:
+		return "", false
+	case len(ir) == 0:
+		return "=", true
+	}
+
+	return wire.EncodeBase64(ir), true
+}
+
+// DecodeInitialResponse is the inverse of EncodeInitialResponse, for use by
+// servers and test harnesses
+func DecodeInitialResponse(encoded string) ([]byte, error) {
+	if encoded == "=" {
+		return []byte{}, nil
+	}
+
+	return wire.DecodeBase64(encoded)
+}
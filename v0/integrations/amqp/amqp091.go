@@ -0,0 +1,95 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package amqp runs SASL authentication for AMQP connections:  the
+// Connection.Start-Ok and Secure-Ok methods of AMQP 0-9-1, as used by RabbitMQ,
+// and the SASL layer of AMQP 1.0 (§ 5.3).
+package amqp
+
+import (
+	"fmt"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+)
+
+// AMQP 0-9-1 reply codes sent in Connection.Close during authentication
+const (
+	ReplyNotAllowed    = 530
+	ReplyAccessRefused = 403
+)
+
+// CloseError is the Connection.Close sent by an AMQP 0-9-1 server that
+// rejects the client.  Refused access matches common.ErrAuthFailed.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("amqp: connection closed: %d %s", e.Code, e.Text)
+}
+
+func (e *CloseError) Is(target error) bool {
+	return target == common.ErrAuthFailed && e.Code == ReplyAccessRefused
+}
+
+// Methods sends and receives the AMQP 0-9-1 connection methods used during
+// authentication.  The driver encodes them, along with the other fields of
+// Start-Ok such as the client properties.
+type Methods interface {
+	// StartOk sends Connection.Start-Ok
+	StartOk(mechanism string, response []byte) error
+
+	// SecureOk sends Connection.Secure-Ok
+	SecureOk(response []byte) error
+
+	// Receive reads the server's next method and returns the challenge in a
+	// Connection.Secure, or done for Connection.Tune.  A Connection.Close
+	// should be returned as a *CloseError.
+	Receive() (challenge []byte, done bool, err error)
+}
+
+// Mechs returns the mechanisms in the mechanisms field of Connection.Start
+func Mechs(mechanisms string) []string {
+	return strings.Fields(mechanisms)
+}
+
+// Authenticate chooses a mechanism from the mechanisms field of
+// Connection.Start and authenticates using m.  It returns once
+// Connection.Tune has been received.
+func Authenticate(client *sasl.SaslClient, mechanisms string, m Methods) error {
+	if _, _, err := client.ChooseMech(Mechs(mechanisms)); err != nil {
+		return err
+	}
+
+	return saslconn.Handshake(client, methodExchanger{m})
+}
+
+// methodExchanger adapts Methods to saslconn
+type methodExchanger struct {
+	m Methods
+}
+
+func (e methodExchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	// Start-Ok always has a response;  a server-first mechanism sends an
+	// empty one
+	if initialResponse == nil {
+		initialResponse = []byte{}
+	}
+
+	if err := e.m.StartOk(mech, initialResponse); err != nil {
+		return nil, false, err
+	}
+	return e.m.Receive()
+}
+
+func (e methodExchanger) Next(response []byte) ([]byte, bool, error) {
+	if err := e.m.SecureOk(response); err != nil {
+		return nil, false, err
+	}
+	return e.m.Receive()
+}
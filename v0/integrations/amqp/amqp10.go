@@ -0,0 +1,384 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package amqp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+)
+
+// SASLHeader is the protocol header that starts the AMQP 1.0 SASL layer
+var SASLHeader = []byte{'A', 'M', 'Q', 'P', 3, 1, 0, 0}
+
+// AMQP 1.0 SASL performatives
+const (
+	saslMechanisms = 0x40
+	saslInit       = 0x41
+	saslChallenge  = 0x42
+	saslResponse   = 0x43
+	saslOutcome    = 0x44
+)
+
+// AMQP 1.0 type codes
+const (
+	typeDescribed  = 0x00
+	typeNull       = 0x40
+	typeList0      = 0x45
+	typeUbyte      = 0x50
+	typeSmallUlong = 0x53
+	typeVbin8      = 0xa0
+	typeStr8       = 0xa1
+	typeSym8       = 0xa3
+	typeVbin32     = 0xb0
+	typeStr32      = 0xb1
+	typeSym32      = 0xb3
+	typeList8      = 0xc0
+	typeList32     = 0xd0
+	typeArray8     = 0xe0
+	typeArray32    = 0xf0
+)
+
+const (
+	frameTypeSASL = 1
+	maxFrameSize  = 1 << 16 // 512 is the minimum before the connection is open
+)
+
+// SASL outcome codes
+const (
+	OutcomeOK      = 0
+	OutcomeAuth    = 1
+	OutcomeSys     = 2
+	OutcomeSysPerm = 3
+	OutcomeSysTemp = 4
+)
+
+// OutcomeError is a sasl-outcome other than ok.  A failed authentication
+// matches common.ErrAuthFailed.
+type OutcomeError struct {
+	Code uint8
+}
+
+func (e *OutcomeError) Error() string {
+	return fmt.Sprintf("amqp: SASL outcome %d", e.Code)
+}
+
+func (e *OutcomeError) Is(target error) bool {
+	return target == common.ErrAuthFailed && e.Code == OutcomeAuth
+}
+
+var errFrame = fmt.Errorf("amqp: malformed SASL frame: %w", common.ErrProtocol)
+
+// Negotiate runs the AMQP 1.0 SASL layer on rw:  it exchanges the SASL
+// protocol headers, chooses a mechanism from the server's sasl-mechanisms and
+// authenticates.  hostname is sent in sasl-init if it isn't empty.  After a
+// successful outcome the client continues with the AMQP protocol header.
+func Negotiate(rw io.ReadWriter, client *sasl.SaslClient, hostname string) error {
+	if _, err := rw.Write(SASLHeader); err != nil {
+		return err
+	}
+
+	hdr := make([]byte, len(SASLHeader))
+	if _, err := io.ReadFull(rw, hdr); err != nil {
+		return err
+	}
+	if string(hdr) != string(SASLHeader) {
+		return fmt.Errorf("amqp: server sent protocol header %q: %w", hdr, common.ErrProtocol)
+	}
+
+	code, fields, err := readFrame(rw)
+	if err != nil {
+		return err
+	}
+	if code != saslMechanisms || len(fields) == 0 {
+		return errFrame
+	}
+
+	var mechs []string
+	switch v := fields[0].(type) {
+	case string:
+		mechs = []string{v}
+	case []string:
+		mechs = v
+	default:
+		return errFrame
+	}
+
+	if _, _, err := client.ChooseMech(mechs); err != nil {
+		return err
+	}
+
+	return saslconn.Handshake(client, &Exchanger{rw: rw, hostname: hostname})
+}
+
+// Exchanger sends SASL messages in sasl-init and sasl-response frames
+type Exchanger struct {
+	rw       io.ReadWriter
+	hostname string
+}
+
+var _ saslconn.TokenExchanger = (*Exchanger)(nil)
+
+func (e *Exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	var fields []byte
+	fields = appendSymbol(fields, mech)
+	fields = appendBinary(fields, initialResponse)
+	if e.hostname != "" {
+		fields = appendString(fields, e.hostname)
+	}
+
+	if _, err := e.rw.Write(frame(saslInit, fields, 2+btoi(e.hostname != ""))); err != nil {
+		return nil, false, err
+	}
+	return e.receive()
+}
+
+func (e *Exchanger) Next(response []byte) ([]byte, bool, error) {
+	if response == nil {
+		response = []byte{}
+	}
+
+	if _, err := e.rw.Write(frame(saslResponse, appendBinary(nil, response), 1)); err != nil {
+		return nil, false, err
+	}
+	return e.receive()
+}
+
+// receive returns the next challenge, or done with the additional data of a
+// successful outcome
+func (e *Exchanger) receive() ([]byte, bool, error) {
+	code, fields, err := readFrame(e.rw)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch {
+	case code == saslChallenge && len(fields) == 1:
+		challenge, ok := fields[0].([]byte)
+		if !ok {
+			return nil, false, errFrame
+		}
+		return challenge, false, nil
+	case code == saslOutcome && len(fields) >= 1:
+		outcome, ok := fields[0].(uint8)
+		if !ok {
+			return nil, false, errFrame
+		}
+		if outcome != OutcomeOK {
+			return nil, false, &OutcomeError{Code: outcome}
+		}
+
+		var data []byte
+		if len(fields) > 1 {
+			data, _ = fields[1].([]byte)
+		}
+		return data, true, nil
+	}
+
+	return nil, false, fmt.Errorf("amqp: unexpected SASL performative %#02x: %w", code, common.ErrProtocol)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// frame returns a SASL frame holding a performative with count fields
+func frame(code byte, fields []byte, count int) []byte {
+	body := []byte{typeDescribed, typeSmallUlong, code, typeList32}
+	body = appendUint32(body, uint32(4+len(fields)))
+	body = appendUint32(body, uint32(count))
+	body = append(body, fields...)
+
+	f := appendUint32(nil, uint32(8+len(body)))
+	f = append(f, 2, frameTypeSASL, 0, 0)
+	return append(f, body...)
+}
+
+// readFrame reads a SASL frame, skipping empty frames, and returns the
+// performative's code and fields
+func readFrame(r io.Reader) (code byte, fields []interface{}, err error) {
+	for {
+		var hdr [8]byte
+		if _, err = io.ReadFull(r, hdr[:]); err != nil {
+			return 0, nil, err
+		}
+
+		size := binary.BigEndian.Uint32(hdr[:])
+		doff := int(hdr[4]) * 4
+		if size > maxFrameSize || doff < 8 || uint32(doff) > size || hdr[5] != frameTypeSASL {
+			return 0, nil, errFrame
+		}
+
+		b := make([]byte, size-8)
+		if _, err = io.ReadFull(r, b); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, nil, err
+		}
+		b = b[doff-8:]
+		if len(b) == 0 {
+			continue
+		}
+
+		if len(b) < 3 || b[0] != typeDescribed || b[1] != typeSmallUlong {
+			return 0, nil, errFrame
+		}
+		v, rest, err := decode(b[3:])
+		if err != nil || len(rest) != 0 {
+			return 0, nil, errFrame
+		}
+		if fields, ok := v.([]interface{}); ok {
+			return b[2], fields, nil
+		}
+		return 0, nil, errFrame
+	}
+}
+
+// decode returns the first value in b:  a string for a symbol or string, a
+// []byte for binary, a []string for an array of symbols, a uint8 for a ubyte,
+// a []interface{} for a list, or nil for null
+func decode(b []byte) (v interface{}, rest []byte, err error) {
+	if len(b) == 0 {
+		return nil, nil, errFrame
+	}
+	typ, b := b[0], b[1:]
+
+	switch typ {
+	case typeNull:
+		return nil, b, nil
+	case typeList0:
+		return []interface{}{}, b, nil
+	case typeUbyte:
+		if len(b) < 1 {
+			return nil, nil, errFrame
+		}
+		return b[0], b[1:], nil
+	case typeVbin8, typeStr8, typeSym8, typeVbin32, typeStr32, typeSym32:
+		data, rest, err := variable(typ, b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if typ == typeVbin8 || typ == typeVbin32 {
+			return append([]byte{}, data...), rest, nil
+		}
+		return string(data), rest, nil
+	case typeList8, typeList32:
+		data, rest, count, err := compound(typ == typeList32, b)
+		if err != nil {
+			return nil, nil, err
+		}
+		list := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			var item interface{}
+			if item, data, err = decode(data); err != nil {
+				return nil, nil, err
+			}
+			list = append(list, item)
+		}
+		return list, rest, nil
+	case typeArray8, typeArray32:
+		data, rest, count, err := compound(typ == typeArray32, b)
+		if err != nil || len(data) == 0 {
+			return nil, nil, errFrame
+		}
+		elem, data := data[0], data[1:]
+		if elem != typeSym8 && elem != typeSym32 {
+			return nil, nil, errFrame
+		}
+		syms := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			var sym []byte
+			if sym, data, err = variable(elem, data); err != nil {
+				return nil, nil, err
+			}
+			syms = append(syms, string(sym))
+		}
+		return syms, rest, nil
+	}
+
+	return nil, nil, errFrame
+}
+
+// variable returns the data of a variable-width value
+func variable(typ byte, b []byte) (data, rest []byte, err error) {
+	var size int
+	if typ&0xf0 == 0xa0 {
+		if len(b) < 1 {
+			return nil, nil, errFrame
+		}
+		size, b = int(b[0]), b[1:]
+	} else {
+		if len(b) < 4 {
+			return nil, nil, errFrame
+		}
+		size, b = int(binary.BigEndian.Uint32(b)), b[4:]
+	}
+	if size < 0 || size > len(b) {
+		return nil, nil, errFrame
+	}
+
+	return b[:size], b[size:], nil
+}
+
+// compound returns the items of a list or array, and their count
+func compound(wide bool, b []byte) (data, rest []byte, count int, err error) {
+	var size int
+	if wide {
+		if len(b) < 8 {
+			return nil, nil, 0, errFrame
+		}
+		size, count, b = int(binary.BigEndian.Uint32(b)), int(binary.BigEndian.Uint32(b[4:])), b[4:]
+	} else {
+		if len(b) < 2 {
+			return nil, nil, 0, errFrame
+		}
+		size, count, b = int(b[0]), int(b[1]), b[1:]
+	}
+	// size includes the count
+	if size < 1 || size > len(b) || count > size {
+		return nil, nil, 0, errFrame
+	}
+	if wide {
+		return b[4:size], b[size:], count, nil
+	}
+	return b[1:size], b[size:], count, nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendVariable(b []byte, typ8, typ32 byte, data []byte) []byte {
+	if len(data) < 256 {
+		return append(append(b, typ8, byte(len(data))), data...)
+	}
+	return append(appendUint32(append(b, typ32), uint32(len(data))), data...)
+}
+
+func appendSymbol(b []byte, s string) []byte {
+	return appendVariable(b, typeSym8, typeSym32, []byte(s))
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendVariable(b, typeStr8, typeStr32, []byte(s))
+}
+
+// appendBinary appends data, or null if data is nil
+func appendBinary(b []byte, data []byte) []byte {
+	if data == nil {
+		return append(b, typeNull)
+	}
+	return appendVariable(b, typeVbin8, typeVbin32, data)
+}
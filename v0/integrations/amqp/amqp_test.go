@@ -0,0 +1,151 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package amqp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/internal/testmech"
+	"github.com/stretchr/testify/assert"
+)
+
+// methods replays the server's side of an AMQP 0-9-1 exchange
+type methods struct {
+	challenges []string // "" means Connection.Tune
+	sent       []string
+	closeErr   error
+}
+
+func (m *methods) StartOk(mechanism string, response []byte) error {
+	m.sent = append(m.sent, "start-ok "+mechanism+" "+string(response))
+	return nil
+}
+
+func (m *methods) SecureOk(response []byte) error {
+	m.sent = append(m.sent, "secure-ok "+string(response))
+	return nil
+}
+
+func (m *methods) Receive() ([]byte, bool, error) {
+	if len(m.challenges) == 0 {
+		return nil, false, m.closeErr
+	}
+	c := m.challenges[0]
+	m.challenges = m.challenges[1:]
+	return []byte(c), c == "", nil
+}
+
+func TestAuthenticate091(t *testing.T) {
+	m := &methods{challenges: []string{"challenge", ""}}
+	assert.NoError(t, Authenticate(testmech.NewClient(t, "amqp", 0), "PLAIN AMQPLAIN TEST", m))
+	assert.Equal(t, []string{"start-ok TEST hello", "secure-ok response"}, m.sent)
+
+	m = &methods{closeErr: &CloseError{Code: ReplyAccessRefused, Text: "ACCESS_REFUSED"}}
+	err := Authenticate(testmech.NewClient(t, "amqp", 0), "TEST", m)
+	assert.ErrorIs(t, err, common.ErrAuthFailed)
+	assert.NotErrorIs(t, &CloseError{Code: ReplyNotAllowed}, common.ErrAuthFailed)
+
+	err = Authenticate(testmech.NewClient(t, "amqp", 0), "PLAIN AMQPLAIN", m)
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
+
+func symbols(syms ...string) []byte {
+	items := []byte{typeSym8}
+	for _, s := range syms {
+		items = append(append(items, byte(len(s))), s...)
+	}
+	return append([]byte{typeArray8, byte(1 + len(items)), byte(len(syms))}, items...)
+}
+
+func outcome(code byte, data []byte) []byte {
+	fields := []byte{typeUbyte, code}
+	if data != nil {
+		return frame(saslOutcome, appendBinary(fields, data), 2)
+	}
+	return frame(saslOutcome, fields, 1)
+}
+
+// serve sends the server's header and mechanisms, then reads a client frame
+// before each reply.  It returns the fields of the client's frames.
+func serve(t *testing.T, conn net.Conn, mechs []byte, replies ...[]byte) <-chan [][]interface{} {
+	done := make(chan [][]interface{}, 1)
+	go func() {
+		var got [][]interface{}
+		hdr := make([]byte, 8)
+		io.ReadFull(conn, hdr)
+		assert.Equal(t, SASLHeader, hdr)
+		conn.Write(SASLHeader)
+		// an empty frame is a heartbeat
+		conn.Write([]byte{0, 0, 0, 8, 2, frameTypeSASL, 0, 0})
+		conn.Write(frame(saslMechanisms, mechs, 1))
+
+		for _, reply := range replies {
+			_, fields, err := readFrame(conn)
+			if err != nil {
+				break
+			}
+			got = append(got, fields)
+			conn.Write(reply)
+		}
+		done <- got
+	}()
+	return done
+}
+
+func TestNegotiate(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	got := serve(t, server, symbols("ANONYMOUS", "TEST"),
+		frame(saslChallenge, appendBinary(nil, []byte("challenge")), 1),
+		outcome(OutcomeOK, nil),
+	)
+	assert.NoError(t, Negotiate(client, testmech.NewClient(t, "amqp", 0), "broker.example.com"))
+	assert.Equal(t, [][]interface{}{
+		{"TEST", []byte("hello"), "broker.example.com"},
+		{[]byte("response")},
+	}, <-got)
+}
+
+func TestNegotiateFailure(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// a single mechanism needn't be an array
+	serve(t, server, appendSymbol(nil, "TEST"), outcome(OutcomeAuth, []byte{}))
+	err := Negotiate(client, testmech.NewClient(t, "amqp", 0), "")
+	assert.ErrorIs(t, err, common.ErrAuthFailed)
+	assert.NotErrorIs(t, &OutcomeError{Code: OutcomeSysTemp}, common.ErrAuthFailed)
+
+	serve(t, server, symbols("PLAIN"))
+	err = Negotiate(client, testmech.NewClient(t, "amqp", 0), "")
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
+
+func TestDecode(t *testing.T) {
+	long := bytes.Repeat([]byte("x"), 300)
+	b := appendBinary(appendString(appendSymbol(nil, "sym"), string(long)), long)
+	b = appendBinary(b, nil)
+
+	_, fields, err := readFrame(bytes.NewReader(frame(saslInit, b, 4)))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"sym", string(long), long, nil}, fields)
+
+	// a binary that overruns the frame
+	f := frame(saslInit, b, 4)
+	f[len(f)-302] = 0xff
+	_, _, err = readFrame(bytes.NewReader(f))
+	assert.ErrorIs(t, err, common.ErrProtocol)
+
+	_, _, err = decode([]byte{typeList8, 5, 1, typeNull})
+	assert.ErrorIs(t, err, common.ErrProtocol)
+	_, _, err = decode([]byte{typeArray8, 2, 1, typeUbyte, 1})
+	assert.ErrorIs(t, err, common.ErrProtocol)
+}
@@ -0,0 +1,202 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package emersion adapts between SaslClient and the Client interface of
+// github.com/emersion/go-sasl, used by go-imap, go-smtp and related packages.
+// Wrap lets those packages authenticate with any mechanism registered here,
+// eg. GSSAPI;  Register makes their mechanisms available to a SaslClient.
+//
+// A go-sasl Client has no way to tell when the exchange is complete, so once
+// the server reports success the caller should pass any additional data that
+// came with the success response (or nil if there was none) to Step.
+package emersion
+
+import (
+	"fmt"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/pkg/loggable"
+	"github.com/golang-auth/go-sasl/registry"
+)
+
+// Client has the methods of the go-sasl Client interface
+type Client interface {
+	Start() (mech string, ir []byte, err error)
+	Next(challenge []byte) (response []byte, err error)
+}
+
+// Wrap returns a go-sasl Client that authenticates using c.  A security layer
+// can't be installed through go-sasl, so c should be limited to WithMaxSSF(0).
+func Wrap(c *sasl.SaslClient) Client {
+	return wrapped{c}
+}
+
+type wrapped struct {
+	c *sasl.SaslClient
+}
+
+func (w wrapped) Start() (string, []byte, error) {
+	return w.c.Start()
+}
+
+func (w wrapped) Next(challenge []byte) ([]byte, error) {
+	// go-sasl never sends nil;  an empty challenge is still a challenge
+	if challenge == nil {
+		challenge = []byte{}
+	}
+
+	out, _, err := w.c.Step(challenge)
+	return out, err
+}
+
+// ClientFactory returns a new go-sasl Client for a single authentication
+// exchange
+type ClientFactory func(cfg common.MechConfig) Client
+
+// Register makes the go-sasl Clients returned by f available as the SASL
+// mechanism name.  The caller supplies the mechanism properties as there is
+// no way to derive them from a Client.  Register fails under the same
+// conditions as registry.Register.
+func Register(name string, f ClientFactory, props common.MechProps) error {
+	return RegisterWith(registry.Default(), name, f, props)
+}
+
+// RegisterWith is like Register but adds the mechanism to r
+func RegisterWith(r *registry.Registry, name string, f ClientFactory, props common.MechProps) error {
+	return r.Register(name, func(cfg common.MechConfig) common.Mech {
+		return newMech(name, f(cfg), props, cfg)
+	}, props)
+}
+
+type state uint8
+
+const (
+	stateNotStarted state = iota
+	stateAuthenticating
+	stateAuthenticated
+	stateClosed
+)
+
+type ClientMech struct {
+	loggable.Loggable
+	name   string
+	props  common.MechProps
+	client Client
+	state  state
+}
+
+func newMech(name string, client Client, props common.MechProps, cfg common.MechConfig) *ClientMech {
+	cfg.Logger.Debugf("new emersion ClientMech (%s)", name)
+	return &ClientMech{
+		Loggable: cfg.Logger,
+		name:     name,
+		props:    props,
+		client:   client,
+		state:    stateNotStarted,
+	}
+}
+
+func (m ClientMech) Name() string {
+	return m.name
+}
+
+func (m ClientMech) MechProperties() common.MechProps {
+	return m.props
+}
+
+// Step never returns StepDoneWithFinalToken: a go-sasl Client can't tell that
+// it sent its last message, so the caller passes nil once the server reports
+// success
+func (m *ClientMech) Step(inToken []byte) (outToken []byte, status common.StepStatus, err error) {
+	switch m.state {
+	case stateNotStarted:
+		outToken, err = m.stepStart(inToken)
+	case stateAuthenticating:
+		if inToken == nil {
+			m.state = stateAuthenticated
+			return nil, common.StepDone, nil
+		}
+		outToken, err = m.stepNext(inToken)
+	case stateAuthenticated:
+		return nil, common.StepDone, common.ErrAlreadyEstablished
+	case stateClosed:
+		return nil, common.StepContinue, common.ErrClosed
+	default:
+		return nil, common.StepContinue, emersionError(nil, fmt.Sprintf("step - bad state (%d)", m.state), nil)
+	}
+
+	return outToken, common.StepContinue, err
+}
+
+func (m *ClientMech) stepStart(inToken []byte) (outToken []byte, err error) {
+	m.Debugf("emersion: step (start)")
+
+	if m.client == nil {
+		return nil, emersionError(common.ErrBadConfig, "no go-sasl Client for mech "+m.name, nil)
+	}
+
+	// go-sasl errors are opaque, so they can't be classified
+	mech, ir, err := m.client.Start()
+	if err != nil {
+		return nil, emersionError(nil, "", err)
+	}
+
+	if mech != m.name {
+		return nil, emersionError(common.ErrBadConfig, fmt.Sprintf("go-sasl Client started mech %s, expected %s", mech, m.name), nil)
+	}
+
+	m.state = stateAuthenticating
+
+	// client-first: Start() was called by the SASL client, return the initial response
+	if inToken == nil {
+		return ir, nil
+	}
+
+	// server-first: the first step already carries a challenge
+	if ir != nil {
+		return nil, emersionError(common.ErrProtocol, "go-sasl Client returned an initial response for a server challenge", nil)
+	}
+
+	return m.stepNext(inToken)
+}
+
+func (m *ClientMech) stepNext(inToken []byte) (outToken []byte, err error) {
+	m.Debugf("emersion: step (next)")
+
+	if outToken, err = m.client.Next(inToken); err != nil {
+		return nil, emersionError(nil, "", err)
+	}
+
+	return outToken, nil
+}
+
+func (m ClientMech) IsEstablished() bool {
+	return m.state == stateAuthenticated
+}
+
+// go-sasl mechanisms never provide a security layer
+func (m ClientMech) ContextParams() common.ContextParams {
+	return common.ContextParams{}
+}
+
+func (m *ClientMech) Encode(input []byte) (outToken []byte, err error) {
+	return nil, fmt.Errorf("can't encode data: %w", common.ErrNoLayer)
+}
+
+func (m *ClientMech) Decode(inputToken []byte) (output []byte, err error) {
+	return nil, fmt.Errorf("can't decode data: %w", common.ErrNoLayer)
+}
+
+// Close drops the mech's reference to the go-sasl Client, which may hold a
+// password
+func (m *ClientMech) Close() error {
+	m.client = nil
+	m.state = stateClosed
+	return nil
+}
+
+func emersionError(class error, detail string, err error) error {
+	return common.NewError("emersion", class, detail, err)
+}
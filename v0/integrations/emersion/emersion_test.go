@@ -0,0 +1,124 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package emersion
+
+import (
+	"errors"
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// plainClient works like go-sasl's NewPlainClient
+type plainClient struct {
+	username, password string
+}
+
+func (c plainClient) Start() (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + c.username + "\x00" + c.password), nil
+}
+
+func (c plainClient) Next(challenge []byte) ([]byte, error) {
+	return nil, errors.New("unexpected server challenge")
+}
+
+// loginClient works like go-sasl's NewLoginClient
+type loginClient struct {
+	username, password string
+}
+
+func (c loginClient) Start() (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (c loginClient) Next(challenge []byte) ([]byte, error) {
+	switch string(challenge) {
+	case "Username:":
+		return []byte(c.username), nil
+	case "Password:":
+		return []byte(c.password), nil
+	}
+	return nil, errors.New("unexpected server challenge")
+}
+
+func TestRegister(t *testing.T) {
+	r := registry.New()
+	err := RegisterWith(r, "PLAIN", func(common.MechConfig) Client {
+		return plainClient{"user", "pass"}
+	}, common.MechProps{SecurityProperties: common.SecNoAnonymous | common.SecPassCredentials})
+	assert.NoError(t, err)
+
+	mech := r.NewMech("PLAIN", common.MechConfig{})
+	assert.Equal(t, "PLAIN", mech.Name())
+	out, status, err := mech.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, common.StepContinue, status)
+	assert.Equal(t, []byte("\x00user\x00pass"), out)
+	assert.False(t, mech.IsEstablished())
+
+	// server reports success
+	_, status, err = mech.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, common.StepDone, status)
+	assert.True(t, mech.IsEstablished())
+
+	_, _, err = mech.Step(nil)
+	assert.ErrorIs(t, err, common.ErrAlreadyEstablished)
+
+	assert.NoError(t, mech.Close())
+	_, _, err = mech.Step(nil)
+	assert.ErrorIs(t, err, common.ErrClosed)
+
+	// the client must start the registered mech
+	assert.NoError(t, RegisterWith(r, "OTHER", func(common.MechConfig) Client {
+		return plainClient{}
+	}, common.MechProps{}))
+	_, _, err = r.NewMech("OTHER", common.MechConfig{}).Step(nil)
+	assert.ErrorIs(t, err, common.ErrBadConfig)
+}
+
+func TestRegisterServerFirst(t *testing.T) {
+	r := registry.New()
+	assert.NoError(t, RegisterWith(r, "LOGIN", func(common.MechConfig) Client {
+		return loginClient{"user", "pass"}
+	}, common.MechProps{Fearures: common.FeatServerFirst}))
+
+	mech := r.NewMech("LOGIN", common.MechConfig{})
+	out, _, err := mech.Step([]byte("Username:"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("user"), out)
+	out, _, err = mech.Step([]byte("Password:"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pass"), out)
+
+	_, _, err = mech.Step([]byte("bogus"))
+	assert.EqualError(t, errors.Unwrap(err), "unexpected server challenge")
+}
+
+func TestWrap(t *testing.T) {
+	// a registered go-sasl client, wrapped again for go-sasl
+	r := registry.New()
+	assert.NoError(t, RegisterWith(r, "LOGIN", func(common.MechConfig) Client {
+		return loginClient{"user", "pass"}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous, Fearures: common.FeatServerFirst}))
+
+	cli, err := sasl.NewSaslClient("imap", sasl.WithRegistry(r))
+	assert.NoError(t, err)
+	c := Wrap(&cli)
+
+	mech, ir, err := c.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "LOGIN", mech)
+	assert.Nil(t, ir)
+
+	out, err := c.Next([]byte("Username:"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("user"), out)
+
+	_, err = c.Next([]byte("bogus"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,35 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzExchanger feeds server responses to an AUTHENTICATE exchange
+func FuzzExchanger(f *testing.F) {
+	for _, s := range []string{
+		"+ aGVsbG8=\r\na0 OK done\r\n",
+		"* CAPABILITY IMAP4rev1\r\n+\r\na0 NO [AUTHENTICATIONFAILED] no\r\n",
+		"+ !!\r\n",
+		"a0 BAD\r\n",
+	} {
+		f.Add([]byte(s))
+	}
+
+	f.Fuzz(func(t *testing.T, responses []byte) {
+		e := NewExchanger(bufio.NewReader(bytes.NewReader(responses)), ioutil.Discard, "a0", []string{"SASL-IR"})
+		_, done, err := e.Start("GSSAPI", []byte("ir"))
+		for i := 0; err == nil && !done && i < 100; i++ {
+			_, done, err = e.Next([]byte("response"))
+		}
+	})
+}
@@ -0,0 +1,168 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package imap authenticates IMAP connections with the AUTHENTICATE command
+// (RFC 3501 § 6.2.2), sending the initial response with the command when the
+// server supports SASL-IR (RFC 4959).
+package imap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// Mechs returns the mechanisms in the server's AUTH= capabilities
+func Mechs(caps []string) []string {
+	return wire.IMAPMechs(caps)
+}
+
+func hasCap(caps []string, name string) bool {
+	for _, c := range caps {
+		if strings.EqualFold(c, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authenticate chooses a mechanism from the server's capabilities, as sent in
+// the greeting or a CAPABILITY response, and authenticates conn using the
+// command tag.  The returned connection applies the negotiated security layer,
+// which starts after the tagged OK response.
+func Authenticate(conn net.Conn, client *sasl.SaslClient, tag string, caps []string) (net.Conn, error) {
+	if _, _, err := client.ChooseMech(Mechs(caps)); err != nil {
+		return nil, err
+	}
+
+	// the server sends nothing after the tagged response until the next
+	// command, so nothing is left in the buffer
+	return saslconn.Client(conn, client, NewExchanger(bufio.NewReader(conn), conn, tag, caps))
+}
+
+// Exchanger sends SASL messages with the AUTHENTICATE command.  Untagged
+// responses received during the exchange are ignored.
+type Exchanger struct {
+	r      *bufio.Reader
+	w      io.Writer
+	tag    string
+	saslIR bool
+}
+
+var _ saslconn.TokenExchanger = (*Exchanger)(nil)
+var _ saslconn.Canceler = (*Exchanger)(nil)
+
+// NewExchanger returns an Exchanger that runs AUTHENTICATE with the command
+// tag.  caps are the server's capabilities.
+func NewExchanger(r *bufio.Reader, w io.Writer, tag string, caps []string) *Exchanger {
+	return &Exchanger{r: r, w: w, tag: tag, saslIR: hasCap(caps, "SASL-IR")}
+}
+
+func (e *Exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	cmd := e.tag + " AUTHENTICATE " + mech
+	if e.saslIR {
+		if encoded, ok := sasl.EncodeInitialResponse(initialResponse); ok {
+			cmd += " " + encoded
+			initialResponse = nil
+		}
+	}
+
+	if err := e.send(cmd); err != nil {
+		return nil, false, err
+	}
+
+	challenge, done, err := e.receive()
+	if err != nil || done || initialResponse == nil {
+		return challenge, done, err
+	}
+
+	// the server asks for the initial response with an empty challenge
+	if len(challenge) != 0 {
+		return nil, false, fmt.Errorf("imap: challenge sent before the initial response: %w", common.ErrProtocol)
+	}
+
+	return e.Next(initialResponse)
+}
+
+func (e *Exchanger) Next(response []byte) ([]byte, bool, error) {
+	if err := e.send(wire.EncodeBase64(response)); err != nil {
+		return nil, false, err
+	}
+
+	return e.receive()
+}
+
+// Cancel aborts the exchange;  the server's tagged BAD response is expected
+func (e *Exchanger) Cancel() error {
+	if err := e.send(wire.Cancel); err != nil {
+		return err
+	}
+
+	for {
+		_, done, err := e.receive()
+		if done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (e *Exchanger) send(line string) error {
+	_, err := io.WriteString(e.w, line+"\r\n")
+	return err
+}
+
+// receive returns the next challenge, or done once the tagged response is read
+func (e *Exchanger) receive() (challenge []byte, done bool, err error) {
+	for {
+		line, err := e.r.ReadString('\n')
+		if err != nil {
+			return nil, false, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case strings.HasPrefix(line, "* "):
+			// untagged data, eg. CAPABILITY
+			continue
+		case strings.HasPrefix(line, e.tag+" "):
+			return nil, true, status(line[len(e.tag)+1:])
+		}
+
+		challenge, err = wire.IMAPContinuation.Decode(line)
+		if errors.Is(err, wire.ErrNotContinuation) {
+			return nil, false, fmt.Errorf("imap: unexpected response %q: %w", line, common.ErrProtocol)
+		}
+
+		return challenge, false, err
+	}
+}
+
+// status returns the error for a tagged response, or nil if it is OK
+func status(resp string) error {
+	word := resp
+	if i := strings.IndexByte(resp, ' '); i >= 0 {
+		word = resp[:i]
+	}
+
+	switch strings.ToUpper(word) {
+	case "OK":
+		return nil
+	case "NO":
+		return fmt.Errorf("imap: %s: %w", resp, common.ErrAuthFailed)
+	}
+
+	return fmt.Errorf("imap: %s: %w", resp, common.ErrProtocol)
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package imap
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/internal/testmech"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMechs(t *testing.T) {
+	assert.Equal(t, []string{"GSSAPI", "PLAIN"}, Mechs([]string{"IMAP4rev1", "auth=gssapi", "SASL-IR", "AUTH=PLAIN", "AUTH="}))
+}
+
+func TestAuthenticate(t *testing.T) {
+	var tests = []struct {
+		name   string
+		caps   []string
+		script []string
+		err    error
+	}{
+		{"SASL-IR", []string{"IMAP4rev1", "SASL-IR", "AUTH=TEST"}, []string{
+			"C: a1 AUTHENTICATE TEST aGVsbG8=",
+			"S: + Y2hhbGxlbmdl",
+			"C: cmVzcG9uc2U=",
+			"S: * CAPABILITY IMAP4rev1",
+			"S: a1 OK authenticated",
+		}, nil},
+		{"no SASL-IR", []string{"IMAP4rev1", "AUTH=TEST"}, []string{
+			"C: a1 AUTHENTICATE TEST",
+			"S: +",
+			"C: aGVsbG8=",
+			"S: + Y2hhbGxlbmdl",
+			"C: cmVzcG9uc2U=",
+			"S: a1 OK authenticated",
+		}, nil},
+		{"rejected", []string{"SASL-IR", "AUTH=TEST"}, []string{
+			"C: a1 AUTHENTICATE TEST aGVsbG8=",
+			"S: a1 NO [AUTHENTICATIONFAILED] invalid credentials",
+		}, common.ErrAuthFailed},
+		{"cancelled", []string{"SASL-IR", "AUTH=TEST"}, []string{
+			"C: a1 AUTHENTICATE TEST aGVsbG8=",
+			"S: + Z2FyYmFnZQ==",
+			"C: *",
+			"S: a1 BAD cancelled",
+		}, common.ErrBadToken},
+		{"bad response", []string{"SASL-IR", "AUTH=TEST"}, []string{
+			"C: a1 AUTHENTICATE TEST aGVsbG8=",
+			"S: hello",
+		}, common.ErrProtocol},
+	}
+
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		got := testmech.Serve(server, tt.script...)
+
+		conn, err := Authenticate(client, testmech.NewClient(t, "imap", 0), "a1", tt.caps)
+		if tt.err == nil {
+			assert.NoError(t, err, tt.name)
+			assert.Equal(t, client, conn, tt.name)
+		} else {
+			assert.ErrorIs(t, err, tt.err, tt.name)
+		}
+		assert.Equal(t, testmech.Expected(tt.script), <-got, tt.name)
+
+		client.Close()
+		server.Close()
+	}
+
+	// no common mechanism
+	_, err := Authenticate(nil, testmech.NewClient(t, "imap", 0), "a1", []string{"AUTH=PLAIN"})
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
+
+func TestSecurityLayer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	got := testmech.Serve(server,
+		"C: a1 AUTHENTICATE TEST aGVsbG8=",
+		"S: + Y2hhbGxlbmdl",
+		"C: cmVzcG9uc2U=",
+		"S: a1 OK authenticated",
+	)
+	conn, err := Authenticate(client, testmech.NewClient(t, "imap", 56), "a1", []string{"SASL-IR", "AUTH=TEST"})
+	assert.NoError(t, err)
+	<-got
+
+	go conn.Write([]byte("a2 NOOP\r\n"))
+	b := make([]byte, 4+11)
+	_, err = io.ReadFull(server, b)
+	assert.NoError(t, err)
+	assert.Equal(t, "\x00\x00\x00\x0b[a2 NOOP\r\n]", string(b))
+}
@@ -0,0 +1,338 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package irc authenticates IRC connections with the IRCv3 sasl capability
+// and the AUTHENTICATE command.  IRC doesn't use SASL security layers.
+package irc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// AUTHENTICATE payloads are split into chunks of this size
+const chunkSize = 400
+
+// SASL numerics
+const (
+	RplLoggedIn    = "900"
+	ErrNickLocked  = "902"
+	RplSASLSuccess = "903"
+	ErrSASLFail    = "904"
+	ErrSASLTooLong = "905"
+	ErrSASLAborted = "906"
+	ErrSASLAlready = "907"
+	RplSASLMechs   = "908"
+)
+
+// NumericError is a numeric reply that ends the exchange unsuccessfully.
+// ERR_SASLFAIL and ERR_NICKLOCKED match common.ErrAuthFailed.
+type NumericError struct {
+	Numeric string
+	Text    string
+}
+
+func (e *NumericError) Error() string {
+	return "irc: " + e.Numeric + " " + e.Text
+}
+
+func (e *NumericError) Is(target error) bool {
+	switch e.Numeric {
+	case ErrSASLFail, ErrNickLocked:
+		return target == common.ErrAuthFailed
+	case ErrSASLTooLong:
+		return target == common.ErrProtocol
+	}
+
+	return false
+}
+
+// Mechs returns the mechanisms in the value of the sasl capability, eg.
+// "PLAIN,EXTERNAL".  The value is empty before CAP version 302, in which case
+// Mechs returns nil.
+func Mechs(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(strings.ToUpper(value), ",")
+}
+
+// Negotiate requests the sasl capability, authenticates and ends capability
+// negotiation.  It is used during registration, before or after NICK and
+// USER;  applications that request other capabilities use Authenticate.
+func Negotiate(r *bufio.Reader, w io.Writer, client *sasl.SaslClient) error {
+	value, ok, err := listSASL(r, w)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("irc: server doesn't offer sasl: %w", common.ErrNoMech)
+	}
+
+	if err = send(w, "CAP REQ :sasl"); err != nil {
+		return err
+	}
+	for {
+		msg, err := readMessage(r, w)
+		if err != nil {
+			return err
+		}
+		if msg.command != "CAP" || len(msg.params) < 3 {
+			continue
+		}
+		if msg.params[1] == "NAK" {
+			return fmt.Errorf("irc: server refused the sasl capability: %w", common.ErrProtocol)
+		}
+		if msg.params[1] == "ACK" {
+			break
+		}
+	}
+
+	if err = Authenticate(r, w, client, Mechs(value)); err != nil {
+		send(w, "CAP END")
+		return err
+	}
+
+	return send(w, "CAP END")
+}
+
+// listSASL sends CAP LS and returns the value of the sasl capability
+func listSASL(r *bufio.Reader, w io.Writer) (value string, ok bool, err error) {
+	if err = send(w, "CAP LS 302"); err != nil {
+		return "", false, err
+	}
+
+	for {
+		msg, err := readMessage(r, w)
+		if err != nil {
+			return "", false, err
+		}
+		if msg.command != "CAP" || len(msg.params) < 3 || msg.params[1] != "LS" {
+			continue
+		}
+
+		// "CAP * LS * :caps" continues on the next line
+		caps, more := msg.params[2], false
+		if caps == "*" && len(msg.params) > 3 {
+			caps, more = msg.params[3], true
+		}
+		for _, c := range strings.Fields(caps) {
+			name := c
+			if i := strings.IndexByte(c, '='); i >= 0 {
+				name = c[:i]
+			}
+			if name == "sasl" {
+				value, ok = strings.TrimPrefix(c[len(name):], "="), true
+			}
+		}
+
+		if !more {
+			return value, ok, nil
+		}
+	}
+}
+
+// Authenticate chooses a mechanism from mechs, if it isn't nil, and runs the
+// AUTHENTICATE exchange once the sasl capability is enabled
+func Authenticate(r *bufio.Reader, w io.Writer, client *sasl.SaslClient, mechs []string) error {
+	if mechs != nil {
+		if _, _, err := client.ChooseMech(mechs); err != nil {
+			return err
+		}
+	}
+
+	return saslconn.Handshake(client, NewExchanger(r, w))
+}
+
+// Exchanger sends SASL messages with AUTHENTICATE.  PING is answered, and
+// other messages received during the exchange are ignored.
+type Exchanger struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+var _ saslconn.TokenExchanger = (*Exchanger)(nil)
+var _ saslconn.Canceler = (*Exchanger)(nil)
+
+// NewExchanger returns an Exchanger that runs AUTHENTICATE on r and w
+func NewExchanger(r *bufio.Reader, w io.Writer) *Exchanger {
+	return &Exchanger{r: r, w: w}
+}
+
+func (e *Exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	if err := send(e.w, "AUTHENTICATE "+mech); err != nil {
+		return nil, false, err
+	}
+
+	// the server asks for the first message with an empty challenge
+	challenge, done, err := e.receive()
+	if err != nil || done || initialResponse == nil {
+		return challenge, done, err
+	}
+	if len(challenge) != 0 {
+		return nil, false, fmt.Errorf("irc: challenge sent before the initial response: %w", common.ErrProtocol)
+	}
+
+	return e.Next(initialResponse)
+}
+
+func (e *Exchanger) Next(response []byte) ([]byte, bool, error) {
+	encoded := wire.EncodeBase64(response)
+	for len(encoded) >= chunkSize {
+		if err := send(e.w, "AUTHENTICATE "+encoded[:chunkSize]); err != nil {
+			return nil, false, err
+		}
+		encoded = encoded[chunkSize:]
+	}
+
+	// a short chunk ends the message;  "+" if there is nothing left
+	if encoded == "" {
+		encoded = "+"
+	}
+	if err := send(e.w, "AUTHENTICATE "+encoded); err != nil {
+		return nil, false, err
+	}
+
+	return e.receive()
+}
+
+// Cancel aborts the exchange;  the server's ERR_SASLABORTED is expected
+func (e *Exchanger) Cancel() error {
+	if err := send(e.w, "AUTHENTICATE *"); err != nil {
+		return err
+	}
+
+	_, _, err := e.receive()
+	if _, ok := err.(*NumericError); ok {
+		return nil
+	}
+	return err
+}
+
+// receive returns the next challenge, reassembled from its chunks, or done on
+// RPL_SASLSUCCESS
+func (e *Exchanger) receive() (challenge []byte, done bool, err error) {
+	var encoded strings.Builder
+	for {
+		msg, err := readMessage(e.r, e.w)
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch msg.command {
+		case "AUTHENTICATE":
+			if len(msg.params) != 1 {
+				return nil, false, fmt.Errorf("irc: bad AUTHENTICATE message: %w", common.ErrProtocol)
+			}
+			chunk := msg.params[0]
+			if chunk == "+" {
+				chunk = ""
+			}
+			encoded.WriteString(chunk)
+			if len(chunk) == chunkSize {
+				continue
+			}
+			if encoded.Len() == 0 {
+				return []byte{}, false, nil
+			}
+			challenge, err = wire.DecodeBase64(encoded.String())
+			return challenge, false, err
+		case RplSASLSuccess:
+			return nil, true, nil
+		case ErrNickLocked, ErrSASLFail, ErrSASLTooLong, ErrSASLAborted, ErrSASLAlready:
+			return nil, false, &NumericError{Numeric: msg.command, Text: msg.last()}
+		}
+	}
+}
+
+type message struct {
+	command string
+	params  []string
+}
+
+// last returns the last parameter, usually human-readable text
+func (m message) last() string {
+	if len(m.params) == 0 {
+		return ""
+	}
+	return m.params[len(m.params)-1]
+}
+
+// readMessage reads the next message, answering any PINGs
+func readMessage(r *bufio.Reader, w io.Writer) (message, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return message{}, err
+		}
+
+		msg := parse(strings.TrimRight(line, "\r\n"))
+		if msg.command == "PING" {
+			if err = send(w, "PONG :"+msg.last()); err != nil {
+				return message{}, err
+			}
+			continue
+		}
+		if msg.command != "" {
+			return msg, nil
+		}
+	}
+}
+
+// parse splits a line into its command and parameters, dropping any tags and
+// source
+func parse(line string) (msg message) {
+	if strings.HasPrefix(line, "@") {
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = line[i+1:]
+		} else {
+			return msg
+		}
+	}
+	line = strings.TrimLeft(line, " ")
+	if strings.HasPrefix(line, ":") {
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = line[i+1:]
+		} else {
+			return msg
+		}
+	}
+
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, ":") && msg.command != "":
+			msg.params = append(msg.params, line[1:])
+			line = ""
+		default:
+			word := line
+			if i := strings.IndexByte(line, ' '); i >= 0 {
+				word, line = line[:i], line[i+1:]
+			} else {
+				line = ""
+			}
+			if msg.command == "" {
+				msg.command = strings.ToUpper(word)
+			} else {
+				msg.params = append(msg.params, word)
+			}
+		}
+	}
+
+	return msg
+}
+
+func send(w io.Writer, line string) error {
+	_, err := io.WriteString(w, line+"\r\n")
+	return err
+}
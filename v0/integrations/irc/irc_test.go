@@ -0,0 +1,136 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package irc
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/internal/testmech"
+	"github.com/golang-auth/go-sasl/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMechs(t *testing.T) {
+	assert.Equal(t, []string{"PLAIN", "EXTERNAL"}, Mechs("plain,EXTERNAL"))
+	assert.Nil(t, Mechs(""))
+}
+
+func TestNegotiate(t *testing.T) {
+	var tests = []struct {
+		name   string
+		script []string
+		err    error
+	}{
+		{"success", []string{
+			"C: CAP LS 302",
+			"S: :irc.example.com CAP * LS * :multi-prefix sasl=PLAIN,TEST",
+			"S: PING :12345",
+			"C: PONG :12345",
+			"S: :irc.example.com CAP * LS :server-time",
+			"C: CAP REQ :sasl",
+			"S: @time=2021-01-01T00:00:00Z :irc.example.com CAP * ACK :sasl",
+			"C: AUTHENTICATE TEST",
+			"S: AUTHENTICATE +",
+			"C: AUTHENTICATE aGVsbG8=",
+			"S: AUTHENTICATE Y2hhbGxlbmdl",
+			"C: AUTHENTICATE cmVzcG9uc2U=",
+			"S: :irc.example.com 900 bot bot!bot@host bot :You are now logged in as bot",
+			"S: :irc.example.com 903 bot :SASL authentication successful",
+			"C: CAP END",
+		}, nil},
+		{"rejected", []string{
+			"C: CAP LS 302",
+			"S: :irc.example.com CAP * LS :sasl",
+			"C: CAP REQ :sasl",
+			"S: :irc.example.com CAP * ACK :sasl",
+			"C: AUTHENTICATE TEST",
+			"S: AUTHENTICATE +",
+			"C: AUTHENTICATE aGVsbG8=",
+			"S: :irc.example.com 904 * :SASL authentication failed",
+			"C: CAP END",
+		}, common.ErrAuthFailed},
+		{"cancelled", []string{
+			"C: CAP LS 302",
+			"S: :irc.example.com CAP * LS :sasl=TEST",
+			"C: CAP REQ :sasl",
+			"S: :irc.example.com CAP * ACK :sasl",
+			"C: AUTHENTICATE TEST",
+			"S: AUTHENTICATE +",
+			"C: AUTHENTICATE aGVsbG8=",
+			"S: AUTHENTICATE Z2FyYmFnZQ==",
+			"C: AUTHENTICATE *",
+			"S: :irc.example.com 906 * :SASL authentication aborted",
+			"C: CAP END",
+		}, common.ErrBadToken},
+		{"no sasl", []string{
+			"C: CAP LS 302",
+			"S: :irc.example.com CAP * LS :multi-prefix",
+		}, common.ErrNoMech},
+		{"refused", []string{
+			"C: CAP LS 302",
+			"S: :irc.example.com CAP * LS :sasl",
+			"C: CAP REQ :sasl",
+			"S: :irc.example.com CAP * NAK :sasl",
+		}, common.ErrProtocol},
+	}
+
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		got := testmech.Serve(server, tt.script...)
+
+		err := Negotiate(bufio.NewReader(client), client, testmech.NewClient(t, "irc", 0))
+		if tt.err == nil {
+			assert.NoError(t, err, tt.name)
+		} else {
+			assert.ErrorIs(t, err, tt.err, tt.name)
+		}
+		assert.Equal(t, testmech.Expected(tt.script), <-got, tt.name)
+
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestChunks(t *testing.T) {
+	for _, size := range []int{0, 297, 299, 600, 601} {
+		n := len(wire.EncodeBase64(make([]byte, size)))
+		var out strings.Builder
+		e := NewExchanger(bufio.NewReader(strings.NewReader(":s 903 * :ok\r\n")), &out)
+		_, done, err := e.Next([]byte(strings.Repeat("x", size)))
+		assert.NoError(t, err)
+		assert.True(t, done)
+
+		var lines []string
+		for _, l := range strings.Split(strings.TrimSuffix(out.String(), "\r\n"), "\r\n") {
+			lines = append(lines, strings.TrimPrefix(l, "AUTHENTICATE "))
+		}
+		// a message that fills its last chunk ends with "+"
+		assert.Equal(t, n/chunkSize+1, len(lines), size)
+		if n%chunkSize == 0 {
+			assert.Equal(t, "+", lines[len(lines)-1], size)
+		}
+		encoded := strings.Join(lines, "")
+		assert.Equal(t, wire.EncodeBase64([]byte(strings.Repeat("x", size))), strings.TrimSuffix(encoded, "+"), size)
+	}
+
+	// a challenge in two chunks
+	long := wire.EncodeBase64([]byte(strings.Repeat("y", 300)))
+	in := "AUTHENTICATE " + long + "\r\nAUTHENTICATE +\r\n"
+	e := NewExchanger(bufio.NewReader(strings.NewReader(in)), ioutil.Discard)
+	challenge, _, err := e.receive()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(strings.Repeat("y", 300)), challenge)
+}
+
+func TestParse(t *testing.T) {
+	assert.Equal(t, message{"CAP", []string{"*", "LS", "sasl multi-prefix"}}, parse("@a=b :server CAP * LS :sasl multi-prefix"))
+	assert.Equal(t, message{"AUTHENTICATE", []string{"+"}}, parse("authenticate +"))
+	assert.Equal(t, message{"PING", []string{""}}, parse("PING :"))
+	assert.Equal(t, message{}, parse(":server"))
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package kafka adapts a SaslClient to the SASL interfaces of Kafka client
+// libraries.  The libraries send each message in a SaslAuthenticate request
+// (KIP-152), so GSSAPI's security layer negotiation runs as ordinary steps.
+//
+// The adapters match the libraries' interfaces structurally, so this package
+// doesn't import them.
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// Mechanism starts franz-go sessions for one mechanism.  franz-go's
+// sasl.Mechanism returns its own Session type, so it needs a small wrapper:
+//
+//	func (m mech) Authenticate(ctx context.Context, host string) (sasl.Session, []byte, error) {
+//		return m.Mechanism.Authenticate(ctx, host)
+//	}
+type Mechanism struct {
+	// MechName is the mechanism sent in the SaslHandshake request
+	MechName string
+
+	// NewClient returns the client used to authenticate to host, a
+	// "host:port" address.  The client must be able to use MechName.
+	NewClient func(host string) (*sasl.SaslClient, error)
+}
+
+func (m Mechanism) Name() string {
+	return m.MechName
+}
+
+// Authenticate starts a session with the broker at host and returns the
+// client's first message.
+func (m Mechanism) Authenticate(ctx context.Context, host string) (*Session, []byte, error) {
+	client, err := m.NewClient(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, _, err = client.ChooseMech([]string{m.MechName}); err != nil {
+		return nil, nil, err
+	}
+
+	mech, ir, err := client.StartContext(ctx)
+	switch {
+	case err != nil:
+		return nil, nil, err
+	case mech != m.MechName:
+		return nil, nil, fmt.Errorf("kafka: client chose %s, not %s: %w", mech, m.MechName, common.ErrBadConfig)
+	}
+
+	// Kafka has no way to let the server go first
+	if ir == nil {
+		ir = []byte{}
+	}
+	return &Session{client: client, ctx: ctx}, ir, nil
+}
+
+// Session is a franz-go sasl.Session
+type Session struct {
+	client *sasl.SaslClient
+	ctx    context.Context
+}
+
+// Challenge processes a message from the broker.  done is true once the client
+// has authenticated the broker, in which case a final message may be returned.
+func (s *Session) Challenge(in []byte) (done bool, out []byte, err error) {
+	if out, _, err = s.client.StepContext(s.ctx, in); err != nil {
+		return false, nil, err
+	}
+
+	return s.client.IsEstablished(), out, nil
+}
+
+// SCRAMClient is a Sarama SCRAMClient, which Sarama uses for the SCRAM
+// mechanisms.  The credentials are those of the SaslClient;  the user name and
+// password in Sarama's configuration are ignored.
+type SCRAMClient struct {
+	Client *sasl.SaslClient
+
+	ir      []byte
+	started bool
+}
+
+// Begin starts the exchange
+func (s *SCRAMClient) Begin(userName, password, authzID string) error {
+	_, ir, err := s.Client.Start()
+	if err != nil {
+		return err
+	}
+
+	s.ir, s.started = ir, false
+	return nil
+}
+
+// Step returns the response to challenge.  Sarama calls it with an empty
+// challenge to get the client's first message.
+func (s *SCRAMClient) Step(challenge string) (string, error) {
+	if !s.started {
+		s.started = true
+		if s.ir != nil {
+			return string(s.ir), nil
+		}
+	}
+
+	out, _, err := s.Client.Step([]byte(challenge))
+	return string(out), err
+}
+
+// Done reports whether the exchange is complete
+func (s *SCRAMClient) Done() bool {
+	return s.Client.IsEstablished()
+}
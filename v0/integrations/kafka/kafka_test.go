@@ -0,0 +1,71 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/internal/testmech"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClient(string) (*sasl.SaslClient, error) {
+	r := registry.New()
+	for _, name := range []string{"TEST", "OTHER"} {
+		if err := testmech.New(name).Register(r); err != nil {
+			return nil, err
+		}
+	}
+
+	cli, err := sasl.NewSaslClient("kafka", sasl.WithRegistry(r))
+	return &cli, err
+}
+
+func TestMechanism(t *testing.T) {
+	m := Mechanism{MechName: "TEST", NewClient: newClient}
+	assert.Equal(t, "TEST", m.Name())
+
+	s, ir, err := m.Authenticate(context.Background(), "broker:9092")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), ir)
+
+	done, out, err := s.Challenge([]byte("challenge"))
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []byte("response"), out)
+
+	s, _, _ = m.Authenticate(context.Background(), "broker:9092")
+	_, _, err = s.Challenge([]byte("garbage"))
+	assert.ErrorIs(t, err, common.ErrBadToken)
+
+	// the broker must have enabled the mechanism
+	_, _, err = Mechanism{MechName: "PLAIN", NewClient: newClient}.Authenticate(context.Background(), "broker:9092")
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
+
+func TestSCRAMClient(t *testing.T) {
+	client, _ := newClient("")
+	s := &SCRAMClient{Client: client}
+
+	assert.NoError(t, s.Begin("ignored", "ignored", ""))
+	assert.False(t, s.Done())
+
+	out, err := s.Step("")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", out)
+
+	out, err = s.Step("challenge")
+	assert.NoError(t, err)
+	assert.Equal(t, "response", out)
+	assert.True(t, s.Done())
+
+	// starting again resets the exchange
+	assert.NoError(t, s.Begin("", "", ""))
+	out, _ = s.Step("")
+	assert.Equal(t, "hello", out)
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package ldap
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// Just enough BER (X.690) for bind requests and responses
+
+const (
+	tagInteger        = 0x02
+	tagOctetString    = 0x04
+	tagEnumerated     = 0x0a
+	tagSequence       = 0x30
+	tagBindRequest    = 0x60 // [APPLICATION 0] constructed
+	tagBindResponse   = 0x61 // [APPLICATION 1] constructed
+	tagSASLAuth       = 0xa3 // [3] constructed, in a bind request
+	tagServerSASLCred = 0x87 // [7] primitive, in a bind response
+
+	maxMessageSize = 16 << 20
+)
+
+var errBER = fmt.Errorf("ldap: malformed BER: %w", common.ErrProtocol)
+
+func appendLength(dst []byte, n int) []byte {
+	if n < 0x80 {
+		return append(dst, byte(n))
+	}
+
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append(append(dst, 0x80|byte(len(b))), b...)
+}
+
+func appendTLV(dst []byte, tag byte, content []byte) []byte {
+	dst = append(dst, tag)
+	dst = appendLength(dst, len(content))
+	return append(dst, content...)
+}
+
+// appendInt encodes a non-negative INTEGER or ENUMERATED
+func appendInt(dst []byte, tag byte, v int) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		if v >>= 8; v == 0 {
+			break
+		}
+	}
+
+	// keep it positive
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return appendTLV(dst, tag, b)
+}
+
+// parseTLV splits the first element off b
+func parseTLV(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBER
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return 0, nil, nil, errBER
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+
+	if n < 0 || n > len(b) {
+		return 0, nil, nil, errBER
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// parseInt decodes the content of an INTEGER or ENUMERATED
+func parseInt(b []byte) (int, error) {
+	if len(b) == 0 || len(b) > 4 {
+		return 0, errBER
+	}
+
+	v := int(int8(b[0]))
+	for _, c := range b[1:] {
+		v = v<<8 | int(c)
+	}
+	return v, nil
+}
+
+// readMessage reads exactly one element from r, so that nothing after it is
+// consumed
+func readMessage(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+
+	n := int(hdr[1])
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 {
+			return nil, errBER
+		}
+		hdr = hdr[:2+size]
+		if _, err := io.ReadFull(r, hdr[2:]); err != nil {
+			return nil, err
+		}
+		n = 0
+		for _, c := range hdr[2:] {
+			n = n<<8 | int(c)
+		}
+	}
+
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("ldap: %d byte message is too large: %w", n, common.ErrProtocol)
+	}
+
+	msg := make([]byte, len(hdr)+n)
+	copy(msg, hdr)
+	if _, err := io.ReadFull(r, msg[len(hdr):]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return msg, nil
+}
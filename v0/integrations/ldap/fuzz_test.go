@@ -0,0 +1,46 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package ldap
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzParseBindResponse(f *testing.F) {
+	f.Add(bindResponse(1, ResultSASLBindInProgress, []byte("AP-REP")))
+	f.Add(bindResponse(3, ResultSuccess, nil))
+	f.Add(bindResponse(1<<20, ResultInvalidCredentials, nil))
+	f.Add([]byte{tagSequence, 0x84, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		ParseBindResponse(msg)
+	})
+}
+
+// rw reads the server's responses and discards requests
+type rw struct {
+	*bytes.Reader
+}
+
+func (rw) Write(b []byte) (int, error) { return len(b), nil }
+
+func FuzzSupportedMechs(f *testing.F) {
+	entry := searchMessage(1, tagSearchResultEntry, rootDSEEntry("supportedSASLMechanisms", "GSSAPI", "EXTERNAL"))
+	done := searchMessage(1, tagSearchResultDone, searchDone(ResultSuccess))
+	f.Add(append(append([]byte{}, entry...), done...))
+	f.Add(done)
+	f.Add(searchMessage(1, tagSearchResultRef, nil))
+
+	f.Fuzz(func(t *testing.T, responses []byte) {
+		mechs, err := SupportedMechs(rw{bytes.NewReader(responses)}, 1)
+		if err != nil && mechs != nil {
+			t.Fatalf("returned mechanisms %q with %v", mechs, err)
+		}
+	})
+}
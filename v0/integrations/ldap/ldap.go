@@ -0,0 +1,241 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package ldap performs LDAP SASL binds (RFC 4513 § 5.2.1) and installs the
+// negotiated security layer on the connection, so that GSSAPI can protect LDAP
+// traffic without TLS.
+package ldap
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+)
+
+// LDAP result codes used during a SASL bind (RFC 4511 § 4.1.9)
+const (
+	ResultSuccess                     = 0
+	ResultAuthMethodNotSupported      = 7
+	ResultStrongerAuthRequired        = 8
+	ResultSASLBindInProgress          = 14
+	ResultInappropriateAuthentication = 48
+	ResultInvalidCredentials          = 49
+)
+
+// ResultError is returned when the server ends the bind with a result other
+// than success.  Rejected credentials match common.ErrAuthFailed, and weak
+// or unsupported mechanisms common.ErrWeakSecurity.
+type ResultError struct {
+	Code    int
+	Message string // diagnostic message from the server
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("ldap: bind failed: result %d: %s", e.Code, e.Message)
+}
+
+func (e *ResultError) Is(target error) bool {
+	switch e.Code {
+	case ResultInvalidCredentials, ResultInappropriateAuthentication:
+		return target == common.ErrAuthFailed
+	case ResultAuthMethodNotSupported, ResultStrongerAuthRequired:
+		return target == common.ErrWeakSecurity
+	}
+
+	return false
+}
+
+// Bind performs a SASL bind on conn and returns a connection that applies the
+// negotiated security layer.  If mechs is not nil, the mechanism is chosen
+// from it, eg. the supportedSASLMechanisms attribute of the root DSE.
+// messageID is the ID of the first bind request.
+func Bind(conn net.Conn, client *sasl.SaslClient, mechs []string, messageID int) (net.Conn, error) {
+	if mechs != nil {
+		if _, _, err := client.ChooseMech(mechs); err != nil {
+			return nil, err
+		}
+	}
+
+	return saslconn.Client(conn, client, NewExchanger(conn, messageID))
+}
+
+// Exchanger sends SASL messages in bind requests.  It can be used with
+// saslconn, or by LDAP libraries that manage the connection themselves.
+type Exchanger struct {
+	rw   io.ReadWriter
+	id   int
+	mech string
+}
+
+var _ saslconn.TokenExchanger = (*Exchanger)(nil)
+
+// NewExchanger returns an Exchanger whose first bind request has ID messageID;
+// later requests use the following IDs.  Messages are read from rw one at a
+// time, so nothing after the final response is consumed.
+func NewExchanger(rw io.ReadWriter, messageID int) *Exchanger {
+	return &Exchanger{rw: rw, id: messageID}
+}
+
+// NextMessageID returns the ID to use for the next request on the connection
+func (e *Exchanger) NextMessageID() int {
+	return e.id
+}
+
+func (e *Exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	e.mech = mech
+	return e.Next(initialResponse)
+}
+
+func (e *Exchanger) Next(response []byte) ([]byte, bool, error) {
+	id := e.id
+	e.id++
+
+	if _, err := e.rw.Write(BindRequest(id, e.mech, response)); err != nil {
+		return nil, false, err
+	}
+
+	msg, err := readMessage(e.rw)
+	if err != nil {
+		return nil, false, err
+	}
+
+	respID, code, message, creds, err := ParseBindResponse(msg)
+	switch {
+	case err != nil:
+		return nil, false, err
+	case respID != id:
+		return nil, false, fmt.Errorf("ldap: response to message %d, expected %d: %w", respID, id, common.ErrProtocol)
+	case code == ResultSASLBindInProgress:
+		if creds == nil {
+			creds = []byte{}
+		}
+		return creds, false, nil
+	case code != ResultSuccess:
+		return nil, false, &ResultError{Code: code, Message: message}
+	}
+
+	return creds, true, nil
+}
+
+// BindRequest encodes an LDAPMessage holding a SASL bind request.  A nil
+// credentials is left out of the request.
+func BindRequest(messageID int, mech string, credentials []byte) []byte {
+	saslCreds := appendTLV(nil, tagOctetString, []byte(mech))
+	if credentials != nil {
+		saslCreds = appendTLV(saslCreds, tagOctetString, credentials)
+	}
+
+	var req []byte
+	req = appendInt(req, tagInteger, 3) // version
+	req = appendTLV(req, tagOctetString, nil)
+	req = appendTLV(req, tagSASLAuth, saslCreds)
+
+	msg := appendInt(nil, tagInteger, messageID)
+	msg = appendTLV(msg, tagBindRequest, req)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// ParseBindResponse decodes an LDAPMessage holding a bind response.  creds is
+// nil if the server didn't send any.
+func ParseBindResponse(msg []byte) (messageID, code int, message string, creds []byte, err error) {
+	tag, body, _, err := parseTLV(msg)
+	if err != nil || tag != tagSequence {
+		return 0, 0, "", nil, errBER
+	}
+
+	tag, id, body, err := parseTLV(body)
+	if err != nil || tag != tagInteger {
+		return 0, 0, "", nil, errBER
+	}
+	if messageID, err = parseInt(id); err != nil {
+		return 0, 0, "", nil, err
+	}
+
+	tag, resp, _, err := parseTLV(body)
+	if err != nil {
+		return 0, 0, "", nil, err
+	}
+	if tag != tagBindResponse {
+		return 0, 0, "", nil, fmt.Errorf("ldap: unexpected message type %#02x: %w", tag, common.ErrProtocol)
+	}
+
+	// resultCode, matchedDN, diagnosticMessage, then optional elements
+	var fields [3][]byte
+	for i := range fields {
+		if tag, fields[i], resp, err = parseTLV(resp); err != nil {
+			return 0, 0, "", nil, err
+		}
+		if want := []byte{tagEnumerated, tagOctetString, tagOctetString}[i]; tag != want {
+			return 0, 0, "", nil, errBER
+		}
+	}
+	if code, err = parseInt(fields[0]); err != nil {
+		return 0, 0, "", nil, err
+	}
+	message = string(fields[2])
+
+	for len(resp) > 0 {
+		var content []byte
+		if tag, content, resp, err = parseTLV(resp); err != nil {
+			return 0, 0, "", nil, err
+		}
+		if tag == tagServerSASLCred {
+			creds = append([]byte{}, content...)
+		}
+	}
+
+	return messageID, code, message, creds, nil
+}
+
+// GSSAPIClient adapts client to the GSSAPIClient interface of go-ldap's
+// Conn.GSSAPIBind.  go-ldap doesn't install a security layer, so the client
+// must be limited to WithMaxSSF(0);  use Bind for a protected connection.
+type GSSAPIClient struct {
+	Client *sasl.SaslClient
+}
+
+func (g GSSAPIClient) InitSecContext(target string, token []byte) ([]byte, bool, error) {
+	var out []byte
+	var err error
+	if token == nil {
+		var mech string
+		if mech, out, err = g.Client.Start(); err == nil && mech != "GSSAPI" {
+			err = fmt.Errorf("ldap: GSSAPIBind needs GSSAPI, not %s: %w", mech, common.ErrBadConfig)
+		}
+	} else {
+		out, _, err = g.Client.Step(token)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the GSS-API context is complete once the layer is being negotiated
+	state, _ := g.Client.State()
+	return out, state == sasl.StateNegotiating, nil
+}
+
+func (g GSSAPIClient) NegotiateSaslAuth(token []byte, authzid string) ([]byte, error) {
+	out, _, err := g.Client.Step(token)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := g.Client.ContextParams()
+	if err != nil {
+		return nil, err
+	}
+	if params.SSF > 0 {
+		return nil, fmt.Errorf("ldap: go-ldap can't apply a security layer: %w", common.ErrBadConfig)
+	}
+
+	return out, nil
+}
+
+func (g GSSAPIClient) DeleteSecContext() error {
+	return g.Client.Close()
+}
@@ -0,0 +1,299 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package ldap
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// kerbMech imitates GSSAPI: a context token, the server's reply, then the
+// security layer negotiation.  Its layer wraps data in square brackets.
+type kerbMech struct {
+	ssf   uint
+	stage int
+}
+
+func (m *kerbMech) Name() string                     { return "GSSAPI" }
+func (m *kerbMech) MechProperties() common.MechProps { return common.MechProps{} }
+func (m *kerbMech) IsEstablished() bool              { return m.stage == 2 }
+func (m *kerbMech) NegotiatingLayer() bool           { return m.stage == 1 }
+func (m *kerbMech) Close() error                     { return nil }
+
+func (m *kerbMech) ContextParams() common.ContextParams {
+	return common.ContextParams{SSF: m.ssf, MaxPeerMessageSize: 64}
+}
+
+func (m *kerbMech) Step(in []byte) ([]byte, common.StepStatus, error) {
+	switch {
+	case in == nil:
+		return []byte("AP-REQ"), common.StepContinue, nil
+	case m.stage == 0 && string(in) == "AP-REP":
+		m.stage = 1
+		return []byte{}, common.StepContinue, nil
+	case m.stage == 1 && string(in) == "offer":
+		m.stage = 2
+		return []byte("choice"), common.StepDoneWithFinalToken, nil
+	}
+	return nil, common.StepContinue, common.ErrBadToken
+}
+
+func (m *kerbMech) Encode(in []byte) ([]byte, error) {
+	return append(append([]byte("["), in...), ']'), nil
+}
+
+func (m *kerbMech) Decode(in []byte) ([]byte, error) {
+	return in[1 : len(in)-1], nil
+}
+
+func newClient(t *testing.T, ssf uint) *sasl.SaslClient {
+	r := registry.New()
+	r.MustRegister("GSSAPI", func(common.MechConfig) common.Mech {
+		return &kerbMech{ssf: ssf}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := sasl.NewSaslClient("ldap", sasl.WithRegistry(r))
+	assert.NoError(t, err)
+	return &cli
+}
+
+func bindResponse(id, code int, creds []byte) []byte {
+	resp := appendInt(nil, tagEnumerated, code)
+	resp = appendTLV(resp, tagOctetString, nil)
+	resp = appendTLV(resp, tagOctetString, []byte("diagnostic"))
+	if creds != nil {
+		resp = appendTLV(resp, tagServerSASLCred, creds)
+	}
+
+	msg := appendInt(nil, tagInteger, id)
+	msg = appendTLV(msg, tagBindResponse, resp)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// parseBindRequest decodes what BindRequest encodes
+func parseBindRequest(t *testing.T, msg []byte) (id int, mech string, creds []byte) {
+	_, body, _, err := parseTLV(msg)
+	assert.NoError(t, err)
+	_, idb, body, err := parseTLV(body)
+	assert.NoError(t, err)
+	id, _ = parseInt(idb)
+
+	tag, req, _, err := parseTLV(body)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(tagBindRequest), tag)
+	_, version, req, _ := parseTLV(req)
+	assert.Equal(t, []byte{3}, version)
+	_, _, req, _ = parseTLV(req)
+
+	tag, auth, _, _ := parseTLV(req)
+	assert.Equal(t, byte(tagSASLAuth), tag)
+	_, m, auth, _ := parseTLV(auth)
+	if len(auth) > 0 {
+		_, creds, _, _ = parseTLV(auth)
+	}
+
+	return id, string(m), creds
+}
+
+// serve answers each bind request with the next response
+func serve(t *testing.T, conn net.Conn, responses ...[]byte) <-chan []string {
+	done := make(chan []string, 1)
+	go func() {
+		var got []string
+		for _, resp := range responses {
+			msg, err := readMessage(conn)
+			if err != nil {
+				break
+			}
+			id, mech, creds := parseBindRequest(t, msg)
+			got = append(got, string(rune('0'+id))+" "+mech+" "+string(creds))
+			conn.Write(resp)
+		}
+		done <- got
+	}()
+	return done
+}
+
+func TestBER(t *testing.T) {
+	for _, v := range []int{0, 1, 127, 128, 255, 256, 65535, 1 << 24} {
+		tag, content, rest, err := parseTLV(appendInt(nil, tagInteger, v))
+		assert.NoError(t, err)
+		assert.Equal(t, byte(tagInteger), tag)
+		assert.Empty(t, rest)
+		got, err := parseInt(content)
+		assert.NoError(t, err)
+		assert.Equal(t, v, got)
+	}
+
+	long := make([]byte, 300)
+	_, content, _, err := parseTLV(appendTLV(nil, tagOctetString, long))
+	assert.NoError(t, err)
+	assert.Equal(t, long, content)
+
+	_, _, _, err = parseTLV([]byte{tagOctetString, 5, 1})
+	assert.ErrorIs(t, err, common.ErrProtocol)
+}
+
+func TestBind(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	got := serve(t, server,
+		bindResponse(1, ResultSASLBindInProgress, []byte("AP-REP")),
+		bindResponse(2, ResultSASLBindInProgress, []byte("offer")),
+		bindResponse(3, ResultSuccess, nil),
+	)
+	conn, err := Bind(client, newClient(t, 0), []string{"GSSAPI", "PLAIN"}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, client, conn)
+	assert.Equal(t, []string{"1 GSSAPI AP-REQ", "2 GSSAPI ", "3 GSSAPI choice"}, <-got)
+}
+
+func TestBindLayer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	got := serve(t, server,
+		bindResponse(5, ResultSASLBindInProgress, []byte("AP-REP")),
+		bindResponse(6, ResultSASLBindInProgress, []byte("offer")),
+		bindResponse(7, ResultSuccess, nil),
+	)
+	conn, err := Bind(client, newClient(t, 56), nil, 5)
+	assert.NoError(t, err)
+	<-got
+
+	go conn.Write([]byte("search"))
+	b := make([]byte, 4+8)
+	_, err = io.ReadFull(server, b)
+	assert.NoError(t, err)
+	assert.Equal(t, "\x00\x00\x00\x08[search]", string(b))
+}
+
+func TestBindFailure(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	serve(t, server, bindResponse(1, ResultInvalidCredentials, nil))
+	_, err := Bind(client, newClient(t, 0), nil, 1)
+	assert.ErrorIs(t, err, common.ErrAuthFailed)
+	var resultErr *ResultError
+	assert.ErrorAs(t, err, &resultErr)
+	assert.Equal(t, ResultInvalidCredentials, resultErr.Code)
+	assert.Equal(t, "diagnostic", resultErr.Message)
+
+	// a response to another request
+	serve(t, server, bindResponse(9, ResultSuccess, nil))
+	_, err = Bind(client, newClient(t, 0), nil, 1)
+	assert.ErrorIs(t, err, common.ErrProtocol)
+
+	assert.ErrorIs(t, &ResultError{Code: ResultStrongerAuthRequired}, common.ErrWeakSecurity)
+	assert.NotErrorIs(t, &ResultError{Code: 51}, common.ErrProtocol)
+}
+
+func TestGSSAPIClient(t *testing.T) {
+	g := GSSAPIClient{newClient(t, 0)}
+
+	out, more, err := g.InitSecContext("ldap/ldap.example.com", nil)
+	assert.NoError(t, err)
+	assert.True(t, more)
+	assert.Equal(t, []byte("AP-REQ"), out)
+
+	_, more, err = g.InitSecContext("ldap/ldap.example.com", []byte("AP-REP"))
+	assert.NoError(t, err)
+	assert.False(t, more)
+
+	out, err = g.NegotiateSaslAuth([]byte("offer"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("choice"), out)
+	assert.NoError(t, g.DeleteSecContext())
+
+	// go-ldap would send the data unprotected
+	g = GSSAPIClient{newClient(t, 56)}
+	g.InitSecContext("ldap/ldap.example.com", nil)
+	g.InitSecContext("ldap/ldap.example.com", []byte("AP-REP"))
+	_, err = g.NegotiateSaslAuth([]byte("offer"), "")
+	assert.ErrorIs(t, err, common.ErrBadConfig)
+}
+
+func searchMessage(id int, tag byte, body []byte) []byte {
+	msg := appendInt(nil, tagInteger, id)
+	msg = appendTLV(msg, tag, body)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+func rootDSEEntry(attr string, values ...string) []byte {
+	var vals []byte
+	for _, v := range values {
+		vals = appendTLV(vals, tagOctetString, []byte(v))
+	}
+	partial := appendTLV(nil, tagOctetString, []byte(attr))
+	partial = appendTLV(partial, tagSet, vals)
+
+	entry := appendTLV(nil, tagOctetString, nil)
+	return appendTLV(entry, tagSequence, appendTLV(nil, tagSequence, partial))
+}
+
+func searchDone(code int) []byte {
+	resp := appendInt(nil, tagEnumerated, code)
+	resp = appendTLV(resp, tagOctetString, nil)
+	return appendTLV(resp, tagOctetString, []byte("diagnostic"))
+}
+
+func TestSupportedMechs(t *testing.T) {
+	var tests = []struct {
+		responses [][]byte
+		mechs     []string
+		err       error
+	}{
+		{[][]byte{
+			searchMessage(1, tagSearchResultEntry, rootDSEEntry("supportedSASLMechanisms", "GSSAPI", "EXTERNAL")),
+			searchMessage(1, tagSearchResultDone, searchDone(ResultSuccess)),
+		}, []string{"GSSAPI", "EXTERNAL"}, nil},
+		{[][]byte{
+			searchMessage(1, tagSearchResultEntry, rootDSEEntry("namingContexts", "dc=example,dc=com")),
+			searchMessage(1, tagSearchResultDone, searchDone(ResultSuccess)),
+		}, nil, nil},
+		{[][]byte{
+			searchMessage(1, tagSearchResultDone, searchDone(ResultInappropriateAuthentication)),
+		}, nil, common.ErrAuthFailed},
+		{[][]byte{
+			searchMessage(2, tagSearchResultDone, searchDone(ResultSuccess)),
+		}, nil, common.ErrProtocol},
+	}
+
+	for i, tt := range tests {
+		client, server := net.Pipe()
+		go func() {
+			msg, err := readMessage(server)
+			assert.NoError(t, err)
+			tag, body, err := parseMessage(msg, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, byte(tagSearchRequest), tag)
+			assert.Contains(t, string(body), "supportedSASLMechanisms")
+			for _, resp := range tt.responses {
+				server.Write(resp)
+			}
+		}()
+
+		mechs, err := SupportedMechs(client, 1)
+		if tt.err == nil {
+			assert.NoError(t, err, i)
+			assert.Equal(t, tt.mechs, mechs, i)
+		} else {
+			assert.ErrorIs(t, err, tt.err, i)
+		}
+
+		client.Close()
+		server.Close()
+	}
+}
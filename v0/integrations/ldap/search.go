@@ -0,0 +1,162 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package ldap
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+const (
+	tagBoolean           = 0x01
+	tagSet               = 0x31
+	tagSearchRequest     = 0x63 // [APPLICATION 3] constructed
+	tagSearchResultEntry = 0x64 // [APPLICATION 4] constructed
+	tagSearchResultDone  = 0x65 // [APPLICATION 5] constructed
+	tagSearchResultRef   = 0x73 // [APPLICATION 19] constructed
+	tagFilterPresent     = 0x87 // [7] primitive
+)
+
+// SupportedMechs reads the supportedSASLMechanisms attribute of the server's
+// root DSE (RFC 4512 § 5.1), for passing to Bind.  It sends a search request
+// with messageID and reads the results.
+func SupportedMechs(rw io.ReadWriter, messageID int) ([]string, error) {
+	if _, err := rw.Write(rootDSERequest(messageID, "supportedSASLMechanisms")); err != nil {
+		return nil, err
+	}
+
+	var mechs []string
+	for {
+		msg, err := readMessage(rw)
+		if err != nil {
+			return nil, err
+		}
+
+		tag, body, err := parseMessage(msg, messageID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case tagSearchResultEntry:
+			values, err := attributeValues(body, "supportedSASLMechanisms")
+			if err != nil {
+				return nil, err
+			}
+			mechs = append(mechs, values...)
+		case tagSearchResultDone:
+			code, message, err := parseResult(body)
+			if err != nil {
+				return nil, err
+			}
+			if code != ResultSuccess {
+				return nil, &ResultError{Code: code, Message: message}
+			}
+			return mechs, nil
+		case tagSearchResultRef:
+		default:
+			return nil, fmt.Errorf("ldap: unexpected message type %#02x: %w", tag, common.ErrProtocol)
+		}
+	}
+}
+
+// rootDSERequest encodes a base search of the root DSE for attr
+func rootDSERequest(messageID int, attr string) []byte {
+	var req []byte
+	req = appendTLV(req, tagOctetString, nil)   // baseObject
+	req = appendInt(req, tagEnumerated, 0)      // scope: baseObject
+	req = appendInt(req, tagEnumerated, 0)      // derefAliases: never
+	req = appendInt(req, tagInteger, 0)         // sizeLimit
+	req = appendInt(req, tagInteger, 0)         // timeLimit
+	req = appendTLV(req, tagBoolean, []byte{0}) // typesOnly
+	req = appendTLV(req, tagFilterPresent, []byte("objectClass"))
+	req = appendTLV(req, tagSequence, appendTLV(nil, tagOctetString, []byte(attr)))
+
+	msg := appendInt(nil, tagInteger, messageID)
+	msg = appendTLV(msg, tagSearchRequest, req)
+	return appendTLV(nil, tagSequence, msg)
+}
+
+// parseMessage returns the protocol op of an LDAPMessage with messageID
+func parseMessage(msg []byte, messageID int) (tag byte, body []byte, err error) {
+	tag, body, _, err = parseTLV(msg)
+	if err != nil || tag != tagSequence {
+		return 0, nil, errBER
+	}
+
+	tag, id, body, err := parseTLV(body)
+	if err != nil || tag != tagInteger {
+		return 0, nil, errBER
+	}
+	respID, err := parseInt(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	if respID != messageID {
+		return 0, nil, fmt.Errorf("ldap: response to message %d, expected %d: %w", respID, messageID, common.ErrProtocol)
+	}
+
+	tag, body, _, err = parseTLV(body)
+	return tag, body, err
+}
+
+// parseResult decodes the resultCode and diagnosticMessage of an LDAPResult
+func parseResult(b []byte) (code int, message string, err error) {
+	var fields [3][]byte
+	for i := range fields {
+		var tag byte
+		if tag, fields[i], b, err = parseTLV(b); err != nil {
+			return 0, "", err
+		}
+		if want := []byte{tagEnumerated, tagOctetString, tagOctetString}[i]; tag != want {
+			return 0, "", errBER
+		}
+	}
+
+	code, err = parseInt(fields[0])
+	return code, string(fields[2]), err
+}
+
+// attributeValues returns the values of attr in a SearchResultEntry
+func attributeValues(entry []byte, attr string) ([]string, error) {
+	// objectName, then the attributes
+	_, _, rest, err := parseTLV(entry)
+	if err != nil {
+		return nil, err
+	}
+	tag, attrs, _, err := parseTLV(rest)
+	if err != nil || tag != tagSequence {
+		return nil, errBER
+	}
+
+	var values []string
+	for len(attrs) > 0 {
+		var partial, typ, vals []byte
+		if tag, partial, attrs, err = parseTLV(attrs); err != nil || tag != tagSequence {
+			return nil, errBER
+		}
+		if tag, typ, partial, err = parseTLV(partial); err != nil || tag != tagOctetString {
+			return nil, errBER
+		}
+		if tag, vals, _, err = parseTLV(partial); err != nil || tag != tagSet {
+			return nil, errBER
+		}
+		if !strings.EqualFold(string(typ), attr) {
+			continue
+		}
+
+		for len(vals) > 0 {
+			var v []byte
+			if tag, v, vals, err = parseTLV(vals); err != nil || tag != tagOctetString {
+				return nil, errBER
+			}
+			values = append(values, string(v))
+		}
+	}
+
+	return values, nil
+}
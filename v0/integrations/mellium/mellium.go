@@ -0,0 +1,73 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package mellium exposes SaslClients as mellium.im/sasl mechanisms.
+//
+// A mellium Mechanism is a struct whose functions take mellium's Negotiator,
+// so Mechanism here provides their bodies and the application fills in the
+// struct:
+//
+//	m := mellium.Mechanism{Name: "GSSAPI", NewClient: newClient}
+//	mech := sasl.Mechanism{
+//		Name: m.Name,
+//		Start: func(n *sasl.Negotiator) (bool, []byte, interface{}, error) {
+//			return m.Start(n.Credentials())
+//		},
+//		Next: func(n *sasl.Negotiator, challenge []byte, data interface{}) (bool, []byte, interface{}, error) {
+//			return m.Next(challenge, data)
+//		},
+//	}
+package mellium
+
+import (
+	"fmt"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// Mechanism runs one mechanism for mellium's Negotiator.  Each exchange uses a
+// new SaslClient, which is kept in the Negotiator's cache between steps.
+type Mechanism struct {
+	Name string
+
+	// NewClient returns the client for an exchange, given the credentials
+	// of the Negotiator.  The client must be able to use Name.
+	NewClient func(username, password, identity []byte) (*sasl.SaslClient, error)
+}
+
+// Start begins an exchange and returns the initial response
+func (m Mechanism) Start(username, password, identity []byte) (more bool, resp []byte, cache interface{}, err error) {
+	client, err := m.NewClient(username, password, identity)
+	if err != nil {
+		return false, nil, nil, err
+	}
+	if _, _, err = client.ChooseMech([]string{m.Name}); err != nil {
+		return false, nil, nil, err
+	}
+
+	mech, resp, err := client.Start()
+	switch {
+	case err != nil:
+		return false, nil, nil, err
+	case mech != m.Name:
+		return false, nil, nil, fmt.Errorf("mellium: client chose %s, not %s: %w", mech, m.Name, common.ErrBadConfig)
+	}
+
+	return !client.IsEstablished(), resp, client, nil
+}
+
+// Next processes a challenge;  data is the cache returned by the last step
+func (m Mechanism) Next(challenge []byte, data interface{}) (more bool, resp []byte, cache interface{}, err error) {
+	client, ok := data.(*sasl.SaslClient)
+	if !ok {
+		return false, nil, nil, fmt.Errorf("mellium: Next called without Start: %w", common.ErrNotStarted)
+	}
+
+	if resp, _, err = client.Step(challenge); err != nil {
+		return false, nil, client, err
+	}
+
+	return !client.IsEstablished(), resp, client, nil
+}
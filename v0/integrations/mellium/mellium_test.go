@@ -0,0 +1,74 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package mellium
+
+import (
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// testMech sends the user name, then answers "challenge" with the password
+type testMech struct {
+	cfg         common.MechConfig
+	established bool
+}
+
+func (m *testMech) Name() string                        { return "TEST" }
+func (m *testMech) MechProperties() common.MechProps    { return common.MechProps{} }
+func (m *testMech) IsEstablished() bool                 { return m.established }
+func (m *testMech) Close() error                        { return nil }
+func (m *testMech) ContextParams() common.ContextParams { return common.ContextParams{} }
+func (m *testMech) Encode(in []byte) ([]byte, error)    { return in, nil }
+func (m *testMech) Decode(in []byte) ([]byte, error)    { return in, nil }
+
+func (m *testMech) Step(in []byte) ([]byte, common.StepStatus, error) {
+	if in == nil {
+		return []byte(m.cfg.ExtraProps["user"]), common.StepContinue, nil
+	}
+	if string(in) != "challenge" {
+		return nil, common.StepContinue, common.ErrBadToken
+	}
+	m.established = true
+	password, err := m.cfg.Prompt(common.Prompt{Type: common.PromptPassword})
+	return password, common.StepDoneWithFinalToken, err
+}
+
+func newClient(username, password, identity []byte) (*sasl.SaslClient, error) {
+	r := registry.New()
+	r.MustRegister("TEST", func(cfg common.MechConfig) common.Mech {
+		return &testMech{cfg: cfg}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := sasl.NewSaslClient("xmpp", sasl.WithRegistry(r),
+		sasl.WithExtraProps("user", string(username)), sasl.WithPassword(password))
+	return &cli, err
+}
+
+func TestMechanism(t *testing.T) {
+	m := Mechanism{Name: "TEST", NewClient: newClient}
+
+	more, resp, cache, err := m.Start([]byte("user"), []byte("pass"), nil)
+	assert.NoError(t, err)
+	assert.True(t, more)
+	assert.Equal(t, []byte("user"), resp)
+
+	more, resp, _, err = m.Next([]byte("challenge"), cache)
+	assert.NoError(t, err)
+	assert.False(t, more)
+	assert.Equal(t, []byte("pass"), resp)
+
+	_, _, cache, _ = m.Start([]byte("user"), []byte("pass"), nil)
+	_, _, _, err = m.Next([]byte("garbage"), cache)
+	assert.ErrorIs(t, err, common.ErrBadToken)
+
+	_, _, _, err = m.Next([]byte("challenge"), nil)
+	assert.ErrorIs(t, err, common.ErrNotStarted)
+
+	_, _, _, err = Mechanism{Name: "PLAIN", NewClient: newClient}.Start(nil, nil, nil)
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
@@ -0,0 +1,148 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package mongo runs SASL authentication in MongoDB's saslStart and
+// saslContinue command conversation.  GSSAPI clients should use the service
+// name "mongodb".
+//
+// The commands are BSON documents, which this package leaves to the driver:
+// it supplies a RunFunc that encodes each Command and decodes the Reply.
+package mongo
+
+import (
+	"context"
+	"fmt"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+)
+
+// Command is a saslStart command if ConversationID is zero, otherwise a
+// saslContinue command
+type Command struct {
+	Mechanism      string // saslStart only
+	ConversationID int32
+	Payload        []byte
+}
+
+// Name returns the command name
+func (c Command) Name() string {
+	if c.ConversationID == 0 {
+		return "saslStart"
+	}
+	return "saslContinue"
+}
+
+// Reply holds the fields of the server's reply to a command
+type Reply struct {
+	ConversationID int32
+	Done           bool
+	Payload        []byte
+}
+
+// RunFunc sends a command to the authentication database and returns the
+// reply.  Replies with "ok: 0" should be returned as errors.
+type RunFunc func(ctx context.Context, cmd Command) (Reply, error)
+
+// Authenticate chooses a mechanism from mechs, if it isn't nil, and runs the
+// conversation with run.  mechs is usually the saslSupportedMechs of the
+// server's hello reply.
+func Authenticate(ctx context.Context, client *sasl.SaslClient, mechs []string, run RunFunc) error {
+	if mechs != nil {
+		if _, _, err := client.ChooseMech(mechs); err != nil {
+			return err
+		}
+	}
+
+	return saslconn.Handshake(client, NewExchanger(ctx, run))
+}
+
+// Exchanger sends SASL messages in saslStart and saslContinue commands
+type Exchanger struct {
+	ctx context.Context
+	run RunFunc
+	id  int32
+}
+
+var _ saslconn.TokenExchanger = (*Exchanger)(nil)
+
+// NewExchanger returns an Exchanger that runs commands with run
+func NewExchanger(ctx context.Context, run RunFunc) *Exchanger {
+	return &Exchanger{ctx: ctx, run: run}
+}
+
+func (e *Exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	// saslStart always carries a payload
+	if initialResponse == nil {
+		initialResponse = []byte{}
+	}
+
+	reply, err := e.run(e.ctx, Command{Mechanism: mech, Payload: initialResponse})
+	if err != nil {
+		return nil, false, err
+	}
+	if reply.ConversationID == 0 {
+		return nil, false, fmt.Errorf("mongo: saslStart reply has no conversation ID: %w", common.ErrProtocol)
+	}
+
+	e.id = reply.ConversationID
+	return e.challenge(reply)
+}
+
+func (e *Exchanger) Next(response []byte) ([]byte, bool, error) {
+	if response == nil {
+		response = []byte{}
+	}
+
+	reply, err := e.run(e.ctx, Command{ConversationID: e.id, Payload: response})
+	if err != nil {
+		return nil, false, err
+	}
+	if reply.ConversationID != e.id {
+		return nil, false, fmt.Errorf("mongo: reply to conversation %d, expected %d: %w", reply.ConversationID, e.id, common.ErrProtocol)
+	}
+
+	return e.challenge(reply)
+}
+
+func (e *Exchanger) challenge(reply Reply) ([]byte, bool, error) {
+	// the server omits an empty final payload
+	if !reply.Done && reply.Payload == nil {
+		reply.Payload = []byte{}
+	}
+	if reply.Done && len(reply.Payload) == 0 {
+		reply.Payload = nil
+	}
+
+	return reply.Payload, reply.Done, nil
+}
+
+// DriverClient adapts client to the SaslClient interface of the MongoDB Go
+// driver's x/mongo/driver/auth package, for use with ConductSaslConversation
+type DriverClient struct {
+	Client *sasl.SaslClient
+}
+
+func (d DriverClient) Start() (string, []byte, error) {
+	mech, ir, err := d.Client.Start()
+	if err == nil && ir == nil {
+		ir = []byte{}
+	}
+
+	return mech, ir, err
+}
+
+func (d DriverClient) Next(challenge []byte) ([]byte, error) {
+	out, _, err := d.Client.Step(challenge)
+	return out, err
+}
+
+func (d DriverClient) Completed() bool {
+	return d.Client.IsEstablished()
+}
+
+func (d DriverClient) Close() {
+	d.Client.Close()
+}
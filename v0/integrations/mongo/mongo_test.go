@@ -0,0 +1,140 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package mongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// scramMech imitates SCRAM:  it is established by the server's final message
+type scramMech struct {
+	stage int
+}
+
+func (m *scramMech) Name() string                        { return "SCRAM-TEST" }
+func (m *scramMech) MechProperties() common.MechProps    { return common.MechProps{} }
+func (m *scramMech) IsEstablished() bool                 { return m.stage == 2 }
+func (m *scramMech) Close() error                        { return nil }
+func (m *scramMech) ContextParams() common.ContextParams { return common.ContextParams{} }
+func (m *scramMech) Encode(in []byte) ([]byte, error)    { return in, nil }
+func (m *scramMech) Decode(in []byte) ([]byte, error)    { return in, nil }
+
+func (m *scramMech) Step(in []byte) ([]byte, common.StepStatus, error) {
+	switch {
+	case in == nil && m.stage == 0:
+		return []byte("client-first"), common.StepContinue, nil
+	case m.stage == 0 && string(in) == "server-first":
+		m.stage = 1
+		return []byte("client-final"), common.StepContinue, nil
+	case m.stage == 1 && string(in) == "server-final":
+		m.stage = 2
+		return nil, common.StepDone, nil
+	}
+	return nil, common.StepContinue, common.ErrAuthFailed
+}
+
+func newClient(t *testing.T) *sasl.SaslClient {
+	r := registry.New()
+	r.MustRegister("SCRAM-TEST", func(common.MechConfig) common.Mech {
+		return &scramMech{}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := sasl.NewSaslClient("mongodb", sasl.WithRegistry(r))
+	assert.NoError(t, err)
+	return &cli
+}
+
+// server replies in turn and records the commands it was sent
+type server struct {
+	replies []Reply
+	got     []Command
+}
+
+func (s *server) run(ctx context.Context, cmd Command) (Reply, error) {
+	s.got = append(s.got, cmd)
+	if len(s.replies) == 0 {
+		return Reply{}, errors.New("command failed: AuthenticationFailed")
+	}
+	reply := s.replies[0]
+	s.replies = s.replies[1:]
+	return reply, nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	// with skipEmptyExchange the final message comes with done
+	s := &server{replies: []Reply{
+		{ConversationID: 7, Payload: []byte("server-first")},
+		{ConversationID: 7, Done: true, Payload: []byte("server-final")},
+	}}
+	assert.NoError(t, Authenticate(context.Background(), newClient(t), []string{"SCRAM-SHA-1", "SCRAM-TEST"}, s.run))
+	assert.Equal(t, []Command{
+		{Mechanism: "SCRAM-TEST", Payload: []byte("client-first")},
+		{ConversationID: 7, Payload: []byte("client-final")},
+	}, s.got)
+	assert.Equal(t, "saslStart", s.got[0].Name())
+	assert.Equal(t, "saslContinue", s.got[1].Name())
+
+	// otherwise the client sends an empty message
+	s = &server{replies: []Reply{
+		{ConversationID: 7, Payload: []byte("server-first")},
+		{ConversationID: 7, Payload: []byte("server-final")},
+		{ConversationID: 7, Done: true},
+	}}
+	assert.NoError(t, Authenticate(context.Background(), newClient(t), nil, s.run))
+	assert.Equal(t, []byte{}, s.got[2].Payload)
+}
+
+func TestAuthenticateFailure(t *testing.T) {
+	s := &server{}
+	err := Authenticate(context.Background(), newClient(t), nil, s.run)
+	assert.EqualError(t, err, "command failed: AuthenticationFailed")
+
+	s = &server{replies: []Reply{{Payload: []byte("server-first")}}}
+	err = Authenticate(context.Background(), newClient(t), nil, s.run)
+	assert.ErrorIs(t, err, common.ErrProtocol)
+
+	s = &server{replies: []Reply{
+		{ConversationID: 7, Payload: []byte("server-first")},
+		{ConversationID: 8, Done: true, Payload: []byte("server-final")},
+	}}
+	err = Authenticate(context.Background(), newClient(t), nil, s.run)
+	assert.ErrorIs(t, err, common.ErrProtocol)
+
+	// done before the server proved itself
+	s = &server{replies: []Reply{
+		{ConversationID: 7, Payload: []byte("server-first")},
+		{ConversationID: 7, Done: true},
+	}}
+	err = Authenticate(context.Background(), newClient(t), nil, s.run)
+	assert.ErrorIs(t, err, common.ErrAuthFailed)
+
+	err = Authenticate(context.Background(), newClient(t), []string{"PLAIN"}, s.run)
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
+
+func TestDriverClient(t *testing.T) {
+	d := DriverClient{newClient(t)}
+	defer d.Close()
+
+	mech, ir, err := d.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "SCRAM-TEST", mech)
+	assert.Equal(t, []byte("client-first"), ir)
+
+	out, err := d.Next([]byte("server-first"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("client-final"), out)
+	assert.False(t, d.Completed())
+
+	_, err = d.Next([]byte("server-final"))
+	assert.NoError(t, err)
+	assert.True(t, d.Completed())
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package negotiate implements the HTTP Negotiate authentication scheme
+// (RFC 4559) with the GSSAPI mechanism in HTTP mode.
+package negotiate
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// Scheme is the name of the authentication scheme
+const Scheme = "Negotiate"
+
+// the most requests sent to authenticate one request
+const maxLegs = 10
+
+// Transport is an http.RoundTripper that authenticates with the Negotiate
+// scheme when the server responds 401 Unauthorized.  Requests with a body are
+// only retried if it can be replayed, ie. GetBody is set.
+type Transport struct {
+	// Base sends the requests;  http.DefaultTransport if nil.  Multi-leg
+	// exchanges need it to reuse the connection between legs.
+	Base http.RoundTripper
+
+	// NewClient returns the client used to authenticate a request to host.
+	// By default this uses GSSAPI in HTTP mode for the HTTP service, which
+	// must be enabled, eg. by importing the allmechs package.
+	NewClient func(host string) (*sasl.SaslClient, error)
+
+	// Mutual requires the server to authenticate itself with a token in its
+	// final response
+	Mutual bool
+}
+
+var _ http.RoundTripper = (*Transport)(nil)
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *Transport) newClient(host string) (*sasl.SaslClient, error) {
+	if t.NewClient != nil {
+		return t.NewClient(host)
+	}
+
+	client, err := sasl.NewSaslClient("HTTP",
+		sasl.WithServerFQDN(host),
+		sasl.WithNeedHTTP(),
+		sasl.WithMechList([]string{"GSSAPI"}),
+	)
+	return &client, err
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base().RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	if _, ok, _ := challenge(resp.Header); !ok {
+		return resp, nil
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+
+	client, err := t.newClient(req.URL.Hostname())
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	defer client.Close()
+
+	_, out, err := client.Start()
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	var token []byte
+	for legs := 1; ; legs++ {
+		discard(resp)
+
+		retry, err := authorized(req, out)
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = t.base().RoundTrip(retry); err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		if token, ok, err = challenge(resp.Header); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			break
+		}
+
+		// without a token the server rejected the client
+		if !ok || token == nil || legs == maxLegs {
+			return resp, nil
+		}
+		if out, _, err = client.Step(token); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+
+	// the final token authenticates the server
+	if token != nil && !client.IsEstablished() {
+		if _, _, err = client.Step(token); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	if t.Mutual && (token == nil || !client.IsEstablished()) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("negotiate: %w", common.ErrNoMutualAuth)
+	}
+
+	return resp, nil
+}
+
+// authorized returns a copy of req carrying token
+func authorized(req *http.Request, token []byte) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+
+	retry.Header.Set("Authorization", Scheme+" "+base64.StdEncoding.EncodeToString(token))
+	return retry, nil
+}
+
+// discard reads the rest of the body so that the connection can be reused
+func discard(resp *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
+
+// challenge returns the token in the Negotiate challenge of a WWW-Authenticate
+// header.  ok is false if the scheme isn't offered, and token is nil if the
+// challenge has no token.
+func challenge(h http.Header) (token []byte, ok bool, err error) {
+	// h.Values needs Go 1.14
+	for _, v := range h["Www-Authenticate"] {
+		for _, c := range strings.Split(v, ",") {
+			if token, ok, err = parse(c); ok {
+				return token, ok, err
+			}
+		}
+	}
+
+	return nil, false, nil
+}
+
+// parse returns the token in "Negotiate [token]"
+func parse(value string) (token []byte, ok bool, err error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], Scheme) {
+		return nil, false, nil
+	}
+	if len(fields) == 1 {
+		return nil, true, nil
+	}
+	if len(fields) > 2 {
+		return nil, true, fmt.Errorf("negotiate: bad %s header: %w", Scheme, common.ErrProtocol)
+	}
+
+	if token, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+		return nil, true, fmt.Errorf("negotiate: bad token: %w", common.ErrBadToken)
+	}
+	return token, true, nil
+}
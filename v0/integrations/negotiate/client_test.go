@@ -0,0 +1,117 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package negotiate
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/internal/testmech"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/golang-auth/go-sasl/saslmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClient(string) (*sasl.SaslClient, error) {
+	r := registry.New()
+	props := testmech.Props
+	props.Fearures = common.FeatSupportsHTTP
+	if err := testmech.New("GSSAPI", testmech.WithMutual(), saslmock.WithProps(props)).Register(r); err != nil {
+		return nil, err
+	}
+
+	cli, err := sasl.NewSaslClient("HTTP", sasl.WithRegistry(r), sasl.WithNeedHTTP())
+	return &cli, err
+}
+
+// server runs the exchange, finishing with final in the 200 response
+func server(t *testing.T, final string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.Header.Get("Authorization") {
+		case "":
+			w.Header().Add("WWW-Authenticate", `Basic realm="a, b"`)
+			w.Header().Add("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+		case "Negotiate aGVsbG8=":
+			w.Header().Set("WWW-Authenticate", "Negotiate Y2hhbGxlbmdl")
+			w.WriteHeader(http.StatusUnauthorized)
+		case "Negotiate cmVzcG9uc2U=":
+			if final != "" {
+				w.Header().Set("WWW-Authenticate", "Negotiate "+final)
+			}
+			w.Write(body)
+		default:
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+}
+
+func TestTransport(t *testing.T) {
+	var tests = []struct {
+		name   string
+		final  string
+		mutual bool
+		err    error
+	}{
+		{"mutual", "bXV0dWFs", true, nil},
+		{"not verified", "", false, nil},
+		{"no final token", "", true, common.ErrNoMutualAuth},
+		{"bad final token", "Ym9ndXM=", false, common.ErrBadToken},
+		{"bad base64", "!!", false, common.ErrBadToken},
+	}
+
+	for _, tt := range tests {
+		srv := server(t, tt.final)
+		c := http.Client{Transport: &Transport{NewClient: newClient, Mutual: tt.mutual}}
+
+		resp, err := c.Post(srv.URL, "text/plain", strings.NewReader("request body"))
+		if tt.err == nil {
+			assert.NoError(t, err, tt.name)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, tt.name)
+			body, _ := ioutil.ReadAll(resp.Body)
+			assert.Equal(t, "request body", string(body), tt.name)
+			resp.Body.Close()
+		} else {
+			assert.ErrorIs(t, err, tt.err, tt.name)
+		}
+
+		srv.Close()
+	}
+}
+
+func TestTransportRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	c := http.Client{Transport: &Transport{NewClient: newClient}}
+	resp, err := c.Get(srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestChallenge(t *testing.T) {
+	h := http.Header{}
+	_, ok, _ := challenge(h)
+	assert.False(t, ok)
+
+	h.Add("WWW-Authenticate", "Basic realm=x, negotiate   aGVsbG8=")
+	token, ok, err := challenge(h)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("hello"), token)
+
+	_, _, err = challenge(http.Header{"Www-Authenticate": {"Negotiate a b"}})
+	assert.ErrorIs(t, err, common.ErrProtocol)
+}
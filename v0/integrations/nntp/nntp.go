@@ -0,0 +1,184 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package nntp authenticates NNTP connections with AUTHINFO SASL (RFC 4643),
+// eg. with GSSAPI to INN.
+package nntp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// the longest command line, including CRLF (RFC 4643 § 2.4)
+const maxCommandLength = 497
+
+// Mechs returns the mechanisms in the SASL line of a CAPABILITIES response
+func Mechs(caps []string) []string {
+	for _, line := range caps {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.EqualFold(fields[0], "SASL") {
+			mechs := fields[1:]
+			for i := range mechs {
+				mechs[i] = strings.ToUpper(mechs[i])
+			}
+			return mechs
+		}
+	}
+
+	return nil
+}
+
+// Authenticate chooses a mechanism from the server's CAPABILITIES response
+// and authenticates conn.  The returned connection applies the negotiated
+// security layer, which starts after the 281 or 283 response.
+func Authenticate(conn net.Conn, client *sasl.SaslClient, caps []string) (net.Conn, error) {
+	if _, _, err := client.ChooseMech(Mechs(caps)); err != nil {
+		return nil, err
+	}
+
+	// the server sends nothing after the final response until the next
+	// command, so nothing is left in the buffer
+	return saslconn.Client(conn, client, NewExchanger(bufio.NewReader(conn), conn))
+}
+
+// Exchanger sends SASL messages with AUTHINFO SASL
+type Exchanger struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+var _ saslconn.TokenExchanger = (*Exchanger)(nil)
+var _ saslconn.Canceler = (*Exchanger)(nil)
+
+// NewExchanger returns an Exchanger that runs AUTHINFO SASL on r and w
+func NewExchanger(r *bufio.Reader, w io.Writer) *Exchanger {
+	return &Exchanger{r: r, w: w}
+}
+
+func (e *Exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	cmd := "AUTHINFO SASL " + mech
+	if encoded, ok := sasl.EncodeInitialResponse(initialResponse); ok && len(cmd)+1+len(encoded)+2 <= maxCommandLength {
+		cmd += " " + encoded
+		initialResponse = nil
+	}
+
+	if err := e.send(cmd); err != nil {
+		return nil, false, err
+	}
+
+	challenge, done, err := e.receive()
+	if err != nil || done || initialResponse == nil {
+		return challenge, done, err
+	}
+
+	// the initial response was too long for the command line, so it is
+	// sent after an empty challenge
+	if len(challenge) != 0 {
+		return nil, false, fmt.Errorf("nntp: challenge sent before the initial response: %w", common.ErrProtocol)
+	}
+
+	return e.Next(initialResponse)
+}
+
+func (e *Exchanger) Next(response []byte) ([]byte, bool, error) {
+	line := wire.EncodeBase64(response)
+	if len(response) == 0 {
+		line = "="
+	}
+
+	if err := e.send(line); err != nil {
+		return nil, false, err
+	}
+
+	return e.receive()
+}
+
+// Cancel aborts the exchange;  the server's 481 response is expected
+func (e *Exchanger) Cancel() error {
+	if err := e.send(wire.Cancel); err != nil {
+		return err
+	}
+
+	_, _, err := e.receive()
+	if _, ok := err.(*ResponseError); ok {
+		return nil
+	}
+	return err
+}
+
+func (e *Exchanger) send(line string) error {
+	_, err := io.WriteString(e.w, line+"\r\n")
+	return err
+}
+
+// ResponseError is a response that ends the exchange unsuccessfully.  Code
+// 481 matches common.ErrAuthFailed and 483 common.ErrWeakSecurity.
+type ResponseError struct {
+	Code int
+	Text string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("nntp: %d %s", e.Code, e.Text)
+}
+
+func (e *ResponseError) Is(target error) bool {
+	switch e.Code {
+	case 481:
+		return target == common.ErrAuthFailed
+	case 483:
+		return target == common.ErrWeakSecurity
+	}
+
+	return false
+}
+
+// receive returns the next challenge, or done with any additional data once
+// the server reports success
+func (e *Exchanger) receive() (challenge []byte, done bool, err error) {
+	line, err := e.r.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	if len(line) < 3 {
+		return nil, false, fmt.Errorf("nntp: unexpected response %q: %w", line, common.ErrProtocol)
+	}
+	code, err := strconv.Atoi(line[:3])
+	if err != nil {
+		return nil, false, fmt.Errorf("nntp: unexpected response %q: %w", line, common.ErrProtocol)
+	}
+	text := strings.TrimPrefix(line[3:], " ")
+
+	switch code {
+	case 281:
+		return nil, true, nil
+	case 283:
+		data, err := sasl.DecodeInitialResponse(text)
+		return data, err == nil, err
+	case 383:
+		if text == "=" {
+			return []byte{}, false, nil
+		}
+		challenge, err = wire.NNTPContinuation.Decode(line)
+		if errors.Is(err, wire.ErrNotContinuation) {
+			err = fmt.Errorf("nntp: unexpected response %q: %w", line, common.ErrProtocol)
+		}
+		return challenge, false, err
+	}
+
+	return nil, false, &ResponseError{Code: code, Text: text}
+}
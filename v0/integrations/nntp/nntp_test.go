@@ -0,0 +1,125 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package nntp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/internal/testmech"
+	"github.com/golang-auth/go-sasl/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMechs(t *testing.T) {
+	caps := []string{"VERSION 2", "READER", "SASL gssapi PLAIN", "AUTHINFO SASL"}
+	assert.Equal(t, []string{"GSSAPI", "PLAIN"}, Mechs(caps))
+	assert.Nil(t, Mechs([]string{"VERSION 2"}))
+}
+
+func TestAuthenticate(t *testing.T) {
+	var tests = []struct {
+		name   string
+		script []string
+		err    error
+	}{
+		{"success", []string{
+			"C: AUTHINFO SASL TEST aGVsbG8=",
+			"S: 383 Y2hhbGxlbmdl",
+			"C: cmVzcG9uc2U=",
+			"S: 281 Authentication accepted",
+		}, nil},
+		{"success with data", []string{
+			"C: AUTHINFO SASL TEST aGVsbG8=",
+			"S: 383 Y2hhbGxlbmdl",
+			"C: cmVzcG9uc2U=",
+			"S: 283 ZG9uZQ==",
+		}, nil},
+		{"rejected", []string{
+			"C: AUTHINFO SASL TEST aGVsbG8=",
+			"S: 481 Authentication failed",
+		}, common.ErrAuthFailed},
+		{"encryption required", []string{
+			"C: AUTHINFO SASL TEST aGVsbG8=",
+			"S: 483 Encryption or stronger authentication required",
+		}, common.ErrWeakSecurity},
+		{"cancelled", []string{
+			"C: AUTHINFO SASL TEST aGVsbG8=",
+			"S: 383 Z2FyYmFnZQ==",
+			"C: *",
+			"S: 481 Authentication cancelled",
+		}, common.ErrBadToken},
+		{"bad response", []string{
+			"C: AUTHINFO SASL TEST aGVsbG8=",
+			"S: hello",
+		}, common.ErrProtocol},
+	}
+
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		got := testmech.Serve(server, tt.script...)
+
+		conn, err := Authenticate(client, testmech.NewClient(t, "news", 0), []string{"SASL TEST"})
+		if tt.err == nil {
+			assert.NoError(t, err, tt.name)
+			assert.Equal(t, client, conn, tt.name)
+		} else {
+			assert.ErrorIs(t, err, tt.err, tt.name)
+		}
+		assert.Equal(t, testmech.Expected(tt.script), <-got, tt.name)
+
+		client.Close()
+		server.Close()
+	}
+
+	_, err := Authenticate(nil, testmech.NewClient(t, "news", 0), []string{"SASL PLAIN"})
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
+
+func TestEmptyMessages(t *testing.T) {
+	var out strings.Builder
+	in := "383 =\r\n383 =\r\n281 done\r\n"
+	e := NewExchanger(bufio.NewReader(strings.NewReader(in)), &out)
+
+	// too long for the command line
+	long := []byte(strings.Repeat("x", 400))
+	challenge, done, err := e.Start("TEST", long)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, []byte{}, challenge)
+	assert.Equal(t, "AUTHINFO SASL TEST\r\n"+wire.EncodeBase64(long)+"\r\n", out.String())
+
+	// an empty response is "="
+	out.Reset()
+	_, done, err = e.Next([]byte{})
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "=\r\n", out.String())
+}
+
+func TestSecurityLayer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	got := testmech.Serve(server,
+		"C: AUTHINFO SASL TEST aGVsbG8=",
+		"S: 383 Y2hhbGxlbmdl",
+		"C: cmVzcG9uc2U=",
+		"S: 281 Authentication accepted",
+	)
+	conn, err := Authenticate(client, testmech.NewClient(t, "news", 56), []string{"SASL TEST"})
+	assert.NoError(t, err)
+	<-got
+
+	go conn.Write([]byte("LIST\r\n"))
+	b := make([]byte, 4+8)
+	_, err = io.ReadFull(server, b)
+	assert.NoError(t, err)
+	assert.Equal(t, "\x00\x00\x00\x08[LIST\r\n]", string(b))
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package pop3 authenticates POP3 connections with the AUTH command
+// (RFC 5034).
+package pop3
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// the longest command line, including CRLF (RFC 5034 § 4)
+const maxCommandLength = 255
+
+// Mechs returns the mechanisms in the SASL line of a CAPA response
+func Mechs(capa []string) []string {
+	for _, line := range capa {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.EqualFold(fields[0], "SASL") {
+			mechs := fields[1:]
+			for i := range mechs {
+				mechs[i] = strings.ToUpper(mechs[i])
+			}
+			return mechs
+		}
+	}
+
+	return nil
+}
+
+// Authenticate chooses a mechanism from the server's CAPA response and
+// authenticates conn.  The returned connection applies the negotiated
+// security layer, which starts after the +OK response.
+func Authenticate(conn net.Conn, client *sasl.SaslClient, capa []string) (net.Conn, error) {
+	if _, _, err := client.ChooseMech(Mechs(capa)); err != nil {
+		return nil, err
+	}
+
+	// the server sends nothing after +OK until the next command, so nothing
+	// is left in the buffer
+	return saslconn.Client(conn, client, NewExchanger(bufio.NewReader(conn), conn))
+}
+
+// Exchanger sends SASL messages with the AUTH command
+type Exchanger struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+var _ saslconn.TokenExchanger = (*Exchanger)(nil)
+var _ saslconn.Canceler = (*Exchanger)(nil)
+
+// NewExchanger returns an Exchanger that runs AUTH on r and w
+func NewExchanger(r *bufio.Reader, w io.Writer) *Exchanger {
+	return &Exchanger{r: r, w: w}
+}
+
+func (e *Exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	cmd := "AUTH " + mech
+	if encoded, ok := sasl.EncodeInitialResponse(initialResponse); ok && len(cmd)+1+len(encoded)+2 <= maxCommandLength {
+		cmd += " " + encoded
+		initialResponse = nil
+	}
+
+	if err := e.send(cmd); err != nil {
+		return nil, false, err
+	}
+
+	challenge, done, err := e.receive()
+	if err != nil || done || initialResponse == nil {
+		return challenge, done, err
+	}
+
+	// the initial response was too long for the command line, so it is
+	// sent after an empty challenge
+	if len(challenge) != 0 {
+		return nil, false, fmt.Errorf("pop3: challenge sent before the initial response: %w", common.ErrProtocol)
+	}
+
+	return e.Next(initialResponse)
+}
+
+func (e *Exchanger) Next(response []byte) ([]byte, bool, error) {
+	if err := e.send(wire.EncodeBase64(response)); err != nil {
+		return nil, false, err
+	}
+
+	return e.receive()
+}
+
+// Cancel aborts the exchange;  the server's -ERR response is expected
+func (e *Exchanger) Cancel() error {
+	if err := e.send(wire.Cancel); err != nil {
+		return err
+	}
+
+	_, _, err := e.receive()
+	if _, ok := err.(*ServerError); ok {
+		return nil
+	}
+	return err
+}
+
+func (e *Exchanger) send(line string) error {
+	_, err := io.WriteString(e.w, line+"\r\n")
+	return err
+}
+
+// ServerError is an -ERR response.  It matches common.ErrAuthFailed unless the
+// response code says that the failure was temporary (RFC 3206).
+type ServerError struct {
+	Text string
+}
+
+func (e *ServerError) Error() string {
+	return "pop3: -ERR " + e.Text
+}
+
+func (e *ServerError) Is(target error) bool {
+	return target == common.ErrAuthFailed && !strings.HasPrefix(strings.ToUpper(e.Text), "[SYS/TEMP]")
+}
+
+// receive returns the next challenge, or done after +OK
+func (e *Exchanger) receive() (challenge []byte, done bool, err error) {
+	line, err := e.r.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	switch {
+	case line == "+OK" || strings.HasPrefix(line, "+OK "):
+		return nil, true, nil
+	case line == "-ERR" || strings.HasPrefix(line, "-ERR "):
+		return nil, false, &ServerError{Text: strings.TrimPrefix(strings.TrimPrefix(line, "-ERR"), " ")}
+	}
+
+	challenge, err = wire.IMAPContinuation.Decode(line)
+	if err == wire.ErrNotContinuation {
+		return nil, false, fmt.Errorf("pop3: unexpected response %q: %w", line, common.ErrProtocol)
+	}
+
+	return challenge, false, err
+}
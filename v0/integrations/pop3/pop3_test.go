@@ -0,0 +1,115 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package pop3
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/internal/testmech"
+	"github.com/golang-auth/go-sasl/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMechs(t *testing.T) {
+	capa := []string{"TOP", "sasl gssapi PLAIN", "USER"}
+	assert.Equal(t, []string{"GSSAPI", "PLAIN"}, Mechs(capa))
+	assert.Nil(t, Mechs([]string{"USER"}))
+}
+
+func TestAuthenticate(t *testing.T) {
+	var tests = []struct {
+		name   string
+		script []string
+		err    error
+	}{
+		{"initial response", []string{
+			"C: AUTH TEST aGVsbG8=",
+			"S: + Y2hhbGxlbmdl",
+			"C: cmVzcG9uc2U=",
+			"S: +OK maildrop locked and ready",
+		}, nil},
+		{"rejected", []string{
+			"C: AUTH TEST aGVsbG8=",
+			"S: -ERR [AUTH] invalid credentials",
+		}, common.ErrAuthFailed},
+		{"cancelled", []string{
+			"C: AUTH TEST aGVsbG8=",
+			"S: + Z2FyYmFnZQ==",
+			"C: *",
+			"S: -ERR cancelled",
+		}, common.ErrBadToken},
+		{"bad response", []string{
+			"C: AUTH TEST aGVsbG8=",
+			"S: hello",
+		}, common.ErrProtocol},
+	}
+
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		got := testmech.Serve(server, tt.script...)
+
+		conn, err := Authenticate(client, testmech.NewClient(t, "pop", 0), []string{"SASL TEST"})
+		if tt.err == nil {
+			assert.NoError(t, err, tt.name)
+			assert.Equal(t, client, conn, tt.name)
+		} else {
+			assert.ErrorIs(t, err, tt.err, tt.name)
+		}
+		assert.Equal(t, testmech.Expected(tt.script), <-got, tt.name)
+
+		client.Close()
+		server.Close()
+	}
+
+	_, err := Authenticate(nil, testmech.NewClient(t, "pop", 0), []string{"SASL PLAIN"})
+	assert.ErrorIs(t, err, common.ErrNoMech)
+
+	assert.NotErrorIs(t, &ServerError{Text: "[SYS/TEMP] try later"}, common.ErrAuthFailed)
+}
+
+func TestInitialResponse(t *testing.T) {
+	var out strings.Builder
+	in := "+ \r\n+OK\r\n+\r\n+OK\r\n"
+	e := NewExchanger(bufio.NewReader(strings.NewReader(in)), &out)
+
+	// too long for the command line
+	long := []byte(strings.Repeat("x", 200))
+	_, done, err := e.Start("TEST", long)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, "AUTH TEST\r\n"+wire.EncodeBase64(long)+"\r\n", out.String())
+
+	// an empty initial response is "="
+	out.Reset()
+	_, _, err = e.Start("EXTERNAL", []byte{})
+	assert.NoError(t, err)
+	assert.Equal(t, "AUTH EXTERNAL =\r\n", out.String())
+}
+
+func TestSecurityLayer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	got := testmech.Serve(server,
+		"C: AUTH TEST aGVsbG8=",
+		"S: + Y2hhbGxlbmdl",
+		"C: cmVzcG9uc2U=",
+		"S: +OK",
+	)
+	conn, err := Authenticate(client, testmech.NewClient(t, "pop", 56), []string{"SASL TEST"})
+	assert.NoError(t, err)
+	<-got
+
+	go conn.Write([]byte("STAT\r\n"))
+	b := make([]byte, 4+8)
+	_, err = io.ReadFull(server, b)
+	assert.NoError(t, err)
+	assert.Equal(t, "\x00\x00\x00\x08[STAT\r\n]", string(b))
+}
@@ -0,0 +1,223 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package postgres runs SASL authentication in the PostgreSQL frontend/backend
+// protocol (version 3), eg. for SCRAM-SHA-256.  It takes over from the driver
+// once the server has sent AuthenticationSASL, and returns after the server's
+// AuthenticationOk.
+package postgres
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+)
+
+// message types
+const (
+	msgAuthentication = 'R'
+	msgErrorResponse  = 'E'
+	msgSASLResponse   = 'p'
+)
+
+// authentication message codes
+const (
+	authOk           = 0
+	authSASL         = 10
+	authSASLContinue = 11
+	authSASLFinal    = 12
+)
+
+// the largest message accepted from the server
+const maxMessageSize = 1 << 20
+
+// ServerError is an ErrorResponse sent by the server.  Rejected credentials
+// match common.ErrAuthFailed.
+type ServerError struct {
+	Code    string // SQLSTATE
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("postgres: %s (SQLSTATE %s)", e.Message, e.Code)
+}
+
+func (e *ServerError) Is(target error) bool {
+	// class 28, invalid authorization specification
+	return target == common.ErrAuthFailed && len(e.Code) == 5 && e.Code[:2] == "28"
+}
+
+// ChannelBinding returns the binding for a TLS connection to the server.
+// PostgreSQL only supports tls-server-end-point.
+func ChannelBinding(cs tls.ConnectionState) (common.ChannelBinding, error) {
+	return common.ChannelBindingFromTLS(cs, common.CBTLSServerEndPoint)
+}
+
+// Authenticate chooses a mechanism from those in the server's
+// AuthenticationSASL message and authenticates over rw.
+func Authenticate(rw io.ReadWriter, client *sasl.SaslClient, mechs []string) error {
+	if _, _, err := client.ChooseMech(mechs); err != nil {
+		return err
+	}
+
+	return saslconn.Handshake(client, NewExchanger(rw))
+}
+
+// Mechs returns the mechanisms in the body of an AuthenticationSASL message
+func Mechs(body []byte) ([]string, error) {
+	code, body, err := authCode(body)
+	if err != nil || code != authSASL {
+		return nil, fmt.Errorf("postgres: not an AuthenticationSASL message: %w", common.ErrProtocol)
+	}
+
+	var mechs []string
+	for {
+		i := bytes.IndexByte(body, 0)
+		if i < 0 {
+			return nil, fmt.Errorf("postgres: bad AuthenticationSASL message: %w", common.ErrProtocol)
+		}
+		if i == 0 {
+			return mechs, nil
+		}
+		mechs = append(mechs, string(body[:i]))
+		body = body[i+1:]
+	}
+}
+
+// Exchanger sends SASL messages in SASLInitialResponse and SASLResponse
+// messages
+type Exchanger struct {
+	rw io.ReadWriter
+}
+
+var _ saslconn.TokenExchanger = (*Exchanger)(nil)
+
+// NewExchanger returns an Exchanger that reads the server's messages from rw
+// one at a time, so nothing after AuthenticationOk is consumed
+func NewExchanger(rw io.ReadWriter) *Exchanger {
+	return &Exchanger{rw: rw}
+}
+
+func (e *Exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	body := append([]byte(mech), 0)
+	if initialResponse == nil {
+		body = appendInt32(body, -1)
+	} else {
+		body = appendInt32(body, int32(len(initialResponse)))
+		body = append(body, initialResponse...)
+	}
+
+	if err := e.send(body); err != nil {
+		return nil, false, err
+	}
+	return e.receive()
+}
+
+func (e *Exchanger) Next(response []byte) ([]byte, bool, error) {
+	if err := e.send(response); err != nil {
+		return nil, false, err
+	}
+	return e.receive()
+}
+
+func (e *Exchanger) send(body []byte) error {
+	msg := appendInt32([]byte{msgSASLResponse}, int32(4+len(body)))
+	_, err := e.rw.Write(append(msg, body...))
+	return err
+}
+
+// receive returns the next challenge, or done with the final data once
+// AuthenticationOk is read
+func (e *Exchanger) receive() (challenge []byte, done bool, err error) {
+	var final []byte
+	for {
+		typ, body, err := ReadMessage(e.rw)
+		if err != nil {
+			return nil, false, err
+		}
+		if typ == msgErrorResponse {
+			return nil, false, parseError(body)
+		}
+		if typ != msgAuthentication {
+			return nil, false, fmt.Errorf("postgres: unexpected message type %q: %w", typ, common.ErrProtocol)
+		}
+
+		code, data, err := authCode(body)
+		switch {
+		case err != nil:
+			return nil, false, err
+		case code == authSASLContinue && final == nil:
+			return data, false, nil
+		case code == authSASLFinal && final == nil:
+			final = data
+		case code == authOk:
+			return final, true, nil
+		default:
+			return nil, false, fmt.Errorf("postgres: unexpected authentication message %d: %w", code, common.ErrProtocol)
+		}
+	}
+}
+
+// ReadMessage reads a message from the server and returns its type and body
+func ReadMessage(r io.Reader) (typ byte, body []byte, err error) {
+	var hdr [5]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size < 4 || size-4 > maxMessageSize {
+		return 0, nil, fmt.Errorf("postgres: bad message length %d: %w", size, common.ErrProtocol)
+	}
+
+	body = make([]byte, size-4)
+	if _, err = io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	return hdr[0], body, nil
+}
+
+func authCode(body []byte) (code int32, rest []byte, err error) {
+	if len(body) < 4 {
+		return 0, nil, fmt.Errorf("postgres: short authentication message: %w", common.ErrProtocol)
+	}
+
+	return int32(binary.BigEndian.Uint32(body)), body[4:], nil
+}
+
+// parseError decodes the fields of an ErrorResponse
+func parseError(body []byte) error {
+	e := &ServerError{}
+	for len(body) > 1 {
+		i := bytes.IndexByte(body[1:], 0)
+		if i < 0 {
+			break
+		}
+		switch body[0] {
+		case 'C':
+			e.Code = string(body[1 : 1+i])
+		case 'M':
+			e.Message = string(body[1 : 1+i])
+		}
+		body = body[i+2:]
+	}
+
+	return e
+}
+
+func appendInt32(b []byte, v int32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], uint32(v))
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package postgres
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// scramMech imitates SCRAM:  it is established by the server's final message
+type scramMech struct {
+	stage int
+}
+
+func (m *scramMech) Name() string                        { return "SCRAM-TEST" }
+func (m *scramMech) MechProperties() common.MechProps    { return common.MechProps{} }
+func (m *scramMech) IsEstablished() bool                 { return m.stage == 2 }
+func (m *scramMech) Close() error                        { return nil }
+func (m *scramMech) ContextParams() common.ContextParams { return common.ContextParams{} }
+func (m *scramMech) Encode(in []byte) ([]byte, error)    { return in, nil }
+func (m *scramMech) Decode(in []byte) ([]byte, error)    { return in, nil }
+
+func (m *scramMech) Step(in []byte) ([]byte, common.StepStatus, error) {
+	switch {
+	case in == nil:
+		return []byte("client-first"), common.StepContinue, nil
+	case m.stage == 0 && string(in) == "server-first":
+		m.stage = 1
+		return []byte("client-final"), common.StepContinue, nil
+	case m.stage == 1 && string(in) == "server-final":
+		m.stage = 2
+		return nil, common.StepDone, nil
+	}
+	return nil, common.StepContinue, common.ErrAuthFailed
+}
+
+func newClient(t *testing.T) *sasl.SaslClient {
+	r := registry.New()
+	r.MustRegister("SCRAM-TEST", func(common.MechConfig) common.Mech {
+		return &scramMech{}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := sasl.NewSaslClient("postgres", sasl.WithRegistry(r))
+	assert.NoError(t, err)
+	return &cli
+}
+
+func message(typ byte, body []byte) []byte {
+	return append(appendInt32([]byte{typ}, int32(4+len(body))), body...)
+}
+
+func auth(code int32, data string) []byte {
+	return message(msgAuthentication, append(appendInt32(nil, code), data...))
+}
+
+// serve reads a client message before sending each reply, and returns the
+// bodies of the client's messages
+func serve(conn net.Conn, replies ...[]byte) <-chan []string {
+	done := make(chan []string, 1)
+	go func() {
+		var got []string
+		for _, reply := range replies {
+			typ, body, err := ReadMessage(conn)
+			if err != nil || typ != msgSASLResponse {
+				break
+			}
+			got = append(got, string(body))
+			conn.Write(reply)
+		}
+		done <- got
+	}()
+	return done
+}
+
+func TestMechs(t *testing.T) {
+	mechs, err := Mechs([]byte("\x00\x00\x00\x0aSCRAM-SHA-256-PLUS\x00SCRAM-SHA-256\x00\x00"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SCRAM-SHA-256-PLUS", "SCRAM-SHA-256"}, mechs)
+
+	_, err = Mechs([]byte("\x00\x00\x00\x0aSCRAM-SHA-256"))
+	assert.ErrorIs(t, err, common.ErrProtocol)
+	_, err = Mechs([]byte("\x00\x00\x00\x03"))
+	assert.ErrorIs(t, err, common.ErrProtocol)
+}
+
+func TestAuthenticate(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	got := serve(server,
+		auth(authSASLContinue, "server-first"),
+		append(auth(authSASLFinal, "server-final"), auth(authOk, "")...),
+	)
+	assert.NoError(t, Authenticate(client, newClient(t), []string{"SCRAM-SHA-256", "SCRAM-TEST"}))
+	assert.Equal(t, []string{"SCRAM-TEST\x00\x00\x00\x00\x0cclient-first", "client-final"}, <-got)
+}
+
+func TestAuthenticateFailure(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	serve(server, message(msgErrorResponse, []byte("SFATAL\x00C28P01\x00Mpassword authentication failed\x00\x00")))
+	err := Authenticate(client, newClient(t), []string{"SCRAM-TEST"})
+	assert.ErrorIs(t, err, common.ErrAuthFailed)
+	var serverErr *ServerError
+	assert.ErrorAs(t, err, &serverErr)
+	assert.Equal(t, "password authentication failed", serverErr.Message)
+
+	// AuthenticationOk without the final message
+	serve(server, auth(authSASLContinue, "server-first"), auth(authOk, ""))
+	err = Authenticate(client, newClient(t), []string{"SCRAM-TEST"})
+	assert.ErrorIs(t, err, common.ErrProtocol)
+
+	serve(server, message('Z', []byte("I")))
+	err = Authenticate(client, newClient(t), []string{"SCRAM-TEST"})
+	assert.ErrorIs(t, err, common.ErrProtocol)
+}
+
+func TestReadMessage(t *testing.T) {
+	_, _, err := ReadMessage(bytes.NewReader([]byte{'R', 0, 0, 0, 3}))
+	assert.ErrorIs(t, err, common.ErrProtocol)
+	_, _, err = ReadMessage(bytes.NewReader([]byte{'R', 0x7f, 0, 0, 0}))
+	assert.ErrorIs(t, err, common.ErrProtocol)
+
+	typ, body, err := ReadMessage(bytes.NewReader(auth(authOk, "")))
+	assert.NoError(t, err)
+	assert.Equal(t, byte(msgAuthentication), typ)
+	assert.Equal(t, []byte{0, 0, 0, 0}, body)
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package sieve
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzExchanger feeds server responses to an AUTHENTICATE exchange
+func FuzzExchanger(f *testing.F) {
+	for _, s := range []string{
+		"\"aGVsbG8=\"\r\nOK\r\n",
+		"{8}\r\naGVsbG8=\r\nOK (SASL \"aGVsbG8=\")\r\n",
+		"NO (REFERRAL \"sieve://other\") \"go away\"\r\n",
+		"BYE {5}\r\nhello\r\n",
+		"{4294967296}\r\n",
+		"OK (SASL\r\n",
+	} {
+		f.Add([]byte(s))
+	}
+
+	f.Fuzz(func(t *testing.T, responses []byte) {
+		e := NewExchanger(bufio.NewReader(bytes.NewReader(responses)), ioutil.Discard)
+		_, done, err := e.Start("GSSAPI", nil)
+		for i := 0; err == nil && !done && i < 100; i++ {
+			_, done, err = e.Next([]byte("response"))
+		}
+	})
+}
@@ -0,0 +1,295 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package sieve authenticates ManageSieve connections with the AUTHENTICATE
+// command (RFC 5804 § 2.1).
+package sieve
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// the largest literal accepted from the server
+const maxLiteral = 1 << 16
+
+// longer strings are sent as literals (RFC 5804 § 4)
+const maxQuoted = 1024
+
+// Mechs returns the mechanisms in the SASL capability, given the server's
+// capability lines, eg. `"SASL" "PLAIN GSSAPI"`
+func Mechs(caps []string) []string {
+	for _, line := range caps {
+		r := strings.NewReader(line)
+		name, err := quoted(r)
+		if err != nil || !strings.EqualFold(name, "SASL") {
+			continue
+		}
+
+		r.ReadByte()
+		value, err := quoted(r)
+		if err != nil {
+			return nil
+		}
+		return strings.Fields(strings.ToUpper(value))
+	}
+
+	return nil
+}
+
+// Authenticate chooses a mechanism from the server's capabilities and
+// authenticates conn.  The returned connection applies the negotiated security
+// layer, which starts after the OK response.
+func Authenticate(conn net.Conn, client *sasl.SaslClient, caps []string) (net.Conn, error) {
+	if _, _, err := client.ChooseMech(Mechs(caps)); err != nil {
+		return nil, err
+	}
+
+	// the server sends nothing after OK until the next command, so nothing
+	// is left in the buffer
+	return saslconn.Client(conn, client, NewExchanger(bufio.NewReader(conn), conn))
+}
+
+// ResponseError is a NO or BYE response.  A NO matches common.ErrAuthFailed
+// unless it has the TRANSITION-NEEDED or TRYLATER response code.  Referral is
+// set if the server sent a REFERRAL to another server.
+type ResponseError struct {
+	Status   string // NO or BYE
+	Code     string // response code, eg. "REFERRAL"
+	Referral string // sieve URL
+	Text     string
+}
+
+func (e *ResponseError) Error() string {
+	msg := "sieve: " + e.Status
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	if e.Referral != "" {
+		msg += " referral to " + e.Referral
+	}
+	if e.Text != "" {
+		msg += ": " + e.Text
+	}
+	return msg
+}
+
+func (e *ResponseError) Is(target error) bool {
+	return target == common.ErrAuthFailed && e.Status == "NO" &&
+		e.Code != "TRANSITION-NEEDED" && e.Code != "TRYLATER"
+}
+
+// Exchanger sends SASL messages with the AUTHENTICATE command
+type Exchanger struct {
+	r *bufio.Reader
+	w io.Writer
+}
+
+var _ saslconn.TokenExchanger = (*Exchanger)(nil)
+var _ saslconn.Canceler = (*Exchanger)(nil)
+
+// NewExchanger returns an Exchanger that runs AUTHENTICATE on r and w
+func NewExchanger(r *bufio.Reader, w io.Writer) *Exchanger {
+	return &Exchanger{r: r, w: w}
+}
+
+func (e *Exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	cmd := "AUTHENTICATE " + quote(mech)
+	if initialResponse != nil {
+		cmd += " " + encode(wire.EncodeBase64(initialResponse))
+	}
+
+	if err := e.send(cmd); err != nil {
+		return nil, false, err
+	}
+	return e.receive()
+}
+
+func (e *Exchanger) Next(response []byte) ([]byte, bool, error) {
+	if err := e.send(encode(wire.EncodeBase64(response))); err != nil {
+		return nil, false, err
+	}
+	return e.receive()
+}
+
+// Cancel aborts the exchange;  the server's NO response is expected
+func (e *Exchanger) Cancel() error {
+	if err := e.send(quote(wire.Cancel)); err != nil {
+		return err
+	}
+
+	_, _, err := e.receive()
+	if _, ok := err.(*ResponseError); ok {
+		return nil
+	}
+	return err
+}
+
+func (e *Exchanger) send(line string) error {
+	_, err := io.WriteString(e.w, line+"\r\n")
+	return err
+}
+
+// receive returns the next challenge, or done with the data of the SASL
+// response code once the server sends OK
+func (e *Exchanger) receive() (challenge []byte, done bool, err error) {
+	line, err := e.readLine()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// a challenge is a string
+	if strings.HasPrefix(line, `"`) || strings.HasPrefix(line, "{") {
+		s, err := e.str(strings.NewReader(line), line)
+		if err != nil {
+			return nil, false, err
+		}
+		challenge, err = wire.DecodeBase64(s)
+		return challenge, false, err
+	}
+
+	status := line
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		status, line = line[:i], line[i+1:]
+	} else {
+		line = ""
+	}
+	status = strings.ToUpper(status)
+	if status != "OK" && status != "NO" && status != "BYE" {
+		return nil, false, fmt.Errorf("sieve: unexpected response %q: %w", status, common.ErrProtocol)
+	}
+
+	code, arg, text, err := e.responseCode(line)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if status != "OK" {
+		resp := &ResponseError{Status: status, Code: code, Text: text}
+		if code == "REFERRAL" {
+			resp.Referral = arg
+		}
+		return nil, false, resp
+	}
+
+	if code == "SASL" {
+		data, err := wire.DecodeBase64(arg)
+		return data, err == nil, err
+	}
+	return nil, true, nil
+}
+
+// responseCode parses the rest of an OK, NO or BYE response:  an optional
+// code in parentheses, which may have a string argument, and optional text
+func (e *Exchanger) responseCode(line string) (code, arg, text string, err error) {
+	r := strings.NewReader(line)
+	if strings.HasPrefix(line, "(") {
+		end := strings.IndexByte(line, ')')
+		if end < 0 {
+			return "", "", "", fmt.Errorf("sieve: bad response code: %w", common.ErrProtocol)
+		}
+		inner := line[1:end]
+		code = inner
+		if i := strings.IndexByte(inner, ' '); i >= 0 {
+			code = inner[:i]
+			if arg, err = quoted(strings.NewReader(inner[i+1:])); err != nil {
+				return "", "", "", err
+			}
+		}
+		code = strings.ToUpper(code)
+		r = strings.NewReader(strings.TrimLeft(line[end+1:], " "))
+	}
+
+	if r.Len() > 0 {
+		rest := line[len(line)-r.Len():]
+		if text, err = e.str(r, rest); err != nil {
+			return "", "", "", err
+		}
+	}
+	return code, arg, text, nil
+}
+
+// str returns the string at the start of r:  a quoted string, or a literal
+// whose data follows line
+func (e *Exchanger) str(r *strings.Reader, line string) (string, error) {
+	if !strings.HasPrefix(line, "{") {
+		return quoted(r)
+	}
+
+	end := strings.IndexByte(line, '}')
+	if end < 0 {
+		return "", fmt.Errorf("sieve: bad literal: %w", common.ErrProtocol)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(line[1:end], "+"))
+	if err != nil || n < 0 || n > maxLiteral {
+		return "", fmt.Errorf("sieve: bad literal: %w", common.ErrProtocol)
+	}
+
+	data := make([]byte, n)
+	if _, err = io.ReadFull(e.r, data); err != nil {
+		return "", err
+	}
+	// the rest of the line after the literal
+	if _, err = e.readLine(); err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+func (e *Exchanger) readLine() (string, error) {
+	line, err := e.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// quoted returns the quoted string at the start of r
+func quoted(r *strings.Reader) (string, error) {
+	if c, err := r.ReadByte(); err != nil || c != '"' {
+		return "", fmt.Errorf("sieve: expected a quoted string: %w", common.ErrProtocol)
+	}
+
+	var s strings.Builder
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("sieve: unterminated string: %w", common.ErrProtocol)
+		}
+		switch c {
+		case '"':
+			return s.String(), nil
+		case '\\':
+			if c, err = r.ReadByte(); err != nil {
+				return "", fmt.Errorf("sieve: unterminated string: %w", common.ErrProtocol)
+			}
+		}
+		s.WriteByte(c)
+	}
+}
+
+// quote returns s as a quoted string;  mechanism names and base64 never need
+// escaping, but quote escapes '"' and '\' anyway
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// encode returns s as a quoted string, or as a non-synchronizing literal if it
+// is too long
+func encode(s string) string {
+	if len(s) <= maxQuoted {
+		return quote(s)
+	}
+	return "{" + strconv.Itoa(len(s)) + "+}\r\n" + s
+}
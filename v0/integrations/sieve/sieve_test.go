@@ -0,0 +1,141 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sieve
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/internal/testmech"
+	"github.com/golang-auth/go-sasl/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMechs(t *testing.T) {
+	caps := []string{`"IMPLEMENTATION" "Dovecot Pigeonhole"`, `"SIEVE" "fileinto vacation"`, `"SASL" "plain GSSAPI"`, `"VERSION" "1.0"`}
+	assert.Equal(t, []string{"PLAIN", "GSSAPI"}, Mechs(caps))
+	assert.Nil(t, Mechs([]string{`"VERSION" "1.0"`}))
+}
+
+func TestAuthenticate(t *testing.T) {
+	var tests = []struct {
+		name   string
+		script []string
+		err    error
+	}{
+		{"success", []string{
+			`C: AUTHENTICATE "TEST" "aGVsbG8="`,
+			`S: "Y2hhbGxlbmdl"`,
+			`C: "cmVzcG9uc2U="`,
+			`S: OK "Logged in."`,
+		}, nil},
+		{"literal challenge", []string{
+			`C: AUTHENTICATE "TEST" "aGVsbG8="`,
+			`S: {12}`,
+			`S: Y2hhbGxlbmdl`,
+			`C: "cmVzcG9uc2U="`,
+			`S: OK (SASL "ZG9uZQ==") "Logged in."`,
+		}, nil},
+		{"rejected", []string{
+			`C: AUTHENTICATE "TEST" "aGVsbG8="`,
+			`S: NO "Authentication failed."`,
+		}, common.ErrAuthFailed},
+		{"cancelled", []string{
+			`C: AUTHENTICATE "TEST" "aGVsbG8="`,
+			`S: "Z2FyYmFnZQ=="`,
+			`C: "*"`,
+			`S: NO "Cancelled"`,
+		}, common.ErrBadToken},
+		{"bad response", []string{
+			`C: AUTHENTICATE "TEST" "aGVsbG8="`,
+			`S: hello`,
+		}, common.ErrProtocol},
+	}
+
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		got := testmech.Serve(server, tt.script...)
+
+		conn, err := Authenticate(client, testmech.NewClient(t, "sieve", 0), []string{`"SASL" "TEST"`})
+		if tt.err == nil {
+			assert.NoError(t, err, tt.name)
+			assert.Equal(t, client, conn, tt.name)
+		} else {
+			assert.ErrorIs(t, err, tt.err, tt.name)
+		}
+		assert.Equal(t, testmech.Expected(tt.script), <-got, tt.name)
+
+		client.Close()
+		server.Close()
+	}
+
+	_, err := Authenticate(nil, testmech.NewClient(t, "sieve", 0), []string{`"SASL" "PLAIN"`})
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
+
+func TestResponses(t *testing.T) {
+	in := "BYE (REFERRAL \"sieve://other.example.com\") \"Try another server\"\r\n" +
+		"NO (TRYLATER) {15}\r\nServer is busy.\r\n" +
+		"OK\r\n"
+	e := NewExchanger(bufio.NewReader(strings.NewReader(in)), ioutil.Discard)
+
+	_, _, err := e.receive()
+	var resp *ResponseError
+	assert.ErrorAs(t, err, &resp)
+	assert.Equal(t, &ResponseError{Status: "BYE", Code: "REFERRAL", Referral: "sieve://other.example.com", Text: "Try another server"}, resp)
+	assert.NotErrorIs(t, err, common.ErrAuthFailed)
+
+	_, _, err = e.receive()
+	assert.ErrorAs(t, err, &resp)
+	assert.Equal(t, "TRYLATER", resp.Code)
+	assert.Equal(t, "Server is busy.", resp.Text)
+	assert.NotErrorIs(t, err, common.ErrAuthFailed)
+
+	data, done, err := e.receive()
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Nil(t, data)
+}
+
+func TestLongResponse(t *testing.T) {
+	var out strings.Builder
+	e := NewExchanger(bufio.NewReader(strings.NewReader("OK\r\n")), &out)
+
+	long := []byte(strings.Repeat("x", 1000))
+	_, _, err := e.Next(long)
+	assert.NoError(t, err)
+	assert.Equal(t, "{1336+}\r\n"+wire.EncodeBase64(long)+"\r\n", out.String())
+
+	assert.Equal(t, `"a\\b\"c"`, quote(`a\b"c`))
+	s, err := quoted(strings.NewReader(`"a\\b\"c"`))
+	assert.NoError(t, err)
+	assert.Equal(t, `a\b"c`, s)
+}
+
+func TestSecurityLayer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	got := testmech.Serve(server,
+		`C: AUTHENTICATE "TEST" "aGVsbG8="`,
+		`S: "Y2hhbGxlbmdl"`,
+		`C: "cmVzcG9uc2U="`,
+		`S: OK`,
+	)
+	conn, err := Authenticate(client, testmech.NewClient(t, "sieve", 56), []string{`"SASL" "TEST"`})
+	assert.NoError(t, err)
+	<-got
+
+	go conn.Write([]byte("LOGOUT\r\n"))
+	b := make([]byte, 4+10)
+	_, err = io.ReadFull(server, b)
+	assert.NoError(t, err)
+	assert.Equal(t, "\x00\x00\x00\x0a[LOGOUT\r\n]", string(b))
+}
@@ -0,0 +1,224 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package xmpp runs SASL negotiation on an XMPP stream (RFC 6120 § 6), leaving
+// the stream itself to the XMPP library.
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// NS is the XML namespace of the SASL elements
+const NS = "urn:ietf:params:xml:ns:xmpp-sasl"
+
+// Mechanisms is the <mechanisms/> stream feature
+type Mechanisms struct {
+	XMLName   xml.Name `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms"`
+	Mechanism []string `xml:"mechanism"`
+}
+
+// Mechs returns the mechanisms offered in a <stream:features/> element, or in
+// a bare <mechanisms/> element.  It returns nil if SASL isn't offered.
+func Mechs(features []byte) ([]string, error) {
+	d := xml.NewDecoder(bytes.NewReader(features))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xmpp: bad stream features: %w", common.ErrProtocol)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Space != NS || start.Name.Local != "mechanisms" {
+			continue
+		}
+
+		var m Mechanisms
+		if err := d.DecodeElement(&m, &start); err != nil {
+			return nil, fmt.Errorf("xmpp: bad stream features: %w", common.ErrProtocol)
+		}
+		for i := range m.Mechanism {
+			m.Mechanism[i] = strings.TrimSpace(m.Mechanism[i])
+		}
+		return m.Mechanism, nil
+	}
+}
+
+// Failure is the <failure/> element sent when the server rejects the
+// authentication.  Rejected credentials match common.ErrAuthFailed, a
+// mechanism that is too weak common.ErrWeakSecurity, and an invalid exchange
+// common.ErrProtocol.
+type Failure struct {
+	Condition string // eg. "not-authorized"
+	Text      string
+}
+
+func (f *Failure) Error() string {
+	if f.Text != "" {
+		return "xmpp: authentication failed: " + f.Condition + ": " + f.Text
+	}
+	return "xmpp: authentication failed: " + f.Condition
+}
+
+func (f *Failure) Is(target error) bool {
+	switch f.Condition {
+	case "not-authorized", "credentials-expired", "account-disabled":
+		return target == common.ErrAuthFailed
+	case "mechanism-too-weak", "encryption-required":
+		return target == common.ErrWeakSecurity
+	case "invalid-mechanism", "incorrect-encoding", "malformed-request", "invalid-authzid":
+		return target == common.ErrProtocol
+	}
+
+	return false
+}
+
+// Authenticate chooses a mechanism from mechs, as returned by Mechs, and
+// authenticates using the stream's decoder d.  The returned connection applies
+// the negotiated security layer;  the caller then restarts the stream on it.
+func Authenticate(conn net.Conn, d *xml.Decoder, client *sasl.SaslClient, mechs []string) (net.Conn, error) {
+	if _, _, err := client.ChooseMech(mechs); err != nil {
+		return nil, err
+	}
+
+	// the server waits for the new stream header after <success/>, so d has
+	// nothing left in its buffer
+	return saslconn.Client(conn, client, NewExchanger(d, conn))
+}
+
+// Exchanger sends SASL messages in <auth/> and <response/> elements.
+// Whitespace between elements is ignored.
+type Exchanger struct {
+	d *xml.Decoder
+	w io.Writer
+}
+
+var _ saslconn.TokenExchanger = (*Exchanger)(nil)
+var _ saslconn.Canceler = (*Exchanger)(nil)
+
+// NewExchanger returns an Exchanger that reads the server's elements from d
+// and writes to w
+func NewExchanger(d *xml.Decoder, w io.Writer) *Exchanger {
+	return &Exchanger{d: d, w: w}
+}
+
+func (e *Exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	var mechAttr bytes.Buffer
+	xml.EscapeText(&mechAttr, []byte(mech))
+
+	// an empty initial response is "=", and no initial response an empty
+	// element
+	encoded, _ := sasl.EncodeInitialResponse(initialResponse)
+	if err := e.send(fmt.Sprintf("<auth xmlns='%s' mechanism='%s'>%s</auth>", NS, mechAttr.String(), encoded)); err != nil {
+		return nil, false, err
+	}
+
+	return e.receive()
+}
+
+func (e *Exchanger) Next(response []byte) ([]byte, bool, error) {
+	if err := e.send(fmt.Sprintf("<response xmlns='%s'>%s</response>", NS, wire.EncodeBase64(response))); err != nil {
+		return nil, false, err
+	}
+
+	return e.receive()
+}
+
+// Cancel sends <abort/>;  the server's <failure/> is expected
+func (e *Exchanger) Cancel() error {
+	if err := e.send(fmt.Sprintf("<abort xmlns='%s'/>", NS)); err != nil {
+		return err
+	}
+
+	_, done, err := e.receive()
+	if done {
+		return nil
+	}
+	if _, ok := err.(*Failure); ok {
+		return nil
+	}
+	return err
+}
+
+func (e *Exchanger) send(s string) error {
+	_, err := io.WriteString(e.w, s)
+	return err
+}
+
+// element is any of the server's SASL elements
+type element struct {
+	XMLName  xml.Name
+	Data     string `xml:",chardata"`
+	Text     string `xml:"text"`
+	Children []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// receive returns the next challenge, or done once <success/> is read
+func (e *Exchanger) receive() (challenge []byte, done bool, err error) {
+	var start xml.StartElement
+	for {
+		tok, err := e.d.Token()
+		if err != nil {
+			return nil, false, err
+		}
+		var ok bool
+		if start, ok = tok.(xml.StartElement); ok {
+			break
+		}
+	}
+
+	var el element
+	if start.Name.Space != NS {
+		return nil, false, fmt.Errorf("xmpp: unexpected element <%s>: %w", start.Name.Local, common.ErrProtocol)
+	}
+	if err := e.d.DecodeElement(&el, &start); err != nil {
+		return nil, false, fmt.Errorf("xmpp: bad <%s> element: %w", start.Name.Local, common.ErrProtocol)
+	}
+
+	switch start.Name.Local {
+	case "challenge":
+		challenge, err = decode(el.Data)
+		if challenge == nil && err == nil {
+			challenge = []byte{}
+		}
+		return challenge, false, err
+	case "success":
+		challenge, err = decode(el.Data)
+		return challenge, err == nil, err
+	case "failure":
+		f := &Failure{Text: el.Text}
+		if len(el.Children) > 0 {
+			f.Condition = el.Children[0].XMLName.Local
+		}
+		return nil, false, f
+	}
+
+	return nil, false, fmt.Errorf("xmpp: unexpected element <%s>: %w", start.Name.Local, common.ErrProtocol)
+}
+
+// decode returns the data in an element:  nil if it is empty, and an empty
+// slice for "="
+func decode(data string) ([]byte, error) {
+	data = strings.TrimSpace(data)
+	if data == "" {
+		return nil, nil
+	}
+
+	return sasl.DecodeInitialResponse(data)
+}
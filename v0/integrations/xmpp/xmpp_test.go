@@ -0,0 +1,158 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package xmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/internal/testmech"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMechs(t *testing.T) {
+	features := `<stream:features xmlns:stream='http://etherx.jabber.org/streams'>
+  <starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>
+  <mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>
+    <mechanism>SCRAM-SHA-1</mechanism>
+    <mechanism> PLAIN </mechanism>
+  </mechanisms>
+</stream:features>`
+
+	mechs, err := Mechs([]byte(features))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SCRAM-SHA-1", "PLAIN"}, mechs)
+
+	mechs, err = Mechs([]byte(`<mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><mechanism>GSSAPI</mechanism></mechanisms>`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GSSAPI"}, mechs)
+
+	// wrong namespace
+	mechs, err = Mechs([]byte(`<mechanisms><mechanism>GSSAPI</mechanism></mechanisms>`))
+	assert.NoError(t, err)
+	assert.Nil(t, mechs)
+
+	_, err = Mechs([]byte(`<stream:features><mechanisms xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>`))
+	assert.ErrorIs(t, err, common.ErrProtocol)
+}
+
+func TestAuthenticate(t *testing.T) {
+	var tests = []struct {
+		name   string
+		script []string
+		err    error
+	}{
+		{"success", []string{
+			"C: <auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='TEST'>aGVsbG8=</auth>",
+			"S: <challenge xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>Y2hhbGxlbmdl</challenge>",
+			"C: <response xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>cmVzcG9uc2U=</response>",
+			"S: \n<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>",
+		}, nil},
+		{"rejected", []string{
+			"C: <auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='TEST'>aGVsbG8=</auth>",
+			"S: <failure xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><text>bad password</text><not-authorized/></failure>",
+		}, common.ErrAuthFailed},
+		{"aborted", []string{
+			"C: <auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='TEST'>aGVsbG8=</auth>",
+			"S: <challenge xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>Z2FyYmFnZQ==</challenge>",
+			"C: <abort xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>",
+			"S: <failure xmlns='urn:ietf:params:xml:ns:xmpp-sasl'><aborted/></failure>",
+		}, common.ErrBadToken},
+		{"bad base64", []string{
+			"C: <auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='TEST'>aGVsbG8=</auth>",
+			"S: <challenge xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>!!</challenge>",
+		}, common.ErrBadToken},
+		{"unexpected element", []string{
+			"C: <auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='TEST'>aGVsbG8=</auth>",
+			"S: <message/>",
+		}, common.ErrProtocol},
+	}
+
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		got := testmech.ServeUnterminated(server, tt.script...)
+
+		conn, err := Authenticate(client, xml.NewDecoder(client), testmech.NewClient(t, "xmpp", 0), []string{"PLAIN", "TEST"})
+		if tt.err == nil {
+			assert.NoError(t, err, tt.name)
+			assert.Equal(t, client, conn, tt.name)
+		} else {
+			assert.ErrorIs(t, err, tt.err, tt.name)
+		}
+		assert.Equal(t, testmech.Expected(tt.script), <-got, tt.name)
+
+		client.Close()
+		server.Close()
+	}
+
+	_, err := Authenticate(nil, nil, testmech.NewClient(t, "xmpp", 0), []string{"PLAIN"})
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
+
+func TestEmptyMessages(t *testing.T) {
+	var out bytes.Buffer
+	in := `<challenge xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>` +
+		`<challenge xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>=</challenge>` +
+		`<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>=</success>` +
+		`<success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>`
+	e := NewExchanger(xml.NewDecoder(strings.NewReader(in)), &out)
+
+	// an empty initial response is "=", and none at all an empty element
+	challenge, done, err := e.Start("EXTERNAL", []byte{})
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, []byte{}, challenge)
+	assert.Equal(t, "<auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='EXTERNAL'>=</auth>", out.String())
+
+	out.Reset()
+	challenge, _, err = e.Start("ANONYMOUS", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{}, challenge)
+	assert.Equal(t, "<auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='ANONYMOUS'></auth>", out.String())
+
+	// additional data with success
+	challenge, done, err = e.Next(nil)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []byte{}, challenge)
+
+	challenge, done, err = e.Next(nil)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Nil(t, challenge)
+}
+
+func TestFailure(t *testing.T) {
+	assert.ErrorIs(t, &Failure{Condition: "mechanism-too-weak"}, common.ErrWeakSecurity)
+	assert.ErrorIs(t, &Failure{Condition: "malformed-request"}, common.ErrProtocol)
+	assert.NotErrorIs(t, &Failure{Condition: "temporary-auth-failure"}, common.ErrAuthFailed)
+	assert.Equal(t, "xmpp: authentication failed: not-authorized: bad password", (&Failure{"not-authorized", "bad password"}).Error())
+}
+
+func TestSecurityLayer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	got := testmech.ServeUnterminated(server,
+		"C: <auth xmlns='urn:ietf:params:xml:ns:xmpp-sasl' mechanism='TEST'>aGVsbG8=</auth>",
+		"S: <challenge xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>Y2hhbGxlbmdl</challenge>",
+		"C: <response xmlns='urn:ietf:params:xml:ns:xmpp-sasl'>cmVzcG9uc2U=</response>",
+		"S: <success xmlns='urn:ietf:params:xml:ns:xmpp-sasl'/>",
+	)
+	conn, err := Authenticate(client, xml.NewDecoder(client), testmech.NewClient(t, "xmpp", 56), []string{"TEST"})
+	assert.NoError(t, err)
+	<-got
+
+	go io.WriteString(conn, "<stream>")
+	b := make([]byte, 4+10)
+	_, err = io.ReadFull(server, b)
+	assert.NoError(t, err)
+	assert.Equal(t, "\x00\x00\x00\x0a[<stream>]", string(b))
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package testmech provides the saslmock mechanism and the scripted server
+// shared by the integrations' tests.
+package testmech
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/golang-auth/go-sasl/saslmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// Props are the properties the mechs are registered with
+var Props = common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous}
+
+// New returns a mech that sends "hello", then answers "challenge" with
+// "response".  Its security layer wraps data in square brackets.  opts
+// override the defaults.
+func New(name string, opts ...saslmock.Option) *saslmock.Mech {
+	defaults := []saslmock.Option{
+		saslmock.WithSteps(
+			saslmock.Step{Send: []byte("hello")},
+			saslmock.Step{Expect: []byte("challenge"), Send: []byte("response")},
+		),
+		WithSSF(0),
+		saslmock.WithLayer(wrap, unwrap),
+		saslmock.WithProps(Props),
+	}
+	return saslmock.New(name, append(defaults, opts...)...)
+}
+
+// WithSSF sets the SSF of the mech's security layer
+func WithSSF(ssf uint) saslmock.Option {
+	return saslmock.WithContextParams(common.ContextParams{SSF: ssf, MaxPeerMessageSize: 64})
+}
+
+// WithMutual makes the mech wait for the server's final token "mutual"
+func WithMutual() saslmock.Option {
+	return saslmock.WithSteps(
+		saslmock.Step{Send: []byte("hello")},
+		saslmock.Step{Expect: []byte("challenge"), Send: []byte("response")},
+		saslmock.Step{Expect: []byte("mutual")},
+	)
+}
+
+func wrap(in []byte) ([]byte, error) {
+	return append(append([]byte("["), in...), ']'), nil
+}
+
+func unwrap(in []byte) ([]byte, error) {
+	if len(in) < 2 || in[0] != '[' || in[len(in)-1] != ']' {
+		return nil, common.ErrBadToken
+	}
+	return in[1 : len(in)-1], nil
+}
+
+// NewClient returns a client for service that can only choose the TEST mech,
+// whose layer has the given SSF
+func NewClient(t *testing.T, service string, ssf uint) *sasl.SaslClient {
+	r := registry.New()
+	assert.NoError(t, New("TEST", WithSSF(ssf)).Register(r))
+
+	cli, err := sasl.NewSaslClient(service, sasl.WithRegistry(r))
+	assert.NoError(t, err)
+	return &cli
+}
+
+// Serve plays a script of "C: " lines expected from the client and "S: "
+// lines to send, and returns what the client actually sent
+func Serve(conn net.Conn, script ...string) <-chan []string {
+	done := make(chan []string, 1)
+	go func() {
+		var got []string
+		r := bufio.NewReader(conn)
+		for _, line := range script {
+			if strings.HasPrefix(line, "S: ") {
+				io.WriteString(conn, line[3:]+"\r\n")
+				continue
+			}
+			l, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			got = append(got, "C: "+strings.TrimRight(l, "\r\n"))
+		}
+		done <- got
+	}()
+	return done
+}
+
+// ServeUnterminated is Serve for protocols without line endings:  it sends
+// the "S: " entries as they are, and reads exactly as much as each "C: " entry
+func ServeUnterminated(conn net.Conn, script ...string) <-chan []string {
+	done := make(chan []string, 1)
+	go func() {
+		var got []string
+		for _, el := range script {
+			if strings.HasPrefix(el, "S: ") {
+				io.WriteString(conn, el[3:])
+				continue
+			}
+			b := make([]byte, len(el)-3)
+			if _, err := io.ReadFull(conn, b); err != nil {
+				break
+			}
+			got = append(got, "C: "+string(b))
+		}
+		done <- got
+	}()
+	return done
+}
+
+// Expected returns the "C: " entries of script
+func Expected(script []string) []string {
+	var c []string
+	for _, line := range script {
+		if strings.HasPrefix(line, "C: ") {
+			c = append(c, line)
+		}
+	}
+	return c
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package metrics exports SASL client instrumentation as expvar counters,
+// which are served as JSON at /debug/vars and are easily scraped into
+// Prometheus or similar systems.
+package metrics
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// Expvar implements common.Metrics with counters keyed by mechanism name
+type Expvar struct {
+	Started   *expvar.Map // handshakes started
+	Succeeded *expvar.Map // handshakes that established a context
+	Failed    *expvar.Map // handshakes that failed
+	Seconds   *expvar.Map // total duration of finished handshakes
+	Rejected  *expvar.Map // times the mech was passed over during selection
+	Wrapped   *expvar.Map // bytes encoded by the security layer
+	Unwrapped *expvar.Map // bytes decoded by the security layer
+}
+
+var _ common.Metrics = (*Expvar)(nil)
+
+// New returns counters that are not published, eg. for use in tests or to
+// publish under another name with expvar.Publish
+func New() *Expvar {
+	return &Expvar{
+		Started:   new(expvar.Map).Init(),
+		Succeeded: new(expvar.Map).Init(),
+		Failed:    new(expvar.Map).Init(),
+		Seconds:   new(expvar.Map).Init(),
+		Rejected:  new(expvar.Map).Init(),
+		Wrapped:   new(expvar.Map).Init(),
+		Unwrapped: new(expvar.Map).Init(),
+	}
+}
+
+// Publish returns counters published as an expvar map called name, with one
+// entry per counter.  Like expvar.Publish it panics if name is already in use.
+func Publish(name string) *Expvar {
+	m := New()
+
+	top := expvar.NewMap(name)
+	top.Set("handshakes_started", m.Started)
+	top.Set("handshakes_succeeded", m.Succeeded)
+	top.Set("handshakes_failed", m.Failed)
+	top.Set("handshake_seconds", m.Seconds)
+	top.Set("mechs_rejected", m.Rejected)
+	top.Set("bytes_wrapped", m.Wrapped)
+	top.Set("bytes_unwrapped", m.Unwrapped)
+
+	return m
+}
+
+func (m *Expvar) HandshakeStarted(mech string) {
+	m.Started.Add(mech, 1)
+}
+
+func (m *Expvar) HandshakeFinished(mech string, err error, d time.Duration) {
+	if err == nil {
+		m.Succeeded.Add(mech, 1)
+	} else {
+		m.Failed.Add(mech, 1)
+	}
+	m.Seconds.AddFloat(mech, d.Seconds())
+}
+
+func (m *Expvar) MechRejected(mech string, reason error) {
+	m.Rejected.Add(mech, 1)
+}
+
+func (m *Expvar) BytesWrapped(mech string, n int) {
+	m.Wrapped.Add(mech, int64(n))
+}
+
+func (m *Expvar) BytesUnwrapped(mech string, n int) {
+	m.Unwrapped.Add(mech, int64(n))
+}
@@ -0,0 +1,38 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package metrics
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpvar(t *testing.T) {
+	m := Publish("sasl_test")
+
+	m.HandshakeStarted("GSSAPI")
+	m.HandshakeStarted("GSSAPI")
+	m.HandshakeFinished("GSSAPI", nil, 2*time.Second)
+	m.HandshakeFinished("GSSAPI", errors.New("no ticket"), time.Second)
+	m.MechRejected("PLAIN", errors.New("too weak"))
+	m.BytesWrapped("GSSAPI", 10)
+	m.BytesWrapped("GSSAPI", 5)
+	m.BytesUnwrapped("GSSAPI", 7)
+
+	assert.Equal(t, "2", m.Started.Get("GSSAPI").String())
+	assert.Equal(t, "1", m.Succeeded.Get("GSSAPI").String())
+	assert.Equal(t, "1", m.Failed.Get("GSSAPI").String())
+	assert.Equal(t, "3", m.Seconds.Get("GSSAPI").String())
+	assert.Equal(t, "1", m.Rejected.Get("PLAIN").String())
+	assert.Equal(t, "15", m.Wrapped.Get("GSSAPI").String())
+	assert.Equal(t, "7", m.Unwrapped.Get("GSSAPI").String())
+
+	top := expvar.Get("sasl_test").(*expvar.Map)
+	assert.Equal(t, m.Started, top.Get("handshakes_started"))
+	assert.JSONEq(t, `{"GSSAPI": 15}`, top.Get("bytes_wrapped").String())
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package oauth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// FuzzErrorChallenge feeds the server's error challenge (RFC 7628 § 3.2.2) to
+// OAUTHBEARER and XOAUTH2
+func FuzzErrorChallenge(f *testing.F) {
+	f.Add([]byte(`{"status":"invalid_token","scope":"mail","openid-configuration":"https://example.com/.well-known/openid-configuration"}`), true)
+	f.Add([]byte(`{"status":"401","schemes":"bearer"}`), false)
+	f.Add([]byte(`{"status":1}`), true)
+	f.Add([]byte{}, false)
+	f.Add([]byte("\x01"), true)
+
+	f.Fuzz(func(t *testing.T, challenge []byte, bearer bool) {
+		cfg := common.MechConfig{
+			TokenSource: &countingSource{},
+			Prompter: common.PromptHandlers{
+				common.PromptUsername: func(common.Prompt) ([]byte, error) {
+					return []byte("user"), nil
+				},
+			},
+		}
+		m := NewXOAuth2Mech(cfg)
+		if bearer {
+			m = NewOAuthBearerMech(cfg)
+		}
+		if _, _, err := m.Step(nil); err != nil {
+			t.Fatal(err)
+		}
+
+		out, _, err := m.Step(challenge)
+		if len(challenge) == 0 {
+			if err != nil || !m.IsEstablished() {
+				t.Fatalf("success wasn't accepted: %v", err)
+			}
+			return
+		}
+
+		if err == nil || m.IsEstablished() {
+			t.Fatalf("error challenge %q was accepted", challenge)
+		}
+		var serverErr *ServerError
+		switch {
+		case errors.As(err, &serverErr):
+			if out == nil {
+				t.Fatalf("no response to the error challenge")
+			}
+		case !errors.Is(err, common.ErrBadToken):
+			t.Fatalf("unexpected error %v", err)
+		}
+	})
+}
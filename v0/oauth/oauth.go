@@ -0,0 +1,352 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package oauth implements the OAUTHBEARER (RFC 7628) and XOAUTH2 client
+// mechanisms.  Access tokens come from the client's TokenSource.
+//
+// Both mechs send a single message.  The server either reports success, or
+// sends an error challenge which Step turns into a *ServerError.  Step returns
+// StepContinue for the initial response, so once the server reports success the
+// caller passes nil to Step to complete the context.  When a token is rejected
+// and the token source caches tokens (see ReuseTokenSource), the cached token is
+// discarded;  if ServerError.Retry is set the application should authenticate
+// once more, which will use a fresh token.
+//
+// With a re-authentication cache (sasl.WithReauthCache) a token that the server
+// accepted is reused for later connections to the same server until it expires
+// or is rejected, without asking the token source.
+package oauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/pkg/loggable"
+	"github.com/golang-auth/go-sasl/registry"
+)
+
+const (
+	OAuthBearer = "OAUTHBEARER"
+	XOAuth2     = "XOAUTH2"
+)
+
+var ErrNoTokenSource = errors.New("no OAuth token source configured")
+
+// bearer tokens can be replayed by anyone that sees them: like passwords
+// they need an external security layer
+var mechProps = common.MechProps{
+	MaxSSF:             0,
+	SecurityProperties: common.SecNoAnonymous | common.SecPassCredentials,
+	Fearures:           common.FeatWantClientFirst | common.FeatDontUseUserPassword,
+}
+
+// BearerMechanism returns the OAUTHBEARER mechanism for sasl.Enable or
+// sasl.WithMechanisms.  Importing this package doesn't register it;  see the
+// allmechs package.
+func BearerMechanism() registry.Mechanism {
+	return registry.Mechanism{Name: OAuthBearer, Factory: NewOAuthBearerMech, Props: mechProps}
+}
+
+// XOAuth2Mechanism returns the XOAUTH2 mechanism for sasl.Enable or
+// sasl.WithMechanisms
+func XOAuth2Mechanism() registry.Mechanism {
+	return registry.Mechanism{Name: XOAuth2, Factory: NewXOAuth2Mech, Props: mechProps}
+}
+
+// ServerError is returned by Step when the server rejects the token
+type ServerError struct {
+	Mech                string `json:"-"`
+	Status              string `json:"status"`
+	Scope               string `json:"scope"`
+	OpenIDConfiguration string `json:"openid-configuration"`
+	Retry               bool   `json:"-"` // a new token will be used if the application authenticates again
+}
+
+func (e *ServerError) Error() string {
+	msg := fmt.Sprintf("%s: server rejected token (status: %s", strings.ToLower(e.Mech), e.Status)
+	if e.Scope != "" {
+		msg += ", scope: " + e.Scope
+	}
+
+	return msg + ")"
+}
+
+func (e *ServerError) Is(target error) bool {
+	return target == common.ErrAuthFailed
+}
+
+type state uint8
+
+const (
+	stateInitial state = iota
+	stateSent
+	stateRejected
+	stateAuthenticated
+	stateClosed
+)
+
+type OAuthMech struct {
+	loggable.Loggable
+	name   string
+	config common.MechConfig
+	state  state
+
+	// reported by ContextParams
+	authzID string
+	authCID string
+	expiry  time.Time
+
+	token     *common.Token
+	fromCache bool // token came from the re-authentication cache
+}
+
+func NewOAuthBearerMech(cfg common.MechConfig) common.Mech {
+	return newMech(OAuthBearer, cfg)
+}
+
+func NewXOAuth2Mech(cfg common.MechConfig) common.Mech {
+	return newMech(XOAuth2, cfg)
+}
+
+func newMech(name string, cfg common.MechConfig) *OAuthMech {
+	cfg.Logger.Debugf("new OAuthMech (%s)", name)
+	return &OAuthMech{
+		Loggable: cfg.Logger,
+		name:     name,
+		config:   cfg,
+		state:    stateInitial,
+	}
+}
+
+func (m OAuthMech) Name() string {
+	return m.name
+}
+
+func (m OAuthMech) MechProperties() common.MechProps {
+	return mechProps
+}
+
+// Step returns StepContinue after the initial response because the server may
+// answer with an error challenge, and StepDone once the server reports success
+func (m *OAuthMech) Step(inToken []byte) (outToken []byte, status common.StepStatus, err error) {
+	switch m.state {
+	case stateInitial:
+		outToken, err = m.stepInitial(inToken)
+		return outToken, common.StepContinue, err
+	case stateSent:
+		outToken, err = m.stepResult(inToken)
+		if err == nil {
+			return outToken, common.StepDone, nil
+		}
+		return outToken, common.StepContinue, err
+	case stateRejected:
+		return nil, common.StepContinue, m.error(common.ErrAuthFailed, "token already rejected", nil)
+	case stateAuthenticated:
+		return nil, common.StepDone, common.ErrAlreadyEstablished
+	case stateClosed:
+		return nil, common.StepContinue, common.ErrClosed
+	}
+
+	return nil, common.StepContinue, m.error(nil, fmt.Sprintf("step - bad state (%d)", m.state), nil)
+}
+
+func (m *OAuthMech) stepInitial(inToken []byte) (outToken []byte, err error) {
+	m.Debugf("%s: step (initial)", m.name)
+
+	// a server that goes first sends an empty challenge
+	if len(inToken) > 0 {
+		return nil, m.error(common.ErrProtocol, "unexpected server challenge", common.ErrBadToken)
+	}
+
+	tok, err := m.getToken()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil || tok.AccessToken == "" {
+		return nil, m.error(common.ErrNoCredentials, "token source returned an empty token", nil)
+	}
+	m.token = tok
+	m.expiry = tok.Expiry
+
+	switch m.name {
+	case XOAuth2:
+		outToken, err = m.xoauth2Response(tok)
+	default:
+		outToken, err = m.oauthBearerResponse(tok)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.state = stateSent
+	return outToken, nil
+}
+
+// getToken returns the token that last worked with this server if it is still
+// valid, or a token from the token source
+func (m *OAuthMech) getToken() (*common.Token, error) {
+	if m.config.ReauthCache != nil {
+		if state, ok := m.config.ReauthCache.Get(m.config.ReauthKey(m.name)); ok {
+			tok := &common.Token{}
+			err := json.Unmarshal(state, tok)
+			common.Zero(state)
+
+			if err == nil && tok.Valid() {
+				m.Debugf("%s: using cached token", m.name)
+				m.fromCache = true
+				return tok, nil
+			}
+		}
+	}
+
+	if m.config.TokenSource == nil {
+		return nil, m.error(common.ErrNoCredentials, "", ErrNoTokenSource)
+	}
+
+	tok, err := m.config.TokenSource.Token()
+	if err != nil {
+		return nil, m.error(common.ErrNoCredentials, "token source", err)
+	}
+
+	return tok, nil
+}
+
+// RFC 7628 § 3.1
+func (m *OAuthMech) oauthBearerResponse(tok *common.Token) ([]byte, error) {
+	authzid, err := m.optionalPrompt(common.PromptAuthzID)
+	if err != nil {
+		return nil, err
+	}
+	m.authzID = authzid
+
+	var b strings.Builder
+	b.WriteString("n,")
+	if authzid != "" {
+		b.WriteString("a=" + gs2Escape(authzid))
+	}
+	b.WriteString(",\x01")
+	if m.config.ServerFQDN != "" {
+		b.WriteString("host=" + m.config.ServerFQDN + "\x01")
+	}
+	b.WriteString("auth=Bearer " + tok.AccessToken + "\x01\x01")
+
+	return []byte(b.String()), nil
+}
+
+// https://developers.google.com/gmail/imap/xoauth2-protocol
+func (m *OAuthMech) xoauth2Response(tok *common.Token) ([]byte, error) {
+	user, err := m.config.Prompt(common.Prompt{
+		Type:    common.PromptUsername,
+		Mech:    m.name,
+		Message: "Username: ",
+		Echo:    true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.authCID = string(user)
+
+	return []byte("user=" + string(user) + "\x01auth=Bearer " + tok.AccessToken + "\x01\x01"), nil
+}
+
+func (m *OAuthMech) stepResult(inToken []byte) (outToken []byte, err error) {
+	m.Debugf("%s: step (result)", m.name)
+
+	// success
+	if len(inToken) == 0 {
+		m.state = stateAuthenticated
+		m.cacheToken()
+		return nil, nil
+	}
+
+	// otherwise the server sent an error challenge
+	serverErr := &ServerError{Mech: m.name}
+	if err := json.Unmarshal(inToken, serverErr); err != nil {
+		return nil, m.error(common.ErrProtocol, "bad error challenge", common.ErrBadToken)
+	}
+
+	if inv, ok := m.config.TokenSource.(common.TokenInvalidator); ok {
+		serverErr.Retry = inv.Invalidate()
+	}
+
+	// a fresh token from the source is worth trying instead of a cached one
+	if m.config.ReauthCache != nil {
+		m.config.ReauthCache.Delete(m.config.ReauthKey(m.name))
+		serverErr.Retry = serverErr.Retry || m.fromCache
+	}
+
+	m.state = stateRejected
+
+	// OAUTHBEARER requires a dummy response to the error, XOAUTH2 an empty one
+	if m.name == OAuthBearer {
+		outToken = []byte{0x01}
+	} else {
+		outToken = []byte{}
+	}
+
+	return outToken, serverErr
+}
+
+func (m *OAuthMech) cacheToken() {
+	if m.config.ReauthCache == nil || m.fromCache {
+		return
+	}
+
+	// can't fail: the token only has strings and a time
+	state, _ := json.Marshal(m.token)
+	m.config.ReauthCache.Put(m.config.ReauthKey(m.name), state, m.token.Expiry)
+	common.Zero(state)
+}
+
+func (m OAuthMech) IsEstablished() bool {
+	return m.state == stateAuthenticated
+}
+
+// OAuth mechanisms never provide a security layer
+func (m OAuthMech) ContextParams() common.ContextParams {
+	return common.ContextParams{
+		AuthzID: m.authzID,
+		AuthCID: m.authCID,
+		Expiry:  m.expiry,
+	}
+}
+
+func (m *OAuthMech) Encode(input []byte) (outToken []byte, err error) {
+	return nil, fmt.Errorf("can't encode data: %w", common.ErrNoLayer)
+}
+
+func (m *OAuthMech) Decode(inputToken []byte) (output []byte, err error) {
+	return nil, fmt.Errorf("can't decode data: %w", common.ErrNoLayer)
+}
+
+// Close drops the mech's reference to the access token.  Go strings can't be
+// zeroed, so the token remains in memory until it is garbage collected.
+func (m *OAuthMech) Close() error {
+	m.token = nil
+	m.state = stateClosed
+	return nil
+}
+
+func (m *OAuthMech) error(class error, detail string, err error) error {
+	return common.NewError(strings.ToLower(m.name), class, detail, err)
+}
+
+// optionalPrompt returns an empty answer if the application has no handler
+func (m *OAuthMech) optionalPrompt(t common.PromptType) (string, error) {
+	answer, err := m.config.Prompt(common.Prompt{Type: t, Mech: m.name, Echo: true})
+	if errors.Is(err, common.ErrNoPromptHandler) {
+		return "", nil
+	}
+
+	return string(answer), err
+}
+
+// RFC 5801 § 4
+func gs2Escape(s string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s)
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingSource returns a new token each time it is asked
+type countingSource struct {
+	calls int
+}
+
+func (s *countingSource) Token() (*common.Token, error) {
+	s.calls++
+	return &common.Token{AccessToken: fmt.Sprintf("token%d", s.calls), Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func TestOAuthBearer(t *testing.T) {
+	cfg := common.MechConfig{
+		ServerFQDN:  "imap.example.com",
+		TokenSource: &countingSource{},
+		Prompter: common.PromptHandlers{
+			common.PromptAuthzID: func(common.Prompt) ([]byte, error) {
+				return []byte("user,x=y"), nil
+			},
+		},
+	}
+
+	m := NewOAuthBearerMech(cfg)
+	assert.Equal(t, "OAUTHBEARER", m.Name())
+
+	out, status, err := m.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "n,a=user=2Cx=3Dy,\x01host=imap.example.com\x01auth=Bearer token1\x01\x01", string(out))
+	assert.Equal(t, common.StepContinue, status)
+	assert.False(t, m.IsEstablished())
+
+	// server reports success
+	out, status, err = m.Step(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, out)
+	assert.Equal(t, common.StepDone, status)
+	assert.Equal(t, "user,x=y", m.ContextParams().AuthzID)
+	assert.False(t, m.ContextParams().Expiry.IsZero())
+	assert.True(t, m.IsEstablished())
+
+	// no authzid or host
+	m = NewOAuthBearerMech(common.MechConfig{TokenSource: &countingSource{}})
+	out, _, err = m.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "n,,\x01auth=Bearer token1\x01\x01", string(out))
+}
+
+func TestXOAuth2(t *testing.T) {
+	cfg := common.MechConfig{
+		TokenSource: &countingSource{},
+		Prompter: common.PromptHandlers{
+			common.PromptUsername: func(common.Prompt) ([]byte, error) {
+				return []byte("someuser@example.com"), nil
+			},
+		},
+	}
+
+	m := NewXOAuth2Mech(cfg)
+	out, _, err := m.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "user=someuser@example.com\x01auth=Bearer token1\x01\x01", string(out))
+	assert.Equal(t, "someuser@example.com", m.ContextParams().AuthCID)
+
+	// XOAUTH2 needs a user name
+	m = NewXOAuth2Mech(common.MechConfig{TokenSource: &countingSource{}})
+	_, _, err = m.Step(nil)
+	assert.ErrorIs(t, err, common.ErrNoPromptHandler)
+}
+
+func TestNoTokenSource(t *testing.T) {
+	m := NewOAuthBearerMech(common.MechConfig{})
+	_, _, err := m.Step(nil)
+	assert.ErrorIs(t, err, ErrNoTokenSource)
+	assert.ErrorIs(t, err, common.ErrNoCredentials)
+
+	m = NewOAuthBearerMech(common.MechConfig{TokenSource: common.TokenSourceFunc(func() (*common.Token, error) {
+		return nil, errors.New("refresh failed")
+	})})
+	_, _, err = m.Step(nil)
+	assert.EqualError(t, err, "oauthbearer: token source: refresh failed")
+	assert.ErrorIs(t, err, common.ErrNoCredentials)
+}
+
+func TestRejectedTokenRetry(t *testing.T) {
+	src := &countingSource{}
+	cfg := common.MechConfig{TokenSource: NewReuseTokenSource(src)}
+	rejection := []byte(`{"status":"invalid_token","scope":"mail"}`)
+
+	// the cached token is reused until the server rejects it
+	m := NewOAuthBearerMech(cfg)
+	out, _, _ := m.Step(nil)
+	assert.Contains(t, string(out), "Bearer token1")
+	m = NewOAuthBearerMech(cfg)
+	out, _, _ = m.Step(nil)
+	assert.Contains(t, string(out), "Bearer token1")
+
+	out, _, err := m.Step(rejection)
+	assert.Equal(t, []byte{0x01}, out)
+	var serverErr *ServerError
+	assert.ErrorAs(t, err, &serverErr)
+	assert.Equal(t, "invalid_token", serverErr.Status)
+	assert.Equal(t, "mail", serverErr.Scope)
+	assert.True(t, serverErr.Retry)
+	assert.False(t, m.IsEstablished())
+	assert.EqualError(t, err, "oauthbearer: server rejected token (status: invalid_token, scope: mail)")
+	assert.ErrorIs(t, err, common.ErrAuthFailed)
+
+	// the retry uses a fresh token;  if that's rejected too, don't retry again
+	m = NewOAuthBearerMech(cfg)
+	out, _, _ = m.Step(nil)
+	assert.Contains(t, string(out), "Bearer token2")
+	_, _, err = m.Step([]byte(`{"status":"invalid_token","Mech":"SPOOFED"}`))
+	assert.ErrorAs(t, err, &serverErr)
+	assert.False(t, serverErr.Retry)
+	assert.Equal(t, OAuthBearer, serverErr.Mech)
+	assert.Equal(t, 2, src.calls)
+
+	// XOAUTH2 answers the error with an empty response
+	m = NewXOAuth2Mech(common.MechConfig{TokenSource: src, Prompter: common.PromptFunc(func(common.Prompt) ([]byte, error) {
+		return []byte("user"), nil
+	})})
+	_, _, _ = m.Step(nil)
+	out, _, err = m.Step([]byte(`{"status":"401","schemes":"bearer"}`))
+	assert.Equal(t, []byte{}, out)
+	assert.ErrorAs(t, err, &serverErr)
+	assert.False(t, serverErr.Retry)
+
+	// garbage instead of an error challenge
+	m = NewOAuthBearerMech(common.MechConfig{TokenSource: src})
+	_, _, _ = m.Step(nil)
+	_, _, err = m.Step([]byte("garbage"))
+	assert.ErrorIs(t, err, common.ErrBadToken)
+}
+
+func TestReuseTokenSourceExpiry(t *testing.T) {
+	expired := &common.Token{AccessToken: "old", Expiry: time.Now().Add(5 * time.Second)}
+	calls := 0
+	s := NewReuseTokenSource(common.TokenSourceFunc(func() (*common.Token, error) {
+		calls++
+		if calls == 1 {
+			return expired, nil
+		}
+		return &common.Token{AccessToken: "new"}, nil
+	}))
+
+	tok, _ := s.Token()
+	assert.Equal(t, "old", tok.AccessToken)
+
+	// about to expire: refreshed
+	tok, _ = s.Token()
+	assert.Equal(t, "new", tok.AccessToken)
+
+	// no expiry: reused
+	tok, _ = s.Token()
+	assert.Equal(t, "new", tok.AccessToken)
+	assert.Equal(t, 2, calls)
+}
+
+func TestReauthCache(t *testing.T) {
+	src := &countingSource{}
+	cache := common.NewMemoryReauthCache()
+	cfg := common.MechConfig{Service: "imap", ServerFQDN: "imap.example.com", TokenSource: src, ReauthCache: cache}
+	rejection := []byte(`{"status":"invalid_token"}`)
+
+	// the first token is cached once the server accepts it..
+	m := NewOAuthBearerMech(cfg)
+	out, _, _ := m.Step(nil)
+	assert.Contains(t, string(out), "Bearer token1")
+	_, _, err := m.Step(nil)
+	assert.NoError(t, err)
+
+	// ..and reused without asking the token source
+	m = NewOAuthBearerMech(cfg)
+	out, _, _ = m.Step(nil)
+	assert.Contains(t, string(out), "Bearer token1")
+	assert.Equal(t, 1, src.calls)
+
+	// other servers don't share it
+	other := cfg
+	other.ServerFQDN = "imap2.example.com"
+	m = NewOAuthBearerMech(other)
+	out, _, _ = m.Step(nil)
+	assert.Contains(t, string(out), "Bearer token2")
+
+	// a rejected cached token is dropped and worth retrying
+	m = NewOAuthBearerMech(cfg)
+	_, _, _ = m.Step(nil)
+	_, _, err = m.Step(rejection)
+	var serverErr *ServerError
+	assert.ErrorAs(t, err, &serverErr)
+	assert.True(t, serverErr.Retry)
+	_, ok := cache.Get(cfg.ReauthKey(OAuthBearer))
+	assert.False(t, ok)
+
+	m = NewOAuthBearerMech(cfg)
+	out, _, _ = m.Step(nil)
+	assert.Contains(t, string(out), "Bearer token3")
+}
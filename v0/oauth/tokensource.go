@@ -0,0 +1,60 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package oauth
+
+import (
+	"sync"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// ReuseTokenSource caches the token from another source until it is about to
+// expire or the server rejects it.  A token source can be shared by many
+// clients: only one of them fetches a new token at a time.
+type ReuseTokenSource struct {
+	mu        sync.Mutex
+	src       common.TokenSource
+	tok       *common.Token
+	forceNext bool // the next token fetched is a forced refresh
+	forced    bool // tok was fetched by a forced refresh
+}
+
+func NewReuseTokenSource(src common.TokenSource) *ReuseTokenSource {
+	return &ReuseTokenSource{src: src}
+}
+
+// Token returns the cached token if it is still valid, or fetches a new one
+func (s *ReuseTokenSource) Token() (*common.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tok.Valid() {
+		return s.tok, nil
+	}
+
+	tok, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.tok = tok
+	s.forced = s.forceNext
+	s.forceNext = false
+
+	return tok, nil
+}
+
+// Invalidate discards the cached token.  It returns false if the token was
+// fetched because a previous one was rejected, so that a server that rejects
+// every token is only retried once.
+func (s *ReuseTokenSource) Invalidate() (retry bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	retry = !s.forced
+	s.tok = nil
+	s.forceNext = retry
+
+	return retry
+}
@@ -1,66 +1,160 @@
 package loggable
 
-import "log"
+import (
+	"fmt"
+	"log"
+)
 
 type LoggableOption func(*Loggable) error
 
-type Loggable struct {
-	debugLogger *log.Logger
-	infoLogger  *log.Logger
-	warnLogger  *log.Logger
-	errorLogger *log.Logger
+// Level is the severity of a log message
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Logger is the minimal interface needed to receive log messages.  It is
+// satisfied directly by zap's SugaredLogger and is easily implemented for
+// zerolog, logr and others.
+type Logger interface {
+	Debugf(msg string, args ...interface{})
+	Infof(msg string, args ...interface{})
+	Warnf(msg string, args ...interface{})
+	Errorf(msg string, args ...interface{})
 }
 
-func (c *Loggable) Debugf(msg string, args ...interface{}) {
-	if c.debugLogger == nil {
-		return
+// StdLogger adapts a *log.Logger per level to the Logger interface.  Messages
+// for a level with no logger are discarded.
+type StdLogger struct {
+	Debug *log.Logger
+	Info  *log.Logger
+	Warn  *log.Logger
+	Error *log.Logger
+}
+
+func (s *StdLogger) Debugf(msg string, args ...interface{}) { printf(s.Debug, msg, args) }
+func (s *StdLogger) Infof(msg string, args ...interface{})  { printf(s.Info, msg, args) }
+func (s *StdLogger) Warnf(msg string, args ...interface{})  { printf(s.Warn, msg, args) }
+func (s *StdLogger) Errorf(msg string, args ...interface{}) { printf(s.Error, msg, args) }
+
+func printf(l *log.Logger, msg string, args []interface{}) {
+	if l != nil {
+		l.Printf(msg, args...)
 	}
+}
 
-	c.debugLogger.Printf(msg, args...)
+// sink receives messages with their attributes, for structured loggers
+type sink interface {
+	enabled(level Level) bool
+	log(level Level, msg string, attrs []interface{})
 }
-func (c *Loggable) Infof(msg string, args ...interface{}) {
-	if c.infoLogger == nil {
+
+type Loggable struct {
+	logger Logger
+
+	sink  sink
+	attrs []interface{} // alternating keys and values
+
+	unsafeTokens bool // log tokens in full, see Token
+}
+
+// With returns a copy of c that adds the key/value pairs in args to the
+// messages sent to a structured logger, eg. With("mech", "GSSAPI")
+func (c Loggable) With(args ...interface{}) Loggable {
+	c.attrs = append(append([]interface{}{}, c.attrs...), args...)
+	return c
+}
+
+func (c *Loggable) logf(level Level, msg string, args []interface{}) {
+	if c.sink != nil && c.sink.enabled(level) {
+		c.sink.log(level, fmt.Sprintf(msg, args...), c.attrs)
+	}
+
+	if c.logger == nil {
 		return
 	}
 
-	c.infoLogger.Printf(msg, args...)
+	switch level {
+	case LevelDebug:
+		c.logger.Debugf(msg, args...)
+	case LevelInfo:
+		c.logger.Infof(msg, args...)
+	case LevelWarn:
+		c.logger.Warnf(msg, args...)
+	default:
+		c.logger.Errorf(msg, args...)
+	}
 }
-func (c *Loggable) Warnf(msg string, args ...interface{}) {
-	if c.warnLogger == nil {
-		return
+
+// DebugEnabled reports whether debug messages go anywhere, so that callers can
+// skip building expensive ones
+func (c *Loggable) DebugEnabled() bool {
+	if c.sink != nil && c.sink.enabled(LevelDebug) {
+		return true
 	}
+	if s, ok := c.logger.(*StdLogger); ok {
+		return s.Debug != nil
+	}
+	return c.logger != nil
+}
 
-	c.warnLogger.Printf(msg, args...)
+func (c *Loggable) Debugf(msg string, args ...interface{}) {
+	c.logf(LevelDebug, msg, args)
+}
+func (c *Loggable) Infof(msg string, args ...interface{}) {
+	c.logf(LevelInfo, msg, args)
+}
+func (c *Loggable) Warnf(msg string, args ...interface{}) {
+	c.logf(LevelWarn, msg, args)
 }
 func (c *Loggable) Errorf(msg string, args ...interface{}) {
-	if c.errorLogger == nil {
-		return
+	c.logf(LevelError, msg, args)
+}
+
+// WithLogger sends messages to l, replacing any *log.Logger set previously
+func WithLogger(l Logger) LoggableOption {
+	return func(c *Loggable) error {
+		c.logger = l
+		return nil
 	}
+}
 
-	c.errorLogger.Printf(msg, args...)
+// std returns the StdLogger that the *log.Logger options update, replacing any
+// other Logger
+func (c *Loggable) std() *StdLogger {
+	s, ok := c.logger.(*StdLogger)
+	if !ok {
+		s = &StdLogger{}
+		c.logger = s
+	}
+	return s
 }
 
 func WithDebugLogger(l *log.Logger) LoggableOption {
 	return func(c *Loggable) error {
-		c.debugLogger = l
+		c.std().Debug = l
 		return nil
 	}
 }
 func WithInfoLogger(l *log.Logger) LoggableOption {
 	return func(c *Loggable) error {
-		c.infoLogger = l
+		c.std().Info = l
 		return nil
 	}
 }
 func WithWarnLogger(l *log.Logger) LoggableOption {
 	return func(c *Loggable) error {
-		c.warnLogger = l
+		c.std().Warn = l
 		return nil
 	}
 }
 func WithErrorLogger(l *log.Logger) LoggableOption {
 	return func(c *Loggable) error {
-		c.errorLogger = l
+		c.std().Error = l
 		return nil
 	}
 }
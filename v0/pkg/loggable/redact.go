@@ -0,0 +1,41 @@
+package loggable
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// WithUnsafeTokenLogging makes Token log the full contents of tokens in hex.
+// Tokens can contain credentials, so this is only for debugging in a lab.
+func WithUnsafeTokenLogging() LoggableOption {
+	return func(c *Loggable) error {
+		c.unsafeTokens = true
+		return nil
+	}
+}
+
+// Token returns a value for logging a token.  Unless unsafe token logging is
+// enabled it shows only the length of b and a prefix of its SHA-256 hash, which
+// is enough to tell whether two tokens are the same, eg. "<32 bytes sha256:1a2b3c4d>".
+func (c *Loggable) Token(b []byte) fmt.Stringer {
+	return token{b: b, unsafe: c.unsafeTokens}
+}
+
+type token struct {
+	b      []byte
+	unsafe bool
+}
+
+func (t token) String() string {
+	if t.b == nil {
+		return "<nil>"
+	}
+
+	if t.unsafe {
+		return fmt.Sprintf("<%d bytes: %x>", len(t.b), t.b)
+	}
+
+	sum := sha256.Sum256(t.b)
+	return fmt.Sprintf("<%d bytes sha256:%s>", len(t.b), hex.EncodeToString(sum[:4]))
+}
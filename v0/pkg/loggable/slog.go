@@ -0,0 +1,45 @@
+//go:build go1.21
+// +build go1.21
+
+package loggable
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WithSlogLogger sends messages to l at the matching slog level, along with
+// the attributes added by With
+func WithSlogLogger(l *slog.Logger) LoggableOption {
+	return func(c *Loggable) error {
+		c.sink = nil
+		if l != nil {
+			c.sink = slogSink{l}
+		}
+		return nil
+	}
+}
+
+type slogSink struct {
+	l *slog.Logger
+}
+
+func (s slogSink) enabled(level Level) bool {
+	return s.l.Enabled(context.Background(), slogLevel(level))
+}
+
+func (s slogSink) log(level Level, msg string, attrs []interface{}) {
+	s.l.Log(context.Background(), slogLevel(level), msg, attrs...)
+}
+
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
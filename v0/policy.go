@@ -0,0 +1,90 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// ErrDenied is the reason a mech is rejected if the mech policy doesn't allow it
+var ErrDenied = errors.New("denied by mech policy")
+
+// MechPolicy allows or denies mechanisms by name using shell patterns, eg.
+// "SCRAM-*" or "!*-MD5".  A mech is allowed if it matches no deny pattern
+// (one starting with "!") and either there are no allow patterns or it matches
+// one of them.  Matching is case insensitive.
+type MechPolicy struct {
+	patterns []string
+	allow    []string
+	deny     []string
+}
+
+// NewMechPolicy returns a policy for patterns, or an error wrapping
+// common.ErrBadConfig if any of them is malformed
+func NewMechPolicy(patterns ...string) (*MechPolicy, error) {
+	p := &MechPolicy{patterns: patterns}
+
+	for _, pattern := range patterns {
+		pat := strings.ToUpper(strings.TrimSpace(pattern))
+		list := &p.allow
+		if strings.HasPrefix(pat, "!") {
+			pat = pat[1:]
+			list = &p.deny
+		}
+
+		if _, err := path.Match(pat, ""); err != nil || pat == "" {
+			return nil, fmt.Errorf("mech policy pattern %q: %w", pattern, common.ErrBadConfig)
+		}
+		*list = append(*list, pat)
+	}
+
+	return p, nil
+}
+
+// Allowed reports whether the policy allows mech, eg. to filter the mechs that
+// a server advertises
+func (p *MechPolicy) Allowed(mech string) bool {
+	mech = strings.ToUpper(mech)
+
+	for _, pat := range p.deny {
+		if ok, _ := path.Match(pat, mech); ok {
+			return false
+		}
+	}
+
+	if len(p.allow) == 0 {
+		return true
+	}
+
+	for _, pat := range p.allow {
+		if ok, _ := path.Match(pat, mech); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Patterns returns the patterns the policy was created with
+func (p *MechPolicy) Patterns() []string {
+	return append([]string(nil), p.patterns...)
+}
+
+// WithMechPolicy rejects mechanisms that patterns don't allow during
+// selection, in addition to any mech list, see MechPolicy
+func WithMechPolicy(patterns ...string) SaslClientOption {
+	return func(c *SaslClient) error {
+		p, err := NewMechPolicy(patterns...)
+		if err != nil {
+			return err
+		}
+		c.mechPolicy = p
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMechPolicy(t *testing.T) {
+	var tests = []struct {
+		patterns []string
+		allowed  []string
+		denied   []string
+	}{
+		{nil, []string{"PLAIN", "GSSAPI"}, nil},
+		{[]string{"!*-MD5"}, []string{"GSSAPI", "SCRAM-SHA-1"}, []string{"DIGEST-MD5", "cram-md5"}},
+		{[]string{"scram-*", "GSSAPI"}, []string{"SCRAM-SHA-256-PLUS", "GSSAPI"}, []string{"PLAIN", "GS2-KRB5"}},
+		{[]string{"SCRAM-*", "!SCRAM-SHA-1*"}, []string{"SCRAM-SHA-256"}, []string{"SCRAM-SHA-1", "SCRAM-SHA-1-PLUS"}},
+		{[]string{"GS?-KRB5"}, []string{"GS2-KRB5"}, []string{"GSSAPI"}},
+	}
+
+	for _, tt := range tests {
+		p, err := NewMechPolicy(tt.patterns...)
+		assert.NoError(t, err)
+		for _, mech := range tt.allowed {
+			assert.True(t, p.Allowed(mech), "%v allows %s", tt.patterns, mech)
+		}
+		for _, mech := range tt.denied {
+			assert.False(t, p.Allowed(mech), "%v denies %s", tt.patterns, mech)
+		}
+	}
+
+	for _, bad := range []string{"!", "", "SCRAM-[", "!["} {
+		_, err := NewMechPolicy(bad)
+		assert.ErrorIs(t, err, common.ErrBadConfig, bad)
+	}
+}
+
+func TestWithMechPolicy(t *testing.T) {
+	r := registry.New()
+	props := common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous}
+	for _, name := range []string{"CRAM-MD5", "SCRAM-SHA-1", "SCRAM-SHA-256"} {
+		name := name
+		r.MustRegister(name, func(cfg common.MechConfig) common.Mech {
+			return &scriptedMech{name: name, steps: 1}
+		}, props)
+	}
+
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechPolicy("!*-MD5", "!SCRAM-SHA-1"),
+		WithMechList([]string{"CRAM-MD5", "SCRAM-SHA-1", "SCRAM-SHA-256"}))
+	assert.NoError(t, err)
+
+	mech, rejected, err := cli.ChooseMech([]string{"CRAM-MD5", "SCRAM-SHA-1", "SCRAM-SHA-256"})
+	assert.NoError(t, err)
+	assert.Equal(t, "SCRAM-SHA-256", mech)
+	assert.Equal(t, map[string]error{"CRAM-MD5": ErrDenied, "SCRAM-SHA-1": ErrDenied}, rejected)
+	assert.Contains(t, canonicalJSON(t, cli), `"mech_policy":["!*-MD5","!SCRAM-SHA-1"]`)
+
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechPolicy("GSSAPI"))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.ErrorIs(t, err, common.ErrNoMech)
+
+	_, err = NewSaslClient("imap", WithRegistry(r), WithMechPolicy("["))
+	assert.ErrorIs(t, err, common.ErrBadConfig)
+}
@@ -0,0 +1,175 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package term answers SASL prompts interactively on a terminal.  Passwords and
+// one-time passwords are read with echo disabled, and realms offered by the
+// server are presented as a numbered menu:
+//
+//	client, err := sasl.NewSaslClient("imap", sasl.WithPrompter(term.New()))
+package term
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+var ErrNoEcho = errors.New("term: can't disable echo on this terminal")
+
+// Prompter implements common.SaslPrompt by asking the user
+type Prompter struct {
+	mu  sync.Mutex
+	in  *os.File
+	rd  *bufio.Reader
+	out io.Writer
+}
+
+// New returns a prompter that reads from standard input and writes prompts to
+// standard error
+func New() *Prompter {
+	return NewPrompter(os.Stdin, os.Stderr)
+}
+
+// NewPrompter returns a prompter that reads answers from in and writes prompts
+// to out.  Echo is only disabled if in is a terminal.
+func NewPrompter(in *os.File, out io.Writer) *Prompter {
+	return &Prompter{
+		in:  in,
+		rd:  bufio.NewReader(in),
+		out: out,
+	}
+}
+
+// Prompt implements common.SaslPrompt
+func (p *Prompter) Prompt(pr common.Prompt) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pr.Challenge != "" {
+		fmt.Fprintf(p.out, "%s\n", pr.Challenge)
+	}
+
+	switch {
+	case pr.Type == common.PromptConfirm:
+		return p.confirm(pr)
+	case len(pr.Choices) > 0:
+		return p.menu(pr)
+	case !pr.Echo:
+		return p.readHidden(message(pr))
+	}
+
+	answer, err := p.readLine(message(pr))
+	if err == nil && len(answer) == 0 && pr.Default != "" {
+		answer = []byte(pr.Default)
+	}
+
+	return answer, err
+}
+
+func (p *Prompter) confirm(pr common.Prompt) ([]byte, error) {
+	msg := strings.TrimRight(pr.Message, ": ")
+	if msg == "" {
+		msg = "Continue?"
+	}
+
+	answer, err := p.readLine(msg + " [y/N]: ")
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(string(answer)) {
+	case "y", "yes":
+		return []byte("y"), nil
+	}
+
+	return nil, nil
+}
+
+// menu lets the user choose by number or by typing the choice
+func (p *Prompter) menu(pr common.Prompt) ([]byte, error) {
+	for i, c := range pr.Choices {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, c)
+	}
+
+	for {
+		answer, err := p.readLine(message(pr))
+		if err != nil {
+			return nil, err
+		}
+
+		s := string(answer)
+		if s == "" && pr.Default != "" {
+			return []byte(pr.Default), nil
+		}
+
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= len(pr.Choices) {
+			return []byte(pr.Choices[n-1]), nil
+		}
+
+		for _, c := range pr.Choices {
+			if s == c {
+				return answer, nil
+			}
+		}
+
+		fmt.Fprintf(p.out, "Please enter a number between 1 and %d\n", len(pr.Choices))
+	}
+}
+
+func (p *Prompter) readLine(msg string) ([]byte, error) {
+	fmt.Fprint(p.out, msg)
+
+	line, err := p.rd.ReadBytes('\n')
+	if err != nil && !(err == io.EOF && len(line) > 0) {
+		common.Zero(line)
+		return nil, err
+	}
+
+	answer := bytes.TrimRight(line, "\r\n")
+	out := make([]byte, len(answer))
+	copy(out, answer)
+	common.Zero(line)
+
+	return out, nil
+}
+
+func (p *Prompter) readHidden(msg string) ([]byte, error) {
+	restore, err := disableEcho(p.in)
+	switch {
+	case errors.Is(err, errNotTerminal):
+		// nobody is watching
+		return p.readLine(msg)
+	case err != nil:
+		return nil, err
+	}
+
+	answer, err := p.readLine(msg)
+	restore()
+
+	// the user's newline wasn't echoed
+	fmt.Fprintln(p.out)
+
+	return answer, err
+}
+
+func message(pr common.Prompt) string {
+	msg := pr.Message
+	if msg == "" {
+		msg = strings.ToUpper(pr.Type.String()[:1]) + pr.Type.String()[1:] + ": "
+	}
+
+	if pr.Default != "" && pr.Echo {
+		msg = strings.TrimRight(msg, ": ") + " [" + pr.Default + "]: "
+	}
+
+	return msg
+}
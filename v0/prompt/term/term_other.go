@@ -0,0 +1,20 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd,!windows
+
+package term
+
+import (
+	"errors"
+	"os"
+)
+
+var errNotTerminal = errors.New("term: not a terminal")
+
+// we don't know how to control echo here, so refuse to read secrets
+func disableEcho(f *os.File) (restore func(), err error) {
+	return nil, ErrNoEcho
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package term
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPrompter returns a prompter reading input from a pipe.  The caller
+// closes the read end of the pipe.
+func newTestPrompter(t *testing.T, input string) (*Prompter, *strings.Builder, *os.File) {
+	r, w, err := os.Pipe()
+	assert.NoError(t, err)
+	_, err = w.WriteString(input)
+	assert.NoError(t, err)
+	w.Close()
+
+	out := &strings.Builder{}
+	return NewPrompter(r, out), out, r
+}
+
+func TestPrompt(t *testing.T) {
+	p, out, r := newTestPrompter(t, "jake\r\n\nhunter2\n")
+	defer r.Close()
+
+	answer, err := p.Prompt(common.Prompt{Type: common.PromptUsername, Echo: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("jake"), answer)
+	assert.Equal(t, "Username: ", out.String())
+
+	// defaults
+	out.Reset()
+	answer, err = p.Prompt(common.Prompt{Type: common.PromptAuthzID, Message: "Authorize as: ", Default: "admin", Echo: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("admin"), answer)
+	assert.Equal(t, "Authorize as [admin]: ", out.String())
+
+	// hidden input works from a pipe too
+	out.Reset()
+	answer, err = p.Prompt(common.Prompt{Type: common.PromptPassword, Message: "Password: "})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hunter2"), answer)
+	assert.Equal(t, "Password: ", out.String())
+
+	// end of input
+	_, err = p.Prompt(common.Prompt{Type: common.PromptUsername, Echo: true})
+	assert.Error(t, err)
+}
+
+func TestPromptOTP(t *testing.T) {
+	p, out, r := newTestPrompter(t, "123456")
+	defer r.Close()
+
+	answer, err := p.Prompt(common.Prompt{Type: common.PromptOTP, Challenge: "otp-md5 499 ke1234"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("123456"), answer)
+	assert.Equal(t, "otp-md5 499 ke1234\nOtp: ", out.String())
+}
+
+func TestRealmMenu(t *testing.T) {
+	p, out, r := newTestPrompter(t, "3\nB.COM\n2\n\n")
+	defer r.Close()
+	pr := common.Prompt{Type: common.PromptRealm, Message: "Realm: ", Choices: []string{"A.COM", "B.COM"}, Echo: true}
+
+	// out of range, then by name
+	answer, err := p.Prompt(pr)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("B.COM"), answer)
+	assert.Equal(t, "  1) A.COM\n  2) B.COM\nRealm: Please enter a number between 1 and 2\nRealm: ", out.String())
+
+	// by number
+	answer, err = p.Prompt(pr)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("B.COM"), answer)
+
+	// default
+	pr.Default = "A.COM"
+	answer, err = p.Prompt(pr)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("A.COM"), answer)
+}
+
+func TestConfirm(t *testing.T) {
+	p, out, r := newTestPrompter(t, "Y\nno\n\n")
+	defer r.Close()
+	pr := common.Prompt{Type: common.PromptConfirm, Message: "Trust this server? "}
+
+	answer, err := p.Prompt(pr)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, answer)
+	assert.Equal(t, "Trust this server? [y/N]: ", out.String())
+
+	answer, err = p.Prompt(pr)
+	assert.NoError(t, err)
+	assert.Empty(t, answer)
+
+	answer, err = p.Prompt(pr)
+	assert.NoError(t, err)
+	assert.Empty(t, answer)
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package term
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var errNotTerminal = errors.New("term: not a terminal")
+
+func getTermios(fd uintptr, t *syscall.Termios) syscall.Errno {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(t)))
+	return e
+}
+
+func setTermios(fd uintptr, t *syscall.Termios) syscall.Errno {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(t)))
+	return e
+}
+
+func disableEcho(f *os.File) (restore func(), err error) {
+	fd := f.Fd()
+
+	var old syscall.Termios
+	if e := getTermios(fd, &old); e != 0 {
+		return nil, errNotTerminal
+	}
+
+	t := old
+	t.Lflag &^= syscall.ECHO
+	t.Lflag |= syscall.ICANON | syscall.ISIG
+	t.Iflag |= syscall.ICRNL
+	if e := setTermios(fd, &t); e != 0 {
+		return nil, ErrNoEcho
+	}
+
+	return func() { setTermios(fd, &old) }, nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package term
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var errNotTerminal = errors.New("term: not a console")
+
+const enableEchoInput = 0x0004
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleMode = kernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+func disableEcho(f *os.File) (restore func(), err error) {
+	h := f.Fd()
+
+	var old uint32
+	if r, _, _ := procGetConsoleMode.Call(h, uintptr(unsafe.Pointer(&old))); r == 0 {
+		return nil, errNotTerminal
+	}
+
+	if r, _, _ := procSetConsoleMode.Call(h, uintptr(old&^enableEchoInput)); r == 0 {
+		return nil, ErrNoEcho
+	}
+
+	return func() { procSetConsoleMode.Call(h, uintptr(old)) }, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package registry
+
+import (
+	"sort"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// Usage statuses from the IANA SASL mechanisms registry
+const (
+	StatusCommon   = "common"
+	StatusLimited  = "limited"
+	StatusObsolete = "obsolete"
+)
+
+type ianaEntry struct {
+	reference string
+	status    string
+}
+
+// see: https://www.iana.org/assignments/sasl-mechanisms/sasl-mechanisms.xhtml
+var ianaMechs = map[string]ianaEntry{
+	"ANONYMOUS":          {"RFC 4505", StatusCommon},
+	"CRAM-MD5":           {"RFC 2195", StatusLimited},
+	"DIGEST-MD5":         {"RFC 6331", StatusObsolete},
+	"EXTERNAL":           {"RFC 4422", StatusCommon},
+	"GS2-KRB5":           {"RFC 5801", StatusCommon},
+	"GS2-KRB5-PLUS":      {"RFC 5801", StatusCommon},
+	"GSSAPI":             {"RFC 4752", StatusCommon},
+	"KERBEROS_V4":        {"RFC 2222", StatusObsolete},
+	"LOGIN":              {"draft-murchison-sasl-login", StatusObsolete},
+	"NTLM":               {"MS-NLMP", StatusLimited},
+	"OAUTHBEARER":        {"RFC 7628", StatusCommon},
+	"OPENID20":           {"RFC 6616", StatusCommon},
+	"PLAIN":              {"RFC 4616", StatusCommon},
+	"SAML20":             {"RFC 6595", StatusCommon},
+	"SCRAM-SHA-1":        {"RFC 5802", StatusCommon},
+	"SCRAM-SHA-1-PLUS":   {"RFC 5802", StatusCommon},
+	"SCRAM-SHA-256":      {"RFC 7677", StatusCommon},
+	"SCRAM-SHA-256-PLUS": {"RFC 7677", StatusCommon},
+	"SKEY":               {"RFC 2444", StatusObsolete},
+}
+
+// Description describes a registered mechanism, eg. for a debug endpoint.  The
+// mechanism can negotiate any SSF from zero to MaxSSF.  Reference and Status
+// come from the IANA registry and are empty for unregistered mechanisms such
+// as XOAUTH2.
+type Description struct {
+	Name                string   `json:"name"`
+	MaxSSF              uint     `json:"max_ssf"`
+	SecurityFlags       []string `json:"security_flags"`
+	Features            []string `json:"features"`
+	ChannelBindingTypes []string `json:"channel_binding_types,omitempty"`
+	Reference           string   `json:"reference,omitempty"`
+	Status              string   `json:"status,omitempty"`
+	Deprecated          bool     `json:"deprecated"`
+}
+
+func describe(name string, props common.MechProps) Description {
+	d := Description{
+		Name:                name,
+		MaxSSF:              props.MaxSSF,
+		SecurityFlags:       []string{},
+		Features:            []string{},
+		ChannelBindingTypes: props.ChannelBindingTypes,
+	}
+
+	for _, f := range common.FlagList(props.SecurityProperties) {
+		d.SecurityFlags = append(d.SecurityFlags, f.String())
+	}
+	for _, f := range common.FeatureList(props.Fearures) {
+		d.Features = append(d.Features, f.String())
+	}
+
+	if e, ok := ianaMechs[name]; ok {
+		d.Reference = e.reference
+		d.Status = e.status
+		d.Deprecated = e.status == StatusObsolete
+	}
+
+	return d
+}
+
+// Describe returns a description of the named mechanism, or false if it isn't
+// registered
+func (r *Registry) Describe(name string) (Description, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.mechs[name]
+	if !ok {
+		return Description{}, false
+	}
+
+	return describe(name, m.properties), true
+}
+
+// DescribeAll returns descriptions of the registered mechanisms, sorted by
+// name
+func (r *Registry) DescribeAll() []Description {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	l := make([]Description, 0, len(r.mechs))
+	for name, m := range r.mechs {
+		l = append(l, describe(name, m.properties))
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+
+	return l
+}
+
+// Describe returns a description of a mechanism in the default registry
+func Describe(name string) (Description, bool) {
+	return defaultRegistry.Describe(name)
+}
+
+// DescribeAll returns descriptions of the mechanisms in the default registry
+func DescribeAll() []Description {
+	return defaultRegistry.DescribeAll()
+}
@@ -0,0 +1,51 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribe(t *testing.T) {
+	mf := func(common.MechConfig) common.Mech { return dummyMech{} }
+
+	r := New()
+	r.MustRegister("GSSAPI", mf, common.MechProps{
+		MaxSSF:             256,
+		SecurityProperties: common.SecNoPlainText | common.SecMutualAuth,
+		Fearures:           common.FeatNeedServerFQDN,
+	})
+	r.MustRegister("DIGEST-MD5", mf, common.MechProps{MaxSSF: 128})
+	r.MustRegister("X-CUSTOM", mf, common.MechProps{ChannelBindingTypes: []string{"tls-exporter"}})
+
+	d, ok := r.Describe("GSSAPI")
+	assert.True(t, ok)
+	assert.Equal(t, Description{
+		Name:          "GSSAPI",
+		MaxSSF:        256,
+		SecurityFlags: []string{"SecNoPlainText", "SecMutualAuth"},
+		Features:      []string{"FeatNeedServerFQDN"},
+		Reference:     "RFC 4752",
+		Status:        StatusCommon,
+	}, d)
+
+	d, _ = r.Describe("DIGEST-MD5")
+	assert.True(t, d.Deprecated)
+	assert.Equal(t, StatusObsolete, d.Status)
+
+	_, ok = r.Describe("PLAIN")
+	assert.False(t, ok)
+
+	all := r.DescribeAll()
+	assert.Len(t, all, 3)
+	assert.Equal(t, []string{"DIGEST-MD5", "GSSAPI", "X-CUSTOM"}, []string{all[0].Name, all[1].Name, all[2].Name})
+
+	b, err := json.Marshal(all[2])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"name":"X-CUSTOM","max_ssf":0,"security_flags":[],"features":[],"channel_binding_types":["tls-exporter"],"deprecated":false}`, string(b))
+}
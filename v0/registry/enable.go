@@ -0,0 +1,54 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package registry
+
+import (
+	"fmt"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// Mechanism is everything needed to register a mechanism.  Mech packages
+// return one (eg. gssapi.Mechanism) so that programs can enable them
+// explicitly instead of relying on the side effect of an import.
+type Mechanism struct {
+	Name    string
+	Factory MechFactory
+	Props   common.MechProps
+}
+
+// Enable adds mechs to the registry, replacing any mechanisms with the same
+// names.  Either all of them are added or, if any name is invalid or repeated,
+// none are.
+func (r *Registry) Enable(mechs ...Mechanism) error {
+	seen := make(map[string]bool, len(mechs))
+	for _, m := range mechs {
+		switch {
+		case !saslMechRegexp.MatchString(m.Name):
+			return fmt.Errorf("%w: %q", ErrBadMechName, m.Name)
+		case m.Factory == nil:
+			return fmt.Errorf("%s: nil factory: %w", m.Name, common.ErrBadConfig)
+		case seen[m.Name]:
+			return fmt.Errorf("%w: %s", ErrAlreadyRegistered, m.Name)
+		}
+		seen[m.Name] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range mechs {
+		r.mechs[m.Name] = mech{
+			factory:    m.Factory,
+			properties: m.Props,
+		}
+	}
+
+	return nil
+}
+
+// Enable adds mechs to the default registry
+func Enable(mechs ...Mechanism) error {
+	return defaultRegistry.Enable(mechs...)
+}
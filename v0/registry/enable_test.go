@@ -0,0 +1,45 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package registry
+
+import (
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnable(t *testing.T) {
+	mf := func(rand int) MechFactory {
+		return func(common.MechConfig) common.Mech {
+			return dummyMech{rand: rand}
+		}
+	}
+
+	r := New()
+	assert.NoError(t, r.Enable(
+		Mechanism{Name: "ENABLE-A", Factory: mf(1)},
+		Mechanism{Name: "ENABLE-B", Factory: mf(2), Props: common.MechProps{MaxSSF: 56}},
+	))
+	assert.ElementsMatch(t, []string{"ENABLE-A", "ENABLE-B"}, r.Mechs())
+	assert.Equal(t, uint(56), r.Properties("ENABLE-B").MaxSSF)
+
+	// enabling a mech again replaces it
+	assert.NoError(t, r.Enable(Mechanism{Name: "ENABLE-A", Factory: mf(3)}))
+	assert.Equal(t, 3, r.NewMech("ENABLE-A", common.MechConfig{}).(dummyMech).rand)
+
+	// nothing is added if any of the mechs is bad
+	for _, bad := range []Mechanism{
+		{Name: "bad-mech-name", Factory: mf(4)},
+		{Name: "ENABLE-C"},
+		{Name: "ENABLE-D", Factory: mf(4)},
+	} {
+		err := r.Enable(Mechanism{Name: "ENABLE-D", Factory: mf(4)}, bad)
+		assert.Error(t, err)
+		assert.False(t, r.IsRegistered("ENABLE-D"))
+	}
+	assert.ErrorIs(t, r.Enable(Mechanism{Name: "bad-mech-name", Factory: mf(4)}), ErrBadMechName)
+	assert.ErrorIs(t, r.Enable(Mechanism{Name: "ENABLE-C"}), common.ErrBadConfig)
+	assert.False(t, IsRegistered("ENABLE-A"))
+}
@@ -4,7 +4,11 @@
 package registry
 
 import (
+	"errors"
+	"fmt"
 	"regexp"
+	"sort"
+	"sync"
 
 	"github.com/golang-auth/go-sasl/common"
 )
@@ -12,6 +16,11 @@ import (
 // See RFC 4422 § 3.1
 var saslMechRegexp = regexp.MustCompile(`^[A-Z0-9-_]{1,20}$`)
 
+var (
+	ErrBadMechName       = errors.New("bad mech name")
+	ErrAlreadyRegistered = errors.New("mech already registered")
+)
+
 type MechFactory func(common.MechConfig) common.Mech
 
 type mech struct {
@@ -19,44 +28,108 @@ type mech struct {
 	properties common.MechProps
 }
 
-var mechs map[string]mech
+// Registry is a set of mechanisms available to clients.  Mech packages register
+// themselves in the default registry when they are imported;  programs that need
+// to control exactly which mechs a client can use (eg. tests, or servers acting
+// for several tenants) can populate their own, eg. with Enable.
+//
+// A registry is safe for concurrent use.
+type Registry struct {
+	mu    sync.RWMutex
+	mechs map[string]mech
+}
+
+var defaultRegistry = New()
+
+// New returns an empty registry
+func New() *Registry {
+	return &Registry{mechs: make(map[string]mech)}
+}
+
+// Default returns the registry that mech packages register with
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// Register adds a mechanism to the registry.  It fails if the name is not a
+// valid SASL mech name or is already registered.
+func (r *Registry) Register(name string, f MechFactory, props common.MechProps) error {
+	return r.add(name, f, props, false)
+}
+
+// MustRegister is like Register but panics on error.  It is intended for use
+// in init functions.
+func (r *Registry) MustRegister(name string, f MechFactory, props common.MechProps) {
+	if err := r.Register(name, f, props); err != nil {
+		panic(err)
+	}
+}
+
+// Replace adds a mechanism to the registry, replacing any existing mechanism
+// with the same name
+func (r *Registry) Replace(name string, f MechFactory, props common.MechProps) error {
+	return r.add(name, f, props, true)
+}
 
-func init() {
-	mechs = make(map[string]mech)
+// MustReplace is like Replace but panics on error
+func (r *Registry) MustReplace(name string, f MechFactory, props common.MechProps) {
+	if err := r.Replace(name, f, props); err != nil {
+		panic(err)
+	}
 }
 
-// Register should be called by Mech implementations to enable
-// a mechanism to be used by clients
-func Register(name string, f MechFactory, props common.MechProps) {
+func (r *Registry) add(name string, f MechFactory, props common.MechProps, replace bool) error {
 	if !saslMechRegexp.Match([]byte(name)) {
-		panic("Bad mech name: " + name)
+		return fmt.Errorf("%w: %q", ErrBadMechName, name)
 	}
 
-	_, ok := mechs[name]
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	// can't register two mechs with the same name
-	if ok {
-		panic("Cannot have two mechs named " + name)
+	// can't register two mechs with the same name unless asked to
+	if _, ok := r.mechs[name]; ok && !replace {
+		return fmt.Errorf("%w: %s", ErrAlreadyRegistered, name)
 	}
 
-	mechs[name] = mech{
+	r.mechs[name] = mech{
 		factory:    f,
 		properties: props,
 	}
+
+	return nil
+}
+
+// Unregister removes a mechanism from the registry, returning false if it was
+// not registered.  Clients that have already started the mechanism are not
+// affected.
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.mechs[name]
+	delete(r.mechs, name)
+
+	return ok
 }
 
 // IsRegistered can be used to find out whether a named
 // mechanism is registered or not
-func IsRegistered(name string) bool {
-	_, ok := mechs[name]
+func (r *Registry) IsRegistered(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.mechs[name]
 
 	return ok
 }
 
 // NewMech returns a mechanism context by name
-func NewMech(name string, cfg common.MechConfig) common.Mech {
-	m, ok := mechs[name]
+func (r *Registry) NewMech(name string, cfg common.MechConfig) common.Mech {
+	r.mu.RLock()
+	m, ok := r.mechs[name]
+	r.mu.RUnlock()
 
+	// the factory is called without the lock so that it may use the registry
 	if ok {
 		return m.factory(cfg)
 	}
@@ -64,8 +137,11 @@ func NewMech(name string, cfg common.MechConfig) common.Mech {
 	return nil
 }
 
-func Properties(name string) common.MechProps {
-	m, ok := mechs[name]
+func (r *Registry) Properties(name string) common.MechProps {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.mechs[name]
 
 	if ok {
 		return m.properties
@@ -74,13 +150,63 @@ func Properties(name string) common.MechProps {
 	return common.MechProps{}
 }
 
-// Mechs returns the list of registered mechanism names
-func Mechs() (l []string) {
-	l = make([]string, 0, len(mechs))
+// Mechs returns the names of the registered mechanisms in sorted order, so
+// that clients configured the same way list them the same way
+func (r *Registry) Mechs() (l []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	l = make([]string, 0, len(r.mechs))
 
-	for name := range mechs {
+	for name := range r.mechs {
 		l = append(l, name)
 	}
+	sort.Strings(l)
 
 	return
 }
+
+// Register adds a mechanism to the default registry
+func Register(name string, f MechFactory, props common.MechProps) error {
+	return defaultRegistry.Register(name, f, props)
+}
+
+// MustRegister should be called by Mech implementations from an init function
+// to enable a mechanism to be used by clients
+func MustRegister(name string, f MechFactory, props common.MechProps) {
+	defaultRegistry.MustRegister(name, f, props)
+}
+
+// Replace adds or replaces a mechanism in the default registry
+func Replace(name string, f MechFactory, props common.MechProps) error {
+	return defaultRegistry.Replace(name, f, props)
+}
+
+// MustReplace is like Replace but panics on error
+func MustReplace(name string, f MechFactory, props common.MechProps) {
+	defaultRegistry.MustReplace(name, f, props)
+}
+
+// Unregister removes a mechanism from the default registry
+func Unregister(name string) bool {
+	return defaultRegistry.Unregister(name)
+}
+
+// IsRegistered reports whether a mechanism is in the default registry
+func IsRegistered(name string) bool {
+	return defaultRegistry.IsRegistered(name)
+}
+
+// NewMech returns a mechanism context from the default registry
+func NewMech(name string, cfg common.MechConfig) common.Mech {
+	return defaultRegistry.NewMech(name, cfg)
+}
+
+func Properties(name string) common.MechProps {
+	return defaultRegistry.Properties(name)
+}
+
+// Mechs returns the names of the mechanisms in the default registry
+func Mechs() []string {
+	return defaultRegistry.Mechs()
+}
@@ -4,6 +4,8 @@
 package registry
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/golang-auth/go-sasl/common"
@@ -23,8 +25,8 @@ func (m dummyMech) MechProperties() common.MechProps {
 func (m dummyMech) IsEstablished() bool {
 	return false
 }
-func (m dummyMech) Step(inToken []byte) (outToken []byte, err error) {
-	return nil, nil
+func (m dummyMech) Step(inToken []byte) (outToken []byte, status common.StepStatus, err error) {
+	return nil, common.StepContinue, nil
 }
 func (m dummyMech) ContextParams() common.ContextParams {
 	return common.ContextParams{}
@@ -35,6 +37,9 @@ func (m dummyMech) Encode([]byte) ([]byte, error) {
 func (m dummyMech) Decode([]byte) ([]byte, error) {
 	return nil, nil
 }
+func (m dummyMech) Close() error {
+	return nil
+}
 
 func TestRegister(t *testing.T) {
 	mf := func(common.MechConfig) common.Mech {
@@ -42,13 +47,61 @@ func TestRegister(t *testing.T) {
 	}
 	props := common.MechProps{}
 
-	assert.NotPanics(t, func() { Register("TEST", mf, props) })
+	assert.NoError(t, Register("TEST", mf, props))
+
+	// fails because its already registered
+	assert.ErrorIs(t, Register("TEST", mf, props), ErrAlreadyRegistered)
+	assert.Panics(t, func() { MustRegister("TEST", mf, props) })
+
+	// fails because the mech name isn't valid (lower case not allowed)
+	assert.ErrorIs(t, Register("bad-mech-name", mf, props), ErrBadMechName)
+	assert.Panics(t, func() { MustRegister("bad-mech-name", mf, props) })
+}
+
+func TestReplace(t *testing.T) {
+	mf := func(rand int) MechFactory {
+		return func(common.MechConfig) common.Mech {
+			return dummyMech{rand: rand}
+		}
+	}
+
+	r := New()
+	assert.NoError(t, r.Replace("TEST9", mf(1), common.MechProps{}))
+	assert.NoError(t, r.Replace("TEST9", mf(2), common.MechProps{MaxSSF: 1}))
+	assert.Equal(t, 2, r.NewMech("TEST9", common.MechConfig{}).(dummyMech).rand)
+	assert.Equal(t, uint(1), r.Properties("TEST9").MaxSSF)
+	assert.NotPanics(t, func() { r.MustReplace("TEST9", mf(3), common.MechProps{}) })
+	assert.Panics(t, func() { r.MustReplace("bad-mech-name", mf(3), common.MechProps{}) })
+
+	assert.True(t, r.Unregister("TEST9"))
+	assert.False(t, r.IsRegistered("TEST9"))
+	assert.False(t, r.Unregister("TEST9"))
+
+	// the name can be reused once unregistered
+	assert.NoError(t, r.Register("TEST9", mf(4), common.MechProps{}))
+}
 
-	// panics because its already registered
-	assert.Panics(t, func() { Register("TEST", mf, props) })
+func TestConcurrentRegistry(t *testing.T) {
+	mf := func(common.MechConfig) common.Mech {
+		return dummyMech{}
+	}
 
-	// panics because the mech name isn't valid (lower case not allowed)
-	assert.Panics(t, func() { Register("bad-mech-name", mf, props) })
+	r := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("PLUGIN%d", i)
+			assert.NoError(t, r.Register(name, mf, common.MechProps{}))
+			assert.True(t, r.IsRegistered(name))
+			_ = r.Mechs()
+			assert.NotNil(t, r.NewMech(name, common.MechConfig{}))
+			assert.True(t, r.Unregister(name))
+		}(i)
+	}
+	wg.Wait()
+	assert.Empty(t, r.Mechs())
 }
 
 func TestIsRegistered(t *testing.T) {
@@ -57,25 +110,49 @@ func TestIsRegistered(t *testing.T) {
 	}
 	props := common.MechProps{}
 
-	assert.NotPanics(t, func() { Register("TEST1", mf, props) })
+	assert.NoError(t, Register("TEST1", mf, props))
 	assert.True(t, IsRegistered("TEST1"))
 	assert.False(t, IsRegistered("NEVER_REGISTERED"))
 }
 
 func TestMechs(t *testing.T) {
 	// start with empty mech list
-	mechs = make(map[string]mech)
+	r := New()
 
 	mf := func(common.MechConfig) common.Mech {
 		return dummyMech{rand: 789}
 	}
 	props := common.MechProps{}
 
-	assert.NotPanics(t, func() { Register("TEST2", mf, props) })
-	assert.NotPanics(t, func() { Register("TEST3", mf, props) })
+	assert.NoError(t, r.Register("TEST3", mf, props))
+	assert.NoError(t, r.Register("TEST2", mf, props))
+	assert.NoError(t, r.Register("TEST10", mf, props))
+
+	names := r.Mechs()
+	assert.Equal(t, []string{"TEST10", "TEST2", "TEST3"}, names)
+}
+
+func TestRegistryInstances(t *testing.T) {
+	mf := func(common.MechConfig) common.Mech {
+		return dummyMech{rand: 1}
+	}
+
+	r1, r2 := New(), New()
+	assert.NoError(t, r1.Register("TEST7", mf, common.MechProps{MaxSSF: 56}))
+
+	// registries are independent of each other and of the default
+	assert.True(t, r1.IsRegistered("TEST7"))
+	assert.False(t, r2.IsRegistered("TEST7"))
+	assert.False(t, IsRegistered("TEST7"))
+	assert.Equal(t, uint(56), r1.Properties("TEST7").MaxSSF)
+	assert.NotNil(t, r1.NewMech("TEST7", common.MechConfig{}))
+	assert.Nil(t, r2.NewMech("TEST7", common.MechConfig{}))
+
+	// the same name can be registered in different registries
+	assert.NoError(t, r2.Register("TEST7", mf, common.MechProps{}))
 
-	names := Mechs()
-	assert.Equal(t, []string{"TEST2", "TEST3"}, names)
+	assert.NoError(t, Register("TEST8", mf, common.MechProps{}))
+	assert.True(t, Default().IsRegistered("TEST8"))
 }
 
 func TestNewMech(t *testing.T) {
@@ -87,8 +164,8 @@ func TestNewMech(t *testing.T) {
 	}
 	props := common.MechProps{}
 
-	assert.NotPanics(t, func() { Register("TEST5", mf1, props) })
-	assert.NotPanics(t, func() { Register("TEST6", mf2, props) })
+	assert.NoError(t, Register("TEST5", mf1, props))
+	assert.NoError(t, Register("TEST6", mf2, props))
 
 	mech1 := NewMech("TEST5", common.MechConfig{})
 	mech2 := NewMech("TEST6", common.MechConfig{})
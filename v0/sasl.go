@@ -4,45 +4,82 @@
 package sasl
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/golang-auth/go-sasl/common"
 	"github.com/golang-auth/go-sasl/pkg/loggable"
 	"github.com/golang-auth/go-sasl/registry"
-
-	_ "github.com/golang-auth/go-sasl/gssapi"
 )
 
 type SaslClientOption func(*SaslClient) error
 
+// SaslClient runs one authentication exchange at a time.  Its configuration is
+// fixed by NewSaslClient, but Start, Step and ChooseMech change the state of the
+// exchange, so a client (or a copy of it) must not be used by more than one
+// goroutine at once;  Start and Step return common.ErrConcurrentUse if it is.
+// Use Clone to get an independent client for each connection.
 type SaslClient struct {
 	loggable.Loggable
 
-	mech common.Mech
+	mech     common.Mech
+	registry *registry.Registry
+	scorer   MechScorer
+	fallback bool
+
+	// mechs advertised by the server, set by ChooseMech
+	serverMechs map[string]bool
 
 	service         string
 	mechList        []string
 	serverFQDN      string
+	serverIsIP      bool
+	allowIPLiteral  bool
+	realm           string
 	minSSF          uint
 	maxSSF          uint
 	maxBufSize      uint // max the client can receive
+	replayDetect    bool
 	secProps        common.SecurityFlag
 	extProps        externalProperties
 	needHTTP        bool
 	channelBindings *common.ChannelBinding
 	extraProps      map[string]string
+	mechOptions     map[string]common.MechOptions
+	auditSinks      []common.AuditSink
+	metrics         common.Metrics
+	tracer          common.Tracer
+	transcript      *Transcript
+	ignoreEnv       bool
+	mechPolicy      *MechPolicy
+	preparation     map[string]common.Preparation // by mech, "" for the default
+	prompter        SaslPrompt
+	promptHandlers  common.PromptHandlers
+	password        *common.Secret
+	tokenSource     common.TokenSource
+	reauthCache     common.ReauthCache
+	startTime       time.Time
+	steps           int
+	failed          bool
+
+	// shared by copies of the client, to detect concurrent use
+	busy *int32
 }
 
 type externalProperties struct {
-	ssf uint
-	//	authID string
+	ssf    uint
+	authID string
 }
 
-type SaslPrompt interface {
-}
+// SaslPrompt is implemented by applications to answer prompts from mechanisms
+type SaslPrompt = common.SaslPrompt
 
 type channelBindingDisposition int
 
@@ -54,11 +91,15 @@ const (
 
 func NewSaslClient(service string, opts ...SaslClientOption) (client SaslClient, err error) {
 	client = SaslClient{
-		service:    service,
-		secProps:   common.SecNoAnonymous | common.SecNoPlainText,
-		maxBufSize: 65536,
-		maxSSF:     ^uint(0),
-		extraProps: make(map[string]string),
+		service:        service,
+		secProps:       common.SecNoAnonymous | common.SecNoPlainText,
+		maxBufSize:     65536,
+		maxSSF:         ^uint(0),
+		extraProps:     make(map[string]string),
+		mechOptions:    make(map[string]common.MechOptions),
+		promptHandlers: make(common.PromptHandlers),
+		registry:       registry.Default(),
+		busy:           new(int32),
 	}
 
 	for _, o := range opts {
@@ -67,12 +108,21 @@ func NewSaslClient(service string, opts ...SaslClientOption) (client SaslClient,
 		}
 	}
 
+	if err = client.applyEnv(); err != nil {
+		return
+	}
+
+	if client.serverIsIP && !client.allowIPLiteral {
+		err = fmt.Errorf("server %s is an IP address, see WithIPLiteralServer: %w", client.serverFQDN, common.ErrBadConfig)
+		return
+	}
+
 	if len(client.mechList) > 0 {
 		// trim the mech list to only those that are registered
 		var newMechList []string
 
 		for _, name := range client.mechList {
-			if registry.IsRegistered(name) {
+			if client.registry.IsRegistered(name) {
 				newMechList = append(newMechList, name)
 			}
 		}
@@ -81,7 +131,7 @@ func NewSaslClient(service string, opts ...SaslClientOption) (client SaslClient,
 		client.Debugf("using specified registered mechs: [%s]", strings.Join(client.mechList, ", "))
 	} else {
 		// default to all registered mechs
-		client.mechList = registry.Mechs()
+		client.mechList = client.registry.Mechs()
 		client.Debugf("using all registered mechs: [%s]", strings.Join(client.mechList, ", "))
 	}
 
@@ -94,20 +144,119 @@ func NewSaslClient(service string, opts ...SaslClientOption) (client SaslClient,
 
 var validHostnameRegex = regexp.MustCompile(`^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\-]*[a-zA-Z0-9])\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\-]*[A-Za-z0-9])$`)
 
+// WithServerFQDN sets the server's host name.  Internationalized names are
+// converted to their A-label form, eg. "xn--bcher-kva.example", which mechs use
+// in service principal names.
 func WithServerFQDN(fqdn string) SaslClientOption {
 	return func(c *SaslClient) error {
 		if fqdn != "" {
+			if ip := parseIPLiteral(fqdn); ip != nil {
+				c.serverFQDN = ip.String()
+				c.serverIsIP = true
+				return nil
+			}
+
+			fqdn, err := common.HostnameToASCII(fqdn)
+			if err != nil {
+				return err
+			}
+
 			if !validHostnameRegex.Match([]byte(fqdn)) {
 				return errors.New("bad hostname")
 			}
 
 			c.serverFQDN = fqdn
+			c.serverIsIP = false
+		}
+
+		return nil
+	}
+}
+
+// WithIPLiteralServer allows WithServerFQDN to be given an IPv4 or IPv6
+// address, for environments without DNS.  Mechs see the address in its
+// canonical form, with MechConfig.ServerIsIP set:
+//
+//   - GSSAPI uses it in the host-based service name, eg. imap/192.0.2.1, which
+//     the KDC must have as a principal name
+//   - SCRAM and other mechs that don't name the server are unaffected
+//   - tls-server-end-point bindings come from the server's certificate, so they
+//     work regardless of how the server is named
+func WithIPLiteralServer() SaslClientOption {
+	return func(c *SaslClient) error {
+		c.allowIPLiteral = true
+		return nil
+	}
+}
+
+// parseIPLiteral returns the address in s, which may be enclosed in brackets,
+// or nil if it isn't an IP address
+func parseIPLiteral(s string) net.IP {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+
+	return net.ParseIP(s)
+}
+
+// WithRealm sets the realm to authenticate in, for mechs that use realms
+func WithRealm(realm string) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.realm = realm
+		return nil
+	}
+}
+
+// WithRealmCallback sets a function used to choose the realm when the server
+// offers realms that don't include the one set with WithRealm.  The function
+// is passed the offered realms and should return one of them.
+func WithRealmCallback(f func(offered []string) (string, error)) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.promptHandlers[common.PromptRealm] = func(p common.Prompt) ([]byte, error) {
+			realm, err := f(p.Choices)
+			return []byte(realm), err
 		}
+		return nil
+	}
+}
 
+// WithRegistry makes the client choose mechanisms from r instead of the default
+// registry
+func WithRegistry(r *registry.Registry) SaslClientOption {
+	return func(c *SaslClient) error {
+		if r == nil {
+			return errors.New("nil registry")
+		}
+		c.registry = r
 		return nil
 	}
 }
 
+// WithMechanisms makes the client choose from exactly mechs, in a registry of
+// its own, whatever has been registered by imported packages.  Like
+// WithRegistry, the last of the two options wins.
+//
+//	sasl.NewSaslClient("imap", sasl.WithMechanisms(gssapi.Mechanism()))
+func WithMechanisms(mechs ...registry.Mechanism) SaslClientOption {
+	return func(c *SaslClient) error {
+		r := registry.New()
+		if err := r.Enable(mechs...); err != nil {
+			return err
+		}
+		c.registry = r
+		return nil
+	}
+}
+
+// Enable adds mechs to the default registry, replacing any with the same
+// names.  It is an alternative to importing mech packages for their side
+// effects, eg.
+//
+//	sasl.Enable(gssapi.Mechanism(), oauth.BearerMechanism())
+func Enable(mechs ...registry.Mechanism) error {
+	return registry.Enable(mechs...)
+}
+
 func WithMechList(mechs []string) SaslClientOption {
 	return func(c *SaslClient) error {
 		if len(mechs) > 0 {
@@ -139,8 +288,13 @@ func WithNeedHTTP() SaslClientOption {
 	}
 }
 
+// WithChannelBindings binds the authentication to an outer channel.  Only
+// mechanisms that accept the binding type are used if it is critical.
 func WithChannelBindings(cb common.ChannelBinding) SaslClientOption {
 	return func(c *SaslClient) error {
+		if err := cb.Validate(); err != nil {
+			return err
+		}
 		c.channelBindings = &cb
 		return nil
 	}
@@ -153,6 +307,22 @@ func WithMaxBufSize(size uint) SaslClientOption {
 	}
 }
 
+// WithReplayDetection requires a negotiated security layer to detect replayed
+// and reordered tokens, in which case Decode fails with common.ErrReplayedToken
+// or common.ErrOutOfSequence.  Authentication fails if the mech can't provide it.
+func WithReplayDetection() SaslClientOption {
+	return func(c *SaslClient) error {
+		c.replayDetect = true
+		return nil
+	}
+}
+
+// MaxBufSize returns the size of the largest security layer token that the
+// client accepts, as advertised to the server
+func (c SaslClient) MaxBufSize() uint {
+	return c.maxBufSize
+}
+
 func WithSecurityProps(props common.SecurityFlag) SaslClientOption {
 	return func(c *SaslClient) error {
 		c.secProps = props & (common.SecNoPlainText | common.SecNoActive | common.SecNoDictionary | common.SecForwardSecrecy | common.SecNoAnonymous | common.SecPassCredentials | common.SecMutualAuth)
@@ -160,6 +330,51 @@ func WithSecurityProps(props common.SecurityFlag) SaslClientOption {
 	}
 }
 
+// WithExternalSSF declares the strength of a security layer provided outside of
+// SASL, eg. 256 for a TLS connection using AES-256.  It counts towards the
+// minimum SSF and lets mechs that need a protected channel be chosen.
+func WithExternalSSF(ssf uint) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.extProps.ssf = ssf
+		return nil
+	}
+}
+
+// WithExternalAuthID sets the identity established by the external layer, eg.
+// the subject of the TLS client certificate
+func WithExternalAuthID(authID string) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.extProps.authID = authID
+		return nil
+	}
+}
+
+// WithMechOptions sets the typed options of a mechanism, replacing any set
+// earlier.  They are validated by Start.
+func WithMechOptions(opts common.MechOptions) SaslClientOption {
+	return func(c *SaslClient) error {
+		if opts == nil {
+			return errors.New("nil mech options")
+		}
+		c.mechOptions[opts.Mech()] = opts
+		return nil
+	}
+}
+
+func (c SaslClient) validateMechOptions() error {
+	for name, opts := range c.mechOptions {
+		if !c.registry.IsRegistered(name) {
+			return fmt.Errorf("options for unknown mech %s", name)
+		}
+
+		if err := opts.Validate(); err != nil {
+			return fmt.Errorf("%s options: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
 func WithExtraProps(key, value string) SaslClientOption {
 	return func(c *SaslClient) error {
 		c.extraProps[key] = value
@@ -167,6 +382,146 @@ func WithExtraProps(key, value string) SaslClientOption {
 	}
 }
 
+// WithPrompter sets the prompter used to answer any prompt that doesn't have
+// a handler set with WithPromptHandler
+func WithPrompter(p SaslPrompt) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.prompter = p
+		return nil
+	}
+}
+
+// WithPromptHandler sets the function used to answer one type of prompt
+func WithPromptHandler(t common.PromptType, f common.PromptFunc) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.promptHandlers[t] = f
+		return nil
+	}
+}
+
+// WithPassword sets the password used by mechs that need one.  The client keeps
+// its own copy, so the caller can zero password once the option has been applied.
+// WithPassword replaces any password prompt handler.
+func WithPassword(password []byte) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.setPassword(common.NewSecret(password))
+		return nil
+	}
+}
+
+func (c *SaslClient) setPassword(secret *common.Secret) {
+	c.password = secret
+	c.promptHandlers[common.PromptPassword] = func(common.Prompt) ([]byte, error) {
+		return secret.Bytes(), nil
+	}
+}
+
+// WithPasswordCallback sets a function that supplies the password.  It is only
+// called if the chosen mech needs a password, every time it needs one.
+// WithPasswordCallback replaces any password prompt handler.
+func WithPasswordCallback(f func() ([]byte, error)) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.password = nil
+		c.promptHandlers[common.PromptPassword] = func(common.Prompt) ([]byte, error) {
+			return f()
+		}
+		return nil
+	}
+}
+
+// WithTokenSource sets the source of OAuth access tokens for mechs such as
+// OAUTHBEARER.  Tokens are only requested if such a mech is chosen.
+func WithTokenSource(ts common.TokenSource) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.tokenSource = ts
+		return nil
+	}
+}
+
+// Prompt implements SaslPrompt by dispatching to the handler for the prompt type,
+// or to the prompter if there isn't one
+func (c SaslClient) Prompt(p common.Prompt) ([]byte, error) {
+	if f, ok := c.promptHandlers[p.Type]; ok && f != nil {
+		return f(p)
+	}
+
+	if c.prompter != nil {
+		return c.prompter.Prompt(p)
+	}
+
+	return nil, fmt.Errorf("%s: %w", p.Type, common.ErrNoPromptHandler)
+}
+
+// WithAuditSink adds a sink that receives an audit event when an authentication
+// exchange succeeds or fails.  The option can be used more than once.
+func WithAuditSink(sink common.AuditSink) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.auditSinks = append(c.auditSinks, sink)
+		return nil
+	}
+}
+
+// WithReauthCache lets mechanisms keep state from successful exchanges in cache
+// so that they can authenticate to the same server again more quickly.  A cache
+// can be shared by clients acting for the same user.
+func WithReauthCache(cache common.ReauthCache) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.reauthCache = cache
+		return nil
+	}
+}
+
+// WithPreparation sets the profiles that mechs apply to usernames and
+// passwords, eg. common.PrecisPreparation for servers that use PRECIS.  If mechs
+// are named the profiles are used only for them.  The default is SASLprep.
+func WithPreparation(p common.Preparation, mechs ...string) SaslClientOption {
+	return func(c *SaslClient) error {
+		if c.preparation == nil {
+			c.preparation = make(map[string]common.Preparation)
+		}
+		if len(mechs) == 0 {
+			c.preparation[""] = p
+		}
+		for _, mech := range mechs {
+			c.preparation[strings.ToUpper(mech)] = p
+		}
+		return nil
+	}
+}
+
+func (c SaslClient) mechPreparation(mech string) common.Preparation {
+	if p, ok := c.preparation[mech]; ok {
+		return p
+	}
+	return c.preparation[""]
+}
+
+// WithMetrics sends handshake, selection and security layer instrumentation
+// to m, see the metrics package for an expvar implementation
+func WithMetrics(m common.Metrics) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.metrics = m
+		return nil
+	}
+}
+
+// WithLogger sends the client's and mechanisms' log messages to l, eg. a zap
+// SugaredLogger or an adapter for another logging library
+func WithLogger(l loggable.Logger) SaslClientOption {
+	return func(c *SaslClient) error {
+		return loggable.WithLogger(l)(&c.Loggable)
+	}
+}
+
+// WithUnsafeTokenLogging logs the full contents of tokens in hex, rather than
+// their length and hash.  Tokens can contain credentials, so this is only for
+// debugging in a lab.
+func WithUnsafeTokenLogging() SaslClientOption {
+	return func(c *SaslClient) error {
+		return loggable.WithUnsafeTokenLogging()(&c.Loggable)
+	}
+}
+
 func WithDebugLogger(l *log.Logger) SaslClientOption {
 	return func(c *SaslClient) error {
 		return loggable.WithDebugLogger(l)(&c.Loggable)
@@ -188,6 +543,94 @@ func WithErrorLogger(l *log.Logger) SaslClientOption {
 	}
 }
 
+type canonicalMech struct {
+	Name       string           `json:"name"`
+	Properties common.MechProps `json:"properties"`
+}
+
+type canonicalChannelBinding struct {
+	Name       string `json:"name"`
+	Critical   bool   `json:"critical"`
+	TLSVersion uint16 `json:"tls_version,omitempty"`
+}
+
+// field order is significant: it defines the canonical form
+type canonicalConfig struct {
+	Service         string                        `json:"service"`
+	ServerFQDN      string                        `json:"server_fqdn"`
+	Realm           string                        `json:"realm"`
+	Mechs           []canonicalMech               `json:"mechs"`
+	MinSSF          uint                          `json:"min_ssf"`
+	MaxSSF          uint                          `json:"max_ssf"`
+	MaxBufSize      uint                          `json:"max_buf_size"`
+	ReplayDetect    bool                          `json:"replay_detection"`
+	SecProps        common.SecurityFlag           `json:"security_properties"`
+	ExternalSSF     uint                          `json:"external_ssf"`
+	ExternalAuthID  string                        `json:"external_authid"`
+	NeedHTTP        bool                          `json:"need_http"`
+	ChannelBindings *canonicalChannelBinding      `json:"channel_bindings"`
+	ExtraProps      map[string]string             `json:"extra_props"`
+	MechOptions     map[string]common.MechOptions `json:"mech_options"`
+	MechPolicy      []string                      `json:"mech_policy,omitempty"`
+}
+
+// CanonicalConfig returns a JSON serialization of the resolved client
+// configuration, including the properties of each usable mechanism in
+// preference order.  The field order is stable and map keys are sorted, so
+// identically configured clients produce identical output.  Channel binding
+// data is specific to a connection and is not included.  It fails if mech
+// options set with WithMechOptions can't be serialized as JSON.
+func (c SaslClient) CanonicalConfig() ([]byte, error) {
+	cfg := canonicalConfig{
+		Service:        c.service,
+		ServerFQDN:     c.serverFQDN,
+		Realm:          c.realm,
+		Mechs:          make([]canonicalMech, 0, len(c.mechList)),
+		MinSSF:         c.minSSF,
+		MaxSSF:         c.maxSSF,
+		MaxBufSize:     c.maxBufSize,
+		ReplayDetect:   c.replayDetect,
+		SecProps:       c.secProps,
+		ExternalSSF:    c.extProps.ssf,
+		ExternalAuthID: c.extProps.authID,
+		NeedHTTP:       c.needHTTP,
+		ExtraProps:     c.extraProps,
+		MechOptions:    c.mechOptions,
+	}
+
+	for _, name := range c.rankedMechs() {
+		cfg.Mechs = append(cfg.Mechs, canonicalMech{Name: name, Properties: c.registry.Properties(name)})
+	}
+
+	if c.mechPolicy != nil {
+		cfg.MechPolicy = c.mechPolicy.Patterns()
+	}
+
+	if c.channelBindings != nil {
+		cfg.ChannelBindings = &canonicalChannelBinding{
+			Name:       c.channelBindings.Name,
+			Critical:   c.channelBindings.Critical,
+			TLSVersion: c.channelBindings.TLSVersion,
+		}
+	}
+
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("can't serialize the configuration: %w", err)
+	}
+	return b, nil
+}
+
+// ConfigHash returns a digest of the canonical client configuration, which can be
+// compared between instances to detect configuration drift
+func (c SaslClient) ConfigHash() (string, error) {
+	b, err := c.CanonicalConfig()
+	if err != nil {
+		return "", err
+	}
+	return common.CanonicalHash(b), nil
+}
+
 func (c SaslClient) IsEstablished() bool {
 	if c.mech != nil {
 		return c.mech.IsEstablished()
@@ -196,110 +639,322 @@ func (c SaslClient) IsEstablished() bool {
 	}
 }
 
-func (c *SaslClient) Start() (outToken []byte, err error) {
-	c.mech = nil
-
-	// how much 'extra ssf' do we need if we take the external layer into account?
-	var minSSF uint
-	if c.minSSF < c.extProps.ssf {
-		minSSF = 0
-	} else {
-		minSSF = c.minSSF - c.extProps.ssf
-	}
+// Start chooses a mechanism and returns its name, which the application sends to
+// the server along with the initial response.  A nil initial response means the
+// mechanism waits for the server to go first;  a non-nil (possibly empty) one
+// should be sent to the server.
+func (c *SaslClient) Start() (mech string, initialResponse []byte, err error) {
+	return c.StartContext(context.Background())
+}
 
-	cbDisposition, err := c.channelBindingDisposition()
-	if err != nil {
-		return nil, err
+// StartContext is like Start, but ctx bounds the handshake: it is passed to the
+// mechanism in MechConfig and cancels this and later steps, see StepContext.
+func (c *SaslClient) StartContext(ctx context.Context) (mech string, initialResponse []byte, err error) {
+	if !c.acquire() {
+		return "", nil, common.ErrConcurrentUse
 	}
-
-	// find the first mech that matches the security requirements
-	var chosenMech string
-	for _, mech := range c.mechList {
-		mechProps := registry.Properties(mech)
-
-		// discard if the mech does not meet the min SSF requirement
-		if minSSF > mechProps.MaxSSF {
-			c.Debugf("mech %s max SSF (%d) too low (want %d)", mech, mechProps.MaxSSF, minSSF)
-			continue
+	defer c.release()
+
+	ctx, span := c.startSpan(ctx, "sasl.Start", common.Attribute{Key: common.AttrService, Value: c.service})
+	defer func() {
+		outcome := "continue"
+		if mech != "" {
+			span.SetAttributes(common.Attribute{Key: common.AttrMech, Value: mech})
+			if c.IsEstablished() {
+				outcome = "success"
+			}
 		}
+		endSpan(span, outcome, err)
+	}()
 
-		wantSecProps := c.secProps
-		if (c.extProps.ssf > c.minSSF) && (c.extProps.ssf > 1) {
-			c.Debugf("mech %s (max SSF %d) upgraded to non-plaintext (external SSF: %d)", mech, mechProps.MaxSSF, c.extProps.ssf)
-			wantSecProps &^= common.SecNoPlainText
+	c.closeMech()
+	c.startTime = time.Now()
+	if c.transcript != nil {
+		c.transcript.reset(c.service, c.startTime)
+	}
+	defer func() {
+		c.failed = err != nil
+	}()
+	if c.DebugEnabled() {
+		if hash, err := c.ConfigHash(); err == nil {
+			c.Debugf("config hash: %s", hash)
 		}
+	}
 
-		// does mech meet security requirements?
-		if ((wantSecProps ^ mechProps.SecurityProperties) & wantSecProps) != 0 {
-			c.Debugf("mech %s does not meet security requirements", mech)
-			continue
-		}
+	if err = ctx.Err(); err != nil {
+		c.finish(err)
+		return "", nil, err
+	}
 
-		// does our configuration meet the mech's feature requirements?
+	if err = c.validateMechOptions(); err != nil {
+		c.finish(err)
+		return "", nil, err
+	}
 
-		if cbDisposition == channelBindingDispMust && (mechProps.Fearures&common.FeatChannelBindings == 0) {
-			c.Debugf("mech %s does not support channel bindings", mech)
-			continue
-		}
+	mechs, rejected, err := c.eligibleMechs()
+	c.recordRejected(rejected)
+	if err != nil {
+		c.finish(err)
+		return "", nil, err
+	}
 
-		if (mechProps.Fearures&common.FeatNeedServerFQDN != 0) && c.serverFQDN == "" {
-			c.Debugf("mech %s requires server FQDN", mech)
-			continue
-		}
+	if !c.fallback {
+		initialResponse, err = c.startMech(ctx, mechs[0])
+		return mechs[0], initialResponse, err
+	}
 
-		// do the mech's features cover the required features?
-		if c.needHTTP && (mechProps.Fearures&common.FeatSupportsHTTP == 0) {
-			c.Debugf("mech %s does not support HTTP", mech)
-			continue
+	var failures FallbackError
+	for _, name := range mechs {
+		initialResponse, err = c.startMech(ctx, name)
+		if err == nil || ctx.Err() != nil {
+			return name, initialResponse, err
 		}
 
-		// this looks like a good fit..
-		chosenMech = mech
-		break
+		c.Debugf("mech %s failed, falling back: %s", name, err)
+		failures = append(failures, MechError{Mech: name, Err: err})
 	}
 
-	if chosenMech == "" {
-		return nil, common.ErrNoMech
-	}
+	return "", nil, failures
+}
 
+// startMech creates an instance of the chosen mech and runs its first step
+func (c *SaslClient) startMech(ctx context.Context, chosenMech string) (initialResponse []byte, err error) {
 	c.Debugf("Chose mech %s", chosenMech)
 
 	// Create an instance of the chosen mech
 	cfg := common.MechConfig{
-		Logger:         c.Loggable,
+		Context:        ctx,
+		Logger:         c.Loggable.With("mech", chosenMech),
 		Service:        c.service,
 		ServerFQDN:     c.serverFQDN,
+		ServerIsIP:     c.serverIsIP,
+		Realm:          c.realm,
 		MinSSF:         c.minSSF,
 		MaxSSF:         c.maxSSF,
 		MaxBufSize:     c.maxBufSize,
+		ReplayDetect:   c.replayDetect,
 		ExternalSSF:    c.extProps.ssf,
+		ExternalAuthID: c.extProps.authID,
 		SecProps:       c.secProps,
 		HTTPMode:       c.needHTTP,
 		ExtraProps:     c.extraProps,
+		Options:        c.mechOptions[chosenMech],
 		ChannelBinding: c.channelBindings,
+		CBFlag:         c.channelBindingFlag(chosenMech),
+		Prompter:       c,
+		TokenSource:    c.tokenSource,
+		ReauthCache:    c.reauthCache,
+		Preparation:    c.mechPreparation(chosenMech),
+	}
+	c.closeMech()
+	c.mech = c.registry.NewMech(chosenMech, cfg)
+	c.steps = 0
+	if c.transcript != nil {
+		// only the last mech tried by a fallback is recorded
+		c.transcript.reset(c.service, c.startTime)
+		c.transcript.Mech = chosenMech
+	}
+	if c.metrics != nil {
+		c.metrics.HandshakeStarted(chosenMech)
 	}
-	c.mech = registry.NewMech(chosenMech, cfg)
 
 	// Don't return a token if the mech wants the server to go first
-	mechProps := c.mech.MechProperties()
+	mechProps := c.registry.Properties(chosenMech)
 	if mechProps.Fearures&common.FeatServerFirst != 0 {
 		return nil, nil
 	}
 
 	// otherwise execute the first step
-	return c.Step(nil)
+	initialResponse, _, err = c.stepContext(ctx, nil)
+	if err == nil && initialResponse == nil {
+		initialResponse = []byte{}
+	}
+
+	return initialResponse, err
+}
+
+// Step processes a message from the server and returns the response to send to
+// it.  The status says whether the client expects more messages from the server.
+func (c *SaslClient) Step(inToken []byte) (outToken []byte, status common.StepStatus, err error) {
+	return c.StepContext(context.Background(), inToken)
+}
+
+// StepContext is like Step but returns ctx.Err() as soon as ctx is done, even if
+// the mechanism is blocked acquiring credentials or waiting for a prompt.  A
+// cancelled handshake can't be resumed:  the mechanism is abandoned and the
+// application must call Start again.
+func (c *SaslClient) StepContext(ctx context.Context, inToken []byte) (outToken []byte, status common.StepStatus, err error) {
+	if !c.acquire() {
+		return nil, common.StepContinue, common.ErrConcurrentUse
+	}
+	defer c.release()
+
+	return c.stepContext(ctx, inToken)
 }
 
-func (c *SaslClient) Step(inToken []byte) (outToken []byte, err error) {
+func (c *SaslClient) stepContext(ctx context.Context, inToken []byte) (outToken []byte, status common.StepStatus, err error) {
 	if c.mech == nil {
-		return nil, common.ErrNotStarted
+		return nil, common.StepContinue, common.ErrNotStarted
 	}
 
 	if c.IsEstablished() {
-		return nil, common.ErrAlreadyEstablished
+		return nil, common.StepDone, common.ErrAlreadyEstablished
+	}
+
+	c.steps++
+	if c.transcript != nil && inToken != nil {
+		c.transcript.record(DirectionReceived, c.steps, inToken)
+	}
+	sctx, span := c.startSpan(ctx, "sasl.Step",
+		common.Attribute{Key: common.AttrMech, Value: c.mech.Name()},
+		common.Attribute{Key: common.AttrStep, Value: c.steps})
+	outToken, status, abandoned, err := c.step(sctx, inToken)
+	if c.transcript != nil && err == nil && outToken != nil {
+		c.transcript.record(DirectionSent, c.steps, outToken)
+	}
+	outcome := "continue"
+	switch {
+	case err != nil:
+		c.failed = true
+		c.finish(err)
+	case status != common.StepContinue:
+		outcome = "success"
+		span.SetAttributes(common.Attribute{Key: common.AttrSSF, Value: int(c.mech.ContextParams().SSF)})
+		c.finish(nil)
+	}
+	endSpan(span, outcome, err)
+	c.Debugf("step %d: received %v, sending %v", c.steps, c.Token(inToken), c.Token(outToken))
+	c.logStep(err)
+
+	// a cancelled mech must not be used again.  One that is still running is
+	// closed by step when it returns.
+	switch {
+	case abandoned:
+		c.mech = nil
+	case err != nil && err == ctx.Err():
+		if cerr := c.closeMech(); cerr != nil {
+			c.Debugf("closing cancelled mech: %s", cerr)
+		}
+	}
+
+	return outToken, status, err
+}
+
+// logStep logs the outcome of a step with structured attributes
+func (c *SaslClient) logStep(err error) {
+	state, steps := c.State()
+	l := c.Loggable.With("step", steps, "state", state.String())
+	if c.mech != nil {
+		l = l.With("mech", c.mech.Name())
+	}
+
+	switch {
+	case err != nil:
+		l = l.With("error", err)
+		l.Debugf("step failed: %s", err)
+	case state == StateEstablished:
+		l = l.With("ssf", c.mech.ContextParams().SSF)
+		l.Debugf("authenticated")
+	default:
+		l.Debugf("step %d complete", steps)
+	}
+}
+
+// step runs a mechanism step, abandoning it if ctx is done first.  An
+// abandoned mech is closed as soon as its step returns.
+func (c *SaslClient) step(ctx context.Context, inToken []byte) (outToken []byte, status common.StepStatus, abandoned bool, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, common.StepContinue, false, err
 	}
 
-	return c.mech.Step(inToken)
+	// contexts that can't be cancelled don't need a goroutine
+	if ctx.Done() == nil {
+		outToken, status, err = c.mech.Step(inToken)
+		return outToken, status, false, err
+	}
+
+	type result struct {
+		outToken []byte
+		status   common.StepStatus
+		err      error
+	}
+
+	mech, l := c.mech, c.Loggable
+	done := make(chan result)
+	gone := make(chan struct{})
+	go func() {
+		outToken, status, err := mech.Step(inToken)
+		select {
+		case done <- result{outToken, status, err}:
+		case <-gone:
+			if err := mech.Close(); err != nil {
+				l.Debugf("closing abandoned mech: %s", err)
+			}
+		}
+	}()
+
+	select {
+	case r := <-done:
+		return r.outToken, r.status, false, r.err
+	case <-ctx.Done():
+		close(gone)
+		c.Debugf("step abandoned: %s", ctx.Err())
+		return nil, common.StepContinue, true, ctx.Err()
+	}
+}
+
+// finish reports the outcome of the exchange;  err is nil on success
+func (c *SaslClient) finish(err error) {
+	if c.transcript != nil {
+		c.transcript.finish(err)
+	}
+
+	if c.metrics != nil && c.mech != nil {
+		c.metrics.HandshakeFinished(c.mech.Name(), err, time.Since(c.startTime))
+	}
+
+	c.audit(err)
+}
+
+// audit sends the outcome of the exchange to the audit sinks;  err is nil on success
+func (c *SaslClient) audit(err error) {
+	if len(c.auditSinks) == 0 {
+		return
+	}
+
+	now := time.Now()
+	ev := common.AuditEvent{
+		Time:       now,
+		Service:    c.service,
+		ServerFQDN: c.serverFQDN,
+		Outcome:    common.AuditSuccess,
+		Err:        err,
+		Duration:   now.Sub(c.startTime),
+	}
+
+	if hash, herr := c.ConfigHash(); herr == nil {
+		ev.ConfigHash = hash
+	} else {
+		c.Debugf("audit: %s", herr)
+	}
+
+	if err != nil {
+		ev.Outcome = common.AuditFailure
+	}
+
+	if c.mech != nil {
+		ev.Mech = c.mech.Name()
+		if err == nil {
+			params := c.mech.ContextParams()
+			ev.SSF = params.SSF
+			ev.AuthCID = params.AuthCID
+			ev.AuthZID = params.AuthzID
+		}
+	}
+
+	for _, sink := range c.auditSinks {
+		sink.Audit(ev)
+	}
 }
 
 func (c SaslClient) ContextParams() (params common.ContextParams, err error) {
@@ -313,53 +968,256 @@ func (c SaslClient) ContextParams() (params common.ContextParams, err error) {
 		return
 	}
 
-	return c.mech.ContextParams(), nil
+	params = c.mech.ContextParams()
+	params.Mech = c.mech.Name()
+
+	return params, nil
 }
 
-func (c *SaslClient) Encode(input []byte) (outToken []byte, err error) {
-	if c.mech == nil {
-		return nil, common.ErrNotStarted
+// Close releases the mechanism and zeroes the client's copy of the password.
+// The client can't be used afterwards, but its clones are not affected.
+func (c *SaslClient) Close() error {
+	if !c.acquire() {
+		return common.ErrConcurrentUse
 	}
+	defer c.release()
 
-	if !c.IsEstablished() {
-		return nil, common.ErrNotEstablished
+	err := c.closeMech()
+	c.password.Zero()
+	c.password = nil
+
+	return err
+}
+
+// closeMech closes the current mechanism, if any
+func (c *SaslClient) closeMech() (err error) {
+	if c.mech != nil {
+		err = c.mech.Close()
+		c.mech = nil
+	}
+
+	return err
+}
+
+// Encode applies the security layer to input.  It fails with
+// common.ErrMessageTooLarge if input is more than the server accepts in one
+// message;  use EncodeFragments or an EncodingWriter for arbitrary amounts of data.
+func (c *SaslClient) Encode(input []byte) (outToken []byte, err error) {
+	end := c.traceLayer("sasl.Encode", len(input))
+	defer func() { end(err) }()
+
+	params, err := c.layer()
+	if err != nil {
+		return nil, err
 	}
 
 	// output is the same as input if there is no negotiated security layer
-	if c.mech.ContextParams().SSF == 0 {
-		outToken = input
-	} else {
-		outToken, err = c.mech.Encode(input)
+	if params.SSF == 0 {
+		return input, nil
 	}
 
-	return
+	if err = checkMessageSize(params, input); err != nil {
+		return nil, err
+	}
+
+	if outToken, err = c.mech.Encode(input); err == nil {
+		c.countWrapped(len(input))
+	}
+
+	return outToken, err
+}
+
+// EncodeTo is like Encode but appends the token to dst and returns the extended
+// buffer.  Without a security layer the data is appended unchanged.  Only mechs
+// that implement common.AppendCoder encode into dst;  for the others, including
+// GSSAPI, the token from Encode is copied to dst, which costs more than Encode.
+func (c *SaslClient) EncodeTo(dst, input []byte) (out []byte, err error) {
+	end := c.traceLayer("sasl.Encode", len(input))
+	defer func() { end(err) }()
+
+	params, err := c.layer()
+	if err != nil {
+		return dst, err
+	}
+
+	if params.SSF == 0 {
+		return append(dst, input...), nil
+	}
+
+	if err = checkMessageSize(params, input); err != nil {
+		return dst, err
+	}
+
+	if ac, ok := c.mech.(common.AppendCoder); ok {
+		out, err := ac.EncodeTo(dst, input)
+		if err == nil {
+			c.countWrapped(len(input))
+		}
+		return out, err
+	}
+
+	token, err := c.mech.Encode(input)
+	if err != nil {
+		return dst, err
+	}
+	c.countWrapped(len(input))
+
+	return append(dst, token...), nil
 }
 
+// EncodeFragments splits input into pieces that the server accepts and applies
+// the security layer to each of them.  Without a security layer the only
+// fragment is input itself.
+func (c *SaslClient) EncodeFragments(input []byte) (tokens [][]byte, err error) {
+	params, err := c.ContextParams()
+	if err != nil {
+		return nil, err
+	}
+
+	max := len(input)
+	if params.SSF > 0 && params.MaxPeerMessageSize > 0 && uint64(params.MaxPeerMessageSize) < uint64(max) {
+		max = int(params.MaxPeerMessageSize)
+	}
+
+	for len(input) > 0 || tokens == nil {
+		n := max
+		if n > len(input) {
+			n = len(input)
+		}
+
+		token, err := c.Encode(input[:n])
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		input = input[n:]
+	}
+
+	return tokens, nil
+}
+
+// Decode removes the security layer from a token sent by the server.  Tokens
+// larger than the maximum buffer size advertised to the server are rejected
+// with common.ErrTokenTooLarge before they are decoded.
 func (c *SaslClient) Decode(inputToken []byte) (output []byte, err error) {
+	end := c.traceLayer("sasl.Decode", len(inputToken))
+	defer func() { end(err) }()
+
+	params, err := c.layer()
+	if err != nil {
+		return nil, err
+	}
+
+	// output is the same as input if there is no negotiated security layer
+	if params.SSF == 0 {
+		return inputToken, nil
+	}
+
+	if err = c.checkTokenSize(len(inputToken)); err != nil {
+		return nil, err
+	}
+
+	if output, err = c.mech.Decode(inputToken); err != nil {
+		return nil, err
+	}
+
+	if err = c.checkTokenSize(len(output)); err != nil {
+		return output, err
+	}
+	c.countUnwrapped(len(output))
+
+	return output, nil
+}
+
+// DecodeTo is like Decode but appends the data to dst and returns the extended
+// buffer, see EncodeTo
+func (c *SaslClient) DecodeTo(dst, inputToken []byte) (out []byte, err error) {
+	end := c.traceLayer("sasl.Decode", len(inputToken))
+	defer func() { end(err) }()
+
+	params, err := c.layer()
+	if err != nil {
+		return dst, err
+	}
+
+	if params.SSF == 0 {
+		return append(dst, inputToken...), nil
+	}
+
+	if err = c.checkTokenSize(len(inputToken)); err != nil {
+		return dst, err
+	}
+
+	if ac, ok := c.mech.(common.AppendCoder); ok {
+		out, err := ac.DecodeTo(dst, inputToken)
+		if err == nil {
+			err = c.checkTokenSize(len(out) - len(dst))
+		}
+		if err != nil {
+			return dst, err
+		}
+		c.countUnwrapped(len(out) - len(dst))
+		return out, nil
+	}
+
+	output, err := c.mech.Decode(inputToken)
+	if err == nil {
+		err = c.checkTokenSize(len(output))
+	}
+	if err != nil {
+		return dst, err
+	}
+	c.countUnwrapped(len(output))
+
+	return append(dst, output...), nil
+}
+
+// layer returns the parameters of the established context
+func (c *SaslClient) layer() (common.ContextParams, error) {
 	if c.mech == nil {
-		return nil, common.ErrNotStarted
+		return common.ContextParams{}, common.ErrNotStarted
 	}
 
 	if !c.IsEstablished() {
-		return nil, common.ErrNotEstablished
+		return common.ContextParams{}, common.ErrNotEstablished
 	}
 
-	// output is the same as input if there is no negotiated security layer
-	if c.mech.ContextParams().SSF == 0 {
-		output = inputToken
-	} else {
-		output, err = c.mech.Decode(inputToken)
+	return c.mech.ContextParams(), nil
+}
+
+func (c *SaslClient) countWrapped(n int) {
+	if c.metrics != nil {
+		c.metrics.BytesWrapped(c.mech.Name(), n)
+	}
+}
+
+func (c *SaslClient) countUnwrapped(n int) {
+	if c.metrics != nil {
+		c.metrics.BytesUnwrapped(c.mech.Name(), n)
+	}
+}
+
+func checkMessageSize(params common.ContextParams, input []byte) error {
+	if max := params.MaxPeerMessageSize; max > 0 && uint64(len(input)) > uint64(max) {
+		return fmt.Errorf("%d bytes (max %d): %w", len(input), max, common.ErrMessageTooLarge)
 	}
 
-	return
+	return nil
 }
 
-func supportsChannelBindings(mechList []string) bool {
+func (c *SaslClient) checkTokenSize(size int) error {
+	if c.maxBufSize > 0 && uint(size) > c.maxBufSize {
+		return common.ErrTokenTooLarge{Size: uint(size), Max: c.maxBufSize}
+	}
+
+	return nil
+}
+
+func supportsChannelBindings(r *registry.Registry, mechList []string, typ string) bool {
 	supported := false
 
 	for _, mech := range mechList {
-		mechProps := registry.Properties(mech)
-		if mechProps.Fearures&common.FeatChannelBindings > 0 {
+		if r.Properties(mech).SupportsChannelBinding(typ) {
 			supported = true
 			break
 		}
@@ -370,13 +1228,14 @@ func supportsChannelBindings(mechList []string) bool {
 
 // port of Cyrus SASL _sasl_cbinding_disp
 func (c *SaslClient) channelBindingDisposition() (disp channelBindingDisposition, err error) {
-	serverSupported := supportsChannelBindings(c.mechList)
 	disp = channelBindingDispNone
 	if c.channelBindings == nil {
 		c.Debugf("no channel binding requested")
 		return
 	}
 
+	serverSupported := supportsChannelBindings(c.registry, c.offeredMechs(), c.channelBindings.Name)
+
 	switch {
 	// if negotiating mechs..
 	case len(c.mechList) > 0:
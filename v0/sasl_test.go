@@ -1,16 +1,31 @@
 package sasl
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang-auth/go-sasl/common"
 	"github.com/golang-auth/go-sasl/registry"
+	"github.com/golang-auth/go-sasl/saslmock"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/golang-auth/go-sasl/gssapi"
 )
 
+// the tests expect GSSAPI in the default registry, as a program would have it
+// after importing allmechs
+func init() {
+	if err := Enable(gssapi.Mechanism()); err != nil {
+		panic(err)
+	}
+}
+
 func TestWithServerFQDN(t *testing.T) {
 	cli := SaslClient{}
 
@@ -22,10 +37,51 @@ func TestWithServerFQDN(t *testing.T) {
 	assert.NoError(t, opt(&cli), "foo is a valid hostname")
 	assert.Equal(t, "foo", cli.serverFQDN)
 
+	opt = WithServerFQDN("bücher.example")
+	assert.NoError(t, opt(&cli))
+	assert.Equal(t, "xn--bcher-kva.example", cli.serverFQDN)
+
+	opt = WithServerFQDN("-bücher.example")
+	assert.ErrorIs(t, opt(&cli), common.ErrBadConfig)
+
 	opt = WithServerFQDN("invalid-.hostname")
 	assert.Error(t, opt(&cli), "invalid-.hostname is not a valid hostname")
 }
 
+func TestIPLiteralServer(t *testing.T) {
+	r := registry.New()
+	var cfgs []common.MechConfig
+	r.MustRegister("IPMECH", func(cfg common.MechConfig) common.Mech {
+		cfgs = append(cfgs, cfg)
+		return &scriptedMech{name: "IPMECH", steps: 1}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous, Fearures: common.FeatNeedServerFQDN})
+
+	// IP addresses need an explicit opt-in
+	_, err := NewSaslClient("imap", WithRegistry(r), WithServerFQDN("192.0.2.1"))
+	assert.ErrorIs(t, err, common.ErrBadConfig)
+
+	for _, tt := range []struct{ in, out string }{
+		{"192.0.2.1", "192.0.2.1"},
+		{"2001:DB8::0:1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+	} {
+		cli, err := NewSaslClient("imap", WithRegistry(r), WithServerFQDN(tt.in), WithIPLiteralServer())
+		assert.NoError(t, err, tt.in)
+		_, _, err = cli.Start()
+		assert.NoError(t, err, tt.in)
+		cfg := cfgs[len(cfgs)-1]
+		assert.Equal(t, tt.out, cfg.ServerFQDN, tt.in)
+		assert.True(t, cfg.ServerIsIP, tt.in)
+	}
+
+	// a later host name replaces the address
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithServerFQDN("192.0.2.1"), WithServerFQDN("imap.example.com"))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.False(t, cfgs[len(cfgs)-1].ServerIsIP)
+}
+
 func TestLogging(t *testing.T) {
 	sb := strings.Builder{}
 	loggerD := log.New(&sb, "testD: ", 0)
@@ -51,6 +107,72 @@ func TestLogging(t *testing.T) {
 	assert.Contains(t, sb.String(), "testE: error testing 1 2 3\n")
 }
 
+// recordingLogger implements loggable.Logger
+type recordingLogger struct {
+	lines []string
+}
+
+func (r *recordingLogger) Debugf(msg string, args ...interface{}) {
+	r.lines = append(r.lines, "D "+fmt.Sprintf(msg, args...))
+}
+func (r *recordingLogger) Infof(msg string, args ...interface{}) {
+	r.lines = append(r.lines, "I "+fmt.Sprintf(msg, args...))
+}
+func (r *recordingLogger) Warnf(msg string, args ...interface{}) {
+	r.lines = append(r.lines, "W "+fmt.Sprintf(msg, args...))
+}
+func (r *recordingLogger) Errorf(msg string, args ...interface{}) {
+	r.lines = append(r.lines, "E "+fmt.Sprintf(msg, args...))
+}
+
+func TestWithLogger(t *testing.T) {
+	r := &recordingLogger{}
+	cli, err := NewSaslClient("imap", WithLogger(r))
+	assert.NoError(t, err)
+	r.lines = nil
+	cli.Debugf("debug %d", 1)
+	cli.Infof("info %d", 2)
+	cli.Warnf("warn %d", 3)
+	cli.Errorf("error %d", 4)
+	assert.Equal(t, []string{"D debug 1", "I info 2", "W warn 3", "E error 4"}, r.lines)
+
+	// a *log.Logger replaces the interface
+	sb := strings.Builder{}
+	cli, err = NewSaslClient("imap", WithLogger(r), WithWarnLogger(log.New(&sb, "", 0)))
+	assert.NoError(t, err)
+	r.lines = nil
+	cli.Warnf("warn")
+	cli.Debugf("debug")
+	assert.Empty(t, r.lines)
+	assert.Equal(t, "warn\n", sb.String())
+}
+
+func TestTokenLogging(t *testing.T) {
+	r := registry.New()
+	r.MustRegister("TOKENS", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "TOKENS", steps: 2}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	sb := strings.Builder{}
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithDebugLogger(log.New(&sb, "", 0)))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+
+	// 3c469e9d is the start of the SHA-256 hash of "token"
+	assert.Contains(t, sb.String(), "step 1: received <nil>, sending <5 bytes sha256:3c469e9d>\n")
+	assert.NotContains(t, sb.String(), "746f6b656e")
+
+	sb.Reset()
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithDebugLogger(log.New(&sb, "", 0)), WithUnsafeTokenLogging())
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	_, _, err = cli.Step([]byte("hi"))
+	assert.NoError(t, err)
+	assert.Contains(t, sb.String(), "step 2: received <2 bytes: 6869>, sending <5 bytes: 746f6b656e>\n")
+}
+
 func TestNewSaslClientMechs(t *testing.T) {
 	l := log.New(os.Stderr, "unittest: ", 0)
 	opts := []SaslClientOption{
@@ -74,114 +196,920 @@ func TestNewSaslClientMechs(t *testing.T) {
 	assert.ErrorIs(t, common.ErrNoMech, err)
 }
 
-type mockMech struct {
-}
-
-func (m mockMech) Name() string {
-	return "MOCK"
-}
-func (m mockMech) MechProperties() common.MechProps {
-	return common.MechProps{}
-}
-func (m mockMech) IsEstablished() bool {
-	return false
-}
-func (m mockMech) Step(inToken []byte) (outToken []byte, err error) {
-	return nil, nil
-}
-func (m mockMech) ContextParams() common.ContextParams {
-	return common.ContextParams{}
-}
-func (m mockMech) Encode([]byte) ([]byte, error) {
-	return nil, nil
-}
-func (m mockMech) Decode([]byte) ([]byte, error) {
-	return nil, nil
-}
-
-type mockMech1 struct {
-	mockMech
-}
-type mockMech2 struct {
-	mockMech
-}
-type mockMech3 struct {
-	mockMech
-}
-
-func newMockMech1(cfg common.MechConfig) common.Mech {
-	return &mockMech1{}
-}
-func newMockMech2(cfg common.MechConfig) common.Mech {
-	return &mockMech2{}
-}
-func newMockMech3(cfg common.MechConfig) common.Mech {
-	return &mockMech3{}
-}
-
 func TestSaslClientStart(t *testing.T) {
-	registry.Register("MECH1", newMockMech1, common.MechProps{
+	mech1 := saslmock.New("MECH1", saslmock.WithProps(common.MechProps{
 		MaxSSF:             256,
 		SecurityProperties: common.SecNoPlainText | common.SecNoActive | common.SecNoAnonymous | common.SecMutualAuth | common.SecPassCredentials,
 		Fearures:           common.FeatWantClientFirst | common.FeatDontUseUserPassword,
-	})
+	}))
+	assert.NoError(t, mech1.Register(registry.Default()))
 
-	registry.Register("MECH2", newMockMech2, common.MechProps{
+	mech2 := saslmock.New("MECH2", saslmock.WithProps(common.MechProps{
 		MaxSSF:             0,
 		SecurityProperties: common.SecNoAnonymous | common.SecPassCredentials,
 		Fearures:           common.FeatWantClientFirst,
-	})
+	}))
+	assert.NoError(t, mech2.Register(registry.Default()))
 
-	registry.Register("MECH3", newMockMech3, common.MechProps{
+	mech3 := saslmock.New("MECH3", saslmock.WithProps(common.MechProps{
 		MaxSSF:             10,
 		SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous | common.SecPassCredentials,
 		Fearures:           common.FeatWantClientFirst,
-	})
+	}))
+	assert.NoError(t, mech3.Register(registry.Default()))
 
 	// try with all 3 mechs and no external layer, default options
 	// should choose MECH1
 	cli, err := NewSaslClient("imap", WithMechList([]string{"MECH1", "MECH2", "MECH3"}))
 	assert.NoError(t, err)
-	_, err = cli.Start()
+	_, _, err = cli.Start()
 	assert.NoError(t, err)
-	assert.IsType(t, &mockMech1{}, cli.mech, "MECH1 is preferred")
+	assert.Equal(t, "MECH1", cli.mech.Name(), "MECH1 is preferred")
 
 	// same but with a difference preference order.  MECH3 should be chosen because
 	// it supports the default security requirements
 	cli, err = NewSaslClient("imap", WithMechList([]string{"MECH2", "MECH3", "MECH1"}))
 	assert.NoError(t, err)
-	_, err = cli.Start()
+	_, _, err = cli.Start()
 	assert.NoError(t, err)
-	assert.IsType(t, &mockMech3{}, cli.mech, "MECH1 is preferred")
+	assert.Equal(t, "MECH3", cli.mech.Name(), "MECH1 is preferred")
 
 	// same but with a min-ssf 20 - should choose MECH1
 	cli, err = NewSaslClient("imap",
 		WithMechList([]string{"MECH2", "MECH3", "MECH1"}),
 		WithMinSSF(20))
 	assert.NoError(t, err)
-	_, err = cli.Start()
+	_, _, err = cli.Start()
 	assert.NoError(t, err)
-	assert.IsType(t, &mockMech1{}, cli.mech)
+	assert.Equal(t, "MECH1", cli.mech.Name())
 
 	// same but assume we have an external layer with SSF 15, should choose MECH3 again
 	// because the new mech only needs to provide 5 'ssf units'
 	cli, err = NewSaslClient("imap",
 		WithMechList([]string{"MECH2", "MECH3", "MECH1"}),
-		WithMinSSF(20))
-	cli.extProps.ssf = 15
+		WithMinSSF(20),
+		WithExternalSSF(15))
 	assert.NoError(t, err)
-	_, err = cli.Start()
+	_, _, err = cli.Start()
 	assert.NoError(t, err)
-	assert.IsType(t, &mockMech3{}, cli.mech)
+	assert.Equal(t, "MECH3", cli.mech.Name())
 
 	// now set the external SSF to 25;  MECH2 is now preferred because we no longer need
 	// the SecNoPlainText property
 	cli, err = NewSaslClient("imap",
 		WithMechList([]string{"MECH2", "MECH3", "MECH1"}),
-		WithMinSSF(20))
-	cli.extProps.ssf = 25
+		WithMinSSF(20),
+		WithExternalSSF(25))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "MECH2", cli.mech.Name())
+}
+
+func TestCanonicalConfig(t *testing.T) {
+	assert.NoError(t, saslmock.New("CANON1", saslmock.WithProps(common.MechProps{
+		MaxSSF:             56,
+		SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous,
+		Fearures:           common.FeatWantClientFirst,
+	})).Register(registry.Default()))
+
+	props := registry.Properties("CANON1")
+	assert.JSONEq(t, `{"max_ssf":56,"security_properties":17,"features":2}`, string(props.CanonicalJSON()))
+	assert.Equal(t, props.Hash(), common.MechProps{MaxSSF: 56, SecurityProperties: 17, Fearures: 2}.Hash())
+	assert.NotEqual(t, props.Hash(), common.MechProps{}.Hash())
+
+	// option order doesn't matter, nor does the order of extra props
+	cli1, err := NewSaslClient("imap",
+		WithMechList([]string{"CANON1"}),
+		WithExtraProps("a", "1"),
+		WithExtraProps("b", "2"),
+		WithMinSSF(10))
+	assert.NoError(t, err)
+	cli2, err := NewSaslClient("imap",
+		WithMinSSF(10),
+		WithExtraProps("b", "2"),
+		WithExtraProps("a", "1"),
+		WithMechList([]string{"CANON1"}))
+	assert.NoError(t, err)
+	assert.Equal(t, canonicalJSON(t, cli1), canonicalJSON(t, cli2))
+	assert.Equal(t, configHash(t, cli1), configHash(t, cli2))
+	assert.Contains(t, canonicalJSON(t, cli1), `"mechs":[{"name":"CANON1","properties":{"max_ssf":56,"security_properties":17,"features":2}}]`)
+	assert.Contains(t, canonicalJSON(t, cli1), `"extra_props":{"a":"1","b":"2"}`)
+
+	// channel binding data is per-connection and doesn't affect the hash
+	cli1, _ = NewSaslClient("imap", WithMechList([]string{"CANON1"}), WithChannelBindings(common.ChannelBinding{Name: "tls-unique", Data: []byte{1}}))
+	cli2, _ = NewSaslClient("imap", WithMechList([]string{"CANON1"}), WithChannelBindings(common.ChannelBinding{Name: "tls-unique", Data: []byte{2}}))
+	assert.Equal(t, configHash(t, cli1), configHash(t, cli2))
+
+	// anything else does
+	cli2, _ = NewSaslClient("imap", WithMechList([]string{"CANON1"}), WithChannelBindings(common.ChannelBinding{Name: "tls-unique", Critical: true}))
+	assert.NotEqual(t, configHash(t, cli1), configHash(t, cli2))
+	cli2, _ = NewSaslClient("ldap", WithMechList([]string{"CANON1"}), WithChannelBindings(common.ChannelBinding{Name: "tls-unique"}))
+	assert.NotEqual(t, configHash(t, cli1), configHash(t, cli2))
+
+	// without a mech list, clients list the registered mechs in the same order
+	r := registry.New()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, saslmock.New(fmt.Sprintf("CANON-%d", i)).Register(r))
+	}
+	for _, opts := range [][]SaslClientOption{
+		{WithServerFQDN("h")},
+		{WithServerFQDN("h"), WithRegistry(r)},
+	} {
+		cli1, err = NewSaslClient("imap", opts...)
+		assert.NoError(t, err)
+		for i := 0; i < 20; i++ {
+			cli2, err = NewSaslClient("imap", opts...)
+			assert.NoError(t, err)
+			assert.Equal(t, configHash(t, cli1), configHash(t, cli2))
+		}
+	}
+}
+
+// canonicalJSON returns the canonical configuration of c as a string
+func canonicalJSON(t *testing.T, c SaslClient) string {
+	b, err := c.CanonicalConfig()
+	assert.NoError(t, err)
+	return string(b)
+}
+
+// configHash returns the configuration hash of c
+func configHash(t *testing.T, c SaslClient) string {
+	hash, err := c.ConfigHash()
+	assert.NoError(t, err)
+	return hash
+}
+
+func TestExternalProps(t *testing.T) {
+	var cfg common.MechConfig
+	registry.MustRegister("EXTPROPS", func(c common.MechConfig) common.Mech {
+		cfg = c
+		return &scriptedMech{name: "EXTPROPS", steps: 1}
+	}, common.MechProps{SecurityProperties: common.SecNoAnonymous | common.SecPassCredentials})
+
+	// a plaintext mech is refused without an external layer..
+	cli, err := NewSaslClient("imap", WithMechList([]string{"EXTPROPS"}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.ErrorIs(t, err, common.ErrNoMech)
+
+	// ..and chosen over TLS, which the mech is told about
+	cli, err = NewSaslClient("imap", WithMechList([]string{"EXTPROPS"}), WithExternalSSF(256), WithExternalAuthID("CN=client"))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(256), cfg.ExternalSSF)
+	assert.Equal(t, "CN=client", cfg.ExternalAuthID)
+	assert.Contains(t, canonicalJSON(t, cli), `"external_ssf":256,"external_authid":"CN=client"`)
+}
+
+func TestWithRegistry(t *testing.T) {
+	r := registry.New()
+	r.MustRegister("PRIVATE", func(c common.MechConfig) common.Mech {
+		return &scriptedMech{name: "PRIVATE", steps: 1}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous, Fearures: common.FeatServerFirst})
+
+	// only the private registry's mechs are available
+	cli, err := NewSaslClient("imap", WithRegistry(r))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PRIVATE"}, cli.mechList)
+	mech, ir, err := cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "PRIVATE", mech)
+	assert.Nil(t, ir)
+
+	_, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"GSSAPI"}))
+	assert.ErrorIs(t, err, common.ErrNoMech)
+	_, err = NewSaslClient("imap", WithRegistry(nil))
+	assert.Error(t, err)
+	assert.False(t, registry.IsRegistered("PRIVATE"))
+}
+
+func TestWithMechanisms(t *testing.T) {
+	private := registry.Mechanism{
+		Name: "PRIVATE",
+		Factory: func(c common.MechConfig) common.Mech {
+			return &scriptedMech{name: "PRIVATE", steps: 1}
+		},
+		Props: common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous, Fearures: common.FeatServerFirst},
+	}
+
+	// GSSAPI is in the default registry but isn't enabled for the client
+	cli, err := NewSaslClient("imap", WithMechanisms(private))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PRIVATE"}, cli.mechList)
+	mech, _, err := cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "PRIVATE", mech)
+	assert.False(t, registry.IsRegistered("PRIVATE"))
+
+	cli, err = NewSaslClient("imap", WithMechanisms(private, gssapi.Mechanism()))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"PRIVATE", "GSSAPI"}, cli.mechList)
+
+	_, err = NewSaslClient("imap", WithMechanisms(registry.Mechanism{Name: "private"}))
+	assert.ErrorIs(t, err, registry.ErrBadMechName)
+	_, err = NewSaslClient("imap", WithMechanisms())
+	assert.ErrorIs(t, err, common.ErrNoMech)
+
+	// enabling a mech that is already registered replaces it
+	assert.NoError(t, Enable(gssapi.Mechanism()))
+	assert.Equal(t, gssapi.Mechanism().Props, registry.Properties("GSSAPI"))
+}
+
+// blockingMech is stuck in its first step until release is closed, and
+// closes closed when it is closed
+type blockingMech struct {
+	scriptedMech
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (m *blockingMech) Step(inToken []byte) (outToken []byte, status common.StepStatus, err error) {
+	<-m.release
+	return m.scriptedMech.Step(inToken)
+}
+
+func (m *blockingMech) Close() error {
+	close(m.closed)
+	return nil
+}
+
+func TestContext(t *testing.T) {
+	release := make(chan struct{})
+	closed := make(chan struct{})
+
+	var cfg common.MechConfig
+	r := registry.New()
+	r.MustRegister("BLOCKING", func(c common.MechConfig) common.Mech {
+		cfg = c
+		return &blockingMech{scriptedMech{name: "BLOCKING", steps: 2}, release, closed}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	var events []common.AuditEvent
+	sink := common.AuditFunc(func(ev common.AuditEvent) {
+		events = append(events, ev)
+	})
+
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"BLOCKING"}), WithAuditSink(sink))
+	assert.NoError(t, err)
+
+	// already cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = cli.StartContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// a blocked step is abandoned when the deadline passes
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = cli.StartContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, ctx, cfg.Context)
+	assert.False(t, cli.IsEstablished())
+	_, _, err = cli.Step([]byte("challenge"))
+	assert.ErrorIs(t, err, common.ErrNotStarted)
+
+	// the abandoned mech is closed once its step returns
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("abandoned mech wasn't closed")
+	}
+
+	assert.Len(t, events, 2)
+	assert.Equal(t, "BLOCKING", events[1].Mech)
+	assert.Equal(t, common.AuditFailure, events[1].Outcome)
+}
+
+// scriptedMech establishes after a number of steps, or fails if err is set
+type scriptedMech struct {
+	name   string
+	steps  int
+	err    error
+	ssf    uint
+	props  common.MechProps
+	closed bool
+}
+
+func (m *scriptedMech) Name() string {
+	return m.name
+}
+func (m *scriptedMech) MechProperties() common.MechProps {
+	return m.props
+}
+func (m *scriptedMech) IsEstablished() bool {
+	return m.steps == 0
+}
+func (m *scriptedMech) Step(inToken []byte) (outToken []byte, status common.StepStatus, err error) {
+	if m.err != nil {
+		return nil, common.StepContinue, m.err
+	}
+	m.steps--
+	if m.steps == 0 {
+		return []byte("token"), common.StepDoneWithFinalToken, nil
+	}
+	return []byte("token"), common.StepContinue, nil
+}
+func (m *scriptedMech) ContextParams() common.ContextParams {
+	return common.ContextParams{SSF: m.ssf}
+}
+func (m *scriptedMech) Encode([]byte) ([]byte, error) {
+	return nil, nil
+}
+func (m *scriptedMech) Decode([]byte) ([]byte, error) {
+	return nil, nil
+}
+func (m *scriptedMech) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestAudit(t *testing.T) {
+	registry.MustRegister("AUDIT-OK", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "AUDIT-OK", steps: 2, ssf: 56}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+	registry.MustRegister("AUDIT-FAIL", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "AUDIT-FAIL", steps: 2, err: errors.New("bad password")}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	var events []common.AuditEvent
+	sink := common.AuditFunc(func(ev common.AuditEvent) {
+		events = append(events, ev)
+	})
+
+	// success is reported once, when the context is established
+	cli, err := NewSaslClient("imap", WithMechList([]string{"AUDIT-OK"}), WithAuditSink(sink), WithServerFQDN("imap.example.com"))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Len(t, events, 0)
+	_, status, err := cli.Step([]byte("challenge"))
+	assert.NoError(t, err)
+	assert.Equal(t, common.StepDoneWithFinalToken, status)
+	params, err := cli.ContextParams()
+	assert.NoError(t, err)
+	assert.Equal(t, "AUDIT-OK", params.Mech)
+	assert.Equal(t, uint(56), params.SSF)
+	assert.Len(t, events, 1)
+	assert.Equal(t, common.AuditSuccess, events[0].Outcome)
+	assert.Equal(t, "AUDIT-OK", events[0].Mech)
+	assert.Equal(t, "imap", events[0].Service)
+	assert.Equal(t, "imap.example.com", events[0].ServerFQDN)
+	assert.Equal(t, uint(56), events[0].SSF)
+	assert.Equal(t, configHash(t, cli), events[0].ConfigHash)
+	assert.Nil(t, events[0].Err)
+
+	// mech failures carry the reason
+	events = nil
+	cli, err = NewSaslClient("imap", WithMechList([]string{"AUDIT-FAIL"}), WithAuditSink(sink))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.Error(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, common.AuditFailure, events[0].Outcome)
+	assert.Equal(t, "AUDIT-FAIL", events[0].Mech)
+	assert.EqualError(t, events[0].Err, "bad password")
+	assert.Equal(t, uint(0), events[0].SSF)
+
+	// as do selection failures
+	events = nil
+	cli, err = NewSaslClient("imap", WithMechList([]string{"AUDIT-OK"}), WithAuditSink(sink), WithMinSSF(256))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.ErrorIs(t, err, common.ErrNoMech)
+	assert.Len(t, events, 1)
+	assert.Equal(t, common.AuditFailure, events[0].Outcome)
+	assert.Equal(t, "", events[0].Mech)
+	assert.ErrorIs(t, events[0].Err, common.ErrNoMech)
+}
+
+// recordingMetrics implements common.Metrics
+type recordingMetrics struct {
+	events []string
+}
+
+func (r *recordingMetrics) HandshakeStarted(mech string) {
+	r.events = append(r.events, "started "+mech)
+}
+func (r *recordingMetrics) HandshakeFinished(mech string, err error, d time.Duration) {
+	r.events = append(r.events, fmt.Sprintf("finished %s %v", mech, err))
+}
+func (r *recordingMetrics) MechRejected(mech string, reason error) {
+	r.events = append(r.events, fmt.Sprintf("rejected %s %v", mech, reason))
+}
+func (r *recordingMetrics) BytesWrapped(mech string, n int) {
+	r.events = append(r.events, fmt.Sprintf("wrapped %s %d", mech, n))
+}
+func (r *recordingMetrics) BytesUnwrapped(mech string, n int) {
+	r.events = append(r.events, fmt.Sprintf("unwrapped %s %d", mech, n))
+}
+
+func TestMetrics(t *testing.T) {
+	r := registry.New()
+	r.MustRegister("METRICS-OK", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "METRICS-OK", steps: 2, ssf: 56}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+	r.MustRegister("METRICS-FAIL", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "METRICS-FAIL", steps: 2, err: errors.New("bad password")}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+	r.MustRegister("METRICS-WEAK", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "METRICS-WEAK", steps: 1}
+	}, common.MechProps{})
+
+	m := &recordingMetrics{}
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMetrics(m),
+		WithMechList([]string{"METRICS-WEAK", "METRICS-OK"}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	_, _, err = cli.Step([]byte("challenge"))
+	assert.NoError(t, err)
+	_, err = cli.Encode([]byte("hello"))
+	assert.NoError(t, err)
+	_, err = cli.Decode([]byte("token"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"rejected METRICS-WEAK does not meet security requirements",
+		"started METRICS-OK",
+		"finished METRICS-OK <nil>",
+		"wrapped METRICS-OK 5",
+		"unwrapped METRICS-OK 0",
+	}, m.events)
+
+	// choosing first doesn't count the rejection twice
+	m.events = nil
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMetrics(m),
+		WithMechList([]string{"METRICS-WEAK", "METRICS-OK"}))
+	assert.NoError(t, err)
+	_, _, err = cli.ChooseMech([]string{"METRICS-WEAK", "METRICS-OK"})
+	assert.NoError(t, err)
+	assert.Empty(t, m.events)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"rejected METRICS-WEAK does not meet security requirements",
+		"started METRICS-OK",
+	}, m.events)
+
+	m.events = nil
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMetrics(m), WithMechList([]string{"METRICS-FAIL"}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.Error(t, err)
+	assert.Equal(t, []string{"started METRICS-FAIL", "finished METRICS-FAIL bad password"}, m.events)
+}
+
+func TestWithPreparation(t *testing.T) {
+	var got []common.Preparation
+	r := registry.New()
+	for _, name := range []string{"PREP-A", "PREP-B"} {
+		name := name
+		r.MustRegister(name, func(cfg common.MechConfig) common.Mech {
+			got = append(got, cfg.Preparation)
+			return &scriptedMech{name: name, steps: 1}
+		}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+	}
+
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"PREP-A", "PREP-B"}),
+		WithPreparation(common.PrecisPreparation, "prep-b"))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	_, _, err = cli.ChooseMech([]string{"PREP-B"})
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+
+	if assert.Len(t, got, 2) {
+		// SASLprep unless set for the mech
+		out, err := got[0].PrepareUsername("Juliet")
+		assert.NoError(t, err)
+		assert.Equal(t, "Juliet", out)
+		out, err = got[1].PrepareUsername("Juliet")
+		assert.NoError(t, err)
+		assert.Equal(t, "juliet", out)
+	}
+}
+
+func TestPrompts(t *testing.T) {
+	var mechCfg common.MechConfig
+	registry.MustRegister("PROMPT", func(cfg common.MechConfig) common.Mech {
+		mechCfg = cfg
+		return &scriptedMech{name: "PROMPT", steps: 1}
+	}, common.MechProps{MaxSSF: 0, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	fallback := common.PromptFunc(func(p common.Prompt) ([]byte, error) {
+		return []byte("fallback " + p.Type.String()), nil
+	})
+
+	cli, err := NewSaslClient("imap",
+		WithMechList([]string{"PROMPT"}),
+		WithPrompter(fallback),
+		WithPromptHandler(common.PromptUsername, func(p common.Prompt) ([]byte, error) {
+			return []byte("jake"), nil
+		}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+
+	// mechs prompt through their config: specific handlers win over the fallback
+	answer, err := mechCfg.Prompt(common.Prompt{Type: common.PromptUsername, Mech: "PROMPT"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("jake"), answer)
+	answer, err = mechCfg.Prompt(common.Prompt{Type: common.PromptRealm, Mech: "PROMPT"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("fallback realm"), answer)
+
+	// no handlers at all
+	cli, err = NewSaslClient("imap", WithMechList([]string{"PROMPT"}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	_, err = mechCfg.Prompt(common.Prompt{Type: common.PromptPassword})
+	assert.ErrorIs(t, err, common.ErrNoPromptHandler)
+	_, err = common.MechConfig{}.Prompt(common.Prompt{Type: common.PromptPassword})
+	assert.ErrorIs(t, err, common.ErrNoPromptHandler)
+
+	// handler maps can be used as prompters directly
+	handlers := common.PromptHandlers{
+		common.PromptOTP: func(p common.Prompt) ([]byte, error) {
+			return []byte("123456"), nil
+		},
+	}
+	answer, err = handlers.Prompt(common.Prompt{Type: common.PromptOTP})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("123456"), answer)
+	_, err = handlers.Prompt(common.Prompt{Type: common.PromptConfirm})
+	assert.ErrorIs(t, err, common.ErrNoPromptHandler)
+}
+
+func TestPassword(t *testing.T) {
+	var mechCfg common.MechConfig
+	registry.MustRegister("PASSWORD", func(cfg common.MechConfig) common.Mech {
+		mechCfg = cfg
+		return &scriptedMech{name: "PASSWORD", steps: 1}
+	}, common.MechProps{MaxSSF: 0, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	// the client keeps its own copy of a static password
+	pw := []byte("secret")
+	cli, err := NewSaslClient("imap", WithMechList([]string{"PASSWORD"}), WithPassword(pw))
+	assert.NoError(t, err)
+	common.Zero(pw)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+
+	// and mechs get a copy they can zero
+	answer, err := mechCfg.Password("PASSWORD")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), answer)
+	common.Zero(answer)
+	answer, err = mechCfg.Password("PASSWORD")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), answer)
+
+	// the callback isn't used until a mech asks for the password
+	calls := 0
+	cli, err = NewSaslClient("imap", WithMechList([]string{"PASSWORD"}), WithPasswordCallback(func() ([]byte, error) {
+		calls++
+		return []byte("from callback"), nil
+	}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, calls)
+	answer, err = mechCfg.Password("PASSWORD")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("from callback"), answer)
+	assert.Equal(t, 1, calls)
+
+	// without a password, mechs get an error
+	cli, err = NewSaslClient("imap", WithMechList([]string{"PASSWORD"}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	_, err = mechCfg.Password("PASSWORD")
+	assert.ErrorIs(t, err, common.ErrNoPromptHandler)
+}
+
+func TestSecret(t *testing.T) {
+	s := common.NewSecret([]byte("key"))
+	assert.Equal(t, []byte("key"), s.Bytes())
+
+	s.Zero()
+	assert.Nil(t, s.Bytes())
+
+	var nilSecret *common.Secret
+	assert.Nil(t, nilSecret.Bytes())
+	assert.NotPanics(t, nilSecret.Zero)
+}
+
+func TestRealm(t *testing.T) {
+	// no server offer: use the configured realm, or none
+	cfg := common.MechConfig{Realm: "EXAMPLE.COM"}
+	realm, err := cfg.ChooseRealm("DIGEST-MD5", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "EXAMPLE.COM", realm)
+	realm, err = common.MechConfig{}.ChooseRealm("DIGEST-MD5", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", realm)
+
+	// configured realm is offered by the server
+	realm, err = cfg.ChooseRealm("DIGEST-MD5", []string{"OTHER.COM", "EXAMPLE.COM"})
+	assert.NoError(t, err)
+	assert.Equal(t, "EXAMPLE.COM", realm)
+
+	// a single offer is used if nothing was configured and there's no callback
+	realm, err = common.MechConfig{}.ChooseRealm("DIGEST-MD5", []string{"OTHER.COM"})
+	assert.NoError(t, err)
+	assert.Equal(t, "OTHER.COM", realm)
+
+	// but not if it conflicts with the configured realm
+	_, err = cfg.ChooseRealm("DIGEST-MD5", []string{"OTHER.COM"})
+	assert.ErrorIs(t, err, common.ErrNoPromptHandler)
+	_, err = common.MechConfig{}.ChooseRealm("DIGEST-MD5", []string{"A.COM", "B.COM"})
+	assert.ErrorIs(t, err, common.ErrNoPromptHandler)
+
+	// the callback chooses from the offers
+	var mechCfg common.MechConfig
+	registry.MustRegister("REALM", func(cfg common.MechConfig) common.Mech {
+		mechCfg = cfg
+		return &scriptedMech{name: "REALM", steps: 1}
+	}, common.MechProps{MaxSSF: 0, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := NewSaslClient("imap",
+		WithMechList([]string{"REALM"}),
+		WithRealm("EXAMPLE.COM"),
+		WithRealmCallback(func(offered []string) (string, error) {
+			return offered[len(offered)-1], nil
+		}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "EXAMPLE.COM", mechCfg.Realm)
+
+	realm, err = mechCfg.ChooseRealm("REALM", []string{"A.COM", "B.COM"})
+	assert.NoError(t, err)
+	assert.Equal(t, "B.COM", realm)
+}
+
+func TestStartReturnsMech(t *testing.T) {
+	registry.MustRegister("FIRST-CLIENT", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "FIRST-CLIENT", steps: 2}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+	registry.MustRegister("FIRST-SERVER", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "FIRST-SERVER", steps: 2, props: registry.Properties("FIRST-SERVER")}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous, Fearures: common.FeatServerFirst})
+
+	cli, err := NewSaslClient("imap", WithMechList([]string{"FIRST-CLIENT"}))
+	assert.NoError(t, err)
+	mech, ir, err := cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "FIRST-CLIENT", mech)
+	assert.Equal(t, []byte("token"), ir)
+
+	// server-first mechs have no initial response
+	cli, err = NewSaslClient("imap", WithMechList([]string{"FIRST-SERVER"}))
+	assert.NoError(t, err)
+	mech, ir, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "FIRST-SERVER", mech)
+	assert.Nil(t, ir)
+
+	// no suitable mech
+	cli, err = NewSaslClient("imap", WithMechList([]string{"FIRST-CLIENT"}), WithMinSSF(1))
+	assert.NoError(t, err)
+	mech, _, err = cli.Start()
+	assert.ErrorIs(t, err, common.ErrNoMech)
+	assert.Equal(t, "", mech)
+}
+
+func TestInitialResponseMode(t *testing.T) {
+	r := registry.New()
+	props := common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous}
+	r.MustRegister("IR-CLIENT", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "IR-CLIENT", steps: 2}
+	}, props)
+	props.Fearures = common.FeatServerFirst
+	r.MustRegister("IR-SERVER", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "IR-SERVER", steps: 2}
+	}, props)
+
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"IR-CLIENT"}))
+	assert.NoError(t, err)
+	_, err = cli.ClientFirst()
+	assert.ErrorIs(t, err, common.ErrNotStarted)
+
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	clientFirst, err := cli.ClientFirst()
+	assert.NoError(t, err)
+	assert.True(t, clientFirst)
+	mode, err := cli.InitialResponseMode(true)
+	assert.NoError(t, err)
+	assert.Equal(t, IRWithCommand, mode)
+	mode, _ = cli.InitialResponseMode(false)
+	assert.Equal(t, IRAfterChallenge, mode)
+
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"IR-SERVER"}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	mode, _ = cli.InitialResponseMode(true)
+	assert.Equal(t, IRNone, mode)
+
+	// nil, empty and non-empty responses are all distinct on the wire
+	_, ok := EncodeInitialResponse(nil)
+	assert.False(t, ok)
+	enc, ok := EncodeInitialResponse([]byte{})
+	assert.True(t, ok)
+	assert.Equal(t, "=", enc)
+	enc, _ = EncodeInitialResponse([]byte("\x00user\x00pass"))
+	assert.Equal(t, "AHVzZXIAcGFzcw==", enc)
+
+	dec, err := DecodeInitialResponse("=")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{}, dec)
+	dec, err = DecodeInitialResponse(enc)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x00user\x00pass"), dec)
+	_, err = DecodeInitialResponse("!!")
+	assert.Error(t, err)
+}
+
+type testOptions struct {
+	Level int `json:"level"`
+}
+
+func (o testOptions) Mech() string {
+	return "OPTS"
+}
+
+func (o testOptions) Validate() error {
+	if o.Level > 3 {
+		return errors.New("level must be at most 3")
+	}
+	return nil
+}
+
+// funcOptions can't be serialized as JSON
+type funcOptions struct {
+	Callback func()
+}
+
+func (o funcOptions) Mech() string {
+	return "OPTS"
+}
+
+func (o funcOptions) Validate() error {
+	return nil
+}
+
+func TestMechOptions(t *testing.T) {
+	var cfg common.MechConfig
+	r := registry.New()
+	r.MustRegister("OPTS", func(c common.MechConfig) common.Mech {
+		cfg = c
+		return &scriptedMech{name: "OPTS", steps: 1}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechOptions(testOptions{Level: 2}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, testOptions{Level: 2}, cfg.Options)
+	assert.Contains(t, canonicalJSON(t, cli), `"mech_options":{"OPTS":{"level":2}}`)
+
+	// bad values fail before any exchange
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechOptions(testOptions{Level: 4}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.EqualError(t, err, "OPTS options: level must be at most 3")
+
+	// as do options for mechs that don't exist
+	r2 := registry.New()
+	r2.MustRegister("OTHER", func(c common.MechConfig) common.Mech { return &scriptedMech{} }, common.MechProps{})
+	cli, err = NewSaslClient("imap", WithRegistry(r2), WithMechOptions(testOptions{}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.EqualError(t, err, "options for unknown mech OPTS")
+
+	_, err = NewSaslClient("imap", WithMechOptions(nil))
+	assert.Error(t, err)
+
+	// options that can't be serialized have no config hash, rather than a
+	// meaningless one
+	var events []common.AuditEvent
+	sink := common.AuditFunc(func(ev common.AuditEvent) {
+		events = append(events, ev)
+	})
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechOptions(funcOptions{Callback: func() {}}), WithAuditSink(sink))
+	assert.NoError(t, err)
+	_, err = cli.CanonicalConfig()
+	assert.Error(t, err)
+	_, err = cli.ConfigHash()
+	assert.Error(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Empty(t, events[0].ConfigHash)
+	}
+}
+
+func TestState(t *testing.T) {
+	r := registry.New()
+	props := common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous}
+	r.MustRegister("STATE-OK", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "STATE-OK", steps: 2}
+	}, props)
+	r.MustRegister("STATE-FAIL", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "STATE-FAIL", err: errors.New("bad")}
+	}, props)
+
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"STATE-OK"}))
+	assert.NoError(t, err)
+	state, steps := cli.State()
+	assert.Equal(t, StateNotStarted, state)
+	assert.Equal(t, 0, steps)
+
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	state, steps = cli.State()
+	assert.Equal(t, StateNegotiating, state)
+	assert.Equal(t, 1, steps)
+
+	_, _, err = cli.Step([]byte("challenge"))
+	assert.NoError(t, err)
+	state, steps = cli.State()
+	assert.Equal(t, StateEstablished, state)
+	assert.Equal(t, 2, steps)
+	assert.Equal(t, "established", state.String())
+
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"STATE-FAIL"}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.Error(t, err)
+	state, _ = cli.State()
+	assert.Equal(t, StateFailed, state)
+}
+
+func TestClose(t *testing.T) {
+	var mechs []*scriptedMech
+	r := registry.New()
+	r.MustRegister("CLOSE", func(common.MechConfig) common.Mech {
+		m := &scriptedMech{name: "CLOSE", steps: 2}
+		mechs = append(mechs, m)
+		return m
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithPassword([]byte("secret")))
+	assert.NoError(t, err)
+	clone := cli.Clone()
+
+	// starting again releases the previous mech
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Len(t, mechs, 2)
+	assert.True(t, mechs[0].closed)
+	assert.False(t, mechs[1].closed)
+
+	assert.NoError(t, cli.Close())
+	assert.True(t, mechs[1].closed)
+	assert.False(t, cli.IsEstablished())
+	_, _, err = cli.Step(nil)
+	assert.ErrorIs(t, err, common.ErrNotStarted)
+	answer, err := cli.promptHandlers[common.PromptPassword](common.Prompt{})
+	assert.NoError(t, err)
+	assert.Nil(t, answer)
+
+	// the clone's copy of the password survives
+	answer, err = clone.promptHandlers[common.PromptPassword](common.Prompt{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), answer)
+}
+
+func TestReplayDetection(t *testing.T) {
+	var cfg common.MechConfig
+	r := registry.New()
+	r.MustRegister("REPLAY", func(c common.MechConfig) common.Mech {
+		cfg = c
+		return &scriptedMech{name: "REPLAY", steps: 1}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := NewSaslClient("imap", WithRegistry(r))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.False(t, cfg.ReplayDetect)
+	hash := configHash(t, cli)
+
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithReplayDetection())
 	assert.NoError(t, err)
-	_, err = cli.Start()
+	_, _, err = cli.Start()
 	assert.NoError(t, err)
-	assert.IsType(t, &mockMech2{}, cli.mech)
+	assert.True(t, cfg.ReplayDetect)
+	assert.NotEqual(t, hash, configHash(t, cli))
 }
@@ -0,0 +1,135 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package saslconn authenticates a network connection with a SaslClient and
+// applies the negotiated security layer to the data sent over it.
+//
+// Once a layer is negotiated each wrapped token is sent as a 4-byte big-endian
+// length followed by the token, the framing used by LDAP (RFC 4422 § 3.7), IMAP
+// and most other protocols.  The application protocol's authentication
+// commands are supplied by a TokenExchanger.
+package saslconn
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// TokenExchanger sends SASL messages using the application protocol's
+// authentication commands, eg. IMAP AUTHENTICATE or an LDAP bind request.
+// done is true once the server reports success, in which case challenge holds
+// any additional data sent with the success response, or nil if there was none.
+// A rejection by the server is reported as an error.
+type TokenExchanger interface {
+	// Start begins authentication with mech.  initialResponse is nil if the
+	// mechanism waits for the server to go first.
+	Start(mech string, initialResponse []byte) (challenge []byte, done bool, err error)
+
+	// Next sends the response to the server's last challenge
+	Next(response []byte) (challenge []byte, done bool, err error)
+}
+
+// Canceler is implemented by TokenExchangers that can abort the exchange if the
+// client fails part way through, eg. by sending "*" to an IMAP server
+type Canceler interface {
+	Cancel() error
+}
+
+// Client authenticates conn using client and exchange, and returns a connection
+// that applies the negotiated security layer.  If no layer was negotiated, conn
+// itself is returned.  The handshake must not be started already.
+func Client(conn net.Conn, client *sasl.SaslClient, exchange TokenExchanger) (net.Conn, error) {
+	if err := Handshake(client, exchange); err != nil {
+		return nil, err
+	}
+
+	params, err := client.ContextParams()
+	if err != nil {
+		return nil, err
+	}
+
+	if params.SSF == 0 {
+		return conn, nil
+	}
+
+	return &Conn{Conn: conn, r: client.NewDecodingReader(conn), w: client.NewEncodingWriter(conn)}, nil
+}
+
+// Handshake authenticates using client and exchange without installing a
+// security layer, for protocols that don't use one
+func Handshake(client *sasl.SaslClient, exchange TokenExchanger) error {
+	mech, response, err := client.Start()
+	if err != nil {
+		return err
+	}
+
+	challenge, done, err := exchange.Start(mech, response)
+	for err == nil && !done {
+		if response, _, err = client.Step(challenge); err != nil {
+			if c, ok := exchange.(Canceler); ok {
+				c.Cancel()
+			}
+			return err
+		}
+		challenge, done, err = exchange.Next(response)
+	}
+	if err != nil {
+		return err
+	}
+
+	// mechs that can't tell when the exchange is over need the success data,
+	// or nil, to establish the context
+	if !client.IsEstablished() {
+		if _, _, err = client.Step(challenge); err != nil {
+			return err
+		}
+	}
+
+	if !client.IsEstablished() {
+		return fmt.Errorf("saslconn: server reported success before %s finished: %w", mech, common.ErrProtocol)
+	}
+
+	return nil
+}
+
+// Conn is a net.Conn whose data is protected by a SASL security layer
+type Conn struct {
+	net.Conn
+
+	readMu sync.Mutex
+	r      *sasl.DecodingReader
+
+	writeMu sync.Mutex
+	w       *sasl.EncodingWriter
+}
+
+// Read returns data from the next security layer token once any previously
+// decoded data has been consumed
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	return c.r.Read(b)
+}
+
+// Write encodes b as one or more security layer tokens, each small enough for
+// the server to accept
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if _, err := c.w.Write(b); err != nil {
+		return 0, err
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
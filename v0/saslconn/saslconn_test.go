@@ -0,0 +1,179 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package saslconn
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// layerMech establishes after one challenge;  its security layer wraps data in
+// square brackets
+type layerMech struct {
+	ssf         uint
+	established bool
+}
+
+func (m *layerMech) Name() string                     { return "LAYER" }
+func (m *layerMech) MechProperties() common.MechProps { return common.MechProps{} }
+func (m *layerMech) IsEstablished() bool              { return m.established }
+func (m *layerMech) Close() error                     { return nil }
+
+func (m *layerMech) ContextParams() common.ContextParams {
+	return common.ContextParams{SSF: m.ssf, MaxPeerMessageSize: 4}
+}
+
+func (m *layerMech) Step(in []byte) ([]byte, common.StepStatus, error) {
+	if in == nil {
+		return []byte("hello"), common.StepContinue, nil
+	}
+	if string(in) != "challenge" {
+		return nil, common.StepContinue, common.ErrBadToken
+	}
+	m.established = true
+	return []byte("response"), common.StepDoneWithFinalToken, nil
+}
+
+func (m *layerMech) Encode(in []byte) ([]byte, error) {
+	return append(append([]byte("["), in...), ']'), nil
+}
+
+func (m *layerMech) Decode(in []byte) ([]byte, error) {
+	if len(in) < 2 || in[0] != '[' || in[len(in)-1] != ']' {
+		return nil, common.ErrBadToken
+	}
+	return in[1 : len(in)-1], nil
+}
+
+// fakeExchange records the messages sent to the server
+type fakeExchange struct {
+	sent []string
+	err  error
+}
+
+func (e *fakeExchange) Start(mech string, ir []byte) ([]byte, bool, error) {
+	e.sent = append(e.sent, mech+" "+string(ir))
+	return []byte("challenge"), false, nil
+}
+
+func (e *fakeExchange) Next(response []byte) ([]byte, bool, error) {
+	e.sent = append(e.sent, string(response))
+	return nil, true, e.err
+}
+
+// cancelExchange sends a challenge the client can't handle
+type cancelExchange struct {
+	fakeExchange
+	cancelled bool
+}
+
+func (e *cancelExchange) Start(mech string, ir []byte) ([]byte, bool, error) {
+	return []byte("garbage"), false, nil
+}
+
+func (e *cancelExchange) Cancel() error {
+	e.cancelled = true
+	return nil
+}
+
+func newClient(t *testing.T, ssf uint) *sasl.SaslClient {
+	r := registry.New()
+	r.MustRegister("LAYER", func(common.MechConfig) common.Mech {
+		return &layerMech{ssf: ssf}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := sasl.NewSaslClient("ldap", sasl.WithRegistry(r), sasl.WithMaxBufSize(16))
+	assert.NoError(t, err)
+	return &cli
+}
+
+func frame(token string) []byte {
+	b := make([]byte, 4+len(token))
+	binary.BigEndian.PutUint32(b, uint32(len(token)))
+	copy(b[4:], token)
+	return b
+}
+
+func TestNoLayer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ex := &fakeExchange{}
+	conn, err := Client(client, newClient(t, 0), ex)
+	assert.NoError(t, err)
+	assert.Equal(t, client, conn)
+	assert.Equal(t, []string{"LAYER hello", "response"}, ex.sent)
+}
+
+func TestRejected(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	rejected := errors.New("invalid credentials")
+	_, err := Client(client, newClient(t, 56), &fakeExchange{err: rejected})
+	assert.ErrorIs(t, err, rejected)
+}
+
+func TestCancel(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ex := &cancelExchange{}
+	_, err := Client(client, newClient(t, 56), ex)
+	assert.ErrorIs(t, err, common.ErrBadToken)
+	assert.True(t, ex.cancelled)
+}
+
+func TestLayer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn, err := Client(client, newClient(t, 56), &fakeExchange{})
+	assert.NoError(t, err)
+
+	// writes are split into messages the server accepts
+	received := make(chan []byte)
+	go func() {
+		b := make([]byte, len(frame("[abcd]"))+len(frame("[ef]")))
+		io.ReadFull(server, b)
+		received <- b
+	}()
+	n, err := conn.Write([]byte("abcdef"))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, append(frame("[abcd]"), frame("[ef]")...), <-received)
+
+	// tokens are reassembled across short reads
+	go func() {
+		wire := append(frame("[hello]"), frame("[world]")...)
+		for _, c := range wire {
+			server.Write([]byte{c})
+		}
+	}()
+	buf := make([]byte, 3)
+	var got []byte
+	for len(got) < 10 {
+		n, err := conn.Read(buf)
+		assert.NoError(t, err)
+		got = append(got, buf[:n]...)
+	}
+	assert.Equal(t, "helloworld", string(got))
+
+	// tokens larger than we advertised are refused
+	go server.Write(frame("[0123456789abcdef]"))
+	_, err = conn.Read(buf)
+	assert.ErrorIs(t, err, common.ErrBadToken)
+}
@@ -0,0 +1,291 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package saslmock provides a scripted mechanism for testing code that drives
+// a SaslClient, such as protocol integrations, without a KDC or other server
+// infrastructure.
+//
+//	m := saslmock.New("GSSAPI",
+//		saslmock.WithSteps(
+//			saslmock.Step{Send: []byte("hello")},
+//			saslmock.Step{Expect: []byte("welcome"), Send: []byte("bye")},
+//		))
+//	r := registry.New()
+//	m.Register(r)
+//	client, err := sasl.NewSaslClient("imap", sasl.WithRegistry(r))
+package saslmock
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+)
+
+// DefaultProps are the properties of a mock mechanism unless WithProps is
+// used;  they satisfy the SASL client's default security requirements
+var DefaultProps = common.MechProps{
+	SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous,
+}
+
+// Step is one step of a script
+type Step struct {
+	// Expect is the message the mech must receive, if not nil.  Anything else
+	// fails the step with common.ErrBadToken.
+	Expect []byte
+
+	// Send is the mech's response.  A nil Send on the last step completes the
+	// exchange with StepDone instead of StepDoneWithFinalToken.
+	Send []byte
+
+	// Err fails the step
+	Err error
+}
+
+// Mech is a scripted mechanism.  Each call to Step plays the next step of the
+// script, and the mech is established after the last one.  If the context
+// parameters have an SSF the security layer returns data unchanged, unless an
+// error is injected or WithLayer replaces it.
+type Mech struct {
+	name      string
+	steps     []Step
+	props     common.MechProps
+	params    common.ContextParams
+	encodeErr error
+	decodeErr error
+	closeErr  error
+	encode    func([]byte) ([]byte, error)
+	decode    func([]byte) ([]byte, error)
+
+	mu        sync.Mutex
+	config    common.MechConfig
+	next      int
+	received  [][]byte
+	closed    bool
+	instances []*Mech
+}
+
+var _ common.Mech = (*Mech)(nil)
+
+// Option configures a Mech
+type Option func(*Mech)
+
+// New returns a mock mechanism called name.  Without WithSteps it completes in
+// a single step with an empty final token.
+func New(name string, opts ...Option) *Mech {
+	m := &Mech{
+		name:  name,
+		steps: []Step{{Send: []byte{}}},
+		props: DefaultProps,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// WithSteps sets the script
+func WithSteps(steps ...Step) Option {
+	return func(m *Mech) {
+		m.steps = steps
+	}
+}
+
+// WithProps overrides the mechanism's properties, which are also used to
+// register it
+func WithProps(props common.MechProps) Option {
+	return func(m *Mech) {
+		m.props = props
+	}
+}
+
+// WithContextParams sets the parameters reported once the mech is established,
+// eg. an SSF to make the client apply the security layer
+func WithContextParams(params common.ContextParams) Option {
+	return func(m *Mech) {
+		m.params = params
+	}
+}
+
+// WithLayer replaces the security layer, eg. with one that frames the data so
+// that tests can see it was applied.  It is only used once the mech is
+// established with an SSF.
+func WithLayer(encode, decode func([]byte) ([]byte, error)) Option {
+	return func(m *Mech) {
+		m.encode = encode
+		m.decode = decode
+	}
+}
+
+// WithEncodeError makes Encode fail
+func WithEncodeError(err error) Option {
+	return func(m *Mech) {
+		m.encodeErr = err
+	}
+}
+
+// WithDecodeError makes Decode fail
+func WithDecodeError(err error) Option {
+	return func(m *Mech) {
+		m.decodeErr = err
+	}
+}
+
+// WithCloseError makes Close fail
+func WithCloseError(err error) Option {
+	return func(m *Mech) {
+		m.closeErr = err
+	}
+}
+
+// Factory returns a factory that creates a fresh copy of m for each SASL
+// client.  The copies are available from Instances.
+func (m *Mech) Factory() registry.MechFactory {
+	return func(cfg common.MechConfig) common.Mech {
+		c := &Mech{
+			name:      m.name,
+			steps:     m.steps,
+			props:     m.props,
+			params:    m.params,
+			encodeErr: m.encodeErr,
+			decodeErr: m.decodeErr,
+			closeErr:  m.closeErr,
+			encode:    m.encode,
+			decode:    m.decode,
+			config:    cfg,
+		}
+
+		m.mu.Lock()
+		m.instances = append(m.instances, c)
+		m.mu.Unlock()
+		return c
+	}
+}
+
+// Register adds m to r under its name, with its properties
+func (m *Mech) Register(r *registry.Registry) error {
+	return r.Register(m.name, m.Factory(), m.props)
+}
+
+// Mechanism returns m for sasl.Enable or sasl.WithMechanisms
+func (m *Mech) Mechanism() registry.Mechanism {
+	return registry.Mechanism{Name: m.name, Factory: m.Factory(), Props: m.props}
+}
+
+// Instances returns the mechs created by the factory, oldest first
+func (m *Mech) Instances() []*Mech {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Mech{}, m.instances...)
+}
+
+// Config returns the configuration the mech was created with
+func (m *Mech) Config() common.MechConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.config
+}
+
+// Received returns the messages passed to Step, starting with nil unless the
+// mechanism is registered with common.FeatServerFirst
+func (m *Mech) Received() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte{}, m.received...)
+}
+
+// Closed reports whether Close was called
+func (m *Mech) Closed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+func (m *Mech) Name() string {
+	return m.name
+}
+
+func (m *Mech) MechProperties() common.MechProps {
+	return m.props
+}
+
+func (m *Mech) IsEstablished() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.next == len(m.steps)
+}
+
+func (m *Mech) ContextParams() common.ContextParams {
+	return m.params
+}
+
+func (m *Mech) Step(inToken []byte) ([]byte, common.StepStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.received = append(m.received, inToken)
+	switch {
+	case m.closed:
+		return nil, common.StepContinue, common.ErrClosed
+	case m.next == len(m.steps):
+		return nil, common.StepDone, common.ErrAlreadyEstablished
+	}
+
+	step := m.steps[m.next]
+	switch {
+	case step.Err != nil:
+		return nil, common.StepContinue, step.Err
+	case step.Expect != nil && !bytes.Equal(inToken, step.Expect):
+		return nil, common.StepContinue, fmt.Errorf("saslmock: %s: step %d got %q, expected %q: %w", m.name, m.next+1, inToken, step.Expect, common.ErrBadToken)
+	}
+
+	m.next++
+	switch {
+	case m.next < len(m.steps):
+		return step.Send, common.StepContinue, nil
+	case step.Send == nil:
+		return nil, common.StepDone, nil
+	}
+	return step.Send, common.StepDoneWithFinalToken, nil
+}
+
+func (m *Mech) Encode(input []byte) ([]byte, error) {
+	if err := m.layerErr(m.encodeErr); err != nil {
+		return nil, err
+	}
+	if m.encode != nil {
+		return m.encode(input)
+	}
+	return append([]byte{}, input...), nil
+}
+
+func (m *Mech) Decode(inputToken []byte) ([]byte, error) {
+	if err := m.layerErr(m.decodeErr); err != nil {
+		return nil, err
+	}
+	if m.decode != nil {
+		return m.decode(inputToken)
+	}
+	return append([]byte{}, inputToken...), nil
+}
+
+// layerErr returns the reason the security layer can't be used, if any
+func (m *Mech) layerErr(injected error) error {
+	switch {
+	case !m.IsEstablished():
+		return common.ErrNotEstablished
+	case m.params.SSF == 0:
+		return common.ErrNoLayer
+	}
+	return injected
+}
+
+func (m *Mech) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return m.closeErr
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package saslmock
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClient(t *testing.T, m *Mech) *sasl.SaslClient {
+	r := registry.New()
+	assert.NoError(t, m.Register(r))
+
+	cli, err := sasl.NewSaslClient("imap", sasl.WithRegistry(r), sasl.WithServerFQDN("imap.example.com"))
+	assert.NoError(t, err)
+	return &cli
+}
+
+func TestScript(t *testing.T) {
+	m := New("MOCK", WithSteps(
+		Step{Send: []byte("hello")},
+		Step{Expect: []byte("welcome"), Send: []byte("bye")},
+	))
+	cli := newClient(t, m)
+
+	mech, ir, err := cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "MOCK", mech)
+	assert.Equal(t, []byte("hello"), ir)
+	assert.False(t, cli.IsEstablished())
+
+	out, status, err := cli.Step([]byte("welcome"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("bye"), out)
+	assert.Equal(t, common.StepDoneWithFinalToken, status)
+	assert.True(t, cli.IsEstablished())
+
+	instances := m.Instances()
+	assert.Len(t, instances, 1)
+	assert.Equal(t, [][]byte{nil, []byte("welcome")}, instances[0].Received())
+	assert.Equal(t, "imap.example.com", instances[0].Config().ServerFQDN)
+
+	assert.NoError(t, cli.Close())
+	assert.True(t, instances[0].Closed())
+}
+
+func TestScriptFailures(t *testing.T) {
+	// an unexpected challenge
+	cli := newClient(t, New("MOCK", WithSteps(Step{Send: []byte{}}, Step{Expect: []byte("ok")})))
+	_, _, err := cli.Start()
+	assert.NoError(t, err)
+	_, _, err = cli.Step([]byte("not ok"))
+	assert.ErrorIs(t, err, common.ErrBadToken)
+
+	// an injected failure
+	failure := errors.New("injected")
+	cli = newClient(t, New("MOCK", WithSteps(Step{Err: failure})))
+	_, _, err = cli.Start()
+	assert.ErrorIs(t, err, failure)
+
+	// a script with nothing to send at the end
+	m := New("MOCK", WithSteps(Step{Send: []byte{}}, Step{}))
+	m.Factory()(common.MechConfig{})
+	mech := m.Instances()[0]
+	_, status, err := mech.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, common.StepContinue, status)
+	_, status, err = mech.Step([]byte("done"))
+	assert.NoError(t, err)
+	assert.Equal(t, common.StepDone, status)
+	_, _, err = mech.Step(nil)
+	assert.ErrorIs(t, err, common.ErrAlreadyEstablished)
+}
+
+func TestLayer(t *testing.T) {
+	m := New("MOCK", WithContextParams(common.ContextParams{SSF: 56}), WithDecodeError(common.ErrBadToken))
+	cli := newClient(t, m)
+	_, _, err := cli.Start()
+	assert.NoError(t, err)
+
+	out, err := cli.Encode([]byte("data"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), out)
+	_, err = cli.Decode([]byte("data"))
+	assert.ErrorIs(t, err, common.ErrBadToken)
+
+	// a replacement layer
+	upper := func(b []byte) ([]byte, error) { return bytes.ToUpper(b), nil }
+	lower := func(b []byte) ([]byte, error) { return bytes.ToLower(b), nil }
+	m = New("MOCK", WithContextParams(common.ContextParams{SSF: 56}), WithLayer(upper, lower))
+	cli = newClient(t, m)
+	_, err = cli.Encode([]byte("data"))
+	assert.ErrorIs(t, err, common.ErrNotStarted)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	out, err = cli.Encode([]byte("data"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("DATA"), out)
+	out, err = cli.Decode([]byte("DATA"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), out)
+}
+
+func TestProps(t *testing.T) {
+	// plain text mechs aren't chosen by default
+	m := New("MOCK", WithProps(common.MechProps{}))
+	r := registry.New()
+	assert.NoError(t, m.Register(r))
+	assert.Equal(t, common.MechProps{}, r.Properties("MOCK"))
+
+	cli, err := sasl.NewSaslClient("imap", sasl.WithRegistry(r))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasltest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// the longest exchange the suite will drive
+const maxSteps = 32
+
+// behavior is one entry of the conformance suite
+type behavior struct {
+	name string
+	test func(t *testing.T, newMech func() common.Mech, newServer func() Server)
+}
+
+var behaviors = []behavior{
+	{"Name", testName},
+	{"Unestablished", testUnestablished},
+	{"Exchange", testExchange},
+	{"StepAfterEstablished", testStepAfterEstablished},
+	{"Layer", testLayer},
+	{"MalformedChallenge", testMalformedChallenge},
+	{"Close", testClose},
+}
+
+// Conformance runs a subtest for each behavior that every common.Mech must
+// have.  newMech returns a new mech, as its factory would;  newServer returns
+// the server side of the mechanism, which must accept the mech's credentials.
+func Conformance(t *testing.T, newMech func() common.Mech, newServer func() Server) {
+	for _, b := range behaviors {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			b.test(t, newMech, newServer)
+		})
+	}
+}
+
+var errPanicked = errors.New("panicked")
+
+// call returns the error from f, failing t if f panics
+func call(t *testing.T, what string, f func() error) (err error) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("%s panicked: %v", what, r)
+			err = errPanicked
+		}
+	}()
+	return f()
+}
+
+func serverFirst(m common.Mech) bool {
+	return m.MechProperties().Fearures&common.FeatServerFirst != 0
+}
+
+// exchange authenticates m against s the way saslconn does, checking the status
+// of each step, and fails t if the mech isn't established at the end
+func exchange(t *testing.T, m common.Mech, s Server) {
+	t.Helper()
+
+	var challenge []byte
+	var done bool
+	var err error
+	if serverFirst(m) {
+		if challenge, done, err = s.Step(nil); err != nil {
+			t.Fatalf("server's first step: %s", err)
+		}
+	}
+
+	for i := 1; !done; i++ {
+		if i > maxSteps {
+			t.Fatalf("exchange didn't finish in %d steps", maxSteps)
+		}
+
+		response, status, err := m.Step(challenge)
+		if err != nil {
+			t.Fatalf("step %d: %s", i, err)
+		}
+		checkStatus(t, m, i, response, status)
+
+		if challenge, done, err = s.Step(response); err != nil {
+			t.Fatalf("server rejected step %d: %s", i, err)
+		}
+	}
+
+	// the server's success data, or nil
+	if !m.IsEstablished() {
+		response, status, err := m.Step(challenge)
+		if err != nil {
+			t.Fatalf("final step: %s", err)
+		}
+		if response != nil {
+			t.Errorf("final step returned a token after the server reported success")
+		}
+		if status == common.StepContinue {
+			t.Errorf("final step returned %s", status)
+		}
+	}
+
+	if !m.IsEstablished() {
+		t.Fatalf("not established after the server reported success")
+	}
+}
+
+// checkStatus checks that the status of a step agrees with its token and with
+// IsEstablished
+func checkStatus(t *testing.T, m common.Mech, step int, response []byte, status common.StepStatus) {
+	t.Helper()
+
+	switch status {
+	case common.StepContinue:
+		if m.IsEstablished() {
+			t.Errorf("step %d: established, but returned %s", step, status)
+		}
+	case common.StepDone:
+		if response != nil {
+			t.Errorf("step %d: returned a token with %s", step, status)
+		}
+		if !m.IsEstablished() {
+			t.Errorf("step %d: not established, but returned %s", step, status)
+		}
+	case common.StepDoneWithFinalToken:
+		if response == nil {
+			t.Errorf("step %d: returned a nil token with %s;  an empty token must be []byte{}", step, status)
+		}
+		if !m.IsEstablished() {
+			t.Errorf("step %d: not established, but returned %s", step, status)
+		}
+	default:
+		t.Errorf("step %d: unknown status %d", step, status)
+	}
+}
+
+func testName(t *testing.T, newMech func() common.Mech, newServer func() Server) {
+	m := newMech()
+	defer m.Close()
+
+	if !wire.ValidMechName(m.Name()) {
+		t.Errorf("%q isn't a valid mechanism name", m.Name())
+	}
+	if s := newServer(); s.Name() != m.Name() {
+		t.Errorf("mech is %s, server is %s", m.Name(), s.Name())
+	}
+}
+
+func testUnestablished(t *testing.T, newMech func() common.Mech, newServer func() Server) {
+	m := newMech()
+	defer m.Close()
+
+	if m.IsEstablished() {
+		t.Fatalf("established before the first step")
+	}
+	if err := call(t, "Encode", func() error { _, err := m.Encode([]byte("data")); return err }); err == nil {
+		t.Errorf("Encode succeeded before establishment")
+	}
+	if err := call(t, "Decode", func() error { _, err := m.Decode([]byte("data")); return err }); err == nil {
+		t.Errorf("Decode succeeded before establishment")
+	}
+}
+
+func testExchange(t *testing.T, newMech func() common.Mech, newServer func() Server) {
+	m, s := newMech(), newServer()
+	defer m.Close()
+
+	exchange(t, m, s)
+
+	if got, want := m.ContextParams().SSF, s.ContextParams().SSF; got != want {
+		t.Errorf("SSF is %d, server's is %d", got, want)
+	}
+	if ssf, max := m.ContextParams().SSF, m.MechProperties().MaxSSF; ssf > max && max > 0 {
+		t.Errorf("SSF %d is more than the mech's maximum %d", ssf, max)
+	}
+}
+
+func testStepAfterEstablished(t *testing.T, newMech func() common.Mech, newServer func() Server) {
+	m := newMech()
+	defer m.Close()
+	exchange(t, m, newServer())
+
+	if err := call(t, "Step", func() error { _, _, err := m.Step([]byte("again")); return err }); err == nil {
+		t.Errorf("Step succeeded after establishment")
+	}
+	if !m.IsEstablished() {
+		t.Errorf("no longer established after an extra step")
+	}
+}
+
+func testLayer(t *testing.T, newMech func() common.Mech, newServer func() Server) {
+	m, s := newMech(), newServer()
+	defer m.Close()
+	exchange(t, m, s)
+
+	if m.ContextParams().SSF == 0 {
+		_, err := m.Encode([]byte("data"))
+		if err == nil {
+			t.Errorf("Encode succeeded without a security layer")
+		}
+		return
+	}
+
+	for _, msg := range [][]byte{[]byte("data"), {}} {
+		token, err := m.Encode(msg)
+		if err != nil {
+			t.Fatalf("Encode: %s", err)
+		}
+		if got, err := s.Decode(token); err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("server decoded %q as %q, %v", msg, got, err)
+		}
+
+		if token, err = s.Encode(msg); err != nil {
+			t.Fatalf("server Encode: %s", err)
+		}
+		if got, err := m.Decode(token); err != nil || !bytes.Equal(got, msg) {
+			t.Errorf("decoded %q as %q, %v", msg, got, err)
+		}
+	}
+
+	for _, token := range [][]byte{nil, {}, {0xff}, bytes.Repeat([]byte{0xff}, 100)} {
+		call(t, fmt.Sprintf("Decode(%x)", token), func() error { _, err := m.Decode(token); return err })
+	}
+}
+
+func testMalformedChallenge(t *testing.T, newMech func() common.Mech, newServer func() Server) {
+	for _, challenge := range [][]byte{{}, {0xff}, bytes.Repeat([]byte{0xff}, 100)} {
+		m := newMech()
+		if !serverFirst(m) {
+			if _, _, err := m.Step(nil); err != nil {
+				t.Fatalf("first step: %s", err)
+			}
+		}
+
+		if m.IsEstablished() {
+			m.Close()
+			continue
+		}
+
+		err := call(t, fmt.Sprintf("Step(%x)", challenge), func() error { _, _, err := m.Step(challenge); return err })
+		if err != nil && err != errPanicked && m.IsEstablished() {
+			t.Errorf("Step(%x) failed but established the context", challenge)
+		}
+		m.Close()
+	}
+}
+
+func testClose(t *testing.T, newMech func() common.Mech, newServer func() Server) {
+	m := newMech()
+	exchange(t, m, newServer())
+
+	if err := m.Close(); err != nil {
+		t.Errorf("Close: %s", err)
+	}
+	call(t, "second Close", m.Close)
+	if err := call(t, "Step", func() error { _, _, err := m.Step(nil); return err }); err == nil {
+		t.Errorf("Step succeeded after Close")
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasltest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslmock"
+)
+
+// scriptServer is the server side of a saslmock script
+type scriptServer struct {
+	name   string
+	expect [][]byte // the client's messages
+	send   [][]byte // the challenge after each one
+	ssf    uint
+}
+
+func (s *scriptServer) Name() string { return s.name }
+
+func (s *scriptServer) Step(response []byte) ([]byte, bool, error) {
+	if len(s.expect) == 0 || !bytes.Equal(response, s.expect[0]) {
+		return nil, false, common.ErrAuthFailed
+	}
+	challenge := s.send[0]
+	s.expect, s.send = s.expect[1:], s.send[1:]
+	return challenge, len(s.expect) == 0, nil
+}
+
+func (s *scriptServer) ContextParams() common.ContextParams {
+	return common.ContextParams{SSF: s.ssf}
+}
+
+func (s *scriptServer) Encode(in []byte) ([]byte, error) { return in, nil }
+func (s *scriptServer) Decode(in []byte) ([]byte, error) { return in, nil }
+
+func TestConformance(t *testing.T) {
+	t.Run("kerb", func(t *testing.T) {
+		Conformance(t,
+			func() common.Mech { return &kerbMech{ssf: 56} },
+			func() Server { return &kerbServer{kerbMech: kerbMech{ssf: 56}} })
+	})
+
+	for _, ssf := range []uint{0, 56} {
+		ssf := ssf
+		mock := saslmock.New("MOCK",
+			saslmock.WithSteps(
+				saslmock.Step{Send: []byte("hello")},
+				saslmock.Step{Expect: []byte("welcome"), Send: []byte("bye")},
+			),
+			saslmock.WithContextParams(common.ContextParams{SSF: ssf}))
+
+		t.Run("saslmock", func(t *testing.T) {
+			Conformance(t, func() common.Mech { return mock.Factory()(common.MechConfig{}) }, func() Server {
+				return &scriptServer{
+					name:   "MOCK",
+					expect: [][]byte{[]byte("hello"), []byte("bye")},
+					send:   [][]byte{[]byte("welcome"), nil},
+					ssf:    ssf,
+				}
+			})
+		})
+	}
+}
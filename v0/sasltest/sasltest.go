@@ -0,0 +1,301 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package sasltest runs a SaslClient against the server side of a mechanism
+// over an in-memory pipe, for testing mechanisms and protocol integrations.
+//
+// go-sasl has no server mechanisms yet, so the server side is supplied by the
+// caller as a Server, eg. a wrapper around a Kerberos acceptor.  Conformance
+// uses the same Server to check a mechanism's implementation of common.Mech.
+package sasltest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/saslconn"
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// Server is the server side of a mechanism
+type Server interface {
+	Name() string
+
+	// Step processes a message from the client and returns the next
+	// challenge.  The first message is the client's initial response, or nil
+	// if it didn't send one.  done is true once the client is authenticated,
+	// in which case challenge is the additional data to send with the
+	// success response, or nil.
+	Step(response []byte) (challenge []byte, done bool, err error)
+
+	// ContextParams describes the established context;  only SSF and
+	// MaxPeerMessageSize are used
+	ContextParams() common.ContextParams
+
+	Encode(input []byte) ([]byte, error)
+	Decode(inputToken []byte) ([]byte, error)
+}
+
+// ErrRejected is returned to the client when the server's Step fails
+var ErrRejected = fmt.Errorf("sasltest: server rejected the exchange: %w", common.ErrAuthFailed)
+
+// message types sent over the pipe during the handshake
+const (
+	msgNone     = iota // a message with no data, eg. no initial response
+	msgData            // a client response or server challenge
+	msgDone            // success without additional data
+	msgDoneData        // success with additional data
+	msgRejected        // the server failed
+)
+
+// Handshake authenticates client against server over an in-memory pipe and
+// returns both ends of it, each applying the negotiated security layer.  If
+// the server rejects the exchange, its error is returned.
+func Handshake(client *sasl.SaslClient, server Server) (clientConn, serverConn net.Conn, err error) {
+	c, s := net.Pipe()
+
+	var serverErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if serverErr = serve(s, server); serverErr != nil {
+			s.Close()
+		}
+	}()
+
+	clientConn, err = saslconn.Client(c, client, &exchanger{conn: c})
+	if err != nil {
+		c.Close()
+	}
+	wg.Wait()
+
+	switch {
+	case errors.Is(err, ErrRejected) && serverErr != nil:
+		return nil, nil, serverErr
+	case err != nil:
+		s.Close()
+		return nil, nil, err
+	case serverErr != nil:
+		c.Close()
+		return nil, nil, serverErr
+	}
+
+	serverConn = s
+	if server.ContextParams().SSF > 0 {
+		serverConn = &layerConn{Conn: s, server: server}
+	}
+	return clientConn, serverConn, nil
+}
+
+// Check authenticates client against server and reports any failure to t:
+// both sides must finish with the same mechanism and SSF, and data must
+// survive a round trip through the security layer in each direction.  It
+// returns the client's context parameters.
+func Check(t testing.TB, client *sasl.SaslClient, server Server) common.ContextParams {
+	t.Helper()
+
+	clientConn, serverConn, err := Handshake(client, server)
+	if err != nil {
+		t.Fatalf("sasltest: handshake: %s", err)
+	}
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	if !client.IsEstablished() {
+		t.Fatalf("sasltest: client isn't established")
+	}
+
+	params, err := client.ContextParams()
+	if err != nil {
+		t.Fatalf("sasltest: client context parameters: %s", err)
+	}
+	if params.Mech != server.Name() {
+		t.Errorf("sasltest: client used %s, server %s", params.Mech, server.Name())
+	}
+	if ssf := server.ContextParams().SSF; params.SSF != ssf {
+		t.Errorf("sasltest: client SSF %d, server SSF %d", params.SSF, ssf)
+	}
+
+	for _, size := range []int{1, 100, 70000} {
+		msg := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+		if err := roundTrip(clientConn, serverConn, msg); err != nil {
+			t.Errorf("sasltest: client to server, %d bytes: %s", size, err)
+		}
+		if err := roundTrip(serverConn, clientConn, msg); err != nil {
+			t.Errorf("sasltest: server to client, %d bytes: %s", size, err)
+		}
+	}
+
+	return params
+}
+
+// roundTrip writes msg to w and checks that it arrives at r
+func roundTrip(w io.Writer, r io.Reader, msg []byte) error {
+	werr := make(chan error, 1)
+	go func() {
+		_, err := w.Write(msg)
+		werr <- err
+	}()
+
+	got := make([]byte, len(msg))
+	_, err := io.ReadFull(r, got)
+	if e := <-werr; e != nil {
+		return e
+	}
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(got, msg) {
+		return errors.New("data changed in transit")
+	}
+	return nil
+}
+
+// serve runs the server side of the handshake
+func serve(conn net.Conn, server Server) error {
+	mech, err := wire.ReadFrame(conn, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	for first := true; ; first = false {
+		kind, response, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+		if kind == msgNone {
+			response = nil
+		}
+		if first && string(mech) != server.Name() {
+			writeMessage(conn, msgRejected, nil)
+			return fmt.Errorf("sasltest: client chose %s, server is %s: %w", mech, server.Name(), common.ErrProtocol)
+		}
+
+		challenge, done, err := server.Step(response)
+		switch {
+		case err != nil:
+			writeMessage(conn, msgRejected, nil)
+			return err
+		case done && challenge == nil:
+			return writeMessage(conn, msgDone, nil)
+		case done:
+			return writeMessage(conn, msgDoneData, challenge)
+		}
+
+		if err = writeMessage(conn, msgData, challenge); err != nil {
+			return err
+		}
+	}
+}
+
+func writeMessage(w io.Writer, kind byte, data []byte) error {
+	return wire.WriteFrame(w, append([]byte{kind}, data...))
+}
+
+func readMessage(r io.Reader) (kind byte, data []byte, err error) {
+	msg, err := wire.ReadFrame(r, nil, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(msg) == 0 {
+		return 0, nil, fmt.Errorf("sasltest: empty message: %w", common.ErrProtocol)
+	}
+	return msg[0], msg[1:], nil
+}
+
+// exchanger is the client side of the handshake
+type exchanger struct {
+	conn net.Conn
+}
+
+func (e *exchanger) Start(mech string, initialResponse []byte) ([]byte, bool, error) {
+	if err := wire.WriteFrame(e.conn, []byte(mech)); err != nil {
+		return nil, false, err
+	}
+	return e.Next(initialResponse)
+}
+
+func (e *exchanger) Next(response []byte) ([]byte, bool, error) {
+	kind := byte(msgData)
+	if response == nil {
+		kind = msgNone
+	}
+	if err := writeMessage(e.conn, kind, response); err != nil {
+		return nil, false, err
+	}
+
+	kind, challenge, err := readMessage(e.conn)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch kind {
+	case msgData:
+		return challenge, false, nil
+	case msgDone:
+		return nil, true, nil
+	case msgDoneData:
+		return challenge, true, nil
+	case msgRejected:
+		return nil, false, ErrRejected
+	}
+	return nil, false, fmt.Errorf("sasltest: unknown message type %d: %w", kind, common.ErrProtocol)
+}
+
+// layerConn applies the server's security layer, with the framing used by
+// saslconn
+type layerConn struct {
+	net.Conn
+	server Server
+	buf    []byte
+}
+
+func (c *layerConn) Read(b []byte) (int, error) {
+	for len(c.buf) == 0 {
+		token, err := wire.ReadFrame(c.Conn, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		if c.buf, err = c.server.Decode(token); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write sends b in tokens no larger than the client accepts
+func (c *layerConn) Write(b []byte) (int, error) {
+	max := int(c.server.ContextParams().MaxPeerMessageSize)
+	if max == 0 {
+		max = len(b)
+	}
+
+	for n := 0; n < len(b); n += max {
+		end := n + max
+		if end > len(b) {
+			end = len(b)
+		}
+		token, err := c.server.Encode(b[n:end])
+		if err != nil {
+			return n, err
+		}
+		if err = wire.WriteFrame(c.Conn, token); err != nil {
+			return n, err
+		}
+	}
+
+	return len(b), nil
+}
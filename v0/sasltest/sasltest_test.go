@@ -0,0 +1,116 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasltest
+
+import (
+	"testing"
+
+	sasl "github.com/golang-auth/go-sasl"
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// kerbMech imitates GSSAPI: a context token, the server's reply, then the
+// security layer negotiation.  Its layer wraps data in square brackets.
+type kerbMech struct {
+	ssf   uint
+	stage int
+}
+
+func (m *kerbMech) Name() string                     { return "GSSAPI" }
+func (m *kerbMech) MechProperties() common.MechProps { return common.MechProps{} }
+func (m *kerbMech) IsEstablished() bool              { return m.stage == 2 }
+func (m *kerbMech) Close() error                     { m.stage = -1; return nil }
+
+func (m *kerbMech) ContextParams() common.ContextParams {
+	return common.ContextParams{SSF: m.ssf, MaxPeerMessageSize: 1024}
+}
+
+func (m *kerbMech) Step(in []byte) ([]byte, common.StepStatus, error) {
+	switch {
+	case m.stage < 0:
+		return nil, common.StepContinue, common.ErrClosed
+	case m.stage == 2:
+		return nil, common.StepDone, common.ErrAlreadyEstablished
+	case in == nil:
+		return []byte("AP-REQ"), common.StepContinue, nil
+	case m.stage == 0 && string(in) == "AP-REP":
+		m.stage = 1
+		return []byte{}, common.StepContinue, nil
+	case m.stage == 1 && string(in) == "offer":
+		m.stage = 2
+		return []byte("choice"), common.StepDoneWithFinalToken, nil
+	}
+	return nil, common.StepContinue, common.ErrBadToken
+}
+
+func (m *kerbMech) Encode(in []byte) ([]byte, error) {
+	if m.stage != 2 {
+		return nil, common.ErrNotEstablished
+	}
+	return append(append([]byte("["), in...), ']'), nil
+}
+
+func (m *kerbMech) Decode(in []byte) ([]byte, error) {
+	if m.stage != 2 {
+		return nil, common.ErrNotEstablished
+	}
+	if len(in) < 2 || in[0] != '[' || in[len(in)-1] != ']' {
+		return nil, common.ErrBadToken
+	}
+	return in[1 : len(in)-1], nil
+}
+
+// kerbServer is the server side of kerbMech
+type kerbServer struct {
+	kerbMech
+	reject bool
+}
+
+func (s *kerbServer) Step(response []byte) ([]byte, bool, error) {
+	switch {
+	case s.reject:
+		return nil, false, common.ErrAuthFailed
+	case s.stage == 0 && string(response) == "AP-REQ":
+		s.stage = 1
+		return []byte("AP-REP"), false, nil
+	case s.stage == 1 && len(response) == 0:
+		s.stage = 2
+		return []byte("offer"), false, nil
+	case s.stage == 2 && string(response) == "choice":
+		return nil, true, nil
+	}
+	return nil, false, common.ErrBadToken
+}
+
+func newClient(t *testing.T, ssf uint) *sasl.SaslClient {
+	r := registry.New()
+	r.MustRegister("GSSAPI", func(common.MechConfig) common.Mech {
+		return &kerbMech{ssf: ssf}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := sasl.NewSaslClient("imap", sasl.WithRegistry(r))
+	assert.NoError(t, err)
+	return &cli
+}
+
+func TestCheck(t *testing.T) {
+	for _, ssf := range []uint{0, 56} {
+		params := Check(t, newClient(t, ssf), &kerbServer{kerbMech: kerbMech{ssf: ssf}})
+		assert.Equal(t, "GSSAPI", params.Mech)
+		assert.Equal(t, ssf, params.SSF)
+	}
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	_, _, err := Handshake(newClient(t, 0), &kerbServer{reject: true})
+	assert.ErrorIs(t, err, common.ErrAuthFailed)
+
+	// the server gets an unexpected message
+	server := &kerbServer{}
+	server.stage = 1
+	_, _, err = Handshake(newClient(t, 0), server)
+	assert.ErrorIs(t, err, common.ErrBadToken)
+}
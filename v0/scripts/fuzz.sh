@@ -0,0 +1,13 @@
+#!/usr/bin/env bash
+
+# Run each fuzz target for FUZZTIME (default 30s).  go test can only fuzz one
+# target at a time.
+echo "==> Fuzzing token parsers..."
+
+fuzztime=${FUZZTIME:-30s}
+
+grep -r --include='fuzz_test.go' -o '^func Fuzz[A-Za-z0-9]*' . | while IFS=: read -r file fn; do
+    target=${fn#func }
+    echo "--> ${target} ($(dirname "${file}"))"
+    go test -run '^$' -fuzz "^${target}\$" -fuzztime "${fuzztime}" "$(dirname "${file}")" || exit 1
+done
@@ -0,0 +1,203 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package secrets fetches SASL passwords from external secret managers.
+//
+// A Provider is implemented for each secret manager.  Wrapping a provider in a
+// Cache avoids a round trip to the secret manager for every authentication and
+// renews secrets as they approach expiry, so centrally rotated passwords are
+// picked up without restarting.  PasswordCallback connects a provider to a
+// SaslClient:
+//
+//	cache, err := secrets.NewCache(vault)
+//	...
+//	client, err := sasl.NewSaslClient("imap",
+//		sasl.WithPasswordCallback(secrets.PasswordCallback(ctx, cache, "imap/svc-account")))
+package secrets
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+var ErrNotFound = errors.New("secret not found")
+
+// Provider is implemented by secret manager backends
+type Provider interface {
+	// Secret returns the current value of the named secret and how long it
+	// may be cached for.  A zero TTL means the provider doesn't know.
+	Secret(ctx context.Context, name string) (secret *common.Secret, ttl time.Duration, err error)
+}
+
+// ProviderFunc allows an ordinary function to be used as a Provider
+type ProviderFunc func(ctx context.Context, name string) (*common.Secret, time.Duration, error)
+
+func (f ProviderFunc) Secret(ctx context.Context, name string) (*common.Secret, time.Duration, error) {
+	return f(ctx, name)
+}
+
+// PasswordCallback returns a function that fetches the named secret from p, for
+// use with sasl.WithPasswordCallback
+func PasswordCallback(ctx context.Context, p Provider, name string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		secret, _, err := p.Secret(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer secret.Zero()
+
+		return secret.Bytes(), nil
+	}
+}
+
+type CacheOption func(*Cache) error
+
+// Cache is a Provider that caches the secrets returned by another provider.
+// Secrets are fetched again once they are within the refresh window of their
+// expiry;  if that fails the cached secret is used until it expires.
+type Cache struct {
+	provider   Provider
+	defaultTTL time.Duration
+	refresh    time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	mu      sync.Mutex // held while fetching
+	secret  *common.Secret
+	ttl     time.Duration
+	expires time.Time
+}
+
+// NewCache returns a cache in front of p.  By default secrets without a TTL are
+// cached for five minutes and are refreshed in the last fifth of their lifetime.
+func NewCache(p Provider, opts ...CacheOption) (*Cache, error) {
+	c := &Cache{
+		provider:   p,
+		defaultTTL: 5 * time.Minute,
+		now:        time.Now,
+		entries:    make(map[string]*cacheEntry),
+	}
+
+	for _, o := range opts {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// WithDefaultTTL sets how long to cache secrets that the provider returns without a TTL
+func WithDefaultTTL(ttl time.Duration) CacheOption {
+	return func(c *Cache) error {
+		if ttl <= 0 {
+			return errors.New("secrets: default TTL must be positive")
+		}
+		c.defaultTTL = ttl
+		return nil
+	}
+}
+
+// WithRefreshBefore sets how long before expiry a secret is fetched again.  The
+// default is a fifth of the secret's TTL.
+func WithRefreshBefore(d time.Duration) CacheOption {
+	return func(c *Cache) error {
+		c.refresh = d
+		return nil
+	}
+}
+
+// Secret returns a copy of the cached secret, fetching it if necessary.  Only
+// one fetch per name is made at a time: concurrent callers wait for its result.
+func (c *Cache) Secret(ctx context.Context, name string) (*common.Secret, time.Duration, error) {
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	if !ok {
+		e = &cacheEntry{}
+		c.entries[name] = e
+	}
+	c.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := c.now()
+	if e.secret != nil && now.Before(e.expires.Add(-c.refreshWindow(e))) {
+		return c.copyOf(e, now)
+	}
+
+	secret, ttl, err := c.provider.Secret(ctx, name)
+	if err != nil {
+		// keep using the old secret until it expires
+		if e.secret != nil && now.Before(e.expires) {
+			return c.copyOf(e, now)
+		}
+		return nil, 0, err
+	}
+
+	if ttl <= 0 {
+		ttl = c.defaultTTL
+	}
+
+	e.secret.Zero()
+	e.secret = secret
+	e.ttl = ttl
+	e.expires = now.Add(ttl)
+
+	return c.copyOf(e, now)
+}
+
+// Invalidate discards a cached secret, for example after the server rejected
+// it, so that the next call fetches it again
+func (c *Cache) Invalidate(name string) {
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	delete(c.entries, name)
+	c.mu.Unlock()
+
+	if ok {
+		e.mu.Lock()
+		e.secret.Zero()
+		e.secret = nil
+		e.mu.Unlock()
+	}
+}
+
+// Close zeroes all the cached secrets
+func (c *Cache) Close() {
+	c.mu.Lock()
+	entries := c.entries
+	c.entries = make(map[string]*cacheEntry)
+	c.mu.Unlock()
+
+	for _, e := range entries {
+		e.mu.Lock()
+		e.secret.Zero()
+		e.secret = nil
+		e.mu.Unlock()
+	}
+}
+
+func (c *Cache) refreshWindow(e *cacheEntry) time.Duration {
+	if c.refresh > 0 {
+		return c.refresh
+	}
+
+	return e.ttl / 5
+}
+
+func (c *Cache) copyOf(e *cacheEntry, now time.Time) (*common.Secret, time.Duration, error) {
+	b := e.secret.Bytes()
+	defer common.Zero(b)
+
+	return common.NewSecret(b), e.expires.Sub(now), nil
+}
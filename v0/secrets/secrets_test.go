@@ -0,0 +1,204 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingProvider struct {
+	mu    sync.Mutex
+	calls int
+	ttl   time.Duration
+	err   error
+}
+
+func (p *countingProvider) Secret(ctx context.Context, name string) (*common.Secret, time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls++
+	if p.err != nil {
+		return nil, 0, p.err
+	}
+
+	return common.NewSecret([]byte(fmt.Sprintf("%s-%d", name, p.calls))), p.ttl, nil
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	p := &countingProvider{ttl: 100 * time.Second}
+	cache, err := NewCache(p)
+	assert.NoError(t, err)
+
+	now := time.Unix(1000, 0)
+	cache.now = func() time.Time { return now }
+
+	// first fetch, then served from the cache
+	s, ttl, err := cache.Secret(ctx, "pw")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pw-1"), s.Bytes())
+	assert.Equal(t, 100*time.Second, ttl)
+
+	now = now.Add(50 * time.Second)
+	s, ttl, err = cache.Secret(ctx, "pw")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pw-1"), s.Bytes())
+	assert.Equal(t, 50*time.Second, ttl)
+	assert.Equal(t, 1, p.calls)
+
+	// the caller's copy can be zeroed without affecting the cache
+	s.Zero()
+	s, _, _ = cache.Secret(ctx, "pw")
+	assert.Equal(t, []byte("pw-1"), s.Bytes())
+
+	// within the last fifth of the TTL the secret is renewed
+	now = now.Add(35 * time.Second)
+	s, _, err = cache.Secret(ctx, "pw")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pw-2"), s.Bytes())
+	assert.Equal(t, 2, p.calls)
+
+	// renewal failures fall back to the cached secret until it expires
+	p.err = errors.New("vault sealed")
+	now = now.Add(90 * time.Second)
+	s, _, err = cache.Secret(ctx, "pw")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pw-2"), s.Bytes())
+
+	now = now.Add(20 * time.Second)
+	_, _, err = cache.Secret(ctx, "pw")
+	assert.EqualError(t, err, "vault sealed")
+
+	// invalidation forces a fetch
+	p.err = nil
+	_, _, err = cache.Secret(ctx, "pw")
+	assert.NoError(t, err)
+	cache.Invalidate("pw")
+	s, _, err = cache.Secret(ctx, "pw")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pw-6"), s.Bytes())
+	cache.Close()
+}
+
+func TestCacheDefaultTTL(t *testing.T) {
+	p := &countingProvider{}
+	cache, err := NewCache(p, WithDefaultTTL(time.Minute), WithRefreshBefore(time.Second))
+	assert.NoError(t, err)
+
+	now := time.Unix(1000, 0)
+	cache.now = func() time.Time { return now }
+
+	_, ttl, err := cache.Secret(context.Background(), "pw")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+
+	now = now.Add(58 * time.Second)
+	_, _, _ = cache.Secret(context.Background(), "pw")
+	assert.Equal(t, 1, p.calls)
+	now = now.Add(time.Second)
+	_, _, _ = cache.Secret(context.Background(), "pw")
+	assert.Equal(t, 2, p.calls)
+
+	_, err = NewCache(p, WithDefaultTTL(0))
+	assert.Error(t, err)
+}
+
+func TestCacheSingleFetch(t *testing.T) {
+	release := make(chan struct{})
+	calls := 0
+	p := ProviderFunc(func(ctx context.Context, name string) (*common.Secret, time.Duration, error) {
+		calls++
+		<-release
+		return common.NewSecret([]byte("pw")), 0, nil
+	})
+	cache, err := NewCache(p)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, _, err := cache.Secret(context.Background(), "pw")
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("pw"), s.Bytes())
+		}()
+	}
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 1, calls)
+}
+
+func TestPasswordCallback(t *testing.T) {
+	p := &countingProvider{}
+	f := PasswordCallback(context.Background(), p, "imap")
+
+	pw, err := f()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("imap-1"), pw)
+
+	p.err = ErrNotFound
+	_, err = f()
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/kv/data/imap/svc":
+			fmt.Fprint(w, `{"lease_duration": 60, "data": {"data": {"password": "hunter2", "pw2": "other"}}}`)
+		case "/v1/secret/data/imap/svc":
+			fmt.Fprint(w, `{"data": {"data": {"password": "default-mount"}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	v, err := NewVault(srv.URL+"/", []byte("s.token"), WithVaultMount("/kv/"), WithHTTPClient(srv.Client()))
+	assert.NoError(t, err)
+
+	s, ttl, err := v.Secret(context.Background(), "imap/svc")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hunter2"), s.Bytes())
+	assert.Equal(t, time.Minute, ttl)
+
+	_, _, err = v.Secret(context.Background(), "imap/missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	v, err = NewVault(srv.URL, []byte("s.token"), WithVaultMount("kv"), WithVaultField("pw3"))
+	assert.NoError(t, err)
+	_, _, err = v.Secret(context.Background(), "imap/svc")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	v, err = NewVault(srv.URL, []byte("s.token"))
+	assert.NoError(t, err)
+	s, ttl, err = v.Secret(context.Background(), "imap/svc")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("default-mount"), s.Bytes())
+	assert.Equal(t, time.Duration(0), ttl)
+
+	v, err = NewVault(srv.URL, []byte("wrong"))
+	assert.NoError(t, err)
+	_, _, err = v.Secret(context.Background(), "imap/svc")
+	assert.Error(t, err)
+	v.Close()
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+type VaultOption func(*Vault) error
+
+// Vault reads secrets from a HashiCorp Vault KV version 2 secrets engine using
+// the Vault HTTP API.  Secret names are paths within the engine's mount.
+type Vault struct {
+	address string
+	token   *common.Secret
+	mount   string
+	field   string
+	client  *http.Client
+}
+
+// NewVault returns a provider that talks to the Vault server at address (eg.
+// https://vault.example.com:8200) using token.  By default secrets are read from
+// the "password" field of secrets in the engine mounted at "secret".
+func NewVault(address string, token []byte, opts ...VaultOption) (*Vault, error) {
+	if _, err := url.Parse(address); err != nil {
+		return nil, fmt.Errorf("secrets: bad Vault address: %w", err)
+	}
+
+	v := &Vault{
+		address: strings.TrimRight(address, "/"),
+		token:   common.NewSecret(token),
+		mount:   "secret",
+		field:   "password",
+		client:  http.DefaultClient,
+	}
+
+	for _, o := range opts {
+		if err := o(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+// WithVaultMount sets the path that the KV engine is mounted at
+func WithVaultMount(mount string) VaultOption {
+	return func(v *Vault) error {
+		v.mount = strings.Trim(mount, "/")
+		return nil
+	}
+}
+
+// WithVaultField sets the field of the secret data that holds the password
+func WithVaultField(field string) VaultOption {
+	return func(v *Vault) error {
+		v.field = field
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to talk to Vault
+func WithHTTPClient(client *http.Client) VaultOption {
+	return func(v *Vault) error {
+		v.client = client
+		return nil
+	}
+}
+
+type vaultKVResponse struct {
+	LeaseDuration int `json:"lease_duration"`
+	Data          struct {
+		Data map[string]string `json:"data"`
+	} `json:"data"`
+}
+
+// Secret implements Provider
+func (v *Vault) Secret(ctx context.Context, name string) (*common.Secret, time.Duration, error) {
+	u := v.address + "/v1/" + v.mount + "/data/" + strings.TrimLeft(name, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	token := v.token.Bytes()
+	defer common.Zero(token)
+	req.Header.Set("X-Vault-Token", string(token))
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, 0, fmt.Errorf("secrets: vault %s: %w", name, ErrNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, 0, fmt.Errorf("secrets: vault %s: %s", name, resp.Status)
+	}
+
+	var kv vaultKVResponse
+	if err := json.NewDecoder(resp.Body).Decode(&kv); err != nil {
+		return nil, 0, fmt.Errorf("secrets: vault %s: %w", name, err)
+	}
+
+	value, ok := kv.Data.Data[v.field]
+	if !ok {
+		return nil, 0, fmt.Errorf("secrets: vault %s has no field %q: %w", name, v.field, ErrNotFound)
+	}
+	if value == "" {
+		return nil, 0, errors.New("secrets: vault " + name + " is empty")
+	}
+
+	return common.NewSecret([]byte(value)), time.Duration(kv.LeaseDuration) * time.Second, nil
+}
+
+// Close zeroes the Vault token
+func (v *Vault) Close() {
+	v.token.Zero()
+}
@@ -0,0 +1,345 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+var (
+	// ErrNotOffered is the reason a client mech is rejected if the server didn't
+	// advertise it
+	ErrNotOffered = errors.New("not offered by the server")
+
+	// ErrNotSupported is the reason a server mech is rejected if it isn't in the
+	// client's mech list
+	ErrNotSupported = errors.New("not supported by the client")
+)
+
+// MechScorer ranks a mechanism that meets the client's requirements;  higher
+// scores are preferred.  Mechanisms with equal scores are tried in mech list
+// order.
+type MechScorer func(name string, props common.MechProps) int
+
+// OrderedPolicy prefers mechanisms in mech list order.  It is the default.
+func OrderedPolicy(name string, props common.MechProps) int {
+	return 0
+}
+
+// StrongestPolicy prefers the mechanism with the highest maximum SSF, then the
+// one with the most security properties, then the one supporting the most
+// protective features, in the same spirit as Cyrus SASL's client mech selection.
+func StrongestPolicy(name string, props common.MechProps) int {
+	ssf := props.MaxSSF
+	if ssf > 0xffff {
+		ssf = 0xffff
+	}
+
+	protective := props.Fearures & (common.FeatChannelBindings | common.FeatDontUseUserPassword)
+
+	return int(ssf)<<16 |
+		bits.OnesCount(uint(props.SecurityProperties))<<8 |
+		bits.OnesCount(uint(protective))
+}
+
+// WithSelectionPolicy sets how the client chooses between mechanisms that all
+// meet its requirements, eg. OrderedPolicy, StrongestPolicy or a custom scorer
+func WithSelectionPolicy(scorer MechScorer) SaslClientOption {
+	return func(c *SaslClient) error {
+		if scorer == nil {
+			return errors.New("nil selection policy")
+		}
+		c.scorer = scorer
+		return nil
+	}
+}
+
+// rankedMechs returns the mech list in the order the selection policy prefers
+func (c SaslClient) rankedMechs() []string {
+	mechs := make([]string, len(c.mechList))
+	copy(mechs, c.mechList)
+
+	if c.scorer == nil {
+		return mechs
+	}
+
+	scores := make(map[string]int, len(mechs))
+	for _, name := range mechs {
+		scores[name] = c.scorer(name, c.registry.Properties(name))
+	}
+
+	sort.SliceStable(mechs, func(i, j int) bool {
+		return scores[mechs[i]] > scores[mechs[j]]
+	})
+
+	return mechs
+}
+
+// checkMech returns the reason that a mech can't be used, or nil if it can.
+// minSSF is the SSF the mech needs to provide on top of any external layer.
+func (c SaslClient) checkMech(name string, minSSF uint, cbDisposition channelBindingDisposition) error {
+	mechProps := c.registry.Properties(name)
+
+	// discard if the mech does not meet the min SSF requirement
+	if minSSF > mechProps.MaxSSF {
+		return fmt.Errorf("max SSF (%d) too low (want %d)", mechProps.MaxSSF, minSSF)
+	}
+
+	wantSecProps := c.secProps
+	if (c.extProps.ssf > c.minSSF) && (c.extProps.ssf > 1) {
+		c.Debugf("mech %s (max SSF %d) upgraded to non-plaintext (external SSF: %d)", name, mechProps.MaxSSF, c.extProps.ssf)
+		wantSecProps &^= common.SecNoPlainText
+	}
+
+	// does mech meet security requirements?
+	if ((wantSecProps ^ mechProps.SecurityProperties) & wantSecProps) != 0 {
+		return errors.New("does not meet security requirements")
+	}
+
+	// does our configuration meet the mech's feature requirements?
+	if isPlus(name) {
+		if c.channelBindings == nil {
+			return errors.New("requires channel bindings")
+		}
+		if !mechProps.SupportsChannelBinding(c.channelBindings.Name) {
+			return fmt.Errorf("does not support %s channel bindings", c.channelBindings.Name)
+		}
+	}
+
+	if cbDisposition == channelBindingDispMust && !mechProps.SupportsChannelBinding(c.channelBindings.Name) {
+		return fmt.Errorf("does not support %s channel bindings", c.channelBindings.Name)
+	}
+
+	if (mechProps.Fearures&common.FeatNeedServerFQDN != 0) && c.serverFQDN == "" {
+		return errors.New("requires server FQDN")
+	}
+
+	// do the mech's features cover the required features?
+	if c.needHTTP && (mechProps.Fearures&common.FeatSupportsHTTP == 0) {
+		return errors.New("does not support HTTP")
+	}
+
+	return nil
+}
+
+// WithFallback makes Start try the next eligible mechanism if the chosen one
+// fails before anything is sent to the server, eg. because there is no Kerberos
+// ticket or the token source is empty.  If every mechanism fails Start returns
+// a FallbackError.
+func WithFallback() SaslClientOption {
+	return func(c *SaslClient) error {
+		c.fallback = true
+		return nil
+	}
+}
+
+// MechError is the failure of a single mechanism
+type MechError struct {
+	Mech string
+	Err  error
+}
+
+func (e MechError) Error() string {
+	return e.Mech + ": " + e.Err.Error()
+}
+
+func (e MechError) Unwrap() error {
+	return e.Err
+}
+
+// FallbackError holds the failures of each mechanism that was tried, in order
+type FallbackError []MechError
+
+func (e FallbackError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return "all mechanisms failed: " + strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the mechanisms failed with target
+func (e FallbackError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ChooseMech limits the client to the mechanisms in serverMechs, the list that
+// the server advertised, and returns the one that Start will use.  rejected
+// holds the reason that each of the other mechanisms offered by either side
+// can't be used;  eligible mechs that are less preferred than the chosen one
+// are not included.
+func (c *SaslClient) ChooseMech(serverMechs []string) (mech string, rejected map[string]error, err error) {
+	if !c.acquire() {
+		return "", nil, common.ErrConcurrentUse
+	}
+	defer c.release()
+
+	c.serverMechs = make(map[string]bool, len(serverMechs))
+	for _, name := range serverMechs {
+		c.serverMechs[strings.ToUpper(strings.TrimSpace(name))] = true
+	}
+
+	mechs, rejected, err := c.eligibleMechs()
+	if err != nil {
+		return "", rejected, err
+	}
+
+	return mechs[0], rejected, nil
+}
+
+// offeredMechs returns the client's mech list limited to those the server
+// offers, if known
+func (c SaslClient) offeredMechs() []string {
+	if c.serverMechs == nil {
+		return c.mechList
+	}
+
+	var mechs []string
+	for _, name := range c.mechList {
+		if c.serverMechs[name] {
+			mechs = append(mechs, name)
+		}
+	}
+
+	return mechs
+}
+
+// eligibleMechs returns the mechs that meet the client's requirements, most
+// preferred first
+func (c SaslClient) eligibleMechs() (mechs []string, rejected map[string]error, err error) {
+	// how much 'extra ssf' do we need if we take the external layer into account?
+	var minSSF uint
+	if c.minSSF < c.extProps.ssf {
+		minSSF = 0
+	} else {
+		minSSF = c.minSSF - c.extProps.ssf
+	}
+
+	cbDisposition, err := c.channelBindingDisposition()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rejected = make(map[string]error)
+	for _, name := range c.rankedMechs() {
+		var reason error
+		if c.serverMechs != nil && !c.serverMechs[name] {
+			reason = ErrNotOffered
+		} else if c.mechPolicy != nil && !c.mechPolicy.Allowed(name) {
+			reason = ErrDenied
+		} else {
+			reason = c.checkMech(name, minSSF, cbDisposition)
+		}
+
+		if reason != nil {
+			c.Debugf("mech %s %s", name, reason)
+			rejected[name] = reason
+			continue
+		}
+
+		mechs = append(mechs, name)
+	}
+
+	for name := range c.serverMechs {
+		if !c.isListed(name) {
+			rejected[name] = ErrNotSupported
+		}
+	}
+
+	if len(mechs) == 0 {
+		return nil, rejected, common.ErrNoMech
+	}
+
+	return preferPlus(mechs), rejected, nil
+}
+
+// recordRejected reports the mechs that eligibleMechs passed over to the
+// metrics sink, in rank order.  Only StartContext calls it so that a ChooseMech
+// before the start doesn't count them twice.
+func (c SaslClient) recordRejected(rejected map[string]error) {
+	if c.metrics == nil {
+		return
+	}
+	for _, name := range c.rankedMechs() {
+		if reason, ok := rejected[name]; ok {
+			c.metrics.MechRejected(name, reason)
+		}
+	}
+}
+
+// plusSuffix names the variant of a mech that uses channel bindings, eg.
+// SCRAM-SHA-256-PLUS (RFC 5802 § 4)
+const plusSuffix = "-PLUS"
+
+func isPlus(name string) bool {
+	return strings.HasSuffix(name, plusSuffix)
+}
+
+// preferPlus moves each -PLUS mech ahead of its base mech.  -PLUS mechs are
+// only eligible when we have channel bindings.
+func preferPlus(mechs []string) []string {
+	pos := make(map[string]int, len(mechs))
+	for i, name := range mechs {
+		pos[name] = i
+	}
+
+	sorted := make([]string, 0, len(mechs))
+	for _, name := range mechs {
+		if isPlus(name) {
+			if _, ok := pos[strings.TrimSuffix(name, plusSuffix)]; ok {
+				// already added with its base mech
+				continue
+			}
+		} else if _, ok := pos[name+plusSuffix]; ok {
+			sorted = append(sorted, name+plusSuffix)
+		}
+		sorted = append(sorted, name)
+	}
+
+	return sorted
+}
+
+// channelBindingFlag returns the GS2 channel binding flag for mech (RFC 5801
+// § 4).  'y' tells the server that we could have used channel bindings, so that
+// it can detect the -PLUS variant being stripped from its mech list.
+func (c SaslClient) channelBindingFlag(mech string) common.CBFlag {
+	switch {
+	case c.channelBindings == nil:
+		return common.CBFlagNone
+	case isPlus(mech):
+		return common.CBFlagUsed
+	}
+
+	// the server's mech list is needed to know that it didn't offer -PLUS
+	plus := mech + plusSuffix
+	if c.serverMechs != nil && !c.serverMechs[plus] && c.isListed(plus) &&
+		c.registry.Properties(plus).SupportsChannelBinding(c.channelBindings.Name) {
+		return common.CBFlagNotOffered
+	}
+
+	return common.CBFlagNone
+}
+
+func (c SaslClient) isListed(name string) bool {
+	for _, m := range c.mechList {
+		if m == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,228 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"crypto/tls"
+	"errors"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func selectionRegistry() *registry.Registry {
+	r := registry.New()
+	for name, props := range map[string]common.MechProps{
+		"SEL-DES":  {MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous},
+		"SEL-AES":  {MaxSSF: 256, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous},
+		"SEL-AES2": {MaxSSF: 256, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous | common.SecMutualAuth},
+		"SEL-NONE": {MaxSSF: 0, SecurityProperties: common.SecNoAnonymous},
+	} {
+		name := name
+		r.MustRegister(name, func(common.MechConfig) common.Mech {
+			return &scriptedMech{name: name, steps: 1}
+		}, props)
+	}
+
+	return r
+}
+
+func TestSelectionPolicy(t *testing.T) {
+	r := selectionRegistry()
+	list := WithMechList([]string{"SEL-NONE", "SEL-DES", "SEL-AES", "SEL-AES2"})
+
+	// first eligible mech in list order
+	cli, err := NewSaslClient("imap", WithRegistry(r), list)
+	assert.NoError(t, err)
+	mech, _, err := cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "SEL-DES", mech)
+
+	cli, err = NewSaslClient("imap", WithRegistry(r), list, WithSelectionPolicy(OrderedPolicy))
+	assert.NoError(t, err)
+	mech, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "SEL-DES", mech)
+	orderedHash := configHash(t, cli)
+
+	// highest SSF, then most security properties
+	cli, err = NewSaslClient("imap", WithRegistry(r), list, WithSelectionPolicy(StrongestPolicy))
+	assert.NoError(t, err)
+	mech, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "SEL-AES2", mech)
+	assert.Equal(t, []string{"SEL-AES2", "SEL-AES", "SEL-DES", "SEL-NONE"}, cli.rankedMechs())
+	assert.NotEqual(t, orderedHash, configHash(t, cli))
+
+	// the policy only ranks mechs that pass the filters
+	cli, err = NewSaslClient("imap", WithRegistry(r), list, WithSelectionPolicy(func(name string, props common.MechProps) int {
+		if name == "SEL-NONE" {
+			return 100
+		}
+		return 0
+	}))
+	assert.NoError(t, err)
+	mech, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "SEL-DES", mech)
+
+	_, err = NewSaslClient("imap", WithRegistry(r), WithSelectionPolicy(nil))
+	assert.Error(t, err)
+}
+
+func TestChooseMech(t *testing.T) {
+	r := selectionRegistry()
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"SEL-NONE", "SEL-DES", "SEL-AES", "SEL-AES2"}))
+	assert.NoError(t, err)
+
+	// the server doesn't offer SEL-DES, and offers SCRAM which the client doesn't know
+	mech, rejected, err := cli.ChooseMech([]string{"SCRAM-SHA-256", "sel-aes2", "SEL-NONE", " SEL-AES "})
+	assert.NoError(t, err)
+	assert.Equal(t, "SEL-AES", mech)
+	assert.Len(t, rejected, 3)
+	assert.ErrorIs(t, rejected["SEL-DES"], ErrNotOffered)
+	assert.ErrorIs(t, rejected["SCRAM-SHA-256"], ErrNotSupported)
+	assert.EqualError(t, rejected["SEL-NONE"], "does not meet security requirements")
+
+	// Start uses the same choice
+	mech, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "SEL-AES", mech)
+
+	// nothing in common
+	mech, rejected, err = cli.ChooseMech([]string{"PLAIN", "SEL-NONE"})
+	assert.ErrorIs(t, err, common.ErrNoMech)
+	assert.Equal(t, "", mech)
+	assert.Len(t, rejected, 5)
+	_, _, err = cli.Start()
+	assert.ErrorIs(t, err, common.ErrNoMech)
+}
+
+func TestFallback(t *testing.T) {
+	errNoTicket := errors.New("no Kerberos ticket")
+	errNoToken := errors.New("no token")
+
+	r := registry.New()
+	props := common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous}
+	for name, err := range map[string]error{"FB-KRB": errNoTicket, "FB-OAUTH": errNoToken, "FB-OK": nil} {
+		name, err := name, err
+		r.MustRegister(name, func(common.MechConfig) common.Mech {
+			return &scriptedMech{name: name, steps: 2, err: err}
+		}, props)
+	}
+
+	// without fallback the first failure is returned
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"FB-KRB", "FB-OAUTH", "FB-OK"}))
+	assert.NoError(t, err)
+	mech, _, err := cli.Start()
+	assert.Equal(t, "FB-KRB", mech)
+	assert.ErrorIs(t, err, errNoTicket)
+
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"FB-KRB", "FB-OAUTH", "FB-OK"}), WithFallback())
+	assert.NoError(t, err)
+	mech, ir, err := cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "FB-OK", mech)
+	assert.Equal(t, []byte("token"), ir)
+	_, _, err = cli.Step([]byte("challenge"))
+	assert.NoError(t, err)
+	assert.True(t, cli.IsEstablished())
+
+	// every mech fails
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"FB-KRB", "FB-OAUTH"}), WithFallback())
+	assert.NoError(t, err)
+	mech, _, err = cli.Start()
+	assert.Equal(t, "", mech)
+	var fbErr FallbackError
+	assert.ErrorAs(t, err, &fbErr)
+	assert.Len(t, fbErr, 2)
+	assert.ErrorIs(t, err, errNoTicket)
+	assert.ErrorIs(t, err, errNoToken)
+	assert.EqualError(t, err, "all mechanisms failed: FB-KRB: no Kerberos ticket; FB-OAUTH: no token")
+}
+
+func TestChannelBindingType(t *testing.T) {
+	r := registry.New()
+	for name, props := range map[string]common.MechProps{
+		"CB-ANY":      {MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous, Fearures: common.FeatChannelBindings},
+		"CB-EXPORTER": {MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous, Fearures: common.FeatChannelBindings, ChannelBindingTypes: []string{common.CBTLSExporter}},
+		"CB-NONE":     {MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous},
+	} {
+		name := name
+		r.MustRegister(name, func(common.MechConfig) common.Mech {
+			return &scriptedMech{name: name, steps: 1}
+		}, props)
+	}
+
+	// critical bindings need an offered mech that accepts the type
+	list := WithMechList([]string{"CB-EXPORTER", "CB-NONE"})
+	cli, err := NewSaslClient("imap", WithRegistry(r), list, WithChannelBindings(common.ChannelBinding{Name: common.CBTLSUnique, Critical: true}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.ErrorIs(t, err, common.ErrNoMech)
+
+	cli, err = NewSaslClient("imap", WithRegistry(r), list, WithChannelBindings(common.ChannelBinding{Name: common.CBTLSExporter, Critical: true}))
+	assert.NoError(t, err)
+	mech, _, err := cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "CB-EXPORTER", mech)
+
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"CB-ANY"}), WithChannelBindings(common.ChannelBinding{Name: common.CBTLSUnique, Critical: true}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+
+	// tls-unique can't be used with TLS 1.3
+	_, err = NewSaslClient("imap", WithRegistry(r), WithChannelBindings(common.ChannelBinding{Name: common.CBTLSUnique, TLSVersion: tls.VersionTLS13}))
+	assert.ErrorIs(t, err, common.ErrNoChannelBinding)
+}
+
+func TestPreferPlus(t *testing.T) {
+	var flag common.CBFlag
+	r := registry.New()
+	for name, props := range map[string]common.MechProps{
+		"FAM":      {MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous},
+		"FAM-PLUS": {MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous, Fearures: common.FeatChannelBindings},
+	} {
+		name := name
+		r.MustRegister(name, func(cfg common.MechConfig) common.Mech {
+			flag = cfg.CBFlag
+			return &scriptedMech{name: name, steps: 1}
+		}, props)
+	}
+	list := WithMechList([]string{"FAM", "FAM-PLUS"})
+	cb := WithChannelBindings(common.ChannelBinding{Name: common.CBTLSExporter})
+
+	// -PLUS is preferred when we have bindings
+	cli, err := NewSaslClient("imap", WithRegistry(r), list, cb)
+	assert.NoError(t, err)
+	mech, _, err := cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "FAM-PLUS", mech)
+	assert.Equal(t, common.CBFlagUsed, flag)
+
+	// and is useless without them
+	cli, err = NewSaslClient("imap", WithRegistry(r), list)
+	assert.NoError(t, err)
+	mech, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "FAM", mech)
+	assert.Equal(t, common.CBFlagNone, flag)
+	_, rejected, _ := cli.eligibleMechs()
+	assert.Contains(t, rejected, "FAM-PLUS")
+
+	// the server didn't offer -PLUS, perhaps because it was stripped
+	cli, err = NewSaslClient("imap", WithRegistry(r), list, cb)
+	assert.NoError(t, err)
+	mech, _, err = cli.ChooseMech([]string{"FAM"})
+	assert.NoError(t, err)
+	assert.Equal(t, "FAM", mech)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, common.CBFlagNotOffered, flag)
+
+	assert.Equal(t, []string{"B-PLUS", "B", "A", "C-PLUS"}, preferPlus([]string{"B", "A", "B-PLUS", "C-PLUS"}))
+}
@@ -0,0 +1,22 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package sasl
+
+import (
+	"log/slog"
+
+	"github.com/golang-auth/go-sasl/pkg/loggable"
+)
+
+// WithSlogLogger sends the client's and mechanisms' log messages to l as
+// structured records, with attributes such as the mechanism, step and state
+func WithSlogLogger(l *slog.Logger) SaslClientOption {
+	return func(c *SaslClient) error {
+		return loggable.WithSlogLogger(l)(&c.Loggable)
+	}
+}
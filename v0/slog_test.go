@@ -0,0 +1,48 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package sasl
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	registry.MustRegister("SLOG", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "SLOG", steps: 2, ssf: 56}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	buf := &bytes.Buffer{}
+	l := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	cli, err := NewSaslClient("imap", WithMechList([]string{"SLOG"}), WithSlogLogger(l))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	_, _, err = cli.Step([]byte("challenge"))
+	assert.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "level=DEBUG")
+	assert.Contains(t, out, "step=1 state=negotiating mech=SLOG")
+	assert.Contains(t, out, "step=2 state=established mech=SLOG ssf=56")
+
+	// nothing below the handler's level is logged
+	buf.Reset()
+	l = slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	cli, err = NewSaslClient("imap", WithMechList([]string{"SLOG"}), WithSlogLogger(l))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	assert.NotContains(t, buf.String(), "level=DEBUG")
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package smtpauth registers net/smtp Auth implementations as SASL mechanisms
+// so that existing smtp.Auth code can be used with a SaslClient.
+//
+// smtp.Auth has no way to tell when the exchange is complete, so once the server
+// reports success the caller should pass any additional data that came with the
+// success response (or nil if there was none) to Step.  That final call is the
+// equivalent of net/smtp calling Next with more=false, and establishes the context.
+package smtpauth
+
+import (
+	"fmt"
+	"net/smtp"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/pkg/loggable"
+	"github.com/golang-auth/go-sasl/registry"
+)
+
+// AuthFactory returns a new smtp.Auth for a single authentication exchange
+type AuthFactory func(cfg common.MechConfig) smtp.Auth
+
+// Register makes the smtp.Auth implementations returned by f available as the
+// SASL mechanism name.  The caller supplies the mechanism properties as there is
+// no way to derive them from an smtp.Auth.  Register fails under the same
+// conditions as registry.Register.
+func Register(name string, f AuthFactory, props common.MechProps) error {
+	return RegisterWith(registry.Default(), name, f, props)
+}
+
+// RegisterWith is like Register but adds the mechanism to r
+func RegisterWith(r *registry.Registry, name string, f AuthFactory, props common.MechProps) error {
+	m := Mechanism(name, f, props)
+	return r.Register(m.Name, m.Factory, m.Props)
+}
+
+// Mechanism returns the smtp.Auth implementations returned by f as a mechanism
+// for sasl.Enable or sasl.WithMechanisms
+func Mechanism(name string, f AuthFactory, props common.MechProps) registry.Mechanism {
+	return registry.Mechanism{
+		Name: name,
+		Factory: func(cfg common.MechConfig) common.Mech {
+			return newMech(name, f(cfg), props, cfg)
+		},
+		Props: props,
+	}
+}
+
+type state uint8
+
+const (
+	stateNotStarted state = iota
+	stateAuthenticating
+	stateAuthenticated
+	stateClosed
+)
+
+type SMTPAuthMech struct {
+	loggable.Loggable
+	name   string
+	props  common.MechProps
+	config common.MechConfig
+	auth   smtp.Auth
+	state  state
+}
+
+func newMech(name string, auth smtp.Auth, props common.MechProps, cfg common.MechConfig) *SMTPAuthMech {
+	cfg.Logger.Debugf("new SMTPAuthMech (%s)", name)
+	return &SMTPAuthMech{
+		Loggable: cfg.Logger,
+		name:     name,
+		props:    props,
+		config:   cfg,
+		auth:     auth,
+		state:    stateNotStarted,
+	}
+}
+
+func (m SMTPAuthMech) Name() string {
+	return m.name
+}
+
+func (m SMTPAuthMech) MechProperties() common.MechProps {
+	return m.props
+}
+
+// Step never returns StepDoneWithFinalToken: an smtp.Auth can't tell that it
+// sent its last message, so the caller passes nil once the server reports success
+func (m *SMTPAuthMech) Step(inToken []byte) (outToken []byte, status common.StepStatus, err error) {
+	switch m.state {
+	case stateNotStarted:
+		outToken, err = m.stepStart(inToken)
+	case stateAuthenticating:
+		outToken, err = m.stepNext(inToken)
+	case stateAuthenticated:
+		return nil, common.StepDone, common.ErrAlreadyEstablished
+	case stateClosed:
+		return nil, common.StepContinue, common.ErrClosed
+	default:
+		return nil, common.StepContinue, smtpError(nil, fmt.Sprintf("step - bad state (%d)", m.state), nil)
+	}
+
+	if err == nil && m.IsEstablished() {
+		// nothing more can be sent once the server has reported success
+		return nil, common.StepDone, nil
+	}
+
+	return outToken, common.StepContinue, err
+}
+
+func (m *SMTPAuthMech) stepStart(inToken []byte) (outToken []byte, err error) {
+	m.Debugf("smtpauth: step (start)")
+
+	if m.auth == nil {
+		return nil, smtpError(common.ErrBadConfig, "no smtp.Auth for mech "+m.name, nil)
+	}
+
+	// smtp.Auth implementations such as PlainAuth refuse to send credentials
+	// unless they believe the connection is protected by TLS
+	info := &smtp.ServerInfo{
+		Name: m.config.ServerFQDN,
+		TLS:  m.config.ExternalSSF > 0,
+		Auth: []string{m.name},
+	}
+
+	// smtp.Auth errors are opaque, so they can't be classified
+	proto, toServer, err := m.auth.Start(info)
+	if err != nil {
+		return nil, smtpError(nil, "", err)
+	}
+
+	if proto != m.name {
+		return nil, smtpError(common.ErrBadConfig, fmt.Sprintf("smtp.Auth started mech %s, expected %s", proto, m.name), nil)
+	}
+
+	m.state = stateAuthenticating
+
+	// client-first: Start() was called by the SASL client, return the initial response
+	if inToken == nil {
+		return toServer, nil
+	}
+
+	// server-first: the first step already carries a challenge
+	if toServer != nil {
+		return nil, smtpError(common.ErrProtocol, "smtp.Auth returned an initial response for a server challenge", nil)
+	}
+
+	return m.stepNext(inToken)
+}
+
+func (m *SMTPAuthMech) stepNext(inToken []byte) (outToken []byte, err error) {
+	// a nil token signals that the server reported success
+	more := inToken != nil
+	m.Debugf("smtpauth: step (next, more: %t)", more)
+
+	outToken, err = m.auth.Next(inToken, more)
+	if err != nil {
+		return nil, smtpError(nil, "", err)
+	}
+
+	if !more {
+		m.state = stateAuthenticated
+	}
+
+	return outToken, nil
+}
+
+func (m SMTPAuthMech) IsEstablished() bool {
+	return m.state == stateAuthenticated
+}
+
+// smtp.Auth mechanisms never provide a security layer
+func (m SMTPAuthMech) ContextParams() common.ContextParams {
+	return common.ContextParams{}
+}
+
+func (m *SMTPAuthMech) Encode(input []byte) (outToken []byte, err error) {
+	return nil, fmt.Errorf("can't encode data: %w", common.ErrNoLayer)
+}
+
+func (m *SMTPAuthMech) Decode(inputToken []byte) (output []byte, err error) {
+	return nil, fmt.Errorf("can't decode data: %w", common.ErrNoLayer)
+}
+
+// Close drops the mech's reference to the smtp.Auth, which may hold a password
+func (m *SMTPAuthMech) Close() error {
+	m.auth = nil
+	m.state = stateClosed
+	return nil
+}
+
+func smtpError(class error, detail string, err error) error {
+	return common.NewError("smtpauth", class, detail, err)
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package smtpauth
+
+import (
+	"errors"
+	"net/smtp"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// loginAuth is the kind of bespoke smtp.Auth that people write for the LOGIN mech
+type loginAuth struct {
+	username, password string
+}
+
+func (a loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch string(fromServer) {
+	case "Username:":
+		return []byte(a.username), nil
+	case "Password:":
+		return []byte(a.password), nil
+	}
+
+	return nil, errors.New("unexpected server challenge")
+}
+
+func TestPlainAuth(t *testing.T) {
+	err := Register("PLAIN", func(cfg common.MechConfig) smtp.Auth {
+		return smtp.PlainAuth("", "user", "pass", cfg.ServerFQDN)
+	}, common.MechProps{SecurityProperties: common.SecNoAnonymous | common.SecPassCredentials})
+	assert.NoError(t, err)
+
+	assert.True(t, registry.IsRegistered("PLAIN"))
+
+	// PlainAuth won't send the password without TLS to a remote host
+	mech := registry.NewMech("PLAIN", common.MechConfig{ServerFQDN: "mail.example.com"})
+	_, _, err = mech.Step(nil)
+	assert.Error(t, err)
+
+	mech = registry.NewMech("PLAIN", common.MechConfig{ServerFQDN: "mail.example.com", ExternalSSF: 256})
+	assert.Equal(t, "PLAIN", mech.Name())
+	out, _, err := mech.Step(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x00user\x00pass"), out)
+	assert.False(t, mech.IsEstablished())
+
+	// server reports success
+	out, status, err := mech.Step(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, out)
+	assert.Equal(t, common.StepDone, status)
+	assert.True(t, mech.IsEstablished())
+
+	_, _, err = mech.Step(nil)
+	assert.ErrorIs(t, err, common.ErrAlreadyEstablished)
+}
+
+func TestLoginAuth(t *testing.T) {
+	err := Register("LOGIN", func(cfg common.MechConfig) smtp.Auth {
+		return loginAuth{"user", "pass"}
+	}, common.MechProps{Fearures: common.FeatServerFirst})
+	assert.NoError(t, err)
+
+	mech := registry.NewMech("LOGIN", common.MechConfig{})
+
+	// server-first: the first step carries a challenge
+	out, _, err := mech.Step([]byte("Username:"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("user"), out)
+
+	out, _, err = mech.Step([]byte("Password:"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pass"), out)
+	assert.False(t, mech.IsEstablished())
+
+	_, _, err = mech.Step(nil)
+	assert.NoError(t, err)
+	assert.True(t, mech.IsEstablished())
+
+	// auth errors are passed through
+	mech = registry.NewMech("LOGIN", common.MechConfig{})
+	_, _, err = mech.Step([]byte("Something else:"))
+	assert.Error(t, err)
+	assert.False(t, mech.IsEstablished())
+}
+
+func TestWrongProto(t *testing.T) {
+	err := Register("XLOGIN", func(cfg common.MechConfig) smtp.Auth {
+		return loginAuth{"user", "pass"}
+	}, common.MechProps{})
+	assert.NoError(t, err)
+
+	mech := registry.NewMech("XLOGIN", common.MechConfig{})
+	_, _, err = mech.Step(nil)
+	assert.ErrorIs(t, err, common.ErrBadConfig)
+}
+
+func TestRegisterWith(t *testing.T) {
+	r := registry.New()
+	err := RegisterWith(r, "LOGIN", func(cfg common.MechConfig) smtp.Auth {
+		return loginAuth{"user", "pass"}
+	}, common.MechProps{Fearures: common.FeatServerFirst})
+	assert.NoError(t, err)
+
+	assert.True(t, r.IsRegistered("LOGIN"))
+	mech := r.NewMech("LOGIN", common.MechConfig{})
+	assert.Equal(t, common.FeatServerFirst, mech.MechProperties().Fearures)
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import "github.com/golang-auth/go-sasl/common"
+
+// State is the progress of a client's authentication exchange
+type State int
+
+const (
+	StateNotStarted     State = iota
+	StateNegotiating          // authenticating
+	StateSSFNegotiation       // authenticated, negotiating the security layer
+	StateEstablished
+	StateFailed // Start or Step returned an error;  call Start to try again
+)
+
+func (s State) String() string {
+	switch s {
+	case StateNotStarted:
+		return "not started"
+	case StateNegotiating:
+		return "negotiating"
+	case StateSSFNegotiation:
+		return "negotiating SSF"
+	case StateEstablished:
+		return "established"
+	case StateFailed:
+		return "failed"
+	}
+
+	return "unknown"
+}
+
+// State returns the state of the exchange and the number of steps the
+// mechanism has taken, including the one that produced the initial response
+func (c SaslClient) State() (state State, steps int) {
+	switch {
+	case c.failed:
+		state = StateFailed
+	case c.mech == nil:
+		state = StateNotStarted
+	case c.mech.IsEstablished():
+		state = StateEstablished
+	default:
+		state = StateNegotiating
+		if ln, ok := c.mech.(common.LayerNegotiator); ok && ln.NegotiatingLayer() {
+			state = StateSSFNegotiation
+		}
+	}
+
+	return state, c.steps
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/golang-auth/go-sasl/wire"
+)
+
+// Security layer tokens are framed as described in package wire.  Without a
+// security layer data is sent as-is.
+
+// EncodingWriter applies the security layer to the data written to it.  Data is
+// buffered until there is enough to fill a message of the largest size the
+// server accepts, or until Flush is called.
+type EncodingWriter struct {
+	c     *SaslClient
+	w     io.Writer
+	layer bool
+	max   int // largest message the server accepts;  zero if unknown
+	buf   []byte
+	frame []byte // reused for each token
+	err   error
+}
+
+// NewEncodingWriter returns a writer that encodes data written to it and sends
+// it to w.  The context must be established.
+func (c *SaslClient) NewEncodingWriter(w io.Writer) *EncodingWriter {
+	ew := &EncodingWriter{c: c, w: w}
+
+	params, err := c.ContextParams()
+	if err != nil {
+		ew.err = err
+		return ew
+	}
+
+	ew.layer = params.SSF > 0
+	ew.max = int(params.MaxPeerMessageSize)
+	return ew
+}
+
+func (w *EncodingWriter) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	if !w.layer {
+		return w.w.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if w.max > 0 && len(w.buf) >= w.max {
+		err = w.writeTokens(w.max)
+	}
+
+	return len(p), err
+}
+
+// Flush encodes and sends any buffered data
+func (w *EncodingWriter) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.writeTokens(1)
+}
+
+// writeTokens sends the buffered data while at least min bytes remain, and keeps
+// the rest for later
+func (w *EncodingWriter) writeTokens(min int) error {
+	data := w.buf
+	for len(data) >= min && len(data) > 0 {
+		n := len(data)
+		if w.max > 0 && n > w.max {
+			n = w.max
+		}
+
+		if err := w.writeToken(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	w.buf = append(w.buf[:0], data...)
+	return nil
+}
+
+func (w *EncodingWriter) writeToken(data []byte) (err error) {
+	// encode after space for the length, which is filled in afterwards
+	w.frame = append(w.frame[:0], 0, 0, 0, 0)
+	if w.frame, err = w.c.EncodeTo(w.frame, data); err != nil {
+		w.err = err
+		return err
+	}
+
+	binary.BigEndian.PutUint32(w.frame, uint32(len(w.frame)-4))
+	if _, err = w.w.Write(w.frame); err != nil {
+		w.err = err
+	}
+
+	return err
+}
+
+// DecodingReader removes the security layer from the data read from the
+// underlying reader
+type DecodingReader struct {
+	c       *SaslClient
+	r       io.Reader
+	layer   bool
+	max     uint // largest token we accept
+	pending []byte
+	token   []byte // reused for each token
+	out     []byte // reused for each token's data
+	err     error
+}
+
+// NewDecodingReader returns a reader that decodes the data read from r.  The
+// context must be established.
+func (c *SaslClient) NewDecodingReader(r io.Reader) *DecodingReader {
+	dr := &DecodingReader{c: c, r: r, max: c.maxBufSize}
+
+	params, err := c.ContextParams()
+	if err != nil {
+		dr.err = err
+		return dr
+	}
+
+	dr.layer = params.SSF > 0
+	return dr
+}
+
+func (r *DecodingReader) Read(p []byte) (int, error) {
+	if !r.layer && r.err == nil {
+		return r.r.Read(p)
+	}
+
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		var token []byte
+		if token, r.err = wire.ReadFrame(r.r, r.token, r.max); r.err != nil {
+			continue
+		}
+		r.token = token
+
+		r.out, r.err = r.c.DecodeTo(r.out[:0], token)
+		r.pending = r.out
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
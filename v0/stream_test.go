@@ -0,0 +1,200 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// bracketMech has a security layer that wraps messages in square brackets
+type bracketMech struct {
+	scriptedMech
+}
+
+func (m *bracketMech) ContextParams() common.ContextParams {
+	return common.ContextParams{SSF: m.ssf, MaxPeerMessageSize: 4}
+}
+
+func (m *bracketMech) Encode(input []byte) ([]byte, error) {
+	return append(append([]byte("["), input...), ']'), nil
+}
+
+func (m *bracketMech) Decode(input []byte) ([]byte, error) {
+	if len(input) < 2 || input[0] != '[' || input[len(input)-1] != ']' {
+		return nil, common.ErrBadToken
+	}
+	return input[1 : len(input)-1], nil
+}
+
+func streamClient(t *testing.T, ssf uint) *SaslClient {
+	r := registry.New()
+	r.MustRegister("BRACKET", func(common.MechConfig) common.Mech {
+		return &bracketMech{scriptedMech{name: "BRACKET", steps: 1, ssf: ssf}}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	cli, err := NewSaslClient("ldap", WithRegistry(r), WithMaxBufSize(16))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+
+	return &cli
+}
+
+func framed(tokens ...string) []byte {
+	var b []byte
+	for _, tok := range tokens {
+		b = append(b, 0, 0, 0, byte(len(tok)))
+		b = append(b, tok...)
+	}
+	return b
+}
+
+func TestEncodingWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := streamClient(t, 56).NewEncodingWriter(&out)
+
+	// full messages are sent straight away, the rest waits for Flush
+	n, err := w.Write([]byte("abcdefg"))
+	assert.NoError(t, err)
+	assert.Equal(t, 7, n)
+	assert.Equal(t, framed("[abcd]"), out.Bytes())
+	_, err = w.Write([]byte("hi"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, framed("[abcd]", "[efgh]", "[i]"), out.Bytes())
+
+	out.Reset()
+	w = streamClient(t, 0).NewEncodingWriter(&out)
+	_, err = w.Write([]byte("abcdefg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdefg", out.String())
+
+	var cli SaslClient
+	_, err = cli.NewEncodingWriter(&out).Write([]byte("data"))
+	assert.ErrorIs(t, err, common.ErrNotStarted)
+}
+
+func TestDecodingReader(t *testing.T) {
+	// tokens split across reads are reassembled
+	in := iotest.OneByteReader(bytes.NewReader(framed("[hello]", "[]", "[world]")))
+	data, err := ioutil.ReadAll(streamClient(t, 56).NewDecodingReader(in))
+	assert.NoError(t, err)
+	assert.Equal(t, "helloworld", string(data))
+
+	_, err = ioutil.ReadAll(streamClient(t, 56).NewDecodingReader(bytes.NewReader(framed("[hello]")[:6])))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = ioutil.ReadAll(streamClient(t, 56).NewDecodingReader(bytes.NewReader(framed("hello"))))
+	assert.ErrorIs(t, err, common.ErrBadToken)
+
+	// larger than the advertised buffer size
+	_, err = ioutil.ReadAll(streamClient(t, 56).NewDecodingReader(bytes.NewReader(framed("[0123456789abcdef]"))))
+	assert.ErrorIs(t, err, common.ErrBadToken)
+
+	data, err = ioutil.ReadAll(streamClient(t, 0).NewDecodingReader(bytes.NewReader([]byte("plain"))))
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", string(data))
+}
+
+func TestEncodeFragments(t *testing.T) {
+	cli := streamClient(t, 56)
+
+	_, err := cli.Encode([]byte("abcde"))
+	assert.ErrorIs(t, err, common.ErrMessageTooLarge)
+
+	tokens, err := cli.EncodeFragments([]byte("abcdefghi"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("[abcd]"), []byte("[efgh]"), []byte("[i]")}, tokens)
+
+	tokens, err = cli.EncodeFragments([]byte{})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("[]")}, tokens)
+
+	tokens, err = streamClient(t, 0).EncodeFragments([]byte("abcdefghi"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("abcdefghi")}, tokens)
+}
+
+func TestDecodeMaxBufSize(t *testing.T) {
+	cli := streamClient(t, 56)
+
+	out, err := cli.Decode([]byte("[0123456789abcd]"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("0123456789abcd"), out)
+
+	_, err = cli.Decode([]byte("[0123456789abcde]"))
+	assert.ErrorIs(t, err, common.ErrBadToken)
+	var tooLarge common.ErrTokenTooLarge
+	assert.ErrorAs(t, err, &tooLarge)
+	assert.Equal(t, common.ErrTokenTooLarge{Size: 17, Max: 16}, tooLarge)
+
+	// no limit applies without a security layer
+	out, err = streamClient(t, 0).Decode([]byte("[0123456789abcde]"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("[0123456789abcde]"), out)
+}
+
+// appendBracketMech is a bracketMech that can encode into the caller's buffer
+type appendBracketMech struct {
+	bracketMech
+}
+
+func (m *appendBracketMech) EncodeTo(dst, input []byte) ([]byte, error) {
+	dst = append(dst, '[')
+	dst = append(dst, input...)
+	return append(dst, ']'), nil
+}
+
+func (m *appendBracketMech) DecodeTo(dst, input []byte) ([]byte, error) {
+	out, err := m.Decode(input)
+	return append(dst, out...), err
+}
+
+func TestEncodeTo(t *testing.T) {
+	r := registry.New()
+	r.MustRegister("APPEND", func(common.MechConfig) common.Mech {
+		return &appendBracketMech{bracketMech{scriptedMech{name: "APPEND", steps: 1, ssf: 56}}}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+	cli, err := NewSaslClient("ldap", WithRegistry(r), WithMaxBufSize(16))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+
+	buf := make([]byte, 0, 64)
+	buf, err = cli.EncodeTo(append(buf, "hdr:"...), []byte("abcd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hdr:[abcd]", string(buf))
+	buf, err = cli.DecodeTo(buf[:0], []byte("[abcd]"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abcd", string(buf))
+
+	_, err = cli.EncodeTo(buf[:0], []byte("abcde"))
+	assert.ErrorIs(t, err, common.ErrMessageTooLarge)
+	_, err = cli.DecodeTo(buf[:0], []byte("[0123456789abcde]"))
+	assert.ErrorIs(t, err, common.ErrBadToken)
+
+	// the buffer is reused
+	data, token := []byte("abcd"), []byte("[abcd]")
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = cli.EncodeTo(buf[:0], data)
+		buf, _ = cli.DecodeTo(buf[:0], token)
+	})
+	assert.Equal(t, float64(0), allocs)
+
+	// mechs without AppendCoder still work
+	buf, err = streamClient(t, 56).EncodeTo(buf[:0], []byte("abcd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[abcd]", string(buf))
+	buf, err = streamClient(t, 0).DecodeTo(buf[:0], []byte("abcd"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abcd", string(buf))
+}
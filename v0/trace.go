@@ -0,0 +1,63 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"context"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// WithTracer creates spans for Start, Step, Encode and Decode with the mech,
+// step index and outcome as attributes.  Mechanism steps run in the context of
+// their span, so work done by the mechanism appears beneath it.
+func WithTracer(t common.Tracer) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.tracer = t
+		return nil
+	}
+}
+
+// nopSpan is used when there is no tracer
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...common.Attribute) {}
+func (nopSpan) RecordError(error)                 {}
+func (nopSpan) End()                              {}
+
+func (c *SaslClient) startSpan(ctx context.Context, name string, attrs ...common.Attribute) (context.Context, common.Span) {
+	if c.tracer == nil {
+		return ctx, nopSpan{}
+	}
+
+	return c.tracer.Start(ctx, name, attrs...)
+}
+
+// endSpan records the outcome of an operation and ends its span
+func endSpan(span common.Span, outcome string, err error) {
+	if err != nil {
+		span.RecordError(err)
+		outcome = "failure"
+	}
+	span.SetAttributes(common.Attribute{Key: common.AttrOutcome, Value: outcome})
+	span.End()
+}
+
+// traceLayer starts a span for a security layer operation on n bytes and
+// returns the function that ends it
+func (c *SaslClient) traceLayer(name string, n int) func(error) {
+	if c.tracer == nil {
+		return func(error) {}
+	}
+
+	attrs := []common.Attribute{{Key: common.AttrBytes, Value: n}}
+	if c.mech != nil {
+		attrs = append(attrs, common.Attribute{Key: common.AttrMech, Value: c.mech.Name()})
+	}
+
+	_, span := c.tracer.Start(context.Background(), name, attrs...)
+	return func(err error) {
+		endSpan(span, "success", err)
+	}
+}
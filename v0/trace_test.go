@@ -0,0 +1,106 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+type spanKey struct{}
+
+type testSpan struct {
+	name   string
+	parent string
+	attrs  map[string]interface{}
+	err    error
+	ended  bool
+}
+
+func (s *testSpan) SetAttributes(attrs ...common.Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+func (s *testSpan) RecordError(err error) { s.err = err }
+func (s *testSpan) End()                  { s.ended = true }
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, name string, attrs ...common.Attribute) (context.Context, common.Span) {
+	s := &testSpan{name: name, attrs: map[string]interface{}{}}
+	if parent, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		s.parent = parent.name
+	}
+	s.SetAttributes(attrs...)
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func TestTracer(t *testing.T) {
+	r := registry.New()
+	r.MustRegister("TRACE-OK", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "TRACE-OK", steps: 2, ssf: 56}
+	}, common.MechProps{MaxSSF: 56, SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+	r.MustRegister("TRACE-FAIL", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "TRACE-FAIL", steps: 2, err: errors.New("bad password")}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	tr := &testTracer{}
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithTracer(tr), WithMechList([]string{"TRACE-OK"}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	_, _, err = cli.Step([]byte("challenge"))
+	assert.NoError(t, err)
+	_, err = cli.Encode([]byte("hello"))
+	assert.NoError(t, err)
+
+	if assert.Len(t, tr.spans, 4) {
+		start, step1, step2, encode := tr.spans[0], tr.spans[1], tr.spans[2], tr.spans[3]
+		assert.Equal(t, "sasl.Start", start.name)
+		assert.Equal(t, map[string]interface{}{
+			common.AttrService: "imap", common.AttrMech: "TRACE-OK", common.AttrOutcome: "continue",
+		}, start.attrs)
+
+		// the initial response is a child of Start
+		assert.Equal(t, "sasl.Step", step1.name)
+		assert.Equal(t, "sasl.Start", step1.parent)
+		assert.Equal(t, 1, step1.attrs[common.AttrStep])
+		assert.Equal(t, "continue", step1.attrs[common.AttrOutcome])
+
+		assert.Equal(t, "", step2.parent)
+		assert.Equal(t, 2, step2.attrs[common.AttrStep])
+		assert.Equal(t, "success", step2.attrs[common.AttrOutcome])
+		assert.Equal(t, 56, step2.attrs[common.AttrSSF])
+
+		assert.Equal(t, "sasl.Encode", encode.name)
+		assert.Equal(t, 5, encode.attrs[common.AttrBytes])
+		assert.Equal(t, "TRACE-OK", encode.attrs[common.AttrMech])
+
+		for _, s := range tr.spans {
+			assert.True(t, s.ended, s.name)
+		}
+	}
+
+	// failures are recorded on the span
+	tr.spans = nil
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithTracer(tr), WithMechList([]string{"TRACE-FAIL"}))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.Error(t, err)
+	if assert.Len(t, tr.spans, 2) {
+		for _, s := range tr.spans {
+			assert.EqualError(t, s.err, "bad password", s.name)
+			assert.Equal(t, "failure", s.attrs[common.AttrOutcome], s.name)
+		}
+	}
+}
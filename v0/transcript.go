@@ -0,0 +1,111 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoBodies is returned by Transcript.Received when the tokens weren't
+// recorded, see RecordBodies
+var ErrNoBodies = errors.New("token bodies not recorded")
+
+// Direction of a token in a transcript
+type Direction string
+
+const (
+	DirectionSent     Direction = "sent"     // client to server
+	DirectionReceived Direction = "received" // server to client
+)
+
+// TranscriptEntry is one token exchanged with the server
+type TranscriptEntry struct {
+	Direction Direction     `json:"direction"`
+	Step      int           `json:"step"`
+	Length    int           `json:"length"`
+	Elapsed   time.Duration `json:"elapsed_ns"`     // since the exchange started
+	Hash      string        `json:"sha256"`         // prefix of the token's SHA-256 hash
+	Body      string        `json:"body,omitempty"` // the token in hex, if RecordBodies is set
+}
+
+// Transcript records the tokens of an authentication exchange, eg. to attach
+// to an interoperability bug report or to replay in a regression test.  It is
+// exported with encoding/json.  Start resets the transcript, so it holds the
+// most recent exchange.
+type Transcript struct {
+	// RecordBodies includes the tokens themselves.  Tokens can contain
+	// credentials, so only set it when debugging with test accounts.
+	RecordBodies bool `json:"-"`
+
+	Service string            `json:"service"`
+	Mech    string            `json:"mech"`
+	Started time.Time         `json:"started"`
+	Entries []TranscriptEntry `json:"entries"`
+	Outcome string            `json:"outcome,omitempty"` // success or failure, once finished
+	Error   string            `json:"error,omitempty"`
+}
+
+// WithTranscript records each exchange in t.  A transcript must not be shared
+// between clients that are used concurrently;  clones don't record to it.
+func WithTranscript(t *Transcript) SaslClientOption {
+	return func(c *SaslClient) error {
+		c.transcript = t
+		return nil
+	}
+}
+
+// Received returns the bodies of the tokens sent by the server, in order, for
+// replaying the exchange.  It fails with ErrNoBodies unless RecordBodies was set
+// when they were recorded.
+func (t *Transcript) Received() ([][]byte, error) {
+	var tokens [][]byte
+	for _, e := range t.Entries {
+		if e.Direction != DirectionReceived {
+			continue
+		}
+
+		b, err := hex.DecodeString(e.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != e.Length {
+			return nil, fmt.Errorf("step %d: %w", e.Step, ErrNoBodies)
+		}
+		tokens = append(tokens, b)
+	}
+
+	return tokens, nil
+}
+
+func (t *Transcript) reset(service string, started time.Time) {
+	*t = Transcript{RecordBodies: t.RecordBodies, Service: service, Started: started}
+}
+
+func (t *Transcript) record(dir Direction, step int, token []byte) {
+	sum := sha256.Sum256(token)
+	e := TranscriptEntry{
+		Direction: dir,
+		Step:      step,
+		Length:    len(token),
+		Elapsed:   time.Since(t.Started),
+		Hash:      hex.EncodeToString(sum[:4]),
+	}
+	if t.RecordBodies {
+		e.Body = hex.EncodeToString(token)
+	}
+
+	t.Entries = append(t.Entries, e)
+}
+
+func (t *Transcript) finish(err error) {
+	t.Outcome = "success"
+	if err != nil {
+		t.Outcome = "failure"
+		t.Error = err.Error()
+	}
+}
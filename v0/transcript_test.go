@@ -0,0 +1,88 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package sasl
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/golang-auth/go-sasl/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranscript(t *testing.T) {
+	r := registry.New()
+	r.MustRegister("RECORD", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "RECORD", steps: 2}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+	r.MustRegister("RECORD-FAIL", func(cfg common.MechConfig) common.Mech {
+		return &scriptedMech{name: "RECORD-FAIL", steps: 2, err: errors.New("bad password")}
+	}, common.MechProps{SecurityProperties: common.SecNoPlainText | common.SecNoAnonymous})
+
+	tr := &Transcript{}
+	cli, err := NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"RECORD"}), WithTranscript(tr))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	_, _, err = cli.Step([]byte("hi"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "imap", tr.Service)
+	assert.Equal(t, "RECORD", tr.Mech)
+	assert.Equal(t, "success", tr.Outcome)
+	if assert.Len(t, tr.Entries, 3) {
+		assert.Equal(t, DirectionSent, tr.Entries[0].Direction)
+		assert.Equal(t, 1, tr.Entries[0].Step)
+		assert.Equal(t, 5, tr.Entries[0].Length)
+		assert.Equal(t, "3c469e9d", tr.Entries[0].Hash)
+		assert.Equal(t, DirectionReceived, tr.Entries[1].Direction)
+		assert.Equal(t, 2, tr.Entries[1].Step)
+		assert.Equal(t, DirectionSent, tr.Entries[2].Direction)
+		for _, e := range tr.Entries {
+			assert.Empty(t, e.Body)
+		}
+	}
+	_, err = tr.Received()
+	assert.ErrorIs(t, err, ErrNoBodies)
+
+	b, err := json.Marshal(tr)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"mech":"RECORD"`)
+	assert.NotContains(t, string(b), "RecordBodies")
+
+	// bodies can be replayed
+	tr = &Transcript{RecordBodies: true}
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"RECORD"}), WithTranscript(tr))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.NoError(t, err)
+	_, _, err = cli.Step([]byte("hi"))
+	assert.NoError(t, err)
+	assert.Equal(t, "746f6b656e", tr.Entries[0].Body)
+	received, err := tr.Received()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("hi")}, received)
+
+	// including from a transcript that has been exported
+	b, err = json.Marshal(tr)
+	assert.NoError(t, err)
+	loaded := &Transcript{}
+	assert.NoError(t, json.Unmarshal(b, loaded))
+	received, err = loaded.Received()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("hi")}, received)
+
+	// failures, and Start resets the transcript
+	cli, err = NewSaslClient("imap", WithRegistry(r), WithMechList([]string{"RECORD-FAIL"}), WithTranscript(tr))
+	assert.NoError(t, err)
+	_, _, err = cli.Start()
+	assert.Error(t, err)
+	assert.Equal(t, "RECORD-FAIL", tr.Mech)
+	assert.Empty(t, tr.Entries)
+	assert.Equal(t, "failure", tr.Outcome)
+	assert.Equal(t, "bad password", tr.Error)
+	assert.True(t, tr.RecordBodies)
+}
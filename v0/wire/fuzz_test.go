@@ -0,0 +1,78 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzDecodeBase64(f *testing.F) {
+	for _, s := range []string{"", "=", "aGVsbG8=", "aGVsbG8", "*", "a b", "\x00"} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		msg, err := DecodeBase64(line)
+		if err != nil {
+			return
+		}
+		again, err := DecodeBase64(EncodeBase64(msg))
+		if err != nil || !bytes.Equal(msg, again) {
+			t.Fatalf("%q decoded to %x, which doesn't round trip", line, msg)
+		}
+	})
+}
+
+func FuzzContinuation(f *testing.F) {
+	for _, s := range []string{"+ ", "+", "+ aGVsbG8=", "334 ", "334 =", "334 !!"} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		for _, c := range []Continuation{IMAPContinuation, SMTPContinuation, NNTPContinuation} {
+			c.Decode(line)
+		}
+	})
+}
+
+func FuzzParseMechList(f *testing.F) {
+	for _, s := range []string{"GSSAPI PLAIN", "", "gssapi,plain", "SCRAM-SHA-256-PLUS\tX"} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, list string) {
+		var all []string
+		all = append(all, ParseMechList(list)...)
+		all = append(all, SMTPMechs([]string{list})...)
+		all = append(all, IMAPMechs([]string{list})...)
+		all = append(all, LDAPMechs([]string{list})...)
+		for _, name := range all {
+			if !ValidMechName(name) {
+				t.Fatalf("%q gave invalid mechanism %q", list, name)
+			}
+		}
+	})
+}
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 1, 'x'}, uint(0))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff}, uint(1024))
+	f.Add([]byte{0, 0}, uint(0))
+
+	f.Fuzz(func(t *testing.T, data []byte, max uint) {
+		if max == 0 || max > 1<<16 {
+			// don't let the fuzzer allocate huge buffers
+			max = 1 << 16
+		}
+		token, err := ReadFrame(bytes.NewReader(data), nil, max)
+		if err == nil && uint(len(token)) > max {
+			t.Fatalf("read a %d byte token, max %d", len(token), max)
+		}
+	})
+}
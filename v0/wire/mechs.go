@@ -0,0 +1,102 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package wire
+
+import (
+	"strings"
+)
+
+// ValidMechName reports whether name matches the RFC 4422 § 3.1 grammar:
+// 1 to 20 upper case letters, digits, hyphens and underscores
+func ValidMechName(name string) bool {
+	if len(name) == 0 || len(name) > 20 {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MechList returns the valid names in names, in upper case and without
+// duplicates, ready to pass to SaslClient.ChooseMech.  Servers sometimes
+// advertise names in lower case so they are accepted;  anything else that
+// doesn't match the RFC 4422 grammar is dropped.
+func MechList(names []string) []string {
+	var mechs []string
+	seen := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if !ValidMechName(name) || seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		mechs = append(mechs, name)
+	}
+
+	return mechs
+}
+
+// ParseMechList parses a list of mechanism names separated by spaces or
+// commas, eg. "SCRAM-SHA-256 GSSAPI" or "PLAIN,EXTERNAL"
+func ParseMechList(list string) []string {
+	return MechList(strings.FieldsFunc(list, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t'
+	}))
+}
+
+// SMTPMechs returns the mechanisms in the AUTH keyword of an SMTP EHLO
+// response (RFC 4954 § 3).  lines may include the reply codes, eg.
+// "250-AUTH GSSAPI PLAIN", and the obsolete "AUTH=" form is also accepted.
+func SMTPMechs(lines []string) []string {
+	var names []string
+
+	for _, line := range lines {
+		if len(line) > 4 && line[0] >= '0' && line[0] <= '9' && (line[3] == '-' || line[3] == ' ') {
+			line = line[4:]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch kw := strings.ToUpper(fields[0]); {
+		case kw == "AUTH":
+			names = append(names, fields[1:]...)
+		case strings.HasPrefix(kw, "AUTH="):
+			names = append(names, fields[0][5:])
+			names = append(names, fields[1:]...)
+		}
+	}
+
+	return MechList(names)
+}
+
+// IMAPMechs returns the mechanisms in the AUTH= capabilities of an IMAP
+// CAPABILITY response (RFC 3501 § 6.1.1)
+func IMAPMechs(caps []string) []string {
+	var names []string
+
+	for _, c := range caps {
+		if len(c) > 5 && strings.EqualFold(c[:5], "AUTH=") {
+			names = append(names, c[5:])
+		}
+	}
+
+	return MechList(names)
+}
+
+// LDAPMechs returns the mechanisms in the supportedSASLMechanisms values of
+// an LDAP server's root DSE (RFC 4512 § 5.1.6)
+func LDAPMechs(values []string) []string {
+	return MechList(values)
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package wire
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidMechName(t *testing.T) {
+	for _, name := range []string{"GSSAPI", "SCRAM-SHA-256-PLUS", "X_TEST", "ABCDEFGHIJKLMNOPQRST"} {
+		assert.True(t, ValidMechName(name), name)
+	}
+	for _, name := range []string{"", "plain", "ABCDEFGHIJKLMNOPQRSTU", "DIGEST MD5", "AUTH=PLAIN", "MÜ"} {
+		assert.False(t, ValidMechName(name), name)
+	}
+}
+
+func TestMechLists(t *testing.T) {
+	assert.Equal(t, []string{"GSSAPI", "PLAIN"}, MechList([]string{"gssapi", " PLAIN", "GSSAPI", "bad name", ""}))
+	assert.Equal(t, []string{"SCRAM-SHA-256", "GSSAPI", "PLAIN"}, ParseMechList("SCRAM-SHA-256 GSSAPI,plain"))
+	assert.Nil(t, ParseMechList(""))
+
+	assert.Equal(t, []string{"GSSAPI", "PLAIN", "LOGIN"}, SMTPMechs([]string{
+		"250-mail.example.com Hello",
+		"250-PIPELINING",
+		"250-AUTH GSSAPI PLAIN",
+		"250-AUTH=PLAIN LOGIN",
+		"250 8BITMIME",
+	}))
+	assert.Equal(t, []string{"GSSAPI"}, SMTPMechs([]string{"SIZE 1000", "auth gssapi"}))
+	assert.Nil(t, SMTPMechs([]string{"250 AUTHX GSSAPI"}))
+
+	assert.Equal(t, []string{"GSSAPI", "PLAIN"}, IMAPMechs([]string{"IMAP4rev1", "auth=gssapi", "SASL-IR", "AUTH=PLAIN", "AUTH="}))
+
+	assert.Equal(t, []string{"GSSAPI", "EXTERNAL"}, LDAPMechs([]string{"GSSAPI", "EXTERNAL", "DIGEST MD5"}))
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+
+// Package wire encodes SASL messages in the forms used by application
+// protocols: base64 lines for text protocols such as IMAP, SMTP and POP3, and
+// length-prefixed frames for security layer tokens.
+package wire
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/golang-auth/go-sasl/common"
+)
+
+// Cancel is sent by the client in place of a response to abort the exchange
+const Cancel = "*"
+
+// ErrNotContinuation is returned when a line doesn't have the expected
+// continuation prefix, eg. because the server sent a final response instead
+var ErrNotContinuation = errors.New("not a continuation line")
+
+// EncodeBase64 returns msg as a single line of base64 without a line ending
+func EncodeBase64(msg []byte) string {
+	return base64.StdEncoding.EncodeToString(msg)
+}
+
+// DecodeBase64 decodes a single line of base64, ignoring any trailing CRLF
+func DecodeBase64(line string) ([]byte, error) {
+	msg, err := base64.StdEncoding.DecodeString(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		return nil, fmt.Errorf("bad base64 message: %w", common.ErrBadToken)
+	}
+
+	return msg, nil
+}
+
+// Continuation is the prefix of the lines that carry server challenges in a
+// text protocol
+type Continuation string
+
+const (
+	IMAPContinuation Continuation = "+ "   // RFC 3501 § 7.5, also POP3 and ManageSieve
+	SMTPContinuation Continuation = "334 " // RFC 4954 § 4
+	NNTPContinuation Continuation = "383 " // RFC 4643 § 2.4
+)
+
+// Encode returns the continuation line for a challenge, without a line ending
+func (c Continuation) Encode(challenge []byte) string {
+	return string(c) + EncodeBase64(challenge)
+}
+
+// Decode returns the challenge in a continuation line.  An empty challenge may
+// be sent without the trailing space of the prefix.
+func (c Continuation) Decode(line string) ([]byte, error) {
+	line = strings.TrimRight(line, "\r\n")
+
+	switch {
+	case strings.HasPrefix(line, string(c)):
+		return DecodeBase64(line[len(c):])
+	case line == strings.TrimRight(string(c), " "):
+		return []byte{}, nil
+	}
+
+	return nil, ErrNotContinuation
+}
+
+// AppendFrame appends token to dst with a 4-byte big-endian length prefix, the
+// framing of security layer tokens (RFC 4422 § 3.7)
+func AppendFrame(dst, token []byte) []byte {
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(token)))
+	return append(append(dst, hdr[:]...), token...)
+}
+
+// WriteFrame writes token to w with a length prefix
+func WriteFrame(w io.Writer, token []byte) error {
+	_, err := w.Write(AppendFrame(make([]byte, 0, 4+len(token)), token))
+	return err
+}
+
+// ReadFrame reads a length-prefixed token from r into buf, which is grown if
+// necessary, and returns it.  Tokens larger than max are rejected with
+// common.ErrTokenTooLarge unless max is zero.  ReadFrame returns io.EOF only if
+// r ends cleanly between frames.
+func ReadFrame(r io.Reader, buf []byte, max uint) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(hdr[:])
+	if max > 0 && uint(size) > max {
+		return nil, common.ErrTokenTooLarge{Size: uint(size), Max: max}
+	}
+
+	if uint32(cap(buf)) < size {
+		buf = make([]byte, size)
+	}
+	token := buf[:size]
+	if _, err := io.ReadFull(r, token); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Jake Scott. All rights reserved.
+// Use of this source code is governed by the Apache License
+// version 2.0 that can be found in the LICENSE file.
+package wire
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/golang-auth/go-sasl/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBase64(t *testing.T) {
+	assert.Equal(t, "AHVzZXIAcGFzcw==", EncodeBase64([]byte("\x00user\x00pass")))
+	assert.Equal(t, "", EncodeBase64(nil))
+
+	msg, err := DecodeBase64("AHVzZXIAcGFzcw==\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x00user\x00pass"), msg)
+
+	msg, err = DecodeBase64("")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{}, msg)
+
+	_, err = DecodeBase64("AHVzZXI!")
+	assert.ErrorIs(t, err, common.ErrBadToken)
+}
+
+func TestContinuation(t *testing.T) {
+	assert.Equal(t, "+ YWJj", IMAPContinuation.Encode([]byte("abc")))
+	assert.Equal(t, "334 YWJj", SMTPContinuation.Encode([]byte("abc")))
+	assert.Equal(t, "+ ", IMAPContinuation.Encode(nil))
+
+	var tests = []struct {
+		cont Continuation
+		line string
+		want []byte
+		err  error
+	}{
+		{IMAPContinuation, "+ YWJj\r\n", []byte("abc"), nil},
+		{IMAPContinuation, "+ \r\n", []byte{}, nil},
+		{IMAPContinuation, "+\r\n", []byte{}, nil},
+		{IMAPContinuation, "a1 OK done\r\n", nil, ErrNotContinuation},
+		{IMAPContinuation, "+ !!\r\n", nil, common.ErrBadToken},
+		{SMTPContinuation, "334 YWJj", []byte("abc"), nil},
+		{SMTPContinuation, "334", []byte{}, nil},
+		{NNTPContinuation, "383 YWJj", []byte("abc"), nil},
+		{SMTPContinuation, "235 2.7.0 Authentication successful", nil, ErrNotContinuation},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.cont.Decode(tt.line)
+		if tt.err != nil {
+			assert.ErrorIs(t, err, tt.err, tt.line)
+			continue
+		}
+		assert.NoError(t, err, tt.line)
+		assert.Equal(t, tt.want, got, tt.line)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	frame := AppendFrame([]byte("x"), []byte("token"))
+	assert.Equal(t, []byte("x\x00\x00\x00\x05token"), frame)
+
+	var out bytes.Buffer
+	assert.NoError(t, WriteFrame(&out, []byte("one")))
+	assert.NoError(t, WriteFrame(&out, []byte{}))
+	assert.NoError(t, WriteFrame(&out, []byte("three")))
+
+	// frames are read whole from a reader that returns a byte at a time
+	r := iotest.OneByteReader(bytes.NewReader(out.Bytes()))
+	buf := make([]byte, 0, 8)
+	for _, want := range []string{"one", "", "three"} {
+		tok, err := ReadFrame(r, buf, 16)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(tok))
+	}
+	_, err := ReadFrame(r, buf, 16)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = ReadFrame(bytes.NewReader(frame[1:6]), nil, 0)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = ReadFrame(bytes.NewReader(frame[1:]), nil, 4)
+	assert.ErrorIs(t, err, common.ErrBadToken)
+}